@@ -1,19 +1,30 @@
 package main
 
 import (
+	"auth/config"
+	"auth/logger"
+	"auth/passhash"
+	"auth/passpolicy"
+	"auth/signing"
+	"auth/totp"
 	"auth/user/delivery"
+	"auth/user/delivery/middleware"
 	"auth/user/delivery/render"
+	"auth/user/repository/mail"
 	"auth/user/repository/mysql"
 	"auth/user/repository/redis"
 	"auth/user/repository/walletservice"
 	"auth/user/usecase"
-	"fmt"
 	"log"
+	"net"
+	"os"
 	"time"
 
 	"github.com/buaazp/fasthttprouter"
 	"github.com/subosito/gotenv"
 	"github.com/valyala/fasthttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 func init() {
@@ -22,41 +33,122 @@ func init() {
 
 func main() {
 	time.Local = time.FixedZone("CST", 6*3600)
-	fmt.Println(time.Now())
+	logPolicy, err := config.LoadLogPolicy()
+	if err != nil {
+		log.Fatalf("Log policy error: %v", err)
+	}
+	logLevel, err := logger.ParseLevel(logPolicy.Level)
+	if err != nil {
+		log.Fatalf("Log policy error: %v", err)
+	}
+	logger.SetDefault(logger.New(os.Stderr, logLevel, logPolicy.Format))
+	// fatal startup errors and libraries logging with the standard logger are redacted too
+	log.SetFlags(0)
+	log.SetOutput(logger.Default().Writer(logger.LevelError))
+	logger.Info("Starting", "time", time.Now())
 	r := fasthttprouter.New()
 	dbConn, err := mysql.NewMySQLDBInterface()
 	if err != nil {
 		log.Fatalf("Db interface create error: %v", err)
 	}
 	defer dbConn.Close()
+	auditRepo, err := mysql.NewMySQLAuditRepository(dbConn)
+	if err != nil {
+		log.Fatalf("Audit repository error: %v", err)
+	}
 	api := walletservice.NewWalletAPIInterface()
+	rateLimiter, err := redis.NewRedisRateLimiter()
+	if err != nil {
+		logger.Error("Rate limiter create error", "err", err)
+		return
+	}
 	redis, err := redis.NewRedisCacheInterface()
 	if err != nil {
-		fmt.Println(err)
+		logger.Error("Cache interface create error", "err", err)
 		return
 	}
+	loginPolicy, err := config.LoadLoginPolicy()
+	if err != nil {
+		log.Fatalf("Login policy error: %v", err)
+	}
+	rateLimits, err := config.LoadRateLimits()
+	if err != nil {
+		log.Fatalf("Rate limits error: %v", err)
+	}
+	resetPolicy, err := config.LoadPasswordResetPolicy()
+	if err != nil {
+		log.Fatalf("Password reset policy error: %v", err)
+	}
+	mailer, err := mail.NewMailer()
+	if err != nil {
+		log.Fatalf("Mailer error: %v", err)
+	}
+	hashPolicy, err := config.LoadPasswordHashPolicy()
+	if err != nil {
+		log.Fatalf("Password hash policy error: %v", err)
+	}
+	hasher := passhash.NewHasher(hashPolicy)
+	passwordConfig, err := config.LoadPasswordPolicy()
+	if err != nil {
+		log.Fatalf("Password policy error: %v", err)
+	}
+	passwordPolicy, err := passpolicy.NewPolicy(passwordConfig)
+	if err != nil {
+		log.Fatalf("Password policy error: %v", err)
+	}
+	oauthPolicy, err := config.LoadOAuthPolicy()
+	if err != nil {
+		log.Fatalf("OAuth policy error: %v", err)
+	}
+	if _, err := signing.Default(); err != nil {
+		log.Fatalf("Signing keys error: %v", err)
+	}
+	mfaCipher, err := totp.DefaultCipher()
+	if err != nil {
+		log.Fatalf("MFA cipher error: %v", err)
+	}
+	mfaIssuer := os.Getenv("MFA_ISSUER")
+	if mfaIssuer == "" {
+		mfaIssuer = "MyWallet"
+	}
 	updateTokenusecase := usecase.NewUpdateTokenUsecase(redis, dbConn)
-	loginUsecase := usecase.NewLoginUsecase(redis, dbConn)
+	loginUsecase := usecase.NewLoginUsecase(redis, dbConn, hasher, passwordPolicy)
+	loginAttemptsUsecase := usecase.NewLoginAttemptsUsecase(redis, loginPolicy)
 	addWalletUsecase := usecase.NewAddWalletUsecase(api)
 	getInfoUsecase := usecase.NewGetInfoUsecase(api, dbConn)
-	signupUsecase := usecase.NewSignupUsecase(dbConn)
+	signupUsecase := usecase.NewSignupUsecase(dbConn, hasher, passwordPolicy)
 	topupUsecase := usecase.NewTopupUsecase(api)
 	transferUsecase := usecase.NewTransferUsecase(api)
 	topupPageUsecase := usecase.NewTopupPageUsecase(api)
 	transferPageUsecase := usecase.NewTransferPageUsecase(api)
 	getTransactionsUsecase := usecase.NewGetTransactionsUsecase(api)
+	sessionsUsecase := usecase.NewSessionsUsecase(redis)
+	logoutUsecase := usecase.NewLogoutUsecase(redis)
+	passwordResetUsecase := usecase.NewPasswordResetUsecase(redis, dbConn, mailer, resetPolicy, hasher, passwordPolicy)
+	adminUsecase := usecase.NewAdminUsecase(redis, dbConn, api, passwordResetUsecase)
+	mfaUsecase := usecase.NewMFAUsecase(dbConn, mfaCipher, mfaIssuer)
+	profileUsecase := usecase.NewProfileUsecase(redis, dbConn, hasher, passwordPolicy)
+	oauthUsecase := usecase.NewOAuthUsecase(redis, dbConn, oauthPolicy)
+	auditUsecase := usecase.NewAuditUsecase(auditRepo)
+	middleware.SetDenylist(redis)
+	middleware.SetAuditLog(auditRepo)
+	middleware.SetRefresher(delivery.NewSessionRefresher(updateTokenusecase))
 	tc, err := render.CreateTemplateCache()
 	if err != nil {
-		fmt.Println(err)
+		logger.Error("Template cache error", "err", err)
 		return
 	}
+	middleware.SetRateLimiter(rateLimiter, rateLimits, tc["ratelimit.page.html"])
 	delivery.NewHomePageHandler(r, tc["home.page.html"])
-	delivery.NewLogoutHandler(r)
+	delivery.NewLogoutHandler(r, logoutUsecase)
 	delivery.NewGetUserInfoHandler(r, getInfoUsecase, tc["info.page.html"])
 	delivery.NewGetTransactionsHandler(r, getTransactionsUsecase, tc["transactions.page.html"])
 	delivery.NewLoginPageHandler(r, tc["login.page.html"])
-	delivery.NewLoginHandler(r, loginUsecase)
-	delivery.NewSignupPageHandler(r, tc["signup.page.html"])
+	delivery.NewLoginHandler(r, loginUsecase, loginAttemptsUsecase, mfaUsecase)
+	delivery.NewMFALoginPageHandler(r, tc["login.mfa.page.html"])
+	delivery.NewMFAHandler(r, mfaUsecase, tc["mfa.page.html"])
+	delivery.NewPasswordResetHandler(r, passwordResetUsecase, tc["password.forgot.page.html"], tc["password.reset.page.html"])
+	delivery.NewSignupPageHandler(r, tc["signup.page.html"], signupUsecase)
 	delivery.NewSignupHandler(r, signupUsecase)
 	delivery.NewTopupPageHandler(r, tc["topup.page.html"], topupPageUsecase)
 	delivery.NewTopupHandler(r, topupUsecase)
@@ -64,5 +156,45 @@ func main() {
 	delivery.NewTransferHandler(r, transferUsecase)
 	delivery.NewUpdateHandler(r, updateTokenusecase, tc["update.page.html"])
 	delivery.NewAddWalletHandler(r, addWalletUsecase)
-	fasthttp.ListenAndServe(":8080", r.Handler)
+	delivery.NewSessionsHandler(r, sessionsUsecase, tc["sessions.page.html"])
+	delivery.NewProfileHandler(r, profileUsecase, loginAttemptsUsecase, tc["profile.page.html"])
+	delivery.NewAdminHandler(r, adminUsecase, tc["admin.users.page.html"], tc["admin.user.page.html"])
+	delivery.NewAuditHandler(r, auditUsecase, tc["admin.audit.page.html"])
+	delivery.NewOAuthHandler(r, oauthUsecase, tc["oauth.consent.page.html"])
+	delivery.NewOIDCHandler(r, oauthUsecase)
+	delivery.NewJWKSHandler(r)
+	delivery.NewAPIHandler(r, getInfoUsecase, getTransactionsUsecase, topupUsecase, transferUsecase, addWalletUsecase)
+	grpcServer, err := newGRPCServer()
+	if err != nil {
+		log.Fatalf("gRPC server error: %v", err)
+	}
+	delivery.NewGRPCHandler(grpcServer, loginUsecase, loginAttemptsUsecase, mfaUsecase, updateTokenusecase, getInfoUsecase, sessionsUsecase, logoutUsecase)
+	grpcAddr := os.Getenv("GRPC_ADDR")
+	if grpcAddr == "" {
+		grpcAddr = ":9090"
+	}
+	lis, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		log.Fatalf("gRPC listen error: %v", err)
+	}
+	go func() {
+		if err := grpcServer.Serve(lis); err != nil {
+			logger.Error("gRPC server stopped", "err", err)
+		}
+	}()
+	fasthttp.ListenAndServe(":8080", middleware.RequestLog(middleware.CSRF(r.Handler, delivery.CSRFExemptPaths...)))
+}
+
+// newGRPCServer makes gRPC server logging calls with their request IDs, serving TLS when GRPC_TLS_CERT and GRPC_TLS_KEY are set
+func newGRPCServer() (*grpc.Server, error) {
+	logCalls := grpc.UnaryInterceptor(middleware.UnaryRequestLog)
+	cert, key := os.Getenv("GRPC_TLS_CERT"), os.Getenv("GRPC_TLS_KEY")
+	if cert == "" && key == "" {
+		return grpc.NewServer(logCalls), nil
+	}
+	creds, err := credentials.NewServerTLSFromFile(cert, key)
+	if err != nil {
+		return nil, err
+	}
+	return grpc.NewServer(grpc.Creds(creds), logCalls), nil
 }
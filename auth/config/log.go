@@ -0,0 +1,38 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LogPolicy sets what is logged and how
+type LogPolicy struct {
+	// Level is the least severe level written: debug, info, warn or error. Security events are always written
+	Level string
+	// Format is text for logfmt lines or json for log shipping
+	Format string
+}
+
+// LoadLogPolicy reads LOG_LEVEL and LOG_FORMAT, defaulting to info level text logs
+func LoadLogPolicy() (*LogPolicy, error) {
+	p := &LogPolicy{
+		Level:  strings.ToLower(strings.TrimSpace(os.Getenv("LOG_LEVEL"))),
+		Format: strings.ToLower(strings.TrimSpace(os.Getenv("LOG_FORMAT"))),
+	}
+	switch p.Level {
+	case "":
+		p.Level = "info"
+	case "debug", "info", "warn", "error":
+	default:
+		return nil, fmt.Errorf("invalid LOG_LEVEL: %q", p.Level)
+	}
+	switch p.Format {
+	case "":
+		p.Format = "text"
+	case "text", "json":
+	default:
+		return nil, fmt.Errorf("invalid LOG_FORMAT: %q", p.Format)
+	}
+	return p, nil
+}
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadLogPolicy(t *testing.T) {
+	os.Unsetenv("LOG_LEVEL")
+	os.Unsetenv("LOG_FORMAT")
+	p, err := LoadLogPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, &LogPolicy{Level: "info", Format: "text"}, p)
+
+	os.Setenv("LOG_LEVEL", "DEBUG")
+	os.Setenv("LOG_FORMAT", "json")
+	defer os.Unsetenv("LOG_LEVEL")
+	defer os.Unsetenv("LOG_FORMAT")
+	p, err = LoadLogPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, &LogPolicy{Level: "debug", Format: "json"}, p)
+
+	os.Setenv("LOG_LEVEL", "verbose")
+	_, err = LoadLogPolicy()
+	assert.Error(t, err)
+
+	os.Setenv("LOG_LEVEL", "warn")
+	os.Setenv("LOG_FORMAT", "xml")
+	_, err = LoadLogPolicy()
+	assert.Error(t, err)
+}
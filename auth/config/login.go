@@ -0,0 +1,81 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// LoginPolicy drives brute-force protection of the login endpoint
+type LoginPolicy struct {
+	// MaxFailures failed attempts for one username lock it for Lockout
+	MaxFailures int
+	// MaxIPFailures failed attempts from one IP lock the IP for Lockout
+	MaxIPFailures int
+	// FreeAttempts failures are allowed without delay, every next one doubles the delay starting from BaseDelay
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	// Window is how long failures are remembered after the last one
+	Window  time.Duration
+	Lockout time.Duration
+}
+
+// LoadLoginPolicy reads LOGIN_* variables, falling back to defaults for unset ones
+func LoadLoginPolicy() (*LoginPolicy, error) {
+	var err error
+	p := &LoginPolicy{}
+	if p.MaxFailures, err = intEnv("LOGIN_MAX_FAILURES", 5); err != nil {
+		return nil, err
+	}
+	if p.MaxIPFailures, err = intEnv("LOGIN_MAX_IP_FAILURES", 20); err != nil {
+		return nil, err
+	}
+	if p.FreeAttempts, err = intEnv("LOGIN_FREE_ATTEMPTS", 2); err != nil {
+		return nil, err
+	}
+	if p.BaseDelay, err = durationEnv("LOGIN_BASE_DELAY", time.Second); err != nil {
+		return nil, err
+	}
+	if p.MaxDelay, err = durationEnv("LOGIN_MAX_DELAY", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if p.Window, err = durationEnv("LOGIN_FAILURE_WINDOW", 15*time.Minute); err != nil {
+		return nil, err
+	}
+	if p.Lockout, err = durationEnv("LOGIN_LOCKOUT", 15*time.Minute); err != nil {
+		return nil, err
+	}
+	if p.MaxFailures <= 0 || p.MaxIPFailures <= 0 || p.FreeAttempts < 0 || p.Window <= 0 || p.Lockout <= 0 {
+		return nil, fmt.Errorf("invalid login policy: %+v", *p)
+	}
+	return p, nil
+}
+
+// Delay returns how long to wait after the last of failures before the next attempt
+func (p *LoginPolicy) Delay(failures int) time.Duration {
+	if failures <= p.FreeAttempts || p.BaseDelay <= 0 {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+func intEnv(key string, def int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	return n, nil
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadLoginPolicyDefaults(t *testing.T) {
+	os.Unsetenv("LOGIN_MAX_FAILURES")
+	p, err := LoadLoginPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, 5, p.MaxFailures)
+	assert.Equal(t, 20, p.MaxIPFailures)
+	assert.Equal(t, 15*time.Minute, p.Lockout)
+}
+
+func TestLoadLoginPolicyInvalid(t *testing.T) {
+	os.Setenv("LOGIN_MAX_FAILURES", "zero")
+	defer os.Unsetenv("LOGIN_MAX_FAILURES")
+	_, err := LoadLoginPolicy()
+	assert.Error(t, err)
+
+	os.Setenv("LOGIN_MAX_FAILURES", "0")
+	_, err = LoadLoginPolicy()
+	assert.Error(t, err)
+}
+
+func TestLoginPolicyDelay(t *testing.T) {
+	p := &LoginPolicy{FreeAttempts: 2, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	for failures, expected := range map[int]time.Duration{
+		0: 0,
+		2: 0,
+		3: time.Second,
+		4: 2 * time.Second,
+		5: 4 * time.Second,
+		6: 5 * time.Second,
+		9: 5 * time.Second,
+	} {
+		assert.Equal(t, expected, p.Delay(failures), "failures %d", failures)
+	}
+}
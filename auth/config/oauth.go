@@ -0,0 +1,65 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// OAuthPolicy drives tokens issued to OAuth clients
+type OAuthPolicy struct {
+	// CodeTtl is how long an authorization code can be exchanged for tokens
+	CodeTtl time.Duration
+	// RefreshTtl is lifetime of refresh tokens of clients, rotated on every use
+	RefreshTtl time.Duration
+	// IDTokenTtl is lifetime of OpenID Connect ID tokens
+	IDTokenTtl time.Duration
+	// ServiceTokenTtl is lifetime of access tokens of service clients, they get no refresh tokens
+	ServiceTokenTtl time.Duration
+	// Issuer is put into ID tokens as iss and is the base of OpenID Connect discovery
+	Issuer string
+}
+
+// LoadOAuthPolicy reads OAUTH_CODE_TTL, OAUTH_REFRESH_TTL, OAUTH_ID_TOKEN_TTL, OAUTH_SERVICE_TOKEN_TTL and OIDC_ISSUER,
+// falling back to defaults for unset ones. The issuer defaults to APP_URL
+func LoadOAuthPolicy() (*OAuthPolicy, error) {
+	var err error
+	p := &OAuthPolicy{Issuer: strings.TrimRight(os.Getenv("OIDC_ISSUER"), "/")}
+	if p.CodeTtl, err = durationEnv("OAUTH_CODE_TTL", time.Minute); err != nil {
+		return nil, err
+	}
+	if p.RefreshTtl, err = durationEnv("OAUTH_REFRESH_TTL", 720*time.Hour); err != nil {
+		return nil, err
+	}
+	if p.IDTokenTtl, err = durationEnv("OAUTH_ID_TOKEN_TTL", 5*time.Minute); err != nil {
+		return nil, err
+	}
+	if p.ServiceTokenTtl, err = durationEnv("OAUTH_SERVICE_TOKEN_TTL", 5*time.Minute); err != nil {
+		return nil, err
+	}
+	if p.CodeTtl <= 0 || p.CodeTtl > 10*time.Minute {
+		return nil, fmt.Errorf("invalid OAUTH_CODE_TTL: %v, must be up to 10m", p.CodeTtl)
+	}
+	if p.RefreshTtl <= 0 {
+		return nil, fmt.Errorf("invalid OAUTH_REFRESH_TTL: %v", p.RefreshTtl)
+	}
+	if p.IDTokenTtl <= 0 {
+		return nil, fmt.Errorf("invalid OAUTH_ID_TOKEN_TTL: %v", p.IDTokenTtl)
+	}
+	if p.ServiceTokenTtl <= 0 || p.ServiceTokenTtl > time.Hour {
+		return nil, fmt.Errorf("invalid OAUTH_SERVICE_TOKEN_TTL: %v, must be up to 1h", p.ServiceTokenTtl)
+	}
+	if p.Issuer == "" {
+		p.Issuer = strings.TrimRight(os.Getenv("APP_URL"), "/")
+	}
+	if p.Issuer == "" {
+		p.Issuer = "http://localhost:8080"
+	}
+	// OpenID Connect issuer is a URL without query and fragment
+	if u, err := url.Parse(p.Issuer); err != nil || u.Scheme == "" || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("invalid OIDC_ISSUER: %q", p.Issuer)
+	}
+	return p, nil
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadOAuthPolicy(t *testing.T) {
+	os.Unsetenv("OAUTH_CODE_TTL")
+	os.Unsetenv("OIDC_ISSUER")
+	os.Setenv("OAUTH_REFRESH_TTL", "24h")
+	defer os.Unsetenv("OAUTH_REFRESH_TTL")
+	os.Setenv("APP_URL", "https://wallet.example.com/")
+	defer os.Unsetenv("APP_URL")
+	p, err := LoadOAuthPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, p.CodeTtl)
+	assert.Equal(t, 24*time.Hour, p.RefreshTtl)
+	assert.Equal(t, 5*time.Minute, p.IDTokenTtl)
+	assert.Equal(t, 5*time.Minute, p.ServiceTokenTtl)
+	assert.Equal(t, "https://wallet.example.com", p.Issuer)
+
+	os.Setenv("OIDC_ISSUER", "https://id.example.com/")
+	defer os.Unsetenv("OIDC_ISSUER")
+	p, err = LoadOAuthPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, "https://id.example.com", p.Issuer)
+
+	os.Setenv("OIDC_ISSUER", "https://id.example.com/?tenant=1")
+	_, err = LoadOAuthPolicy()
+	assert.Error(t, err)
+	os.Unsetenv("OIDC_ISSUER")
+
+	os.Setenv("OAUTH_SERVICE_TOKEN_TTL", "24h")
+	_, err = LoadOAuthPolicy()
+	assert.Error(t, err)
+	os.Unsetenv("OAUTH_SERVICE_TOKEN_TTL")
+
+	os.Setenv("OAUTH_CODE_TTL", "1h")
+	defer os.Unsetenv("OAUTH_CODE_TTL")
+	_, err = LoadOAuthPolicy()
+	assert.Error(t, err)
+
+	os.Setenv("OAUTH_CODE_TTL", "30s")
+	os.Setenv("OAUTH_REFRESH_TTL", "0s")
+	_, err = LoadOAuthPolicy()
+	assert.Error(t, err)
+}
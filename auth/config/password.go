@@ -0,0 +1,101 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	HashArgon2id = "argon2id"
+	HashBcrypt   = "bcrypt"
+)
+
+// PasswordHashPolicy is used for new hashes, hashes with other parameters are upgraded on login
+type PasswordHashPolicy struct {
+	// Algorithm is HashArgon2id or HashBcrypt
+	Algorithm  string
+	BcryptCost int
+	// Argon2Memory is in KiB
+	Argon2Memory  uint32
+	Argon2Time    uint32
+	Argon2Threads uint8
+}
+
+// LoadPasswordHashPolicy reads PASSWORD_HASH, BCRYPT_COST and ARGON2_* variables, falling back to defaults for unset ones
+func LoadPasswordHashPolicy() (*PasswordHashPolicy, error) {
+	p := &PasswordHashPolicy{Algorithm: os.Getenv("PASSWORD_HASH")}
+	if p.Algorithm == "" {
+		p.Algorithm = HashArgon2id
+	}
+	if p.Algorithm != HashArgon2id && p.Algorithm != HashBcrypt {
+		return nil, fmt.Errorf("PASSWORD_HASH: unknown algorithm %q", p.Algorithm)
+	}
+	cost, err := intEnv("BCRYPT_COST", 12)
+	if err != nil {
+		return nil, err
+	}
+	memory, err := intEnv("ARGON2_MEMORY", 64*1024)
+	if err != nil {
+		return nil, err
+	}
+	iterations, err := intEnv("ARGON2_TIME", 3)
+	if err != nil {
+		return nil, err
+	}
+	threads, err := intEnv("ARGON2_THREADS", 2)
+	if err != nil {
+		return nil, err
+	}
+	// bcrypt accepts costs 4 to 31
+	if cost < 4 || cost > 31 || memory < 8*threads || iterations < 1 || threads < 1 || threads > 255 {
+		return nil, fmt.Errorf("invalid password hash policy: cost=%d memory=%d time=%d threads=%d", cost, memory, iterations, threads)
+	}
+	p.BcryptCost = cost
+	p.Argon2Memory, p.Argon2Time, p.Argon2Threads = uint32(memory), uint32(iterations), uint8(threads)
+	return p, nil
+}
+
+// PasswordPolicy is checked when password is set, and on login to warn about passwords not meeting it anymore
+type PasswordPolicy struct {
+	MinLength int
+	MaxLength int
+	// Classes are required character classes: lower, upper, digit, special
+	Classes []string
+	// BreachedList is file of common or breached passwords, one per line in plain text or as SHA-1 hex.
+	// Empty disables the check
+	BreachedList string
+}
+
+var passwordClasses = map[string]bool{"lower": true, "upper": true, "digit": true, "special": true}
+
+// LoadPasswordPolicy reads PASSWORD_* variables, falling back to defaults for unset ones
+func LoadPasswordPolicy() (*PasswordPolicy, error) {
+	var err error
+	p := &PasswordPolicy{BreachedList: os.Getenv("PASSWORD_BREACHED_LIST")}
+	if p.MinLength, err = intEnv("PASSWORD_MIN_LENGTH", 8); err != nil {
+		return nil, err
+	}
+	// bcrypt ignores bytes after 72nd
+	if p.MaxLength, err = intEnv("PASSWORD_MAX_LENGTH", 72); err != nil {
+		return nil, err
+	}
+	if p.MinLength < 1 || p.MaxLength < p.MinLength {
+		return nil, fmt.Errorf("invalid password length limits: %d-%d", p.MinLength, p.MaxLength)
+	}
+	classes, ok := os.LookupEnv("PASSWORD_CLASSES")
+	if !ok {
+		classes = "lower,upper,digit,special"
+	}
+	for _, class := range strings.Split(classes, ",") {
+		class = strings.TrimSpace(class)
+		if class == "" {
+			continue
+		}
+		if !passwordClasses[class] {
+			return nil, fmt.Errorf("PASSWORD_CLASSES: unknown class %q", class)
+		}
+		p.Classes = append(p.Classes, class)
+	}
+	return p, nil
+}
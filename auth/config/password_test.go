@@ -0,0 +1,61 @@
+package config
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadPasswordHashPolicy(t *testing.T) {
+	for _, key := range []string{"PASSWORD_HASH", "BCRYPT_COST", "ARGON2_MEMORY", "ARGON2_TIME", "ARGON2_THREADS"} {
+		os.Unsetenv(key)
+	}
+	p, err := LoadPasswordHashPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, &PasswordHashPolicy{Algorithm: HashArgon2id, BcryptCost: 12, Argon2Memory: 64 * 1024, Argon2Time: 3, Argon2Threads: 2}, p)
+
+	os.Setenv("PASSWORD_HASH", "md5")
+	_, err = LoadPasswordHashPolicy()
+	assert.Error(t, err)
+
+	os.Setenv("PASSWORD_HASH", HashBcrypt)
+	defer os.Unsetenv("PASSWORD_HASH")
+	os.Setenv("BCRYPT_COST", "3")
+	defer os.Unsetenv("BCRYPT_COST")
+	_, err = LoadPasswordHashPolicy()
+	assert.Error(t, err)
+
+	os.Setenv("BCRYPT_COST", "11")
+	p, err = LoadPasswordHashPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, HashBcrypt, p.Algorithm)
+	assert.Equal(t, 11, p.BcryptCost)
+}
+
+func TestLoadPasswordPolicy(t *testing.T) {
+	for _, key := range []string{"PASSWORD_MIN_LENGTH", "PASSWORD_MAX_LENGTH", "PASSWORD_CLASSES", "PASSWORD_BREACHED_LIST"} {
+		os.Unsetenv(key)
+	}
+	p, err := LoadPasswordPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, &PasswordPolicy{MinLength: 8, MaxLength: 72, Classes: []string{"lower", "upper", "digit", "special"}}, p)
+
+	os.Setenv("PASSWORD_CLASSES", "")
+	defer os.Unsetenv("PASSWORD_CLASSES")
+	p, err = LoadPasswordPolicy()
+	assert.NoError(t, err)
+	assert.Empty(t, p.Classes)
+
+	os.Setenv("PASSWORD_CLASSES", "lower, emoji")
+	_, err = LoadPasswordPolicy()
+	assert.Error(t, err)
+
+	os.Setenv("PASSWORD_CLASSES", "digit")
+	os.Setenv("PASSWORD_MIN_LENGTH", "10")
+	defer os.Unsetenv("PASSWORD_MIN_LENGTH")
+	os.Setenv("PASSWORD_MAX_LENGTH", "9")
+	defer os.Unsetenv("PASSWORD_MAX_LENGTH")
+	_, err = LoadPasswordPolicy()
+	assert.Error(t, err)
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Keys requests are limited by
+const (
+	RateKeyIP     = "ip"
+	RateKeyIIN    = "iin"
+	RateKeyAPIKey = "apikey"
+)
+
+// RateLimit allows Limit requests per Window for every value of Key, zero Limit disables limiting
+type RateLimit struct {
+	Limit  int
+	Window time.Duration
+	Key    string
+}
+
+// defaultRateLimits are used for routes not set in RATE_LIMITS
+var defaultRateLimits = map[string]RateLimit{
+	"login":    {Limit: 10, Window: time.Minute, Key: RateKeyIP},
+	"signup":   {Limit: 5, Window: time.Hour, Key: RateKeyIP},
+	"forgot":   {Limit: 5, Window: time.Hour, Key: RateKeyIP},
+	"topup":    {Limit: 30, Window: time.Minute, Key: RateKeyIIN},
+	"transfer": {Limit: 30, Window: time.Minute, Key: RateKeyIIN},
+	"add":      {Limit: 5, Window: time.Hour, Key: RateKeyIIN},
+	"token":    {Limit: 60, Window: time.Minute, Key: RateKeyIP},
+}
+
+// LoadRateLimits reads per route limits from RATE_LIMITS, e.g. "login=10/1m:ip,add=5/1h:iin"
+func LoadRateLimits() (map[string]RateLimit, error) {
+	limits := make(map[string]RateLimit, len(defaultRateLimits))
+	for route, limit := range defaultRateLimits {
+		limits[route] = limit
+	}
+	custom, err := ParseRateLimits(os.Getenv("RATE_LIMITS"))
+	if err != nil {
+		return nil, fmt.Errorf("RATE_LIMITS: %w", err)
+	}
+	for route, limit := range custom {
+		limits[route] = limit
+	}
+	return limits, nil
+}
+
+// ParseRateLimits parses comma separated route=limit/window[:key] rules, key defaults to ip
+func ParseRateLimits(value string) (map[string]RateLimit, error) {
+	limits := map[string]RateLimit{}
+	for _, rule := range strings.Split(value, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		route, spec, ok := cut(rule, "=")
+		if !ok || route == "" {
+			return nil, fmt.Errorf("invalid rule %q", rule)
+		}
+		spec, key, hasKey := cut(spec, ":")
+		if !hasKey {
+			key = RateKeyIP
+		}
+		if key != RateKeyIP && key != RateKeyIIN && key != RateKeyAPIKey {
+			return nil, fmt.Errorf("unknown key %q in rule %q", key, rule)
+		}
+		count, window, ok := cut(spec, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid rule %q", rule)
+		}
+		var limit RateLimit
+		var err error
+		if limit.Limit, err = strconv.Atoi(count); err != nil || limit.Limit < 0 {
+			return nil, fmt.Errorf("invalid limit in rule %q", rule)
+		}
+		if limit.Window, err = time.ParseDuration(window); err != nil || limit.Window <= 0 {
+			return nil, fmt.Errorf("invalid window in rule %q", rule)
+		}
+		limit.Key = key
+		limits[route] = limit
+	}
+	return limits, nil
+}
+
+// cut slices s around the first instance of sep
+func cut(s, sep string) (string, string, bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := ParseRateLimits("login=3/10s, add=1/1h:iin,api=100/1m:apikey,off=0/1m")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]RateLimit{
+		"login": {Limit: 3, Window: 10 * time.Second, Key: RateKeyIP},
+		"add":   {Limit: 1, Window: time.Hour, Key: RateKeyIIN},
+		"api":   {Limit: 100, Window: time.Minute, Key: RateKeyAPIKey},
+		"off":   {Limit: 0, Window: time.Minute, Key: RateKeyIP},
+	}, limits)
+
+	for _, rule := range []string{"login", "login=3", "login=x/1m", "login=3/x", "login=3/0s", "login=3/1m:user", "=3/1m"} {
+		_, err := ParseRateLimits(rule)
+		assert.Error(t, err, rule)
+	}
+}
+
+func TestLoadRateLimits(t *testing.T) {
+	os.Setenv("RATE_LIMITS", "login=1/1s")
+	defer os.Unsetenv("RATE_LIMITS")
+	limits, err := LoadRateLimits()
+	assert.NoError(t, err)
+	assert.Equal(t, RateLimit{Limit: 1, Window: time.Second, Key: RateKeyIP}, limits["login"])
+	assert.Equal(t, defaultRateLimits["add"], limits["add"])
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// PasswordResetPolicy drives password reset links sent by mail
+type PasswordResetPolicy struct {
+	TokenTtl time.Duration
+	// BaseURL is public address of the service used to build links in mails
+	BaseURL string
+}
+
+// LoadPasswordResetPolicy reads RESET_TOKEN_TTL and APP_URL, falling back to defaults for unset ones
+func LoadPasswordResetPolicy() (*PasswordResetPolicy, error) {
+	var err error
+	p := &PasswordResetPolicy{BaseURL: strings.TrimRight(os.Getenv("APP_URL"), "/")}
+	if p.TokenTtl, err = durationEnv("RESET_TOKEN_TTL", 30*time.Minute); err != nil {
+		return nil, err
+	}
+	if p.TokenTtl <= 0 {
+		return nil, fmt.Errorf("invalid RESET_TOKEN_TTL: %v", p.TokenTtl)
+	}
+	if p.BaseURL == "" {
+		p.BaseURL = "http://localhost:8080"
+	}
+	if u, err := url.Parse(p.BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid APP_URL: %q", p.BaseURL)
+	}
+	return p, nil
+}
@@ -0,0 +1,29 @@
+package config
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadPasswordResetPolicy(t *testing.T) {
+	os.Unsetenv("RESET_TOKEN_TTL")
+	os.Setenv("APP_URL", "https://wallet.example.com/")
+	defer os.Unsetenv("APP_URL")
+	p, err := LoadPasswordResetPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Minute, p.TokenTtl)
+	assert.Equal(t, "https://wallet.example.com", p.BaseURL)
+
+	os.Setenv("APP_URL", "wallet")
+	_, err = LoadPasswordResetPolicy()
+	assert.Error(t, err)
+
+	os.Unsetenv("APP_URL")
+	os.Setenv("RESET_TOKEN_TTL", "0s")
+	defer os.Unsetenv("RESET_TOKEN_TTL")
+	_, err = LoadPasswordResetPolicy()
+	assert.Error(t, err)
+}
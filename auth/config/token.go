@@ -0,0 +1,122 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// CookiePolicy holds attributes of the auth cookies
+type CookiePolicy struct {
+	Secure   bool
+	Domain   string
+	Path     string
+	SameSite fasthttp.CookieSameSite
+}
+
+// TokenPolicy drives token expiration, cookie lifetime and refresh token ttl in redis
+type TokenPolicy struct {
+	AccessTtl  time.Duration
+	RefreshTtl time.Duration
+	// RememberMeTtl is refresh ttl used when user asks to be remembered at login, 0 disables the mode
+	RememberMeTtl time.Duration
+	// MFATtl is lifetime of the token between password and two-factor code steps of login
+	MFATtl time.Duration
+	Cookie CookiePolicy
+}
+
+var (
+	defaultOnce   sync.Once
+	defaultPolicy *TokenPolicy
+	defaultErr    error
+)
+
+// DefaultTokenPolicy returns token policy loaded from environment on first use
+func DefaultTokenPolicy() (*TokenPolicy, error) {
+	defaultOnce.Do(func() {
+		defaultPolicy, defaultErr = LoadTokenPolicy()
+	})
+	return defaultPolicy, defaultErr
+}
+
+// LoadTokenPolicy reads ACCESS_TTL, REFRESH_TTL, REMEMBER_ME_TTL, MFA_PENDING_TTL and COOKIE_* variables,
+// falling back to defaults for unset ones
+func LoadTokenPolicy() (*TokenPolicy, error) {
+	var err error
+	p := &TokenPolicy{
+		Cookie: CookiePolicy{
+			Domain: os.Getenv("COOKIE_DOMAIN"),
+			Path:   "/",
+		},
+	}
+	if p.AccessTtl, err = durationEnv("ACCESS_TTL", 20*time.Second); err != nil {
+		return nil, err
+	}
+	if p.RefreshTtl, err = durationEnv("REFRESH_TTL", 10*time.Minute); err != nil {
+		return nil, err
+	}
+	if p.RememberMeTtl, err = durationEnv("REMEMBER_ME_TTL", 30*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if p.MFATtl, err = durationEnv("MFA_PENDING_TTL", 5*time.Minute); err != nil {
+		return nil, err
+	}
+	if p.MFATtl <= 0 {
+		return nil, fmt.Errorf("invalid MFA_PENDING_TTL: %v", p.MFATtl)
+	}
+	if p.AccessTtl <= 0 || p.RefreshTtl < p.AccessTtl {
+		return nil, fmt.Errorf("invalid token ttl: access %v, refresh %v", p.AccessTtl, p.RefreshTtl)
+	}
+	if path := os.Getenv("COOKIE_PATH"); path != "" {
+		p.Cookie.Path = path
+	}
+	if secure := os.Getenv("COOKIE_SECURE"); secure != "" {
+		if p.Cookie.Secure, err = strconv.ParseBool(secure); err != nil {
+			return nil, fmt.Errorf("COOKIE_SECURE: %w", err)
+		}
+	}
+	if p.Cookie.SameSite, err = parseSameSite(os.Getenv("COOKIE_SAMESITE")); err != nil {
+		return nil, err
+	}
+	if p.Cookie.SameSite == fasthttp.CookieSameSiteNoneMode && !p.Cookie.Secure {
+		return nil, fmt.Errorf("COOKIE_SAMESITE=none requires COOKIE_SECURE=true")
+	}
+	return p, nil
+}
+
+// RefreshTtlFor returns refresh token ttl for the chosen login mode
+func (p *TokenPolicy) RefreshTtlFor(rememberMe bool) time.Duration {
+	if rememberMe && p.RememberMeTtl > 0 {
+		return p.RememberMeTtl
+	}
+	return p.RefreshTtl
+}
+
+func durationEnv(key string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	return d, nil
+}
+
+func parseSameSite(value string) (fasthttp.CookieSameSite, error) {
+	switch strings.ToLower(value) {
+	case "", "lax":
+		return fasthttp.CookieSameSiteLaxMode, nil
+	case "strict":
+		return fasthttp.CookieSameSiteStrictMode, nil
+	case "none":
+		return fasthttp.CookieSameSiteNoneMode, nil
+	}
+	return 0, fmt.Errorf("COOKIE_SAMESITE: unknown mode %q", value)
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+var envKeys = []string{"ACCESS_TTL", "REFRESH_TTL", "REMEMBER_ME_TTL", "MFA_PENDING_TTL", "COOKIE_SECURE", "COOKIE_DOMAIN", "COOKIE_PATH", "COOKIE_SAMESITE"}
+
+func setEnv(t *testing.T, env map[string]string) {
+	for _, key := range envKeys {
+		os.Unsetenv(key)
+	}
+	for key, value := range env {
+		os.Setenv(key, value)
+	}
+	t.Cleanup(func() {
+		for _, key := range envKeys {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func TestLoadTokenPolicyDefaults(t *testing.T) {
+	setEnv(t, nil)
+	p, err := LoadTokenPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, 20*time.Second, p.AccessTtl)
+	assert.Equal(t, 10*time.Minute, p.RefreshTtl)
+	assert.Equal(t, 10*time.Minute, p.RefreshTtlFor(false))
+	assert.Equal(t, 30*24*time.Hour, p.RefreshTtlFor(true))
+	assert.Equal(t, 5*time.Minute, p.MFATtl)
+	assert.Equal(t, "/", p.Cookie.Path)
+	assert.False(t, p.Cookie.Secure)
+	assert.Equal(t, fasthttp.CookieSameSiteLaxMode, p.Cookie.SameSite)
+}
+
+func TestLoadTokenPolicy(t *testing.T) {
+	setEnv(t, map[string]string{
+		"ACCESS_TTL":      "1m",
+		"REFRESH_TTL":     "1h",
+		"REMEMBER_ME_TTL": "0",
+		"COOKIE_SECURE":   "true",
+		"COOKIE_DOMAIN":   "example.com",
+		"COOKIE_PATH":     "/app",
+		"COOKIE_SAMESITE": "None",
+	})
+	p, err := LoadTokenPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, p.AccessTtl)
+	assert.Equal(t, time.Hour, p.RefreshTtlFor(true))
+	assert.True(t, p.Cookie.Secure)
+	assert.Equal(t, "example.com", p.Cookie.Domain)
+	assert.Equal(t, "/app", p.Cookie.Path)
+	assert.Equal(t, fasthttp.CookieSameSiteNoneMode, p.Cookie.SameSite)
+}
+
+var invalidPolicyTable = []struct {
+	name string
+	env  map[string]string
+}{
+	{"bad duration", map[string]string{"ACCESS_TTL": "soon"}},
+	{"refresh shorter than access", map[string]string{"ACCESS_TTL": "1h", "REFRESH_TTL": "1m"}},
+	{"non-positive mfa ttl", map[string]string{"MFA_PENDING_TTL": "0s"}},
+	{"bad secure flag", map[string]string{"COOKIE_SECURE": "maybe"}},
+	{"unknown samesite", map[string]string{"COOKIE_SAMESITE": "sometimes"}},
+	{"samesite none without secure", map[string]string{"COOKIE_SAMESITE": "none"}},
+}
+
+func TestLoadTokenPolicyError(t *testing.T) {
+	for _, tt := range invalidPolicyTable {
+		setEnv(t, tt.env)
+		_, err := LoadTokenPolicy()
+		assert.Error(t, err, tt.name)
+	}
+}
@@ -0,0 +1,33 @@
+package domain
+
+// Admin actions recorded in admin_actions table
+const (
+	AdminActionLock          = "lock"
+	AdminActionUnlock        = "unlock"
+	AdminActionForceLogout   = "force_logout"
+	AdminActionPasswordReset = "password_reset"
+)
+
+type AdminAction struct {
+	ID        int    `json:"id"`
+	Ts        string `json:"ts"`
+	AdminIIN  string `json:"adminIin"`
+	Action    string `json:"action"`
+	TargetIIN string `json:"targetIin"`
+	Details   string `json:"details"`
+}
+
+// AdminInfo is rendered on admin pages and returned to admin API clients
+type AdminInfo struct {
+	Query        string        `json:"query,omitempty"`
+	Users        []User        `json:"users,omitempty"`
+	User         *User         `json:"user,omitempty"`
+	Wallets      []Wallet      `json:"wallets,omitempty"`
+	AccountNo    string        `json:"account,omitempty"`
+	Transactions []Transaction `json:"transactions,omitempty"`
+	Sessions     []Session     `json:"sessions,omitempty"`
+	Actions      []AdminAction `json:"actions,omitempty"`
+	// LoginAttempts shows failed logins and temporary lockout of the user
+	LoginAttempts *LoginAttempts `json:"loginAttempts,omitempty"`
+	Error         string         `json:"error,omitempty"`
+}
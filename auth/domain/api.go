@@ -0,0 +1,47 @@
+package domain
+
+// APIUser is user resource of /api/v1, password hash and lock state are not exposed
+type APIUser struct {
+	IIN         string   `json:"iin"`
+	Username    string   `json:"username"`
+	Email       string   `json:"email,omitempty"`
+	CreatedAt   string   `json:"createdAt"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
+
+// Page is envelope of /api/v1 list resources, Next is link to the following page if there is one
+type Page struct {
+	Items  interface{} `json:"items"`
+	Total  int         `json:"total"`
+	Limit  int         `json:"limit"`
+	Offset int         `json:"offset"`
+	Next   string      `json:"next,omitempty"`
+}
+
+// TopupRequest is body of POST /api/v1/topup
+type TopupRequest struct {
+	Account string `json:"account"`
+	Amount  int    `json:"amount"`
+}
+
+// TransferRequest is body of POST /api/v1/transfer
+type TransferRequest struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Amount int    `json:"amount"`
+}
+
+// Operation is result of topup or transfer, Message is returned by wallet service
+type Operation struct {
+	Type    string `json:"type"`
+	From    string `json:"from,omitempty"`
+	To      string `json:"to"`
+	Amount  int    `json:"amount"`
+	Message string `json:"message"`
+}
+
+// NewWallet is result of POST /api/v1/wallets
+type NewWallet struct {
+	AccountNo string `json:"accountno"`
+}
@@ -0,0 +1,86 @@
+package domain
+
+// Security events recorded in audit_log table. Admin actions are recorded as "admin_" followed by AdminAction
+const (
+	AuditLogin                = "login"
+	AuditLoginMFA             = "login_mfa"
+	AuditLogout               = "logout"
+	AuditSignup               = "signup"
+	AuditTokenRefresh         = "token_refresh"
+	AuditTopup                = "topup"
+	AuditTransfer             = "transfer"
+	AuditWalletCreate         = "wallet_create"
+	AuditPasswordChange       = "password_change"
+	AuditPasswordResetRequest = "password_reset_request"
+	AuditPasswordReset        = "password_reset"
+	AuditUsernameChange       = "username_change"
+	AuditMFAEnable            = "mfa_enable"
+	AuditMFADisable           = "mfa_disable"
+	AuditSessionRevoke        = "session_revoke"
+	AuditOAuthConsent         = "oauth_consent"
+	AuditOAuthToken           = "oauth_token"
+	AuditOAuthRevoke          = "oauth_revoke"
+	AuditOAuthClientRegister  = "oauth_client_register"
+	AuditAdminLock            = "admin_" + AdminActionLock
+	AuditAdminUnlock          = "admin_" + AdminActionUnlock
+	AuditAdminForceLogout     = "admin_" + AdminActionForceLogout
+	AuditAdminPasswordReset   = "admin_" + AdminActionPasswordReset
+)
+
+// AuditActions are listed in the action filter of audit log page
+var AuditActions = []string{
+	AuditLogin, AuditLoginMFA, AuditLogout, AuditSignup, AuditTokenRefresh, AuditTopup, AuditTransfer, AuditWalletCreate,
+	AuditPasswordChange, AuditPasswordResetRequest, AuditPasswordReset, AuditUsernameChange, AuditMFAEnable, AuditMFADisable,
+	AuditSessionRevoke, AuditOAuthConsent, AuditOAuthToken, AuditOAuthRevoke, AuditOAuthClientRegister,
+	AuditAdminLock, AuditAdminUnlock, AuditAdminForceLogout, AuditAdminPasswordReset,
+}
+
+// Outcomes of audited actions. Failure is a rejected request, error is a failure of the service itself
+const (
+	AuditSuccess     = "success"
+	AuditFailure     = "failure"
+	AuditError       = "error"
+	AuditMFARequired = "mfa_required"
+)
+
+// AuditOutcomes are listed in the outcome filter of audit log page
+var AuditOutcomes = []string{AuditSuccess, AuditFailure, AuditError, AuditMFARequired}
+
+// AuditEvent is a security relevant action. ActorIIN is empty when the actor is unknown, like failed login
+// of a nonexistent user, Target is what the action was done to: username, account or IIN
+type AuditEvent struct {
+	ID        int    `json:"id"`
+	Ts        string `json:"ts"`
+	ActorIIN  string `json:"actorIin"`
+	Action    string `json:"action"`
+	Target    string `json:"target"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"userAgent"`
+	Outcome   string `json:"outcome"`
+}
+
+// AuditFilter selects audit events, empty fields match everything. From and To are inclusive dates as 2006-01-02
+type AuditFilter struct {
+	ActorIIN string `json:"actor,omitempty"`
+	Action   string `json:"action,omitempty"`
+	Target   string `json:"target,omitempty"`
+	Outcome  string `json:"outcome,omitempty"`
+	From     string `json:"from,omitempty"`
+	To       string `json:"to,omitempty"`
+	Limit    int    `json:"limit"`
+	Offset   int    `json:"offset"`
+}
+
+// AuditLog is rendered on audit log page and returned to admin API clients
+type AuditLog struct {
+	Filter AuditFilter  `json:"filter"`
+	Events []AuditEvent `json:"events"`
+	Total  int          `json:"total"`
+	// Prev and Next are links to neighbour pages with the same filter
+	Prev  string `json:"prev,omitempty"`
+	Next  string `json:"next,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Actions and Outcomes fill filter selects of the page
+	Actions  []string `json:"-"`
+	Outcomes []string `json:"-"`
+}
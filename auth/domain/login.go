@@ -0,0 +1,15 @@
+package domain
+
+import "time"
+
+// LoginAttempts tracks failed logins of a username or an IP
+type LoginAttempts struct {
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"lastFailure"`
+	LockedUntil time.Time `json:"lockedUntil"`
+}
+
+// Locked reports whether logins are locked at the given moment
+func (a *LoginAttempts) Locked(now time.Time) bool {
+	return now.Before(a.LockedUntil)
+}
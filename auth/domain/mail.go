@@ -0,0 +1,8 @@
+package domain
+
+// Mail is a plain text message sent to a user
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
@@ -0,0 +1,21 @@
+package domain
+
+// MFA is TOTP enrollment of a user, Secret is encrypted
+type MFA struct {
+	UserID   int
+	Secret   []byte
+	Enabled  bool
+	LastStep int64
+}
+
+// MFAEnrollment is shown to user to set up authenticator app
+type MFAEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+	QRCode string `json:"qrCode,omitempty"`
+}
+
+// MFAStatus is shown on two-factor settings page
+type MFAStatus struct {
+	Enabled bool `json:"enabled"`
+}
@@ -0,0 +1,109 @@
+package domain
+
+// Scopes OAuth clients may be registered for. Scopes named after permissions grant them
+// to client tokens if the user has them, so client tokens pass the same RequirePermission checks
+const (
+	// ScopeOpenID makes the client an OpenID Connect relying party getting ID tokens and /userinfo access
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+)
+
+// OAuthScopes describe scopes on consent page
+var OAuthScopes = map[string]string{
+	ScopeOpenID:     "вход с вашим аккаунтом",
+	ScopeProfile:    "имя пользователя и ИИН",
+	PermWalletsRead: "просмотр счетов и операций",
+}
+
+// ServiceScopes are permissions service clients may be registered for, they act on their own without a user
+var ServiceScopes = map[string]string{
+	PermWalletsRead:  "просмотр счетов и операций",
+	PermWalletsWrite: "пополнение счетов и переводы",
+	PermUsersRead:    "поиск пользователей",
+}
+
+// OAuthClient is an application users sign in to with their account.
+// Public clients like mobile apps have no secret and rely on PKCE only.
+// Service clients are background jobs and other services getting tokens of their own by client_credentials grant
+type OAuthClient struct {
+	ID           int      `json:"-"`
+	ClientID     string   `json:"client_id"`
+	Name         string   `json:"client_name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"`
+	Public       bool     `json:"public"`
+	Service      bool     `json:"service"`
+	Ts           string   `json:"created_at,omitempty"`
+	// SecretHash is SHA-256 of the secret, ClientSecret is shown once on registration
+	SecretHash   string `json:"-"`
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// AuthorizationRequest is the query of /oauth/authorize
+type AuthorizationRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	// Nonce is returned in ID token to bind it to the client session
+	Nonce string
+}
+
+// AuthCode is an authorization code waiting to be exchanged for tokens
+type AuthCode struct {
+	ClientID      string `json:"client_id"`
+	IIN           string `json:"iin"`
+	RedirectURI   string `json:"redirect_uri"`
+	Scope         string `json:"scope"`
+	CodeChallenge string `json:"code_challenge"`
+	Nonce         string `json:"nonce,omitempty"`
+}
+
+// OAuthConsent is rendered on consent page, Error is shown instead when the request can't be trusted
+type OAuthConsent struct {
+	Client  *OAuthClient
+	Request AuthorizationRequest
+	Scopes  []string
+	Error   string
+}
+
+// Introspection is response of token introspection endpoint, RFC 7662. Inactive tokens have only Active set.
+// Scope of first party tokens lists permissions of the user
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Iss       string `json:"iss,omitempty"`
+	Jti       string `json:"jti,omitempty"`
+	IIN       string `json:"iin,omitempty"`
+	SessionID string `json:"sid,omitempty"`
+}
+
+// OAuthError is error response of token endpoint
+type OAuthError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// Principal is who made the request: a user, possibly through an OAuth client, or a service client on its own
+type Principal struct {
+	Kind string
+	// IIN is set for users only
+	IIN      string
+	ClientID string
+	Scopes   []string
+}
+
+// Kinds of principals
+const (
+	PrincipalUser    = "user"
+	PrincipalService = "service"
+)
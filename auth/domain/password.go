@@ -0,0 +1,7 @@
+package domain
+
+// PasswordRule is a requirement of password policy, reported when password fails it
+type PasswordRule struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
@@ -6,4 +6,11 @@ type Response struct {
 	WalletList   []string      `json:"walletList"`
 	Wallets      []Wallet      `json:"wallets"`
 	Transactions []Transaction `json:"transactions"`
+	Sessions     []Session     `json:"sessions,omitempty"`
+	// MFARequired asks client to finish login with a code, API clients get MFAToken to send along
+	MFARequired   bool     `json:"mfaRequired,omitempty"`
+	MFAToken      string   `json:"mfaToken,omitempty"`
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+	// PasswordRules are password policy rules failed by the password
+	PasswordRules []PasswordRule `json:"passwordRules,omitempty"`
 }
@@ -0,0 +1,13 @@
+package domain
+
+import "time"
+
+type Session struct {
+	ID        string    `json:"id"`
+	IIN       string    `json:"iin"`
+	UserAgent string    `json:"userAgent"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"createdAt"`
+	LastUsed  time.Time `json:"lastUsed"`
+	Current   bool      `json:"current"`
+}
@@ -0,0 +1,14 @@
+package domain
+
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	// Scope is granted to OAuth client
+	Scope string `json:"scope,omitempty"`
+	// IDToken is issued to OpenID Connect clients granted openid scope
+	IDToken string `json:"id_token,omitempty"`
+	// PasswordRules warn that the password used to login doesn't meet the current policy
+	PasswordRules []PasswordRule `json:"password_rules,omitempty"`
+}
@@ -7,4 +7,20 @@ type User struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 	IsAdmin  bool   `json:"is_admin"`
+	Locked   bool   `json:"locked"`
+	// Email is optional, password reset links are sent to it
+	Email string `json:"email,omitempty"`
+	// Roles and Permissions are loaded from user_roles, IsAdmin is set for users with admin role
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
 }
+
+// Permissions granted to roles, see role_permissions table
+const (
+	PermWalletsRead  = "wallets:read"
+	PermWalletsWrite = "wallets:write"
+	PermUsersRead    = "users:read"
+	PermUsersWrite   = "users:write"
+	PermClientsWrite = "clients:write"
+	PermAuditRead    = "audit:read"
+)
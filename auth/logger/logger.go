@@ -0,0 +1,302 @@
+// Package logger writes leveled structured logs as logfmt text or JSON lines. Every message and value
+// passes through Redact, so tokens, passwords, IINs and account numbers don't reach the logs
+package logger
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+// Levels in order of severity. Security events are always written whatever the level is
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelSecurity
+)
+
+var levelNames = map[Level]string{
+	LevelDebug:    "DEBUG",
+	LevelInfo:     "INFO",
+	LevelWarn:     "WARN",
+	LevelError:    "ERROR",
+	LevelSecurity: "SECURITY",
+}
+
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return "LEVEL(" + strconv.Itoa(int(l)) + ")"
+}
+
+// ParseLevel parses level name as set in LOG_LEVEL, case insensitive
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if level != LevelSecurity && strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", name)
+}
+
+// Output formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+const timeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// Logger writes lines at its level or above. Loggers made by With share the output of their parent
+type Logger struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	level  Level
+	json   bool
+	fields []interface{}
+}
+
+// New makes logger writing to out, format is FormatText or FormatJSON
+func New(out io.Writer, level Level, format string) *Logger {
+	return &Logger{
+		mu:    &sync.Mutex{},
+		out:   out,
+		level: level,
+		json:  format == FormatJSON,
+	}
+}
+
+// With returns logger adding key value pairs to every line
+func (l *Logger) With(keyValues ...interface{}) *Logger {
+	child := *l
+	child.fields = append(append([]interface{}{}, l.fields...), keyValues...)
+	return &child
+}
+
+// Enabled reports whether lines of level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+func (l *Logger) Debug(msg string, keyValues ...interface{}) {
+	l.write(LevelDebug, msg, keyValues)
+}
+
+func (l *Logger) Info(msg string, keyValues ...interface{}) {
+	l.write(LevelInfo, msg, keyValues)
+}
+
+func (l *Logger) Warn(msg string, keyValues ...interface{}) {
+	l.write(LevelWarn, msg, keyValues)
+}
+
+func (l *Logger) Error(msg string, keyValues ...interface{}) {
+	l.write(LevelError, msg, keyValues)
+}
+
+// Security logs security relevant event like lockout or token reuse
+func (l *Logger) Security(msg string, keyValues ...interface{}) {
+	l.write(LevelSecurity, msg, keyValues)
+}
+
+func (l *Logger) write(level Level, msg string, keyValues []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	fields := append(append([]interface{}{}, l.fields...), keyValues...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, "(missing)")
+	}
+	var line bytes.Buffer
+	if l.json {
+		writeJSON(&line, level, msg, fields)
+	} else {
+		writeText(&line, level, msg, fields)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(line.Bytes()) //nolint:errcheck
+}
+
+// writeText writes line in logfmt: key=value pairs, values quoted when needed
+func writeText(line *bytes.Buffer, level Level, msg string, fields []interface{}) {
+	line.WriteString("ts=" + time.Now().Format(timeFormat))
+	line.WriteString(" level=" + level.String())
+	line.WriteString(" msg=" + textValue(Redact(msg)))
+	for i := 0; i < len(fields); i += 2 {
+		key := fmt.Sprint(fields[i])
+		line.WriteString(" " + textKey(key) + "=" + textValue(fmt.Sprint(fieldValue(key, fields[i+1]))))
+	}
+	line.WriteByte('\n')
+}
+
+func textKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' {
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+func textValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " =\"\\") || strings.IndexFunc(value, func(r rune) bool { return r < ' ' }) >= 0 {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+// writeJSON writes line as JSON object keeping order of the fields
+func writeJSON(line *bytes.Buffer, level Level, msg string, fields []interface{}) {
+	line.WriteString(`{"ts":"` + time.Now().Format(timeFormat) + `","level":"` + level.String() + `","msg":`)
+	writeJSONValue(line, Redact(msg))
+	for i := 0; i < len(fields); i += 2 {
+		key := fmt.Sprint(fields[i])
+		line.WriteByte(',')
+		writeJSONValue(line, key)
+		line.WriteByte(':')
+		writeJSONValue(line, fieldValue(key, fields[i+1]))
+	}
+	line.WriteString("}\n")
+}
+
+func writeJSONValue(line *bytes.Buffer, value interface{}) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		encoded, _ = json.Marshal(fmt.Sprint(value))
+	}
+	line.Write(encoded)
+}
+
+// fieldValue redacts value of the key, keeping numbers and booleans as they are
+func fieldValue(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		if sensitiveKey(key) {
+			return redacted
+		}
+		return v
+	case time.Duration:
+		return v.String()
+	case error:
+		return RedactField(key, v.Error())
+	case fmt.Stringer:
+		return RedactField(key, v.String())
+	case []byte:
+		return RedactField(key, string(v))
+	}
+	return RedactField(key, fmt.Sprint(value))
+}
+
+// Writer returns writer logging every line written to it at level, standard log output is redirected to it
+func (l *Logger) Writer(level Level) io.Writer {
+	return lineWriter{l: l, level: level}
+}
+
+type lineWriter struct {
+	l     *Logger
+	level Level
+}
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	w.l.write(w.level, strings.TrimRight(string(p), "\n"), nil)
+	return len(p), nil
+}
+
+var std = New(os.Stderr, LevelInfo, FormatText)
+
+// SetDefault replaces logger used by package functions and Ctx, set on startup
+func SetDefault(l *Logger) {
+	std = l
+}
+
+// Default returns logger used by package functions
+func Default() *Logger {
+	return std
+}
+
+func Debug(msg string, keyValues ...interface{}) {
+	std.write(LevelDebug, msg, keyValues)
+}
+
+func Info(msg string, keyValues ...interface{}) {
+	std.write(LevelInfo, msg, keyValues)
+}
+
+func Warn(msg string, keyValues ...interface{}) {
+	std.write(LevelWarn, msg, keyValues)
+}
+
+func Error(msg string, keyValues ...interface{}) {
+	std.write(LevelError, msg, keyValues)
+}
+
+func Security(msg string, keyValues ...interface{}) {
+	std.write(LevelSecurity, msg, keyValues)
+}
+
+// RequestIDKey is user value of fasthttp request holding ID of the request
+const RequestIDKey = "requestID"
+
+type requestIDKey struct{}
+
+// WithRequestID returns context carrying request ID, used for gRPC calls
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns ID of the request set by WithRequestID or as fasthttp user value, empty if there is none
+func RequestID(ctx context.Context) string {
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	id, _ := ctx.Value(RequestIDKey).(string)
+	return id
+}
+
+// Ctx returns default logger adding ID of the request to every line.
+// *fasthttp.RequestCtx is a context.Context too, so it is passed as it is
+func Ctx(ctx context.Context) *Logger {
+	if id := RequestID(ctx); id != "" {
+		return std.With("request_id", id)
+	}
+	return std
+}
+
+// NewRequestID returns random ID for request that came without one
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// ValidRequestID reports whether ID sent by client can be used as it is: up to 64 letters, digits, '-', '_' or '.'
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,153 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLevel(t *testing.T) {
+	var testTable = []struct {
+		name     string
+		expected Level
+		wantErr  bool
+	}{
+		{"debug", LevelDebug, false},
+		{"INFO", LevelInfo, false},
+		{"Warn", LevelWarn, false},
+		{"error", LevelError, false},
+		{"security", LevelInfo, true},
+		{"verbose", LevelInfo, true},
+	}
+	for _, tt := range testTable {
+		level, err := ParseLevel(tt.name)
+		if (err != nil) != tt.wantErr || level != tt.expected {
+			t.Errorf("for %s, expected %v but got %v %v", tt.name, tt.expected, level, err)
+		}
+	}
+}
+
+func TestLevels(t *testing.T) {
+	var out bytes.Buffer
+	l := New(&out, LevelWarn, FormatText)
+	l.Debug("debug")
+	l.Info("info")
+	l.Warn("warn")
+	l.Error("error")
+	l.Security("security")
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines but got %q", out.String())
+	}
+	for i, level := range []string{"WARN", "ERROR", "SECURITY"} {
+		if !strings.Contains(lines[i], " level="+level+" ") {
+			t.Errorf("expected level %s in %q", level, lines[i])
+		}
+	}
+	// security events are written whatever the level is
+	out.Reset()
+	New(&out, LevelError, FormatText).Security("security")
+	if out.Len() == 0 {
+		t.Error("security event wasn't written")
+	}
+}
+
+func TestText(t *testing.T) {
+	var out bytes.Buffer
+	New(&out, LevelInfo, FormatText).With("request_id", "abc").Info("Couldn't add wallet", "err", errors.New("no route"), "count", 2, "missing")
+	line := out.String()
+	for _, expected := range []string{`level=INFO msg="Couldn't add wallet" request_id=abc err="no route" count=2 missing=(missing)`} {
+		if !strings.Contains(line, expected) {
+			t.Errorf("expected %q in %q", expected, line)
+		}
+	}
+	if !strings.HasPrefix(line, "ts=") || !strings.HasSuffix(line, "\n") || strings.Count(line, "\n") != 1 {
+		t.Errorf("unexpected line %q", line)
+	}
+	// values can't start a new line
+	out.Reset()
+	New(&out, LevelInfo, FormatText).Info("login", "username", "user\nts=forged level=ERROR")
+	if strings.Count(out.String(), "\n") != 1 {
+		t.Errorf("value broke line %q", out.String())
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var out bytes.Buffer
+	New(&out, LevelInfo, FormatJSON).With("request_id", "abc").Error("Login failed", "iin", "910815450350", "status", 401,
+		"duration", time.Second, "password", "secret!", "ok", false)
+	var line map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out.String(), err)
+	}
+	expected := map[string]interface{}{
+		"level":      "ERROR",
+		"msg":        "Login failed",
+		"request_id": "abc",
+		"iin":        "********0350",
+		"status":     float64(401),
+		"duration":   "1s",
+		"password":   "[REDACTED]",
+		"ok":         false,
+	}
+	for key, value := range expected {
+		if line[key] != value {
+			t.Errorf("for %s, expected %v but got %v", key, value, line[key])
+		}
+	}
+	if _, err := time.Parse(timeFormat, line["ts"].(string)); err != nil {
+		t.Errorf("unexpected ts %v", line["ts"])
+	}
+}
+
+func TestCtx(t *testing.T) {
+	var out bytes.Buffer
+	defer SetDefault(Default())
+	SetDefault(New(&out, LevelInfo, FormatText))
+
+	Ctx(WithRequestID(context.Background(), "req-1")).Info("with id")
+	Ctx(context.Background()).Info("without id")
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], "request_id=req-1") || strings.Contains(lines[1], "request_id") {
+		t.Errorf("unexpected lines %q", out.String())
+	}
+	// fasthttp requests keep the ID as user value
+	ctx := context.WithValue(context.Background(), RequestIDKey, "req-2") //nolint:staticcheck
+	if id := RequestID(ctx); id != "req-2" {
+		t.Errorf("expected req-2 but got %q", id)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	if id := NewRequestID(); len(id) != 16 || !ValidRequestID(id) || id == NewRequestID() {
+		t.Errorf("unexpected request ID %q", id)
+	}
+	var testTable = []struct {
+		id    string
+		valid bool
+	}{
+		{"3f2a-9c1b_00.1", true},
+		{"", false},
+		{"id with spaces", false},
+		{"id\nforged", false},
+		{strings.Repeat("a", 65), false},
+	}
+	for _, tt := range testTable {
+		if ValidRequestID(tt.id) != tt.valid {
+			t.Errorf("for %q, expected %v", tt.id, tt.valid)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := New(&out, LevelInfo, FormatText).Writer(LevelError)
+	w.Write([]byte("Db interface create error: Bearer eyJhbGciOiJIUzI1NiJ9.eyJpaW4iOiIxIn0.c2ln\n")) //nolint:errcheck
+	if line := out.String(); !strings.Contains(line, "level=ERROR") || strings.Contains(line, "eyJ") {
+		t.Errorf("unexpected line %q", line)
+	}
+}
@@ -0,0 +1,75 @@
+package logger
+
+import (
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+var (
+	jwtPattern     = regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	bearerPattern  = regexp.MustCompile(`(?i)(bearer|basic)\s+[A-Za-z0-9._~+/=-]+`)
+	paramPattern   = regexp.MustCompile(`(?i)((?:password|passwd|secret|token|code)[a-z_]*=)[^&\s"]+`)
+	accountPattern = regexp.MustCompile(`\bKZT\d{10}\b`)
+	iinPattern     = regexp.MustCompile(`\b\d{12}\b`)
+)
+
+// Redact masks secrets found in free text: JWTs, credentials of Authorization headers and of form or query
+// parameters are replaced, IINs and account numbers keep their last 4 digits
+func Redact(s string) string {
+	s = jwtPattern.ReplaceAllString(s, redacted)
+	s = bearerPattern.ReplaceAllString(s, "$1 "+redacted)
+	s = paramPattern.ReplaceAllString(s, "${1}"+redacted)
+	s = accountPattern.ReplaceAllStringFunc(s, func(account string) string {
+		return "KZT" + maskTail(account[3:])
+	})
+	return iinPattern.ReplaceAllStringFunc(s, maskTail)
+}
+
+// RedactField redacts value logged under key. Values of secret keys like password or token are dropped,
+// values of IIN and account keys are masked whatever their format is
+func RedactField(key, value string) string {
+	switch {
+	case sensitiveKey(key):
+		return redacted
+	case maskedKey(key):
+		return maskTail(value)
+	}
+	return Redact(value)
+}
+
+// normalizeKey lowercases key and drops separators, so that "refresh_token" and "refreshToken" match the same
+func normalizeKey(key string) string {
+	return strings.NewReplacer("_", "", "-", "", ".", "", " ", "").Replace(strings.ToLower(key))
+}
+
+var sensitiveKeyParts = []string{"password", "passwd", "secret", "token", "cookie", "authorization", "apikey", "credential"}
+
+var sensitiveKeys = map[string]bool{"pass": true, "code": true, "mfacode": true, "otp": true, "jwt": true, "access": true, "refresh": true}
+
+func sensitiveKey(key string) bool {
+	key = normalizeKey(key)
+	if sensitiveKeys[key] {
+		return true
+	}
+	for _, part := range sensitiveKeyParts {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+	return false
+}
+
+func maskedKey(key string) bool {
+	key = normalizeKey(key)
+	return strings.HasSuffix(key, "iin") || strings.HasSuffix(key, "account")
+}
+
+// maskTail replaces all but the last 4 characters with '*', short values are masked whole
+func maskTail(s string) string {
+	if len(s) <= 4 {
+		return strings.Repeat("*", len(s))
+	}
+	return strings.Repeat("*", len(s)-4) + s[len(s)-4:]
+}
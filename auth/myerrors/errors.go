@@ -3,18 +3,40 @@ package myerrors
 import "errors"
 
 var (
-	ErrDuplicateUser   = errors.New("username or IIN exists")
-	ErrInvalidAcc      = errors.New("invalid account(s)")
-	ErrInvalidAmt      = errors.New("invalid amount")
-	ErrInvalidInput    = errors.New("invalid input")
-	ErrInvalidPassword = errors.New("invalid password")
-	ErrInvalidToken    = errors.New("invalid token")
-	ErrInvalidUsername = errors.New("invalid username")
-	ErrNilTemplate     = errors.New("template not found")
-	ErrRefreshNotFound = errors.New("refresh token not found in redis")
-	ErrSameAccount     = errors.New("transfer between same account not allowed")
-	ErrTokenExpired    = errors.New("Token is expired")
-	ErrTokenMismatch   = errors.New("tokens don't match")
-	ErrCookieNotFound  = errors.New("token cookie not found")
-	ErrUserNotFound    = errors.New("user not found")
+	ErrDuplicateUser      = errors.New("username or IIN exists")
+	ErrInvalidAcc         = errors.New("invalid account(s)")
+	ErrInvalidAmt         = errors.New("invalid amount")
+	ErrInvalidInput       = errors.New("invalid input")
+	ErrInvalidPassword    = errors.New("invalid password")
+	ErrInvalidToken       = errors.New("invalid token")
+	ErrInvalidUsername    = errors.New("invalid username")
+	ErrNilTemplate        = errors.New("template not found")
+	ErrRefreshNotFound    = errors.New("refresh token not found in redis")
+	ErrSessionNotFound    = errors.New("session not found")
+	ErrSameAccount        = errors.New("transfer between same account not allowed")
+	ErrTokenExpired       = errors.New("Token is expired")
+	ErrTokenMismatch      = errors.New("tokens don't match")
+	ErrTokenReused        = errors.New("refresh token reused")
+	ErrTokenRotated       = errors.New("refresh token was just rotated")
+	ErrTokenRevoked       = errors.New("token revoked")
+	ErrCookieNotFound     = errors.New("token cookie not found")
+	ErrBearerNotFound     = errors.New("bearer token not found")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrUserLocked         = errors.New("account is locked")
+	ErrLoginLocked        = errors.New("login temporarily locked after failed attempts")
+	ErrTooManyAttempts    = errors.New("too many login attempts")
+	ErrMFANotEnrolled     = errors.New("two-factor authentication is not enrolled")
+	ErrMFAEnabled         = errors.New("two-factor authentication is already enabled")
+	ErrInvalidMFACode     = errors.New("invalid two-factor authentication code")
+	ErrResetTokenInvalid  = errors.New("password reset token is invalid or expired")
+	ErrNoEmail            = errors.New("user has no email")
+	ErrWrongPassword      = errors.New("password doesn't match")
+	ErrClientNotFound     = errors.New("oauth client not found")
+	ErrInvalidClient      = errors.New("oauth client authentication failed")
+	ErrInvalidRedirect    = errors.New("redirect uri is not registered for the client")
+	ErrInvalidScope       = errors.New("scope is not allowed for the client")
+	ErrInvalidPKCE        = errors.New("code challenge is missing or not S256")
+	ErrInvalidGrant       = errors.New("authorization grant is invalid or expired")
+	ErrResponseType       = errors.New("unsupported response type")
+	ErrUnauthorizedClient = errors.New("grant type is not allowed for the client")
 )
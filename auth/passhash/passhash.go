@@ -0,0 +1,131 @@
+package passhash
+
+import (
+	"auth/config"
+	"auth/myerrors"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUnknownHash means hash is in format neither argon2id nor bcrypt
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+const (
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+// Hasher hashes passwords into self-describing strings carrying algorithm and parameters,
+// so that hashes made with older parameters keep verifying
+type Hasher interface {
+	Hash(password string) (string, error)
+	// Verify returns myerrors.ErrWrongPassword if password doesn't match
+	Verify(hash, password string) error
+	// NeedsRehash tells whether hash was made with other algorithm or parameters than the current policy
+	NeedsRehash(hash string) bool
+}
+
+type hasher struct {
+	policy config.PasswordHashPolicy
+}
+
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// Hash hashes password with the algorithm of the policy
+func (h *hasher) Hash(password string) (string, error) {
+	if h.policy.Algorithm == config.HashBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.policy.BcryptCost)
+		return string(hash), err
+	}
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.policy.Argon2Time, h.policy.Argon2Memory, h.policy.Argon2Threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+		h.policy.Argon2Memory, h.policy.Argon2Time, h.policy.Argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify checks password against argon2id or bcrypt hash of any parameters
+func (h *hasher) Verify(hash, password string) error {
+	if isBcrypt(hash) {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return myerrors.ErrWrongPassword
+		}
+		return err
+	}
+	p, err := parseArgon2(hash)
+	if err != nil {
+		return err
+	}
+	key := argon2.IDKey([]byte(password), p.salt, p.time, p.memory, p.threads, uint32(len(p.key)))
+	if subtle.ConstantTimeCompare(key, p.key) != 1 {
+		return myerrors.ErrWrongPassword
+	}
+	return nil
+}
+
+// NeedsRehash tells whether hash should be replaced by a new one made with the current policy
+func (h *hasher) NeedsRehash(hash string) bool {
+	if isBcrypt(hash) {
+		if h.policy.Algorithm != config.HashBcrypt {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost != h.policy.BcryptCost
+	}
+	if h.policy.Algorithm != config.HashArgon2id {
+		return true
+	}
+	p, err := parseArgon2(hash)
+	return err != nil || p.memory != h.policy.Argon2Memory || p.time != h.policy.Argon2Time ||
+		p.threads != h.policy.Argon2Threads || len(p.salt) != argon2SaltLen || len(p.key) != argon2KeyLen
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// parseArgon2 parses $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key> in PHC string format
+func parseArgon2(hash string) (*argon2Params, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return nil, ErrUnknownHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, ErrUnknownHash
+	}
+	p := &argon2Params{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil || p.time == 0 || p.threads == 0 {
+		return nil, ErrUnknownHash
+	}
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, ErrUnknownHash
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(p.key) == 0 {
+		return nil, ErrUnknownHash
+	}
+	return p, nil
+}
+
+// NewHasher returns Hasher making new hashes with the policy
+func NewHasher(policy *config.PasswordHashPolicy) Hasher {
+	return &hasher{policy: *policy}
+}
@@ -0,0 +1,77 @@
+package passhash
+
+import (
+	"auth/config"
+	"auth/myerrors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// seedHash is bcrypt hash of "password " from init.sql
+const seedHash = "$2a$10$YVWoFp84S4F7TkIkV2KhguNmQ4bkQRhN14fz.MeocFLOO7XBkLxH."
+
+var testArgon2 = &config.PasswordHashPolicy{Algorithm: config.HashArgon2id, BcryptCost: 4, Argon2Memory: 1024, Argon2Time: 1, Argon2Threads: 1}
+
+func TestArgon2id(t *testing.T) {
+	h := NewHasher(testArgon2)
+	hash, err := h.Hash("password ")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"))
+	assert.NoError(t, h.Verify(hash, "password "))
+	assert.Equal(t, myerrors.ErrWrongPassword, h.Verify(hash, "password"))
+	assert.False(t, h.NeedsRehash(hash))
+
+	other, err := h.Hash("password ")
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash, other, "salt must be random")
+
+	// stronger policy still verifies old hashes but wants them upgraded
+	stronger := NewHasher(&config.PasswordHashPolicy{Algorithm: config.HashArgon2id, Argon2Memory: 2048, Argon2Time: 1, Argon2Threads: 1})
+	assert.NoError(t, stronger.Verify(hash, "password "))
+	assert.True(t, stronger.NeedsRehash(hash))
+}
+
+func TestBcrypt(t *testing.T) {
+	h := NewHasher(&config.PasswordHashPolicy{Algorithm: config.HashBcrypt, BcryptCost: 4})
+	hash, err := h.Hash("password ")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$2a$04$"))
+	assert.NoError(t, h.Verify(hash, "password "))
+	assert.Equal(t, myerrors.ErrWrongPassword, h.Verify(hash, "wrong"))
+	assert.False(t, h.NeedsRehash(hash))
+
+	// seed hash of other cost
+	assert.NoError(t, h.Verify(seedHash, "password "))
+	assert.True(t, h.NeedsRehash(seedHash))
+
+	// argon2id hash is verified and moved to bcrypt
+	argonHash, err := NewHasher(testArgon2).Hash("password ")
+	assert.NoError(t, err)
+	assert.NoError(t, h.Verify(argonHash, "password "))
+	assert.True(t, h.NeedsRehash(argonHash))
+}
+
+func TestSeedHashUpgradedToArgon2id(t *testing.T) {
+	h := NewHasher(testArgon2)
+	assert.NoError(t, h.Verify(seedHash, "password "))
+	assert.Equal(t, myerrors.ErrWrongPassword, h.Verify(seedHash, "wrong"))
+	assert.True(t, h.NeedsRehash(seedHash))
+}
+
+func TestMalformed(t *testing.T) {
+	h := NewHasher(testArgon2)
+	for _, hash := range []string{
+		"",
+		"plain",
+		"$argon2i$v=19$m=1024,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=16$m=1024,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=1024,t=0,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=1024,t=1,p=1$!!$a2V5",
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$",
+	} {
+		assert.Equal(t, ErrUnknownHash, h.Verify(hash, "password "), hash)
+		assert.True(t, h.NeedsRehash(hash), hash)
+	}
+}
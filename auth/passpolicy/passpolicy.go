@@ -0,0 +1,199 @@
+package passpolicy
+
+import (
+	"auth/config"
+	"auth/domain"
+	"auth/logger"
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Rules of the policy
+const (
+	RuleMinLength = "min_length"
+	RuleMaxLength = "max_length"
+	RulePrintable = "printable"
+	RuleLower     = "lower"
+	RuleUpper     = "upper"
+	RuleDigit     = "digit"
+	RuleSpecial   = "special"
+	RuleUsername  = "no_username"
+	RuleIIN       = "no_iin"
+	RuleBreached  = "not_breached"
+)
+
+// minIdentifierLen is the shortest username or IIN looked for inside password, shorter ones match too much
+const minIdentifierLen = 3
+
+// Error lists rules failed by password
+type Error struct {
+	Rules []domain.PasswordRule
+}
+
+func (e *Error) Error() string {
+	rules := make([]string, len(e.Rules))
+	for i, rule := range e.Rules {
+		rules[i] = rule.Rule
+	}
+	return "password doesn't meet policy: " + strings.Join(rules, ", ")
+}
+
+// Policy checks passwords against config.PasswordPolicy
+type Policy struct {
+	minLength int
+	maxLength int
+	classes   []string
+	// breached holds lowercased plain passwords, breachedSHA1 upper case SHA-1 hex of exact passwords
+	breached     map[string]bool
+	breachedSHA1 map[string]bool
+}
+
+var classMessages = map[string]string{
+	RuleLower:   "at least one lowercase letter",
+	RuleUpper:   "at least one uppercase letter",
+	RuleDigit:   "at least one digit",
+	RuleSpecial: "at least one special character",
+}
+
+// Rules describes all requirements of the policy, e.g. to show them next to password field
+func (p *Policy) Rules() []domain.PasswordRule {
+	rules := []domain.PasswordRule{
+		{Rule: RuleMinLength, Message: fmt.Sprintf("at least %d characters", p.minLength)},
+		{Rule: RuleMaxLength, Message: fmt.Sprintf("at most %d characters", p.maxLength)},
+		{Rule: RulePrintable, Message: "only latin letters, digits, spaces and special characters"},
+	}
+	for _, class := range p.classes {
+		rules = append(rules, domain.PasswordRule{Rule: class, Message: classMessages[class]})
+	}
+	rules = append(rules,
+		domain.PasswordRule{Rule: RuleUsername, Message: "must not contain username"},
+		domain.PasswordRule{Rule: RuleIIN, Message: "must not contain IIN"},
+	)
+	if p.breached != nil {
+		rules = append(rules, domain.PasswordRule{Rule: RuleBreached, Message: "must not be a common or breached password"})
+	}
+	return rules
+}
+
+// Check returns rules failed by password of the user, nil when it meets the policy
+func (p *Policy) Check(password, username, IIN string) []domain.PasswordRule {
+	failed := map[string]bool{}
+	if len(password) < p.minLength {
+		failed[RuleMinLength] = true
+	}
+	if len(password) > p.maxLength {
+		failed[RuleMaxLength] = true
+	}
+	has := map[string]bool{}
+	for _, char := range password {
+		switch {
+		case char < 32 || char > 126:
+			failed[RulePrintable] = true
+		case char >= 'a' && char <= 'z':
+			has[RuleLower] = true
+		case char >= 'A' && char <= 'Z':
+			has[RuleUpper] = true
+		case char >= '0' && char <= '9':
+			has[RuleDigit] = true
+		default:
+			has[RuleSpecial] = true
+		}
+	}
+	for _, class := range p.classes {
+		if !has[class] {
+			failed[class] = true
+		}
+	}
+	lower := strings.ToLower(password)
+	if len(username) >= minIdentifierLen && strings.Contains(lower, strings.ToLower(username)) {
+		failed[RuleUsername] = true
+	}
+	if len(IIN) >= minIdentifierLen && strings.Contains(password, IIN) {
+		failed[RuleIIN] = true
+	}
+	if p.isBreached(password) {
+		failed[RuleBreached] = true
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	var rules []domain.PasswordRule
+	for _, rule := range p.Rules() {
+		if failed[rule.Rule] {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// Validate returns *Error when password doesn't meet the policy
+func (p *Policy) Validate(password, username, IIN string) error {
+	if rules := p.Check(password, username, IIN); rules != nil {
+		return &Error{Rules: rules}
+	}
+	return nil
+}
+
+func (p *Policy) isBreached(password string) bool {
+	if p.breached == nil {
+		return false
+	}
+	if p.breached[strings.ToLower(password)] {
+		return true
+	}
+	sum := sha1.Sum([]byte(password))
+	return p.breachedSHA1[strings.ToUpper(hex.EncodeToString(sum[:]))]
+}
+
+// loadBreached reads list of passwords, lines in "<SHA-1 hex>[:count]" form like in HIBP downloads are taken as hashes
+func (p *Policy) loadBreached(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	p.breached, p.breachedSHA1 = map[string]bool{}, map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if hash := strings.SplitN(line, ":", 2)[0]; isSHA1(hash) {
+			p.breachedSHA1[strings.ToUpper(hash)] = true
+			continue
+		}
+		p.breached[strings.ToLower(line)] = true
+	}
+	return scanner.Err()
+}
+
+func isSHA1(s string) bool {
+	if len(s) != 2*sha1.Size {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// NewPolicy returns Policy loading breached password list of the config
+func NewPolicy(cfg *config.PasswordPolicy) (*Policy, error) {
+	p := &Policy{
+		minLength: cfg.MinLength,
+		maxLength: cfg.MaxLength,
+		classes:   cfg.Classes,
+	}
+	if cfg.BreachedList == "" {
+		logger.Warn("PASSWORD_BREACHED_LIST is not set, passwords are not checked against breached ones")
+		return p, nil
+	}
+	if err := p.loadBreached(cfg.BreachedList); err != nil {
+		return nil, fmt.Errorf("breached password list: %w", err)
+	}
+	logger.Info("Loaded breached passwords", "count", len(p.breached)+len(p.breachedSHA1))
+	return p, nil
+}
@@ -0,0 +1,88 @@
+package passpolicy
+
+import (
+	"auth/config"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func rulesOf(p *Policy, password, username, IIN string) []string {
+	var rules []string
+	for _, rule := range p.Check(password, username, IIN) {
+		rules = append(rules, rule.Rule)
+	}
+	return rules
+}
+
+func TestCheck(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "breached.txt")
+	content := "# comment\nPassword1!\r\n\n" + sha1Hex("Tr0ub4dor&3") + ":3\n"
+	assert.NoError(t, os.WriteFile(list, []byte(content), 0600))
+	p, err := NewPolicy(&config.PasswordPolicy{MinLength: 8, MaxLength: 16, Classes: []string{RuleLower, RuleUpper, RuleDigit, RuleSpecial}, BreachedList: list})
+	assert.NoError(t, err)
+
+	var testTable = []struct {
+		name     string
+		password string
+		expected []string
+	}{
+		{"valid", "Correct-Horse9", nil},
+		{"one special char", "!", []string{RuleMinLength, RuleLower, RuleUpper, RuleDigit}},
+		{"too long", "Correct-Horse9-Battery", []string{RuleMaxLength}},
+		{"no classes", "correcthorse", []string{RuleUpper, RuleDigit, RuleSpecial}},
+		{"not printable", "Correct-Horse9\t", []string{RulePrintable}},
+		{"cyrillic", "Пар-Horse9", []string{RulePrintable}},
+		{"username", "xBobby-Horse9", []string{RuleUsername}},
+		{"iin", "Ab!910815450350", []string{RuleIIN}},
+		{"breached plain case-insensitive", "pASSWORD1!", []string{RuleBreached}},
+		{"breached sha1", "Tr0ub4dor&3", []string{RuleBreached}},
+	}
+	for _, tt := range testTable {
+		assert.Equal(t, tt.expected, rulesOf(p, tt.password, "bobby", "910815450350"), tt.name)
+	}
+
+	// short usernames are not looked for
+	assert.Nil(t, rulesOf(p, "Correct-Horse9", "or", ""))
+
+	err = p.Validate("!", "bobby", "")
+	var policyErr *Error
+	if assert.True(t, errors.As(err, &policyErr)) {
+		assert.Len(t, policyErr.Rules, 4)
+	}
+	assert.NoError(t, p.Validate("Correct-Horse9", "bobby", ""))
+}
+
+func TestRules(t *testing.T) {
+	p, err := NewPolicy(&config.PasswordPolicy{MinLength: 8, MaxLength: 72, Classes: []string{RuleDigit}})
+	assert.NoError(t, err)
+	var rules []string
+	for _, rule := range p.Rules() {
+		assert.NotEmpty(t, rule.Message)
+		rules = append(rules, rule.Rule)
+	}
+	// no breached rule without list
+	assert.Equal(t, []string{RuleMinLength, RuleMaxLength, RulePrintable, RuleDigit, RuleUsername, RuleIIN}, rules)
+
+	_, err = NewPolicy(&config.PasswordPolicy{MinLength: 8, MaxLength: 72, BreachedList: filepath.Join(t.TempDir(), "missing.txt")})
+	assert.Error(t, err)
+}
+
+func TestShippedList(t *testing.T) {
+	p, err := NewPolicy(&config.PasswordPolicy{MinLength: 1, MaxLength: 72, BreachedList: "../cmd/breached-passwords.txt"})
+	assert.NoError(t, err)
+	for _, password := range []string{"123456", "Password", "qwerty123", "P@ssw0rd"} {
+		assert.Equal(t, []string{RuleBreached}, rulesOf(p, password, "", ""), password)
+	}
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,4 @@
+// Package authv1 is generated from auth.proto, run go generate after changing it
+package authv1
+
+//go:generate protoc -I ../.. --go_out=../.. --go_opt=paths=source_relative --go-grpc_out=../.. --go-grpc_opt=paths=source_relative auth/v1/auth.proto
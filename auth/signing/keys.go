@@ -0,0 +1,204 @@
+package signing
+
+import (
+	"auth/logger"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Key is a single JWT key identified by kid. Keys without private part can only verify tokens
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+	Public  crypto.PublicKey
+}
+
+// KeySet holds the key used for signing and all keys accepted for verification
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+var (
+	defaultOnce sync.Once
+	defaultSet  *KeySet
+	defaultErr  error
+)
+
+// Default returns key set configured by JWT_KEYS_DIR and JWT_SIGNING_KID, loading it on first use.
+// An ephemeral Ed25519 key is generated only for local development with JWT_DEV_EPHEMERAL_KEY=true:
+// tokens signed with it stop working on restart and other instances can't verify them
+func Default() (*KeySet, error) {
+	defaultOnce.Do(func() {
+		defaultSet, defaultErr = loadDefault(os.Getenv("JWT_KEYS_DIR"), os.Getenv("JWT_SIGNING_KID"), os.Getenv("JWT_DEV_EPHEMERAL_KEY"))
+	})
+	return defaultSet, defaultErr
+}
+
+func loadDefault(dir, signingKID, devEphemeral string) (*KeySet, error) {
+	if dir != "" {
+		return LoadKeySet(dir, signingKID)
+	}
+	ephemeral, err := strconv.ParseBool(devEphemeral)
+	if devEphemeral == "" || err != nil || !ephemeral {
+		return nil, fmt.Errorf("JWT_KEYS_DIR is not set, see cmd/.env on generating signing keys")
+	}
+	logger.Warn("JWT_KEYS_DIR is not set, generating ephemeral signing key for development")
+	return NewEphemeralKeySet()
+}
+
+// NewKeySet builds a key set from keys, signing with the key identified by signingKID
+func NewKeySet(signingKID string, keys ...*Key) (*KeySet, error) {
+	ks := &KeySet{keys: map[string]*Key{}}
+	for _, key := range keys {
+		if _, ok := ks.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		ks.keys[key.ID] = key
+	}
+	signing, ok := ks.keys[signingKID]
+	if !ok {
+		return nil, fmt.Errorf("signing key %q not found", signingKID)
+	}
+	if signing.Private == nil {
+		return nil, fmt.Errorf("signing key %q has no private part", signingKID)
+	}
+	ks.signing = signing
+	return ks, nil
+}
+
+// NewEphemeralKeySet returns key set with a single freshly generated Ed25519 key
+func NewEphemeralKeySet() (*KeySet, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	key := &Key{ID: "ephemeral", Method: jwt.SigningMethodEdDSA, Private: private, Public: public}
+	return NewKeySet(key.ID, key)
+}
+
+// LoadKeySet loads every <kid>.pem file in dir. Private keys can sign and verify,
+// public keys are kept for verification only, e.g. after being rotated out
+func LoadKeySet(dir, signingKID string) (*KeySet, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	var keys []*Key
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ParseKey(strings.TrimSuffix(filepath.Base(file), ".pem"), data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		keys = append(keys, key)
+	}
+	return NewKeySet(signingKID, keys...)
+}
+
+// ParseKey parses a PEM encoded private or public key
+func ParseKey(kid string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{ID: kid}
+	if signer, ok := parsed.(crypto.Signer); ok {
+		key.Private = signer
+		key.Public = signer.Public()
+	} else {
+		key.Public = parsed
+	}
+	key.Method, err = methodFor(key.Public)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// methodFor picks signing method matching the public key type
+func methodFor(public crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return jwt.SigningMethodES256, nil
+		case elliptic.P384():
+			return jwt.SigningMethodES384, nil
+		case elliptic.P521():
+			return jwt.SigningMethodES512, nil
+		}
+		return nil, fmt.Errorf("unsupported curve %s", k.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", public)
+}
+
+// Sign signs claims with the signing key and sets kid header
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.signing.Method, claims)
+	token.Header["kid"] = ks.signing.ID
+	return token.SignedString(ks.signing.Private)
+}
+
+// Alg returns JWS algorithm of the signing key
+func (ks *KeySet) Alg() string {
+	return ks.signing.Method.Alg()
+}
+
+// Keyfunc looks up verification key by kid header, to be passed to jwt.Parse
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, fmt.Errorf("kid header not found")
+	}
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.Public, nil
+}
@@ -0,0 +1,147 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func writePrivateKey(t *testing.T, dir, kid string, key crypto.Signer) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, kid+".pem"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writePublicKey(t *testing.T, dir, kid string, key crypto.PublicKey) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, kid+".pem"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadKeySet(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	oldPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	writePrivateKey(t, dir, "rsa", rsaKey)
+	writePrivateKey(t, dir, "ec", ecKey)
+	writePrivateKey(t, dir, "ed", edKey)
+	writePublicKey(t, dir, "old", oldPublic)
+
+	for kid, alg := range map[string]string{"rsa": "RS256", "ec": "ES256", "ed": "EdDSA"} {
+		ks, err := LoadKeySet(dir, kid)
+		assert.NoError(t, err)
+
+		signed, err := ks.Sign(jwt.MapClaims{"iin": "910815450350"})
+		assert.NoError(t, err)
+		token, err := jwt.Parse(signed, ks.Keyfunc)
+		assert.NoError(t, err)
+		assert.True(t, token.Valid)
+		assert.Equal(t, kid, token.Header["kid"])
+		assert.Equal(t, alg, token.Method.Alg())
+		assert.Equal(t, alg, ks.Alg())
+
+		jwks := ks.JWKS()
+		assert.Len(t, jwks.Keys, 4)
+	}
+
+	// only public part of the rotated out key is available
+	_, err = LoadKeySet(dir, "old")
+	assert.Error(t, err)
+	_, err = LoadKeySet(dir, "missing")
+	assert.Error(t, err)
+
+	ks, err := LoadKeySet(dir, "ed")
+	assert.NoError(t, err)
+	jwks := ks.JWKS()
+	for _, jwk := range jwks.Keys {
+		if jwk.Kid == "ed" {
+			assert.Equal(t, "OKP", jwk.Kty)
+			assert.Equal(t, encode(edPublic), jwk.X)
+		}
+	}
+}
+
+func TestKeyfunc(t *testing.T) {
+	ks, err := NewEphemeralKeySet()
+	assert.NoError(t, err)
+	other, err := NewEphemeralKeySet()
+	assert.NoError(t, err)
+
+	signed, err := other.Sign(jwt.MapClaims{"iin": "910815450350"})
+	assert.NoError(t, err)
+	// same kid, different key
+	_, err = jwt.Parse(signed, ks.Keyfunc)
+	assert.Error(t, err)
+
+	// no kid
+	signed, err = jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{}).SignedString([]byte("secret"))
+	assert.NoError(t, err)
+	_, err = jwt.Parse(signed, ks.Keyfunc)
+	assert.Error(t, err)
+
+	// HMAC token claiming known kid
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{})
+	token.Header["kid"] = "ephemeral"
+	signed, err = token.SignedString([]byte("secret"))
+	assert.NoError(t, err)
+	_, err = jwt.Parse(signed, ks.Keyfunc)
+	assert.Error(t, err)
+}
+
+func TestLoadDefault(t *testing.T) {
+	dir := t.TempDir()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	writePrivateKey(t, dir, "2022-01", private)
+
+	var testTable = []struct {
+		name         string
+		dir          string
+		devEphemeral string
+		expectedKID  string
+	}{
+		{"keys dir", dir, "", "2022-01"},
+		{"keys dir over dev flag", dir, "true", "2022-01"},
+		{"dev flag", "", "true", "ephemeral"},
+		{"not set", "", "", ""},
+		{"dev flag off", "", "false", ""},
+		{"dev flag invalid", "", "yes", ""},
+	}
+	for _, tt := range testTable {
+		ks, err := loadDefault(tt.dir, "2022-01", tt.devEphemeral)
+		if tt.expectedKID == "" {
+			assert.Error(t, err, tt.name)
+			continue
+		}
+		assert.NoError(t, err, tt.name)
+		assert.Equal(t, tt.expectedKID, ks.signing.ID, tt.name)
+	}
+}
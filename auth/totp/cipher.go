@@ -0,0 +1,81 @@
+package totp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Cipher encrypts secrets stored in DB with AES-256-GCM
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+var (
+	defaultOnce   sync.Once
+	defaultCipher *Cipher
+	defaultErr    error
+)
+
+// DefaultCipher returns cipher keyed by base64 encoded 32 byte MFA_ENCRYPTION_KEY, loading it on first use.
+// The key is required: secrets encrypted with a lost key can't be decrypted and their users can't log in
+func DefaultCipher() (*Cipher, error) {
+	defaultOnce.Do(func() {
+		defaultCipher, defaultErr = parseCipherKey(os.Getenv("MFA_ENCRYPTION_KEY"))
+	})
+	return defaultCipher, defaultErr
+}
+
+// parseCipherKey returns cipher of base64 encoded key as set in MFA_ENCRYPTION_KEY
+func parseCipherKey(value string) (*Cipher, error) {
+	if value == "" {
+		return nil, fmt.Errorf("MFA_ENCRYPTION_KEY is not set, generate one with: openssl rand -base64 32")
+	}
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("MFA_ENCRYPTION_KEY: %w", err)
+	}
+	c, err := NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("MFA_ENCRYPTION_KEY: %w", err)
+	}
+	return c, nil
+}
+
+// NewCipher returns cipher with the 32 byte key
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// Encrypt returns nonce followed by sealed plaintext
+func (c *Cipher) Encrypt(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt opens data produced by Encrypt
+func (c *Cipher) Decrypt(data []byte) ([]byte, error) {
+	size := c.aead.NonceSize()
+	if len(data) < size {
+		return nil, fmt.Errorf("encrypted data too short")
+	}
+	return c.aead.Open(nil, data[:size], data[size:], nil)
+}
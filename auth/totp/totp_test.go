@@ -0,0 +1,116 @@
+package totp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// RFC 6238 appendix B SHA1 test vectors truncated to 6 digits
+var codeTestTable = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+var rfcSecret = []byte("12345678901234567890")
+
+func TestCode(t *testing.T) {
+	for _, tt := range codeTestTable {
+		assert.Equal(t, tt.code, Code(rfcSecret, Step(time.Unix(tt.unix, 0))), "time %d", tt.unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step, ok := Validate(rfcSecret, "050471", now)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now), step)
+
+	// previous step is accepted for clock skew
+	_, ok = Validate(rfcSecret, "050471", now.Add(Period*time.Second))
+	assert.True(t, ok)
+	_, ok = Validate(rfcSecret, "050471", now.Add(2*Period*time.Second))
+	assert.False(t, ok)
+
+	for _, code := range []string{"", "12345", "1234567", "000000"} {
+		_, ok = Validate(rfcSecret, code, now)
+		assert.False(t, ok, code)
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("MyWallet", "user", rfcSecret)
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/MyWallet:user?"))
+	assert.Contains(t, uri, "secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+	assert.Contains(t, uri, "issuer=MyWallet")
+}
+
+func TestGenerateSecret(t *testing.T) {
+	first, err := GenerateSecret()
+	assert.NoError(t, err)
+	second, err := GenerateSecret()
+	assert.NoError(t, err)
+	assert.Len(t, first, secretSize)
+	assert.False(t, bytes.Equal(first, second))
+}
+
+func TestCipher(t *testing.T) {
+	c, err := NewCipher(bytes.Repeat([]byte{1}, 32))
+	assert.NoError(t, err)
+	data, err := c.Encrypt(rfcSecret)
+	assert.NoError(t, err)
+	assert.False(t, bytes.Contains(data, rfcSecret))
+	plaintext, err := c.Decrypt(data)
+	assert.NoError(t, err)
+	assert.Equal(t, rfcSecret, plaintext)
+
+	data[len(data)-1] ^= 1
+	_, err = c.Decrypt(data)
+	assert.Error(t, err)
+	_, err = c.Decrypt([]byte{1})
+	assert.Error(t, err)
+
+	_, err = NewCipher([]byte("short"))
+	assert.Error(t, err)
+}
+
+func TestParseCipherKey(t *testing.T) {
+	var testTable = []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{"valid", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)), false},
+		{"missing", "", true},
+		{"not base64", "not a key!", true},
+		{"short", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 16)), true},
+	}
+	for _, tt := range testTable {
+		c, err := parseCipherKey(tt.value)
+		assert.Equal(t, tt.wantErr, err != nil, tt.name)
+		assert.Equal(t, tt.wantErr, c == nil, tt.name)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(RecoveryCodes)
+	assert.NoError(t, err)
+	assert.Len(t, codes, RecoveryCodes)
+	assert.Len(t, codes[0], 11)
+	assert.Equal(t, byte('-'), codes[0][5])
+	assert.NotEqual(t, codes[0], codes[1])
+
+	assert.Equal(t, HashRecoveryCode("abcde-fghjk"), HashRecoveryCode(" ABCDE FGHJK"))
+	assert.NotEqual(t, HashRecoveryCode("abcde-fghjk"), HashRecoveryCode("abcde-fghjm"))
+}
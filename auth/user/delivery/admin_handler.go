@@ -0,0 +1,174 @@
+package delivery
+
+import (
+	"auth/domain"
+	"auth/logger"
+	"auth/myerrors"
+	"auth/user/delivery/middleware"
+	"auth/user/delivery/render"
+	"auth/user/delivery/response"
+	"auth/user/usecase"
+	"encoding/json"
+	"text/template"
+
+	"github.com/buaazp/fasthttprouter"
+	"github.com/valyala/fasthttp"
+)
+
+type AdminHandler struct {
+	uc     usecase.AdminUsecase
+	tUsers *template.Template
+	tUser  *template.Template
+}
+
+// SearchUsers finds users by username or IIN
+func (h *AdminHandler) SearchUsers(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("Admin SearchUsers hit")
+	info := domain.AdminInfo{Query: string(ctx.FormValue("q"))}
+	users, err := h.uc.SearchUsers(info.Query)
+	if err != nil {
+		logger.Ctx(ctx).Error("Couldn't search users", "err", err)
+		h.respond(ctx, fasthttp.StatusInternalServerError, h.tUsers, domain.AdminInfo{Query: info.Query, Error: InternalServerErrorMessage})
+		return
+	}
+	info.Users = users
+	h.respond(ctx, fasthttp.StatusOK, h.tUsers, info)
+}
+
+// GetUser shows user account, wallets, sessions and admin actions on it.
+// Transactions are shown for the account given in query
+func (h *AdminHandler) GetUser(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("Admin GetUser hit")
+	token, ok := ctx.Value("access").(string)
+	if !ok || token == "" {
+		logger.Ctx(ctx).Error("Couldn't get token from ctx")
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	IIN := string(ctx.FormValue("iin"))
+	user, err := h.uc.GetUser(IIN)
+	if err != nil {
+		logger.Ctx(ctx).Error("Admin couldn't get user", "err", err)
+		if err == myerrors.ErrUserNotFound {
+			h.respond(ctx, fasthttp.StatusNotFound, h.tUser, domain.AdminInfo{Error: UserNotFoundMessage})
+			return
+		}
+		h.respond(ctx, fasthttp.StatusInternalServerError, h.tUser, domain.AdminInfo{Error: InternalServerErrorMessage})
+		return
+	}
+	info := domain.AdminInfo{User: user, AccountNo: string(ctx.FormValue("account"))}
+	if info.Wallets, err = h.uc.GetWallets(IIN, token); err != nil {
+		logger.Ctx(ctx).Error("Admin couldn't get wallets", "err", err)
+		info.Error = InternalServerErrorMessage
+	}
+	if info.AccountNo != "" {
+		if info.Transactions, err = h.uc.GetTransactions(token, info.AccountNo); err != nil {
+			logger.Ctx(ctx).Error("Admin couldn't get transactions", "err", err)
+			info.Error = InternalServerErrorMessage
+		}
+	}
+	if info.Sessions, err = h.uc.ListSessions(IIN); err != nil {
+		logger.Ctx(ctx).Error("Admin couldn't list sessions", "err", err)
+		info.Error = InternalServerErrorMessage
+	}
+	if info.Actions, err = h.uc.ListActions(IIN); err != nil {
+		logger.Ctx(ctx).Error("Admin couldn't list actions", "err", err)
+		info.Error = InternalServerErrorMessage
+	}
+	if info.LoginAttempts, err = h.uc.GetLoginAttempts(user.Username); err != nil {
+		logger.Ctx(ctx).Error("Admin couldn't get login attempts", "err", err)
+		info.Error = InternalServerErrorMessage
+	}
+	h.respond(ctx, fasthttp.StatusOK, h.tUser, info)
+}
+
+// LockUser locks user account
+func (h *AdminHandler) LockUser(ctx *fasthttp.RequestCtx) {
+	h.userAction(ctx, h.uc.LockUser, "Account locked")
+}
+
+// UnlockUser unlocks user account
+func (h *AdminHandler) UnlockUser(ctx *fasthttp.RequestCtx) {
+	h.userAction(ctx, h.uc.UnlockUser, "Account unlocked")
+}
+
+// ForceLogout ends all sessions of the user
+func (h *AdminHandler) ForceLogout(ctx *fasthttp.RequestCtx) {
+	h.userAction(ctx, h.uc.ForceLogout, "All sessions revoked")
+}
+
+// ResetPassword ends sessions of the user and mails them a reset link
+func (h *AdminHandler) ResetPassword(ctx *fasthttp.RequestCtx) {
+	h.userAction(ctx, h.uc.TriggerPasswordReset, "Password reset link sent")
+}
+
+// userAction runs admin action on user given in form and responds with JSON
+func (h *AdminHandler) userAction(ctx *fasthttp.RequestCtx, action func(admin domain.User, IIN string) error, message string) {
+	logger.Ctx(ctx).Debug("Admin action hit", "path", string(ctx.Path()))
+	admin, ok := ctx.Value("user").(domain.User)
+	if !ok {
+		logger.Ctx(ctx).Error("User is nil")
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	IIN := string(ctx.FormValue("iin"))
+	middleware.SetAuditTarget(ctx, IIN)
+	if IIN == "" {
+		response.RespondWithError(ctx, fasthttp.StatusBadRequest, "user not specified")
+		return
+	}
+	if err := action(admin, IIN); err != nil {
+		logger.Ctx(ctx).Error("Admin action failed", "err", err)
+		switch err {
+		case myerrors.ErrUserNotFound:
+			response.RespondWithError(ctx, fasthttp.StatusNotFound, "user not found")
+		case myerrors.ErrInvalidInput:
+			response.RespondWithError(ctx, fasthttp.StatusBadRequest, "action not allowed on own account")
+		case myerrors.ErrNoEmail:
+			response.RespondWithError(ctx, fasthttp.StatusConflict, "sessions revoked, but user has no email to send reset link")
+		default:
+			response.RespondInternalServerError(ctx)
+		}
+		return
+	}
+	response.ResponseJSON(ctx, message)
+}
+
+// respond renders admin page or returns JSON to API clients
+func (h *AdminHandler) respond(ctx *fasthttp.RequestCtx, status int, t *template.Template, info domain.AdminInfo) {
+	if response.WantsJSON(ctx) {
+		ctx.SetStatusCode(status)
+		ctx.SetContentType("application/json")
+		json.NewEncoder(ctx).Encode(info)
+		return
+	}
+	if err := render.RenderTemplate(ctx, status, t, info); err != nil {
+		logger.Ctx(ctx).Error("Executing template", "err", err)
+	}
+}
+
+// NewAdminHandler sets /admin routes, reading requires users:read and changing requires users:write permission.
+// Service clients granted users:read may search users, but not open a user: its wallets and transactions are
+// read from the wallet service with the caller's token, which must be a signed in admin's
+func NewAdminHandler(r *fasthttprouter.Router, uc usecase.AdminUsecase, tUsers, tUser *template.Template) {
+	handler := &AdminHandler{
+		uc:     uc,
+		tUsers: tUsers,
+		tUser:  tUser,
+	}
+	search := func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return middleware.SecretMiddleware(middleware.CheckAnyAuthMiddleware(middleware.RequirePermission(domain.PermUsersRead, next)))
+	}
+	read := func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return middleware.SecretMiddleware(middleware.CheckAuthMiddleware(middleware.RequirePermission(domain.PermUsersRead, next)))
+	}
+	write := func(action string, next fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return middleware.SecretMiddleware(middleware.CheckAuthMiddleware(middleware.Audit(action, middleware.RequirePermission(domain.PermUsersWrite, next))))
+	}
+	r.GET("/admin/users", search(handler.SearchUsers))
+	r.GET("/admin/user", read(handler.GetUser))
+	r.POST("/admin/user/lock", write(domain.AuditAdminLock, handler.LockUser))
+	r.POST("/admin/user/unlock", write(domain.AuditAdminUnlock, handler.UnlockUser))
+	r.POST("/admin/user/logout", write(domain.AuditAdminForceLogout, handler.ForceLogout))
+	r.POST("/admin/user/reset-password", write(domain.AuditAdminPasswordReset, handler.ResetPassword))
+}
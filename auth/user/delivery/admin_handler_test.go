@@ -0,0 +1,154 @@
+package delivery
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+var testTableAdmin = []struct {
+	name               string
+	url                string
+	method             string
+	body               string
+	json               bool
+	admin              bool
+	expectedStatusCode int
+}{
+	{"get-users", "/admin/users?q=us", "GET", "", false, true, fasthttp.StatusOK},
+	{"get-users json", "/admin/users?q=us", "GET", "", true, true, fasthttp.StatusOK},
+	{"get-users some err", "/admin/users?q=sthwrong", "GET", "", false, true, fasthttp.StatusInternalServerError},
+	{"get-users not admin", "/admin/users?q=us", "GET", "", false, false, fasthttp.StatusForbidden},
+	{"get-user", "/admin/user?iin=910815450350&account=KZT0000000001", "GET", "", false, true, fasthttp.StatusOK},
+	{"get-user json", "/admin/user?iin=910815450350", "GET", "", true, true, fasthttp.StatusOK},
+	{"get-user nonexistent", "/admin/user?iin=nonexistent", "GET", "", false, true, fasthttp.StatusNotFound},
+	{"get-user sth wrong", "/admin/user?iin=sthwrong", "GET", "", true, true, fasthttp.StatusInternalServerError},
+	{"post-lock", "/admin/user/lock", "POST", "iin=910815450350", false, true, fasthttp.StatusOK},
+	{"post-lock self", "/admin/user/lock", "POST", "iin=0", false, true, fasthttp.StatusBadRequest},
+	{"post-lock nonexistent", "/admin/user/lock", "POST", "iin=nonexistent", false, true, fasthttp.StatusNotFound},
+	{"post-lock no iin", "/admin/user/lock", "POST", "", false, true, fasthttp.StatusBadRequest},
+	{"post-lock not admin", "/admin/user/lock", "POST", "iin=910815450350", false, false, fasthttp.StatusForbidden},
+	{"post-unlock", "/admin/user/unlock", "POST", "iin=910815450350", false, true, fasthttp.StatusOK},
+	{"post-unlock not recorded", "/admin/user/unlock", "POST", "iin=recorderr", false, true, fasthttp.StatusInternalServerError},
+	{"post-logout", "/admin/user/logout", "POST", "iin=910815450350", false, true, fasthttp.StatusOK},
+	{"post-logout nonexistent", "/admin/user/logout", "POST", "iin=nonexistent", false, true, fasthttp.StatusNotFound},
+	{"post-reset-password", "/admin/user/reset-password", "POST", "iin=910815450350", false, true, fasthttp.StatusOK},
+	{"post-reset-password no email", "/admin/user/reset-password", "POST", "iin=noemail", false, true, fasthttp.StatusConflict},
+}
+
+func TestAdminHandlers(t *testing.T) {
+	r := getRoutes()
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	s := &fasthttp.Server{
+		Handler: r,
+	}
+
+	go s.Serve(ln) //nolint:errcheck
+	c := &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	adminAccess, _, err := GenerateAdminTestTokens("0")
+	if err != nil {
+		t.Fatal("Couldn't generate token", err)
+	}
+	userAccess, _, err := GenerateTestTokens("910815450350")
+	if err != nil {
+		t.Fatal("Couldn't generate token", err)
+	}
+
+	for _, tt := range testTableAdmin {
+		fmt.Println("Testing", tt.name, "******************************************************************************************")
+		req, res := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		req.Header.SetMethod(tt.method)
+		req.SetRequestURI(URI + tt.url)
+		if tt.admin {
+			req.Header.SetCookie("access", adminAccess)
+		} else {
+			req.Header.SetCookie("access", userAccess)
+		}
+		if tt.json {
+			req.Header.Set("Accept", "application/json")
+		}
+		if tt.body != "" {
+			req.Header.SetContentType("application/x-www-form-urlencoded")
+			req.SetBodyString(tt.body)
+		}
+
+		setTestCSRF(req)
+		if err := c.Do(req, res); err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode() != tt.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", tt.name, tt.expectedStatusCode, res.StatusCode())
+		}
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(res)
+	}
+}
+
+func TestAdminPagesEscaped(t *testing.T) {
+	r := getRoutes()
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	s := &fasthttp.Server{
+		Handler: r,
+	}
+
+	go s.Serve(ln) //nolint:errcheck
+	c := &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	adminAccess, _, err := GenerateAdminTestTokens("0")
+	if err != nil {
+		t.Fatal("Couldn't generate token", err)
+	}
+
+	script := `<script>alert('x')</script>`
+	var testTable = []struct {
+		name string
+		url  string
+	}{
+		// reflected query and the username found by it
+		{"get-users", "/admin/users?q=" + url.QueryEscape(script)},
+		// username, IIN and email of the fake user are the requested IIN
+		{"get-user", "/admin/user?iin=" + url.QueryEscape(script)},
+	}
+	for _, tt := range testTable {
+		req, res := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		req.SetRequestURI(URI + tt.url)
+		req.Header.SetCookie("access", adminAccess)
+		if err := c.Do(req, res); err != nil {
+			t.Fatal(err)
+		}
+		body := string(res.Body())
+		if res.StatusCode() != fasthttp.StatusOK {
+			t.Errorf("for %s, expected %d but got %d", tt.name, fasthttp.StatusOK, res.StatusCode())
+		}
+		if strings.Contains(body, "<script>alert") || strings.Contains(body, "'x'") {
+			t.Errorf("for %s, user input rendered unescaped", tt.name)
+		}
+		if !strings.Contains(body, "&lt;script&gt;") {
+			t.Errorf("for %s, escaped user input not found", tt.name)
+		}
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(res)
+	}
+}
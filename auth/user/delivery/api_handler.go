@@ -0,0 +1,318 @@
+package delivery
+
+import (
+	"auth/domain"
+	"auth/logger"
+	"auth/myerrors"
+	"auth/user/delivery/middleware"
+	"auth/user/delivery/response"
+	"auth/user/usecase"
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/buaazp/fasthttprouter"
+	"github.com/valyala/fasthttp"
+)
+
+// openAPISpec describes /api/v1, served at /api/v1/openapi.json
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// Limits of list pages of /api/v1
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// walletUnavailable is answered with 502 when wallet service fails
+const walletUnavailable = "wallet service is unavailable, please try again later"
+
+// APIHandler serves /api/v1, JSON API for mobile and other clients not using pages.
+// Errors are returned as domain.Response with ok set to false
+type APIHandler struct {
+	info         usecase.GetInfoUsecase
+	transactions usecase.GetTransactionsUsecase
+	topup        usecase.TopupUsecase
+	transfer     usecase.TransferUsecase
+	wallets      usecase.AddWalletUsecase
+}
+
+// apiUser returns user of the request and its token for wallet service
+func apiUser(ctx *fasthttp.RequestCtx) (domain.User, string, bool) {
+	token, _ := ctx.Value("access").(string)
+	user, ok := ctx.Value("user").(domain.User)
+	if !ok || token == "" {
+		logger.Ctx(ctx).Error("API: user or token not found in ctx")
+		response.RespondInternalServerError(ctx)
+		return domain.User{}, "", false
+	}
+	return user, token, true
+}
+
+// pageParams reads limit and offset query arguments of list resources
+func pageParams(ctx *fasthttp.RequestCtx) (limit, offset int, err error) {
+	limit, offset = defaultPageLimit, 0
+	if value := ctx.QueryArgs().Peek("limit"); len(value) != 0 {
+		if limit, err = strconv.Atoi(string(value)); err != nil || limit < 1 || limit > maxPageLimit {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+	}
+	if value := ctx.QueryArgs().Peek("offset"); len(value) != 0 {
+		if offset, err = strconv.Atoi(string(value)); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("offset must not be negative")
+		}
+	}
+	return limit, offset, nil
+}
+
+// page returns bounds of the page within total items and the envelope for it, items are set by the caller
+func page(ctx *fasthttp.RequestCtx, total, limit, offset int) (int, int, domain.Page) {
+	start, end := offset, offset+limit
+	if start > total {
+		start = total
+	}
+	if end > total {
+		end = total
+	}
+	p := domain.Page{Total: total, Limit: limit, Offset: offset}
+	if end < total {
+		p.Next = fmt.Sprintf("%s?limit=%d&offset=%d", ctx.Path(), limit, end)
+	}
+	return start, end, p
+}
+
+// decodeJSON reads JSON body into v, responding with error if it can't
+func decodeJSON(ctx *fasthttp.RequestCtx, v interface{}) bool {
+	if !bytes.HasPrefix(ctx.Request.Header.ContentType(), []byte("application/json")) {
+		response.RespondWithError(ctx, fasthttp.StatusUnsupportedMediaType, "request body must be application/json")
+		return false
+	}
+	if err := json.Unmarshal(ctx.PostBody(), v); err != nil {
+		response.RespondWithError(ctx, fasthttp.StatusBadRequest, "invalid JSON body")
+		return false
+	}
+	return true
+}
+
+// walletOperation returns message of wallet service on success, responding with error otherwise
+func walletOperation(ctx *fasthttp.RequestCtx, respBytes []byte, status int, err error) (string, bool) {
+	if err != nil {
+		logger.Ctx(ctx).Error("API: couldn't get response from walletService", "err", err)
+		response.RespondWithError(ctx, fasthttp.StatusBadGateway, walletUnavailable)
+		return "", false
+	}
+	var resp domain.Response
+	if err := json.Unmarshal(respBytes, &resp); err != nil {
+		logger.Ctx(ctx).Error("API: getting response from walletService", "err", err)
+		response.RespondWithError(ctx, fasthttp.StatusBadGateway, walletUnavailable)
+		return "", false
+	}
+	if resp.OK {
+		return resp.Message, true
+	}
+	if status == fasthttp.StatusBadRequest {
+		response.RespondWithError(ctx, fasthttp.StatusUnprocessableEntity, resp.Message)
+		return "", false
+	}
+	logger.Ctx(ctx).Error("API: walletService answered with status", "status", status)
+	response.RespondWithError(ctx, fasthttp.StatusBadGateway, walletUnavailable)
+	return "", false
+}
+
+// OpenAPI serves OpenAPI 3 document of /api/v1
+func (h *APIHandler) OpenAPI(ctx *fasthttp.RequestCtx) {
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(openAPISpec)
+}
+
+// User returns the signed in user
+func (h *APIHandler) User(ctx *fasthttp.RequestCtx) {
+	u, _, ok := apiUser(ctx)
+	if !ok {
+		return
+	}
+	user, err := h.info.GetUserInfo(u.IIN)
+	if err == myerrors.ErrUserNotFound {
+		response.RespondWithError(ctx, fasthttp.StatusNotFound, "user not found")
+		return
+	}
+	if err != nil {
+		logger.Ctx(ctx).Error("API: getting user info from DB", "err", err)
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	response.ResponseAPI(ctx, fasthttp.StatusOK, domain.APIUser{
+		IIN:         user.IIN,
+		Username:    user.Username,
+		Email:       user.Email,
+		CreatedAt:   user.Ts,
+		Roles:       u.Roles,
+		Permissions: u.Permissions,
+	})
+}
+
+// Wallets lists wallets of the user
+func (h *APIHandler) Wallets(ctx *fasthttp.RequestCtx) {
+	limit, offset, err := pageParams(ctx)
+	if err != nil {
+		response.RespondWithError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+	user, token, ok := apiUser(ctx)
+	if !ok {
+		return
+	}
+	wallets, err := h.info.GetWalletInfo(user.IIN, token)
+	if err != nil {
+		logger.Ctx(ctx).Error("API: getting wallets", "err", err)
+		response.RespondWithError(ctx, fasthttp.StatusBadGateway, walletUnavailable)
+		return
+	}
+	start, end, p := page(ctx, len(wallets), limit, offset)
+	p.Items = append([]domain.Wallet{}, wallets[start:end]...)
+	response.ResponseAPI(ctx, fasthttp.StatusOK, p)
+}
+
+// CreateWallet opens new wallet for the user
+func (h *APIHandler) CreateWallet(ctx *fasthttp.RequestCtx) {
+	_, token, ok := apiUser(ctx)
+	if !ok {
+		return
+	}
+	account, err := h.wallets.AddWallet(token)
+	if err != nil {
+		logger.Ctx(ctx).Error("API: couldn't add wallet", "err", err)
+		response.RespondWithError(ctx, fasthttp.StatusBadGateway, walletUnavailable)
+		return
+	}
+	ctx.Response.Header.Set(fasthttp.HeaderLocation, "/api/v1/wallets/"+account+"/transactions")
+	middleware.SetAuditTarget(ctx, account)
+	response.ResponseAPI(ctx, fasthttp.StatusCreated, domain.NewWallet{AccountNo: account})
+}
+
+// Transactions lists transactions of a wallet of the user
+func (h *APIHandler) Transactions(ctx *fasthttp.RequestCtx) {
+	account, _ := ctx.UserValue("account").(string)
+	if !validAcc(account) {
+		response.RespondWithError(ctx, fasthttp.StatusBadRequest, myerrors.ErrInvalidAcc.Error())
+		return
+	}
+	limit, offset, err := pageParams(ctx)
+	if err != nil {
+		response.RespondWithError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+	_, token, ok := apiUser(ctx)
+	if !ok {
+		return
+	}
+	transactions, err := h.transactions.GetTransactions(token, account)
+	if err != nil {
+		logger.Ctx(ctx).Error("API: getting transactions", "err", err)
+		response.RespondWithError(ctx, fasthttp.StatusBadGateway, walletUnavailable)
+		return
+	}
+	start, end, p := page(ctx, len(transactions), limit, offset)
+	p.Items = append([]domain.Transaction{}, transactions[start:end]...)
+	response.ResponseAPI(ctx, fasthttp.StatusOK, p)
+}
+
+// TopUp tops up a wallet of the user
+func (h *APIHandler) TopUp(ctx *fasthttp.RequestCtx) {
+	var req domain.TopupRequest
+	if !decodeJSON(ctx, &req) {
+		return
+	}
+	amount := strconv.Itoa(req.Amount)
+	middleware.SetAuditTarget(ctx, req.Account)
+	if !validAcc(req.Account) {
+		response.RespondWithError(ctx, fasthttp.StatusBadRequest, myerrors.ErrInvalidAcc.Error())
+		return
+	}
+	if !validAmt(amount) {
+		response.RespondWithError(ctx, fasthttp.StatusBadRequest, myerrors.ErrInvalidAmt.Error())
+		return
+	}
+	user, token, ok := apiUser(ctx)
+	if !ok {
+		return
+	}
+	respBytes, status, err := h.topup.TopUp(user.IIN, req.Account, amount, token)
+	message, ok := walletOperation(ctx, respBytes, status, err)
+	if !ok {
+		return
+	}
+	logger.Ctx(ctx).Info("API: topped up", "account", req.Account, "amount", req.Amount)
+	response.ResponseAPI(ctx, fasthttp.StatusOK, domain.Operation{Type: "topup", To: req.Account, Amount: req.Amount, Message: message})
+}
+
+// Transfer transfers money from a wallet of the user
+func (h *APIHandler) Transfer(ctx *fasthttp.RequestCtx) {
+	var req domain.TransferRequest
+	if !decodeJSON(ctx, &req) {
+		return
+	}
+	amount := strconv.Itoa(req.Amount)
+	middleware.SetAuditTarget(ctx, req.From+" -> "+req.To)
+	switch {
+	case !validAmt(amount):
+		response.RespondWithError(ctx, fasthttp.StatusBadRequest, myerrors.ErrInvalidAmt.Error())
+		return
+	case !validAcc(req.From) || !validAcc(req.To):
+		response.RespondWithError(ctx, fasthttp.StatusBadRequest, myerrors.ErrInvalidAcc.Error())
+		return
+	case req.From == req.To:
+		response.RespondWithError(ctx, fasthttp.StatusBadRequest, myerrors.ErrSameAccount.Error())
+		return
+	}
+	user, token, ok := apiUser(ctx)
+	if !ok {
+		return
+	}
+	respBytes, status, err := h.transfer.Transfer(user.IIN, req.From, req.To, amount, token)
+	message, ok := walletOperation(ctx, respBytes, status, err)
+	if !ok {
+		return
+	}
+	logger.Ctx(ctx).Info("API: transferred", "amount", req.Amount, "from", req.From, "to", req.To)
+	response.ResponseAPI(ctx, fasthttp.StatusOK, domain.Operation{Type: "transfer", From: req.From, To: req.To, Amount: req.Amount, Message: message})
+}
+
+// jsonAPI makes every answer of the route JSON, including errors of middlewares
+func jsonAPI(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		ctx.SetContentType("application/json")
+		next(ctx)
+	}
+}
+
+// NewAPIHandler sets /api/v1 routes. Reads are open to OAuth clients granted wallets:read like the pages,
+// writes are rate limited together with the pages
+func NewAPIHandler(r *fasthttprouter.Router, info usecase.GetInfoUsecase, transactions usecase.GetTransactionsUsecase,
+	topup usecase.TopupUsecase, transfer usecase.TransferUsecase, wallets usecase.AddWalletUsecase) {
+	handler := &APIHandler{
+		info:         info,
+		transactions: transactions,
+		topup:        topup,
+		transfer:     transfer,
+		wallets:      wallets,
+	}
+	read := func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return jsonAPI(middleware.SecretMiddleware(middleware.CheckDelegatedAuthMiddleware(middleware.RequirePermission(domain.PermWalletsRead, next))))
+	}
+	write := func(limit, action string, next fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return jsonAPI(middleware.SecretMiddleware(middleware.CheckAuthMiddleware(middleware.Audit(action, middleware.RateLimit(limit, middleware.RequirePermission(domain.PermWalletsWrite, next))))))
+	}
+	r.GET("/api/v1/openapi.json", handler.OpenAPI)
+	r.GET("/api/v1/user", jsonAPI(middleware.SecretMiddleware(middleware.CheckDelegatedAuthMiddleware(handler.User))))
+	r.GET("/api/v1/wallets", read(handler.Wallets))
+	r.POST("/api/v1/wallets", write("add", domain.AuditWalletCreate, handler.CreateWallet))
+	r.GET("/api/v1/wallets/:account/transactions", read(handler.Transactions))
+	r.POST("/api/v1/topup", write("topup", domain.AuditTopup, handler.TopUp))
+	r.POST("/api/v1/transfer", write("transfer", domain.AuditTransfer, handler.Transfer))
+}
@@ -0,0 +1,179 @@
+package delivery
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestAPIHandlers(t *testing.T) {
+	r := getRoutes()
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	s := &fasthttp.Server{
+		Handler: r,
+	}
+
+	go s.Serve(ln) //nolint:errcheck
+	c := &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	tokens := map[string]string{}
+	for _, IIN := range []string{"910815450350", "many", "wrong", "rejected", "err"} {
+		access, _, err := GenerateTestTokens(IIN)
+		if err != nil {
+			t.Fatal("Couldn't generate token", err)
+		}
+		tokens[IIN] = access
+	}
+	admin, _, err := GenerateAdminTestTokens("admin")
+	if err != nil {
+		t.Fatal("Couldn't generate token", err)
+	}
+	tokens["admin"] = admin
+
+	const user = "910815450350"
+	const jsonType = "application/json"
+	var testTable = []struct {
+		name        string
+		method      string
+		path        string
+		IIN         string
+		cookie      bool
+		contentType string
+		body        string
+		expected    int
+		// contains is part of the expected body
+		contains string
+	}{
+		{"user", "GET", "/api/v1/user", user, false, "", "", fasthttp.StatusOK, `"iin":"910815450350"`},
+		{"user by cookie", "GET", "/api/v1/user", user, true, "", "", fasthttp.StatusOK, `"roles":["user"]`},
+		{"user not logged in", "GET", "/api/v1/user", "", false, "", "", fasthttp.StatusUnauthorized, `"ok":false`},
+		{"wallets first page", "GET", "/api/v1/wallets?limit=10", "many", false, "", "", fasthttp.StatusOK, `"total":25,"limit":10,"offset":0,"next":"/api/v1/wallets?limit=10&offset=10"`},
+		{"wallets last page", "GET", "/api/v1/wallets?limit=10&offset=20", "many", false, "", "", fasthttp.StatusOK, `"total":25,"limit":10,"offset":20}`},
+		{"wallets past the end", "GET", "/api/v1/wallets?offset=30", "many", false, "", "", fasthttp.StatusOK, `{"items":[],"total":25,"limit":20,"offset":30}`},
+		{"wallets empty", "GET", "/api/v1/wallets", user, false, "", "", fasthttp.StatusOK, `{"items":[],"total":0,"limit":20,"offset":0}`},
+		{"wallets zero limit", "GET", "/api/v1/wallets?limit=0", user, false, "", "", fasthttp.StatusBadRequest, "limit must be between"},
+		{"wallets big limit", "GET", "/api/v1/wallets?limit=101", user, false, "", "", fasthttp.StatusBadRequest, "limit must be between"},
+		{"wallets negative offset", "GET", "/api/v1/wallets?offset=-1", user, false, "", "", fasthttp.StatusBadRequest, "offset must not be negative"},
+		{"wallets service down", "GET", "/api/v1/wallets", "wrong", false, "", "", fasthttp.StatusBadGateway, walletUnavailable},
+		{"wallets no permission", "GET", "/api/v1/wallets", "admin", false, "", "", fasthttp.StatusForbidden, "forbidden"},
+		{"create wallet", "POST", "/api/v1/wallets", user, false, "", "", fasthttp.StatusCreated, `{"accountno":"ss"}`},
+		{"create wallet no permission", "POST", "/api/v1/wallets", "admin", false, "", "", fasthttp.StatusForbidden, "forbidden"},
+		{"transactions", "GET", "/api/v1/wallets/KZT0000000099/transactions?limit=2", user, false, "", "", fasthttp.StatusOK, `"total":3,"limit":2,"offset":0,"next":"/api/v1/wallets/KZT0000000099/transactions?limit=2&offset=2"`},
+		{"transactions wrong account", "GET", "/api/v1/wallets/KZT1/transactions", user, false, "", "", fasthttp.StatusBadRequest, "invalid account"},
+		{"transactions service down", "GET", "/api/v1/wallets/KZT9999999999/transactions", user, false, "", "", fasthttp.StatusBadGateway, walletUnavailable},
+		{"topup", "POST", "/api/v1/topup", user, false, jsonType, `{"account":"KZT0000000001","amount":100}`, fasthttp.StatusOK, `{"type":"topup","to":"KZT0000000001","amount":100,"message":"100"}`},
+		{"topup by cookie", "POST", "/api/v1/topup", user, true, jsonType + "; charset=utf-8", `{"account":"KZT0000000001","amount":100}`, fasthttp.StatusOK, `"type":"topup"`},
+		{"topup form", "POST", "/api/v1/topup", user, false, "application/x-www-form-urlencoded", "accountno=KZT0000000001&amount=100", fasthttp.StatusUnsupportedMediaType, "application/json"},
+		{"topup malformed", "POST", "/api/v1/topup", user, false, jsonType, `{"account":"KZT0000000001","amount":"100"}`, fasthttp.StatusBadRequest, "invalid JSON body"},
+		{"topup zero amount", "POST", "/api/v1/topup", user, false, jsonType, `{"account":"KZT0000000001","amount":0}`, fasthttp.StatusBadRequest, "invalid amount"},
+		{"topup wrong account", "POST", "/api/v1/topup", user, false, jsonType, `{"account":"USD0000000001","amount":100}`, fasthttp.StatusBadRequest, "invalid account"},
+		{"topup rejected", "POST", "/api/v1/topup", "rejected", false, jsonType, `{"account":"KZT0000000001","amount":100}`, fasthttp.StatusUnprocessableEntity, "account not found"},
+		{"topup service down", "POST", "/api/v1/topup", "err", false, jsonType, `{"account":"KZT0000000001","amount":100}`, fasthttp.StatusBadGateway, walletUnavailable},
+		{"transfer", "POST", "/api/v1/transfer", user, false, jsonType, `{"from":"KZT0000000001","to":"KZT0000000002","amount":5}`, fasthttp.StatusOK, `{"type":"transfer","from":"KZT0000000001","to":"KZT0000000002","amount":5`},
+		{"transfer same account", "POST", "/api/v1/transfer", user, false, jsonType, `{"from":"KZT0000000001","to":"KZT0000000001","amount":5}`, fasthttp.StatusBadRequest, "same account"},
+		{"transfer no permission", "POST", "/api/v1/transfer", "admin", false, jsonType, `{"from":"KZT0000000001","to":"KZT0000000002","amount":5}`, fasthttp.StatusForbidden, "forbidden"},
+	}
+	for _, tt := range testTable {
+		req, res := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		req.Header.SetMethod(tt.method)
+		req.SetRequestURI(URI + tt.path)
+		if tt.IIN != "" && tt.cookie {
+			req.Header.SetCookie("access", tokens[tt.IIN])
+		} else if tt.IIN != "" {
+			req.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+tokens[tt.IIN])
+		}
+		if tt.contentType != "" {
+			req.Header.SetContentType(tt.contentType)
+			req.SetBodyString(tt.body)
+		}
+		setTestCSRF(req)
+		if err := c.Do(req, res); err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode() != tt.expected {
+			t.Errorf("for %s, expected %d but got %d: %s", tt.name, tt.expected, res.StatusCode(), res.Body())
+		}
+		if contentType := string(res.Header.ContentType()); contentType != "application/json" {
+			t.Errorf("for %s, expected JSON but got %s", tt.name, contentType)
+		}
+		if !strings.Contains(string(res.Body()), tt.contains) {
+			t.Errorf("for %s, expected %s in body %s", tt.name, tt.contains, res.Body())
+		}
+		if strings.Contains(string(res.Body()), "password") {
+			t.Errorf("for %s, password exposed: %s", tt.name, res.Body())
+		}
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(res)
+	}
+}
+
+func TestAPIOpenAPI(t *testing.T) {
+	r := getRoutes()
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	s := &fasthttp.Server{
+		Handler: r,
+	}
+
+	go s.Serve(ln) //nolint:errcheck
+	c := &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	req, res := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(res)
+	req.SetRequestURI(URI + "/api/v1/openapi.json")
+	if err := c.Do(req, res); err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("expected 200 but got %d", res.StatusCode())
+	}
+	var spec struct {
+		OpenAPI string                                `json:"openapi"`
+		Servers []struct{ URL string }                `json:"servers"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(res.Body(), &spec); err != nil {
+		t.Fatal("OpenAPI document is not JSON:", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") || len(spec.Servers) != 1 || spec.Servers[0].URL != "/api/v1" {
+		t.Errorf("unexpected OpenAPI document header %s %v", spec.OpenAPI, spec.Servers)
+	}
+	// every route of /api/v1 is documented
+	routes := map[string][]string{
+		"/user":                           {"get"},
+		"/wallets":                        {"get", "post"},
+		"/wallets/{account}/transactions": {"get"},
+		"/topup":                          {"post"},
+		"/transfer":                       {"post"},
+	}
+	for path, methods := range routes {
+		for _, method := range methods {
+			if _, ok := spec.Paths[path][method]; !ok {
+				t.Errorf("%s %s is not documented", method, path)
+			}
+		}
+	}
+	if len(spec.Paths) != len(routes) {
+		t.Errorf("expected %d documented paths but got %d", len(routes), len(spec.Paths))
+	}
+}
@@ -0,0 +1,180 @@
+package delivery
+
+import (
+	"auth/domain"
+	"auth/logger"
+	"auth/myerrors"
+	"auth/user/delivery/middleware"
+	"auth/user/delivery/render"
+	"auth/user/delivery/response"
+	"auth/user/usecase"
+	"encoding/csv"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/buaazp/fasthttprouter"
+	"github.com/valyala/fasthttp"
+)
+
+const InvalidAuditFilterMessage = "неверный фильтр: даты в формате ГГГГ-ММ-ДД, начало не позже конца, лимит до 500"
+
+type AuditHandler struct {
+	uc usecase.AuditUsecase
+	t  *template.Template
+}
+
+// auditFilter reads filter from query, malformed limit or offset are reported as ErrInvalidInput
+func auditFilter(ctx *fasthttp.RequestCtx) (*domain.AuditFilter, error) {
+	args := ctx.QueryArgs()
+	filter := &domain.AuditFilter{
+		ActorIIN: string(args.Peek("actor")),
+		Action:   string(args.Peek("action")),
+		Target:   string(args.Peek("target")),
+		Outcome:  string(args.Peek("outcome")),
+		From:     string(args.Peek("from")),
+		To:       string(args.Peek("to")),
+	}
+	var err error
+	if value := args.Peek("limit"); len(value) != 0 {
+		if filter.Limit, err = strconv.Atoi(string(value)); err != nil {
+			return filter, myerrors.ErrInvalidInput
+		}
+	}
+	if value := args.Peek("offset"); len(value) != 0 {
+		if filter.Offset, err = strconv.Atoi(string(value)); err != nil {
+			return filter, myerrors.ErrInvalidInput
+		}
+	}
+	return filter, nil
+}
+
+// auditQuery encodes filter as query of audit log links, leaving out empty fields
+func auditQuery(filter domain.AuditFilter) string {
+	query := url.Values{}
+	for name, value := range map[string]string{
+		"actor":   filter.ActorIIN,
+		"action":  filter.Action,
+		"target":  filter.Target,
+		"outcome": filter.Outcome,
+		"from":    filter.From,
+		"to":      filter.To,
+	} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	if filter.Limit != 0 {
+		query.Set("limit", strconv.Itoa(filter.Limit))
+	}
+	if filter.Offset != 0 {
+		query.Set("offset", strconv.Itoa(filter.Offset))
+	}
+	return query.Encode()
+}
+
+// GetAuditLog shows page of audit events matching filter in query, newest first
+func (h *AuditHandler) GetAuditLog(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("GetAuditLog hit")
+	filter, err := auditFilter(ctx)
+	if err != nil {
+		h.respond(ctx, fasthttp.StatusBadRequest, domain.AuditLog{Filter: *filter, Error: InvalidAuditFilterMessage})
+		return
+	}
+	events, total, err := h.uc.ListEvents(filter)
+	if err != nil {
+		logger.Ctx(ctx).Error("Couldn't list audit events", "err", err)
+		if err == myerrors.ErrInvalidInput {
+			h.respond(ctx, fasthttp.StatusBadRequest, domain.AuditLog{Filter: *filter, Error: InvalidAuditFilterMessage})
+			return
+		}
+		h.respond(ctx, fasthttp.StatusInternalServerError, domain.AuditLog{Filter: *filter, Error: InternalServerErrorMessage})
+		return
+	}
+	auditLog := domain.AuditLog{Filter: *filter, Events: events, Total: total}
+	if filter.Offset > 0 {
+		prev := *filter
+		prev.Offset -= prev.Limit
+		if prev.Offset < 0 {
+			prev.Offset = 0
+		}
+		auditLog.Prev = "/admin/audit?" + auditQuery(prev)
+	}
+	if filter.Offset+len(events) < total {
+		next := *filter
+		next.Offset += next.Limit
+		auditLog.Next = "/admin/audit?" + auditQuery(next)
+	}
+	h.respond(ctx, fasthttp.StatusOK, auditLog)
+}
+
+// ExportAuditLog returns all audit events matching filter in query as CSV, paging is ignored
+func (h *AuditHandler) ExportAuditLog(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("ExportAuditLog hit")
+	filter, err := auditFilter(ctx)
+	if err != nil {
+		response.RespondWithError(ctx, fasthttp.StatusBadRequest, InvalidAuditFilterMessage)
+		return
+	}
+	events, err := h.uc.ExportEvents(filter)
+	if err != nil {
+		logger.Ctx(ctx).Error("Couldn't export audit events", "err", err)
+		if err == myerrors.ErrInvalidInput {
+			response.RespondWithError(ctx, fasthttp.StatusBadRequest, InvalidAuditFilterMessage)
+			return
+		}
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	logger.Ctx(ctx).Security("Audit log exported", "iin", ctx.UserValue("iin"), "events", len(events))
+	ctx.SetContentType("text/csv; charset=utf-8")
+	ctx.Response.Header.Set("Content-Disposition", `attachment; filename="audit.csv"`)
+	ctx.Response.Header.Set("Cache-Control", "no-store")
+	w := csv.NewWriter(ctx)
+	w.Write([]string{"id", "ts", "actor_iin", "action", "target", "ip", "user_agent", "outcome"})
+	for _, event := range events {
+		w.Write([]string{strconv.Itoa(event.ID), event.Ts, csvCell(event.ActorIIN), event.Action, csvCell(event.Target),
+			csvCell(event.IP), csvCell(event.UserAgent), event.Outcome})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		logger.Ctx(ctx).Error("Writing audit CSV", "err", err)
+	}
+}
+
+// csvCell keeps values sent by clients, like user agent or target username, from being run as formulas by spreadsheets
+func csvCell(value string) string {
+	if value != "" && strings.ContainsAny(value[:1], "=+-@\t\r") {
+		return "'" + value
+	}
+	return value
+}
+
+// respond renders audit log page or returns JSON to API clients
+func (h *AuditHandler) respond(ctx *fasthttp.RequestCtx, status int, auditLog domain.AuditLog) {
+	if response.WantsJSON(ctx) {
+		ctx.SetStatusCode(status)
+		ctx.SetContentType("application/json")
+		json.NewEncoder(ctx).Encode(auditLog)
+		return
+	}
+	auditLog.Actions, auditLog.Outcomes = domain.AuditActions, domain.AuditOutcomes
+	if err := render.RenderTemplate(ctx, status, h.t, auditLog); err != nil {
+		logger.Ctx(ctx).Error("Executing template", "err", err)
+	}
+}
+
+// NewAuditHandler sets /admin/audit routes, both require audit:read permission
+func NewAuditHandler(r *fasthttprouter.Router, uc usecase.AuditUsecase, t *template.Template) {
+	handler := &AuditHandler{
+		uc: uc,
+		t:  t,
+	}
+	read := func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return middleware.SecretMiddleware(middleware.CheckAuthMiddleware(middleware.RequirePermission(domain.PermAuditRead, next)))
+	}
+	r.GET("/admin/audit", read(handler.GetAuditLog))
+	r.GET("/admin/audit.csv", read(handler.ExportAuditLog))
+}
@@ -0,0 +1,184 @@
+package delivery
+
+import (
+	"auth/domain"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+var testTableAudit = []struct {
+	name               string
+	url                string
+	json               bool
+	admin              bool
+	expectedStatusCode int
+}{
+	{"get-audit", "/admin/audit", false, true, fasthttp.StatusOK},
+	{"get-audit json", "/admin/audit?action=login&outcome=success", true, true, fasthttp.StatusOK},
+	{"get-audit dates", "/admin/audit?from=2022-01-01&to=2022-01-31", false, true, fasthttp.StatusOK},
+	{"get-audit bad date", "/admin/audit?from=01.01.2022", true, true, fasthttp.StatusBadRequest},
+	{"get-audit reversed dates", "/admin/audit?from=2022-02-01&to=2022-01-01", false, true, fasthttp.StatusBadRequest},
+	{"get-audit bad limit", "/admin/audit?limit=many", false, true, fasthttp.StatusBadRequest},
+	{"get-audit limit too big", "/admin/audit?limit=1000", false, true, fasthttp.StatusBadRequest},
+	{"get-audit some err", "/admin/audit?actor=sthwrong", false, true, fasthttp.StatusInternalServerError},
+	{"get-audit not admin", "/admin/audit", false, false, fasthttp.StatusForbidden},
+	{"get-audit-csv", "/admin/audit.csv?action=login", false, true, fasthttp.StatusOK},
+	{"get-audit-csv bad date", "/admin/audit.csv?to=tomorrow", false, true, fasthttp.StatusBadRequest},
+	{"get-audit-csv not admin", "/admin/audit.csv", false, false, fasthttp.StatusForbidden},
+}
+
+func TestAuditHandlers(t *testing.T) {
+	r := getRoutes()
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	s := &fasthttp.Server{
+		Handler: r,
+	}
+
+	go s.Serve(ln) //nolint:errcheck
+	c := &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	adminAccess, _, err := GenerateAdminTestTokens("0")
+	if err != nil {
+		t.Fatal("Couldn't generate token", err)
+	}
+	userAccess, _, err := GenerateTestTokens("910815450350")
+	if err != nil {
+		t.Fatal("Couldn't generate token", err)
+	}
+
+	for _, tt := range testTableAudit {
+		fmt.Println("Testing", tt.name, "******************************************************************************************")
+		req, res := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		req.SetRequestURI(URI + tt.url)
+		if tt.admin {
+			req.Header.SetCookie("access", adminAccess)
+		} else {
+			req.Header.SetCookie("access", userAccess)
+		}
+		if tt.json {
+			req.Header.Set("Accept", "application/json")
+		}
+		if err := c.Do(req, res); err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode() != tt.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", tt.name, tt.expectedStatusCode, res.StatusCode())
+		}
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(res)
+	}
+}
+
+func TestAuditRecorded(t *testing.T) {
+	r := getRoutes()
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	s := &fasthttp.Server{
+		Handler: r,
+	}
+
+	go s.Serve(ln) //nolint:errcheck
+	c := &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	do := func(req *fasthttp.Request) *fasthttp.Response {
+		res := fasthttp.AcquireResponse()
+		if err := c.Do(req, res); err != nil {
+			t.Fatal(err)
+		}
+		fasthttp.ReleaseRequest(req)
+		return res
+	}
+	login := func(username, password string) {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod(fasthttp.MethodPost)
+		req.SetRequestURI(URI + "/login")
+		req.Header.SetContentType("application/x-www-form-urlencoded")
+		req.Header.SetUserAgent("=cmd|' /C calc'!A0")
+		req.SetBodyString("login=" + username + "&password=" + password)
+		setTestCSRF(req)
+		fasthttp.ReleaseResponse(do(req))
+	}
+
+	var testTable = []struct {
+		name     string
+		username string
+		password string
+		expected domain.AuditEvent
+	}{
+		{"success", "audited", PASSWORD, domain.AuditEvent{ActorIIN: "audited", Target: "audited", Outcome: domain.AuditSuccess}},
+		{"wrong password", "audited", "wrong!", domain.AuditEvent{ActorIIN: "audited", Target: "audited", Outcome: domain.AuditFailure}},
+		{"unknown user", "unknown", PASSWORD, domain.AuditEvent{Target: "unknown", Outcome: domain.AuditFailure}},
+		{"mfa required", "mfa", PASSWORD, domain.AuditEvent{ActorIIN: "mfa", Target: "mfa", Outcome: domain.AuditMFARequired}},
+	}
+	for _, tt := range testTable {
+		login(tt.username, tt.password)
+		event, ok := auditLog.last(domain.AuditLogin)
+		if !ok {
+			t.Fatalf("for %s, login wasn't recorded", tt.name)
+		}
+		if event.ActorIIN != tt.expected.ActorIIN || event.Target != tt.expected.Target || event.Outcome != tt.expected.Outcome ||
+			event.IP == "" || event.UserAgent == "" {
+			t.Errorf("for %s, unexpected event %+v", tt.name, event)
+		}
+	}
+
+	adminAccess, _, err := GenerateAdminTestTokens("0")
+	if err != nil {
+		t.Fatal("Couldn't generate token", err)
+	}
+	req := fasthttp.AcquireRequest()
+	req.SetRequestURI(URI + "/admin/audit?action=login&target=audited&limit=1")
+	req.Header.SetCookie("access", adminAccess)
+	req.Header.Set("Accept", "application/json")
+	res := do(req)
+	var page domain.AuditLog
+	if err := json.Unmarshal(res.Body(), &page); err != nil {
+		t.Fatal("unexpected audit log response", string(res.Body()))
+	}
+	fasthttp.ReleaseResponse(res)
+	if page.Total < 2 || len(page.Events) != 1 || page.Events[0].Outcome != domain.AuditFailure || page.Next == "" || page.Prev != "" {
+		t.Errorf("unexpected audit log page %+v", page)
+	}
+
+	req = fasthttp.AcquireRequest()
+	req.SetRequestURI(URI + "/admin/audit.csv?action=login&target=audited")
+	req.Header.SetCookie("access", adminAccess)
+	res = do(req)
+	defer fasthttp.ReleaseResponse(res)
+	if !strings.HasPrefix(string(res.Header.ContentType()), "text/csv") || !strings.Contains(string(res.Header.Peek("Content-Disposition")), "attachment") {
+		t.Errorf("unexpected CSV headers %s", res.Header.String())
+	}
+	rows, err := csv.NewReader(strings.NewReader(string(res.Body()))).ReadAll()
+	if err != nil || len(rows) < 3 {
+		t.Fatalf("unexpected CSV %q: %v", res.Body(), err)
+	}
+	if strings.Join(rows[0], ",") != "id,ts,actor_iin,action,target,ip,user_agent,outcome" {
+		t.Errorf("unexpected CSV header %v", rows[0])
+	}
+	// user agent set by the client must not be run as a formula
+	if ua := rows[1][6]; !strings.HasPrefix(ua, "'=") {
+		t.Errorf("expected escaped user agent but got %q", ua)
+	}
+}
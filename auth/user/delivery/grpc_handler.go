@@ -0,0 +1,360 @@
+package delivery
+
+import (
+	"auth/config"
+	"auth/domain"
+	"auth/logger"
+	"auth/myerrors"
+	authv1 "auth/proto/auth/v1"
+	"auth/signing"
+	"auth/user/delivery/middleware"
+	"auth/user/usecase"
+	"context"
+	"net"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type GRPCHandler struct {
+	authv1.UnimplementedAuthServiceServer
+	login    usecase.LoginUsecase
+	attempts usecase.LoginAttemptsUsecase
+	mfa      usecase.MFAUsecase
+	update   usecase.UpdateTokenUsecase
+	info     usecase.GetInfoUsecase
+	sessions usecase.SessionsUsecase
+	logout   usecase.LogoutUsecase
+}
+
+var errInvalidToken = status.Error(codes.Unauthenticated, "invalid or expired token")
+
+// secrets returns token signing keys and token policy, what SecretMiddleware does for fasthttp routes
+func secrets(ctx context.Context) (*signing.KeySet, *config.TokenPolicy, error) {
+	keys, err := signing.Default()
+	if err != nil {
+		logger.Ctx(ctx).Error("Error retrieving signing keys", "err", err)
+		return nil, nil, status.Error(codes.Internal, "internal error")
+	}
+	policy, err := config.DefaultTokenPolicy()
+	if err != nil {
+		logger.Ctx(ctx).Error("Error retrieving token policy", "err", err)
+		return nil, nil, status.Error(codes.Internal, "internal error")
+	}
+	return keys, policy, nil
+}
+
+// peerIP returns IP address of the caller, used to count failed logins like RemoteIP of fasthttp
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// userAgent returns user-agent metadata sent by the caller
+func userAgent(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get("user-agent"); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// tokenPair makes TokenPair message
+func tokenPair(access, refresh string, policy *config.TokenPolicy) *authv1.TokenPair {
+	return &authv1.TokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(policy.AccessTtl.Seconds()),
+	}
+}
+
+// Login checks credentials following LogIn: failed attempts are counted, locked accounts are rejected and
+// users with two-factor authentication have to send the code in the same request
+func (h *GRPCHandler) Login(ctx context.Context, req *authv1.LoginRequest) (res *authv1.LoginResponse, err error) {
+	logger.Ctx(ctx).Debug("gRPC Login hit")
+	event := newAuditEvent(ctx, domain.AuditLogin)
+	event.Target = req.Username
+	defer func() { recordAudit(event, err) }()
+	keys, policy, err := secrets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	IP := peerIP(ctx)
+	if retryAfter, err := h.attempts.CheckAllowed(req.Username, IP); err != nil {
+		logger.Ctx(ctx).Error("Login not allowed", "err", err)
+		if err == myerrors.ErrLoginLocked || err == myerrors.ErrTooManyAttempts {
+			return nil, status.Errorf(codes.ResourceExhausted, "too many login attempts, try again in %v", retryAfter.Round(time.Second))
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	user, err := h.login.GetUser(req.Username)
+	if err != nil {
+		logger.Ctx(ctx).Error("Couldn't find user", "err", err)
+		if err != myerrors.ErrUserNotFound {
+			return nil, status.Error(codes.Internal, "internal error")
+		}
+		h.loginFailed(ctx, req.Username, IP)
+		return nil, status.Error(codes.Unauthenticated, "invalid username or password")
+	}
+	event.ActorIIN = user.IIN
+	if err := h.login.CheckPassword(user, req.Password); err != nil {
+		logger.Ctx(ctx).Error("Invalid password", "err", err)
+		h.loginFailed(ctx, req.Username, IP)
+		return nil, status.Error(codes.Unauthenticated, "invalid username or password")
+	}
+	if user.Locked {
+		logger.Ctx(ctx).Error("Login attempt to locked account", "iin", user.IIN)
+		return nil, status.Error(codes.PermissionDenied, "account is locked, please contact support")
+	}
+	enabled, err := h.mfa.IsEnabled(user)
+	if err != nil {
+		logger.Ctx(ctx).Error("Couldn't check two-factor authentication", "err", err)
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	if enabled {
+		if req.MfaCode == "" {
+			logger.Ctx(ctx).Info("Two-factor code required", "iin", user.IIN)
+			return nil, status.Error(codes.FailedPrecondition, "two-factor code required")
+		}
+		if err := h.mfa.Verify(user, req.MfaCode); err != nil {
+			logger.Ctx(ctx).Error("Two-factor verification failed", "err", err)
+			if err != myerrors.ErrInvalidMFACode {
+				return nil, status.Error(codes.Internal, "internal error")
+			}
+			h.loginFailed(ctx, req.Username, IP)
+			return nil, status.Error(codes.Unauthenticated, "invalid code")
+		}
+	}
+	if err := h.attempts.LoginSucceeded(req.Username); err != nil {
+		logger.Ctx(ctx).Error("Couldn't reset failed login attempts", "err", err)
+	}
+
+	sessionID, err := newTokenID()
+	if err != nil {
+		logger.Ctx(ctx).Error("gRPC login", "err", err)
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	access, refresh, err := signSessionTokens(keys, policy, user, sessionID, req.RememberMe)
+	if err != nil {
+		logger.Ctx(ctx).Error("gRPC login", "err", err)
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	now := time.Now()
+	session := &domain.Session{
+		ID:        sessionID,
+		IIN:       user.IIN,
+		UserAgent: userAgent(ctx),
+		IP:        IP,
+		CreatedAt: now,
+		LastUsed:  now,
+	}
+	if err := h.login.CreateSession(session, refresh, policy.RefreshTtlFor(req.RememberMe)); err != nil {
+		logger.Ctx(ctx).Error("Couldn't insert token to redis", "err", err)
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	logger.Ctx(ctx).Info("Successfully inserted refresh after gRPC login")
+	res = &authv1.LoginResponse{Tokens: tokenPair(access, refresh, policy)}
+	for _, rule := range h.login.CheckPasswordPolicy(user, req.Password) {
+		res.PasswordRules = append(res.PasswordRules, &authv1.PasswordRule{Rule: rule.Rule, Message: rule.Message})
+	}
+	return res, nil
+}
+
+// newAuditEvent starts audit event of the call, it is recorded by recordAudit once the call returns
+func newAuditEvent(ctx context.Context, action string) *domain.AuditEvent {
+	return &domain.AuditEvent{Action: action, IP: peerIP(ctx), UserAgent: userAgent(ctx)}
+}
+
+// recordAudit records event with outcome following status code the call returned
+func recordAudit(event *domain.AuditEvent, err error) {
+	switch status.Code(err) {
+	case codes.OK:
+		event.Outcome = domain.AuditSuccess
+	case codes.FailedPrecondition:
+		event.Outcome = domain.AuditMFARequired
+	case codes.Internal:
+		event.Outcome = domain.AuditError
+	default:
+		event.Outcome = domain.AuditFailure
+	}
+	middleware.RecordAudit(event)
+}
+
+// loginFailed counts failed login attempt
+func (h *GRPCHandler) loginFailed(ctx context.Context, username, IP string) {
+	if err := h.attempts.LoginFailed(username, IP); err != nil {
+		logger.Ctx(ctx).Error("Couldn't count failed login attempt", "err", err)
+	}
+}
+
+// Refresh rotates refresh token like /refresh does
+func (h *GRPCHandler) Refresh(ctx context.Context, req *authv1.RefreshRequest) (_ *authv1.TokenPair, err error) {
+	logger.Ctx(ctx).Debug("gRPC Refresh hit")
+	event := newAuditEvent(ctx, domain.AuditTokenRefresh)
+	defer func() { recordAudit(event, err) }()
+	if req.RefreshToken == "" {
+		return nil, status.Error(codes.InvalidArgument, "refresh_token is required")
+	}
+	keys, policy, err := secrets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	user, access, refresh, _, err := rotateSessionTokens(ctx, h.update, keys, policy, req.RefreshToken)
+	if err != nil {
+		if isUnauthorized(err) {
+			return nil, status.Error(codes.Unauthenticated, "invalid refresh token")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	event.ActorIIN = user.IIN
+	return tokenPair(access, refresh, policy), nil
+}
+
+// authenticate verifies access token and checks it wasn't revoked, returning its claims.
+// Tokens issued to OAuth clients are accepted only if allowClients is true
+func (h *GRPCHandler) authenticate(ctx context.Context, token string, allowClients bool) (jwt.MapClaims, error) {
+	if token == "" {
+		return nil, status.Error(codes.InvalidArgument, "access_token is required")
+	}
+	keys, _, err := secrets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := middleware.VerifyToken(keys, token, true); err != nil {
+		logger.Ctx(ctx).Error("Parse access token error", "err", err)
+		return nil, errInvalidToken
+	}
+	if clientID := middleware.ClientID(token); clientID != "" && !allowClients {
+		logger.Ctx(ctx).Error("Token of OAuth client used on first party call", "client_id", clientID)
+		return nil, errInvalidToken
+	}
+	if err := middleware.CheckDenylist(token); err != nil {
+		logger.Ctx(ctx).Error("Access token rejected", "err", err)
+		if err == myerrors.ErrTokenRevoked {
+			return nil, errInvalidToken
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	claims := jwt.MapClaims{}
+	p := jwt.Parser{}
+	if _, _, err := p.ParseUnverified(token, &claims); err != nil {
+		return nil, errInvalidToken
+	}
+	return claims, nil
+}
+
+// ValidateToken returns claims of a valid access token, tokens of OAuth clients included
+func (h *GRPCHandler) ValidateToken(ctx context.Context, req *authv1.ValidateTokenRequest) (*authv1.ValidateTokenResponse, error) {
+	logger.Ctx(ctx).Debug("gRPC ValidateToken hit")
+	claims, err := h.authenticate(ctx, req.AccessToken, true)
+	if err != nil {
+		return nil, err
+	}
+	res := &authv1.ValidateTokenResponse{
+		Roles:       claimList(claims["roles"]),
+		Permissions: claimList(claims["perms"]),
+		Scopes:      middleware.Scope(req.AccessToken),
+	}
+	res.Iin, _ = claims["iin"].(string)
+	res.Username, _ = claims["username"].(string)
+	res.SessionId, _ = claims["sid"].(string)
+	res.ClientId, _ = claims["cid"].(string)
+	exp, _ := claims["exp"].(float64)
+	res.ExpiresAt = int64(exp)
+	return res, nil
+}
+
+// GetUser returns user data from DB, roles and permissions are the ones granted to the token
+func (h *GRPCHandler) GetUser(ctx context.Context, req *authv1.GetUserRequest) (*authv1.User, error) {
+	logger.Ctx(ctx).Debug("gRPC GetUser hit")
+	claims, err := h.authenticate(ctx, req.AccessToken, false)
+	if err != nil {
+		return nil, err
+	}
+	IIN, _ := claims["iin"].(string)
+	user, err := h.info.GetUserInfo(IIN)
+	if err == myerrors.ErrUserNotFound {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		logger.Ctx(ctx).Error("gRPC: getting user info from DB", "err", err)
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &authv1.User{
+		Iin:         user.IIN,
+		Username:    user.Username,
+		Email:       user.Email,
+		CreatedAt:   user.Ts,
+		Roles:       claimList(claims["roles"]),
+		Permissions: claimList(claims["perms"]),
+	}, nil
+}
+
+// RevokeSession revokes one of the user's sessions. Revoking the session of the token itself also denylists the token
+func (h *GRPCHandler) RevokeSession(ctx context.Context, req *authv1.RevokeSessionRequest) (_ *authv1.RevokeSessionResponse, err error) {
+	logger.Ctx(ctx).Debug("gRPC RevokeSession hit")
+	event := newAuditEvent(ctx, domain.AuditSessionRevoke)
+	defer func() { recordAudit(event, err) }()
+	claims, err := h.authenticate(ctx, req.AccessToken, false)
+	if err != nil {
+		return nil, err
+	}
+	IIN, _ := claims["iin"].(string)
+	current, _ := claims["sid"].(string)
+	sessionID := req.SessionId
+	if sessionID == "" {
+		sessionID = current
+	}
+	event.ActorIIN, event.Target = IIN, sessionID
+	if err := h.sessions.RevokeSession(IIN, sessionID); err != nil {
+		logger.Ctx(ctx).Error("Couldn't revoke session", "err", err)
+		if err == myerrors.ErrSessionNotFound {
+			return nil, status.Error(codes.NotFound, "session not found")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	if sessionID == current {
+		jti, exp, err := middleware.TokenID(req.AccessToken)
+		if err != nil {
+			logger.Ctx(ctx).Error("gRPC: parse access token error", "err", err)
+			return nil, status.Error(codes.Internal, "internal error")
+		}
+		if err := h.logout.DenyToken(jti, time.Until(exp)); err != nil {
+			logger.Ctx(ctx).Error("gRPC: couldn't denylist access token", "err", err)
+			return nil, status.Error(codes.Internal, "internal error")
+		}
+	}
+	logger.Ctx(ctx).Security("Session revoked over gRPC", "session_id", sessionID)
+	return &authv1.RevokeSessionResponse{}, nil
+}
+
+// NewGRPCHandler registers AuthService on s
+func NewGRPCHandler(s *grpc.Server, login usecase.LoginUsecase, attempts usecase.LoginAttemptsUsecase, mfa usecase.MFAUsecase,
+	update usecase.UpdateTokenUsecase, info usecase.GetInfoUsecase, sessions usecase.SessionsUsecase, logout usecase.LogoutUsecase) {
+	handler := &GRPCHandler{
+		login:    login,
+		attempts: attempts,
+		mfa:      mfa,
+		update:   update,
+		info:     info,
+		sessions: sessions,
+		logout:   logout,
+	}
+	authv1.RegisterAuthServiceServer(s, handler)
+}
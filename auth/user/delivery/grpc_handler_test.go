@@ -0,0 +1,234 @@
+package delivery
+
+import (
+	"auth/logger"
+	authv1 "auth/proto/auth/v1"
+	"auth/totp"
+	"auth/user/delivery/middleware"
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCLogin(t *testing.T) {
+	client, closeClient := getGRPCClient()
+	defer closeClient()
+
+	var testTable = []struct {
+		name     string
+		req      *authv1.LoginRequest
+		expected codes.Code
+	}{
+		{"ok", &authv1.LoginRequest{Username: "user", Password: PASSWORD}, codes.OK},
+		{"remember me", &authv1.LoginRequest{Username: "user", Password: PASSWORD, RememberMe: true}, codes.OK},
+		{"wrong password", &authv1.LoginRequest{Username: "user", Password: "wrong"}, codes.Unauthenticated},
+		{"unknown user", &authv1.LoginRequest{Username: "unknown", Password: PASSWORD}, codes.Unauthenticated},
+		{"locked user", &authv1.LoginRequest{Username: "locked", Password: PASSWORD}, codes.PermissionDenied},
+		{"session error", &authv1.LoginRequest{Username: "inserterr", Password: PASSWORD}, codes.Internal},
+		{"mfa code required", &authv1.LoginRequest{Username: "mfa", Password: PASSWORD}, codes.FailedPrecondition},
+		{"mfa wrong code", &authv1.LoginRequest{Username: "mfa", Password: PASSWORD, MfaCode: wrongCode()}, codes.Unauthenticated},
+		{"mfa totp code", &authv1.LoginRequest{Username: "mfa", Password: PASSWORD, MfaCode: totp.Code(testMFASecret, totp.Step(time.Now()))}, codes.OK},
+		{"mfa recovery code", &authv1.LoginRequest{Username: "mfa", Password: PASSWORD, MfaCode: testRecoveryCode}, codes.OK},
+	}
+	for _, tt := range testTable {
+		res, err := client.Login(context.Background(), tt.req)
+		if code := status.Code(err); code != tt.expected {
+			t.Errorf("for %s, expected %v but got %v", tt.name, tt.expected, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if res.Tokens.AccessToken == "" || res.Tokens.RefreshToken == "" || res.Tokens.TokenType != "Bearer" || res.Tokens.ExpiresIn <= 0 {
+			t.Errorf("for %s, unexpected tokens %v", tt.name, res.Tokens)
+		}
+	}
+}
+
+func TestGRPCLoginLockout(t *testing.T) {
+	client, closeClient := getGRPCClient()
+	defer closeClient()
+
+	for i := 0; i < testLoginPolicy.MaxFailures; i++ {
+		_, err := client.Login(context.Background(), &authv1.LoginRequest{Username: "lockme", Password: "wrong!"})
+		if status.Code(err) != codes.Unauthenticated {
+			t.Errorf("attempt %d: expected %v but got %v", i+1, codes.Unauthenticated, err)
+		}
+	}
+	// correct password is rejected while locked out
+	_, err := client.Login(context.Background(), &authv1.LoginRequest{Username: "lockme", Password: PASSWORD})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected %v but got %v", codes.ResourceExhausted, err)
+	}
+}
+
+func TestGRPCSession(t *testing.T) {
+	client, closeClient := getGRPCClient()
+	defer closeClient()
+	ctx := context.Background()
+
+	login, err := client.Login(ctx, &authv1.LoginRequest{Username: "910815450350", Password: PASSWORD})
+	if err != nil {
+		t.Fatal("login failed:", err)
+	}
+	access := login.Tokens.AccessToken
+	claims, err := client.ValidateToken(ctx, &authv1.ValidateTokenRequest{AccessToken: access})
+	if err != nil {
+		t.Fatal("validate failed:", err)
+	}
+	if claims.Iin != "910815450350" || claims.SessionId == "" || claims.ClientId != "" || claims.ExpiresAt <= time.Now().Unix() {
+		t.Errorf("unexpected claims %v", claims)
+	}
+	if len(claims.Roles) != 1 || claims.Roles[0] != "user" || len(claims.Permissions) != 2 {
+		t.Errorf("unexpected roles %v and permissions %v", claims.Roles, claims.Permissions)
+	}
+	user, err := client.GetUser(ctx, &authv1.GetUserRequest{AccessToken: access})
+	if err != nil {
+		t.Fatal("get user failed:", err)
+	}
+	if user.Iin != "910815450350" || user.Email != "910815450350@example.com" || len(user.Roles) != 1 {
+		t.Errorf("unexpected user %v", user)
+	}
+	tokens, err := client.Refresh(ctx, &authv1.RefreshRequest{RefreshToken: login.Tokens.RefreshToken})
+	if err != nil {
+		t.Fatal("refresh failed:", err)
+	}
+	if tokens.AccessToken == "" || tokens.RefreshToken == login.Tokens.RefreshToken {
+		t.Errorf("refresh token wasn't rotated %v", tokens)
+	}
+	refreshed, err := client.ValidateToken(ctx, &authv1.ValidateTokenRequest{AccessToken: tokens.AccessToken})
+	if err != nil || refreshed.SessionId != claims.SessionId {
+		t.Errorf("expected refreshed token of session %s but got %v %v", claims.SessionId, refreshed, err)
+	}
+	if _, err := client.RevokeSession(ctx, &authv1.RevokeSessionRequest{AccessToken: tokens.AccessToken}); err != nil {
+		t.Error("revoke failed:", err)
+	}
+}
+
+func TestGRPCTokens(t *testing.T) {
+	client, closeClient := getGRPCClient()
+	defer closeClient()
+	ctx := context.Background()
+
+	access, refresh, err := GenerateTestTokens("910815450350")
+	if err != nil {
+		t.Fatal("Couldn't generate token", err)
+	}
+	tokens := map[string]string{"access": access, "refresh": refresh}
+	for _, IIN := range []string{"denied", "nonexistent", "sthwrong", "reused"} {
+		access, refresh, err := GenerateTestTokens(IIN)
+		if err != nil {
+			t.Fatal("Couldn't generate token", err)
+		}
+		tokens[IIN] = access
+		tokens[IIN+" refresh"] = refresh
+	}
+	if tokens["wrong"], _, err = GenerateWrongToken(); err != nil {
+		t.Fatal("Couldn't generate token", err)
+	}
+
+	var testTable = []struct {
+		name     string
+		call     func() error
+		expected codes.Code
+	}{
+		{"validate", func() error {
+			_, err := client.ValidateToken(ctx, &authv1.ValidateTokenRequest{AccessToken: tokens["access"]})
+			return err
+		}, codes.OK},
+		{"validate empty", func() error {
+			_, err := client.ValidateToken(ctx, &authv1.ValidateTokenRequest{})
+			return err
+		}, codes.InvalidArgument},
+		{"validate wrong signature", func() error {
+			_, err := client.ValidateToken(ctx, &authv1.ValidateTokenRequest{AccessToken: tokens["wrong"]})
+			return err
+		}, codes.Unauthenticated},
+		{"validate refresh token", func() error {
+			_, err := client.ValidateToken(ctx, &authv1.ValidateTokenRequest{AccessToken: tokens["refresh"]})
+			return err
+		}, codes.Unauthenticated},
+		{"validate revoked", func() error {
+			_, err := client.ValidateToken(ctx, &authv1.ValidateTokenRequest{AccessToken: tokens["denied"]})
+			return err
+		}, codes.Unauthenticated},
+		{"get user", func() error {
+			_, err := client.GetUser(ctx, &authv1.GetUserRequest{AccessToken: tokens["access"]})
+			return err
+		}, codes.OK},
+		{"get user not found", func() error {
+			_, err := client.GetUser(ctx, &authv1.GetUserRequest{AccessToken: tokens["nonexistent"]})
+			return err
+		}, codes.NotFound},
+		{"get user db error", func() error {
+			_, err := client.GetUser(ctx, &authv1.GetUserRequest{AccessToken: tokens["sthwrong"]})
+			return err
+		}, codes.Internal},
+		{"refresh empty", func() error {
+			_, err := client.Refresh(ctx, &authv1.RefreshRequest{})
+			return err
+		}, codes.InvalidArgument},
+		{"refresh access token", func() error {
+			_, err := client.Refresh(ctx, &authv1.RefreshRequest{RefreshToken: tokens["access"]})
+			return err
+		}, codes.Unauthenticated},
+		{"refresh reused", func() error {
+			_, err := client.Refresh(ctx, &authv1.RefreshRequest{RefreshToken: tokens["reused refresh"]})
+			return err
+		}, codes.Unauthenticated},
+		{"refresh db error", func() error {
+			_, err := client.Refresh(ctx, &authv1.RefreshRequest{RefreshToken: tokens["sthwrong refresh"]})
+			return err
+		}, codes.Internal},
+		{"revoke other session", func() error {
+			_, err := client.RevokeSession(ctx, &authv1.RevokeSessionRequest{AccessToken: tokens["access"], SessionId: "other"})
+			return err
+		}, codes.OK},
+		{"revoke missing session", func() error {
+			_, err := client.RevokeSession(ctx, &authv1.RevokeSessionRequest{AccessToken: tokens["access"], SessionId: "missing"})
+			return err
+		}, codes.NotFound},
+		{"revoke session of another user", func() error {
+			_, err := client.RevokeSession(ctx, &authv1.RevokeSessionRequest{AccessToken: tokens["nonexistent"], SessionId: "other"})
+			return err
+		}, codes.NotFound},
+		{"revoke with revoked token", func() error {
+			_, err := client.RevokeSession(ctx, &authv1.RevokeSessionRequest{AccessToken: tokens["denied"]})
+			return err
+		}, codes.Unauthenticated},
+	}
+	for _, tt := range testTable {
+		if err := tt.call(); status.Code(err) != tt.expected {
+			t.Errorf("for %s, expected %v but got %v", tt.name, tt.expected, err)
+		}
+	}
+}
+
+func TestGRPCErrorLogRequestID(t *testing.T) {
+	var out bytes.Buffer
+	defer logger.SetDefault(logger.Default())
+	logger.SetDefault(logger.New(&out, logger.LevelInfo, logger.FormatText))
+	client, closeClient := getGRPCClient()
+	defer closeClient()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), middleware.RequestIDHeader, "grpc-req-1")
+	_, err := client.ValidateToken(ctx, &authv1.ValidateTokenRequest{AccessToken: "wrong"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected %v but got %v", codes.Unauthenticated, err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if strings.Contains(line, "Parse access token error") {
+			if !strings.Contains(line, "request_id=grpc-req-1") {
+				t.Errorf("failure logged without request ID: %q", line)
+			}
+			return
+		}
+	}
+	t.Errorf("failure not logged: %q", out.String())
+}
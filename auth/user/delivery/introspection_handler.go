@@ -0,0 +1,204 @@
+package delivery
+
+import (
+	"auth/domain"
+	"auth/logger"
+	"auth/myerrors"
+	"auth/user/delivery/middleware"
+	"auth/user/delivery/response"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/valyala/fasthttp"
+)
+
+// authenticateClient authenticates client calling introspection or revocation endpoint, answering invalid_client on failure
+func (h *OAuthHandler) authenticateClient(ctx *fasthttp.RequestCtx) (*domain.OAuthClient, bool) {
+	clientID, secret := clientCredentials(ctx)
+	middleware.SetAuditTarget(ctx, clientID)
+	client, err := h.uc.AuthenticateClient(clientID, secret)
+	if err != nil {
+		logger.Ctx(ctx).Error("OAuth client authentication", "err", err)
+		if err == myerrors.ErrInvalidClient {
+			ctx.Response.Header.Set("WWW-Authenticate", `Basic realm="oauth"`)
+			response.RespondOAuthError(ctx, fasthttp.StatusUnauthorized, "invalid_client", "client authentication failed")
+			return nil, false
+		}
+		response.RespondOAuthError(ctx, fasthttp.StatusInternalServerError, "server_error", "")
+		return nil, false
+	}
+	return client, true
+}
+
+// Introspect reports whether token is active and whom it was issued to. Service clients may introspect
+// any token, other confidential clients only their own ones, public clients none
+func (h *OAuthHandler) Introspect(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("Introspect hit")
+	client, ok := h.authenticateClient(ctx)
+	if !ok {
+		return
+	}
+	if client.Public {
+		logger.Ctx(ctx).Error("Public OAuth client tried to introspect token", "client_id", client.ClientID)
+		response.RespondOAuthError(ctx, fasthttp.StatusUnauthorized, "invalid_client", "public clients can't introspect tokens")
+		return
+	}
+	token := string(ctx.PostArgs().Peek("token"))
+	if token == "" {
+		response.RespondOAuthError(ctx, fasthttp.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+	info, err := h.introspect(ctx, token)
+	if err != nil {
+		logger.Ctx(ctx).Error("Introspecting token", "err", err)
+		response.RespondOAuthError(ctx, fasthttp.StatusInternalServerError, "server_error", "")
+		return
+	}
+	if info.Active && !client.Service && info.ClientID != client.ClientID {
+		logger.Ctx(ctx).Security("OAuth client introspected token of another client", "client_id", client.ClientID)
+		info = &domain.Introspection{}
+	}
+	ctx.SetContentType("application/json")
+	ctx.Response.Header.Set("Cache-Control", "no-store")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	json.NewEncoder(ctx).Encode(info)
+}
+
+// introspect verifies token and checks it wasn't revoked, its session is alive and the user isn't locked.
+// Error is returned only if that couldn't be checked
+func (h *OAuthHandler) introspect(ctx *fasthttp.RequestCtx, token string) (*domain.Introspection, error) {
+	inactive := &domain.Introspection{}
+	claims, err := verifyToken(ctx, token)
+	if err != nil {
+		logger.Ctx(ctx).Info("Inactive token", "err", err)
+		return inactive, nil
+	}
+	typ, _ := claims["typ"].(string)
+	jti, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+	if jti == "" || (typ != "access" && typ != "refresh" && typ != "service") {
+		return inactive, nil
+	}
+	revoked, err := h.uc.IsTokenRevoked(jti)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return inactive, nil
+	}
+	iat, _ := claims["iat"].(float64)
+	info := &domain.Introspection{
+		Active:    true,
+		TokenType: "Bearer",
+		Exp:       int64(exp),
+		Iat:       int64(iat),
+		Iss:       h.uc.Policy().Issuer,
+		Jti:       jti,
+	}
+	info.ClientID, _ = claims["cid"].(string)
+	info.Scope, _ = claims["scope"].(string)
+	if typ == "service" {
+		info.Sub = info.ClientID
+		return info, nil
+	}
+
+	IIN, _ := claims["iin"].(string)
+	info.SessionID, _ = claims["sid"].(string)
+	refresh := ""
+	if typ == "refresh" {
+		info.TokenType = "refresh_token"
+		refresh = token
+	}
+	user, err := h.uc.CheckSession(IIN, info.SessionID, refresh)
+	if err != nil {
+		if isUnauthorized(err) {
+			logger.Ctx(ctx).Info("Inactive token", "err", err)
+			return inactive, nil
+		}
+		return nil, err
+	}
+	info.Sub = strconv.Itoa(user.ID)
+	info.Username = user.Username
+	info.IIN = user.IIN
+	if info.ClientID == "" && typ == "access" {
+		info.Scope = strings.Join(claimList(claims["perms"]), " ")
+	}
+	return info, nil
+}
+
+// Revoke revokes token issued to the client, RFC 7009. Revoking a token of a user also ends its session,
+// so that the other token of the pair stops working too. Tokens that are invalid or issued to other clients are ignored
+func (h *OAuthHandler) Revoke(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("Revoke hit")
+	client, ok := h.authenticateClient(ctx)
+	if !ok {
+		return
+	}
+	token := string(ctx.PostArgs().Peek("token"))
+	if token == "" {
+		response.RespondOAuthError(ctx, fasthttp.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+	claims, err := verifyToken(ctx, token)
+	if err != nil {
+		logger.Ctx(ctx).Info("Revoking invalid token", "err", err)
+		ctx.SetStatusCode(fasthttp.StatusOK)
+		return
+	}
+	if clientID, _ := claims["cid"].(string); clientID != client.ClientID {
+		logger.Ctx(ctx).Security("OAuth client tried to revoke token of another client", "client_id", client.ClientID)
+		ctx.SetStatusCode(fasthttp.StatusOK)
+		return
+	}
+	jti, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+	IIN, _ := claims["iin"].(string)
+	sessionID, _ := claims["sid"].(string)
+	if jti == "" {
+		ctx.SetStatusCode(fasthttp.StatusOK)
+		return
+	}
+	if err := h.uc.RevokeToken(IIN, sessionID, jti, time.Unix(int64(exp), 0)); err != nil {
+		logger.Ctx(ctx).Error("Revoking token", "err", err)
+		response.RespondOAuthError(ctx, fasthttp.StatusServiceUnavailable, "server_error", "")
+		return
+	}
+	logger.Ctx(ctx).Security("Token revoked by OAuth client", "client_id", client.ClientID, "session_id", sessionID)
+	ctx.Response.Header.Set("Cache-Control", "no-store")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+}
+
+// verifyToken checks signature and expiration of any token issued by the service and returns its claims
+func verifyToken(ctx *fasthttp.RequestCtx, token string) (jwt.MapClaims, error) {
+	keys, err := middleware.GetKeysFromCtx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := jwt.Parse(token, keys.Keyfunc)
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok || !parsed.Valid {
+		return nil, myerrors.ErrInvalidToken
+	}
+	if _, ok := claims["exp"].(float64); !ok {
+		return nil, myerrors.ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// claimList returns string list claim, missing or malformed claim gives empty list
+func claimList(claim interface{}) []string {
+	values, _ := claim.([]interface{})
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if str, ok := v.(string); ok {
+			result = append(result, str)
+		}
+	}
+	return result
+}
@@ -0,0 +1,158 @@
+package delivery
+
+import (
+	"auth/domain"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestOAuthIntrospectAndRevoke(t *testing.T) {
+	r := getRoutes()
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	s := &fasthttp.Server{
+		Handler: r,
+	}
+
+	go s.Serve(ln) //nolint:errcheck
+	c := &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	do := func(path string, form url.Values, header map[string]string) *fasthttp.Response {
+		req, res := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseRequest(req)
+		req.Header.SetMethod("POST")
+		req.SetRequestURI(URI + path)
+		req.Header.SetContentType("application/x-www-form-urlencoded")
+		for key, value := range header {
+			req.Header.Set(key, value)
+		}
+		req.SetBodyString(form.Encode())
+		setTestCSRF(req)
+		if err := c.Do(req, res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	credentials := func(clientID string) map[string]string {
+		return map[string]string{"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte(clientID+":"+testClientSecret))}
+	}
+	access, _, err := GenerateTestTokens("910815450350")
+	if err != nil {
+		t.Fatal("Couldn't generate token", err)
+	}
+	// tokens of the shop client
+	res := do("/oauth/authorize", authorizeQuery("shop", url.Values{"decision": {"approve"}}), map[string]string{"Cookie": "access=" + access})
+	location, err := url.Parse(string(res.Header.Peek("Location")))
+	fasthttp.ReleaseResponse(res)
+	if err != nil || location.Query().Get("code") == "" {
+		t.Fatalf("expected code but got %s", location)
+	}
+	res = do("/oauth/token", url.Values{"grant_type": {"authorization_code"}, "code": {location.Query().Get("code")}, "redirect_uri": {testRedirectURI}, "code_verifier": {testVerifier}}, credentials("shop"))
+	var shop domain.TokenResponse
+	if err := json.Unmarshal(res.Body(), &shop); err != nil || shop.AccessToken == "" {
+		t.Fatalf("expected tokens but got %d %s", res.StatusCode(), res.Body())
+	}
+	fasthttp.ReleaseResponse(res)
+	res = do("/oauth/token", url.Values{"grant_type": {"client_credentials"}}, credentials("batch"))
+	var batch domain.TokenResponse
+	if err := json.Unmarshal(res.Body(), &batch); err != nil || batch.AccessToken == "" {
+		t.Fatalf("expected token but got %d %s", res.StatusCode(), res.Body())
+	}
+	fasthttp.ReleaseResponse(res)
+	missing, _, err := GenerateSessionTestTokens("910815450350", "missing")
+	if err != nil {
+		t.Fatal("Couldn't generate token", err)
+	}
+	denied, _, err := GenerateTestTokens("denied")
+	if err != nil {
+		t.Fatal("Couldn't generate token", err)
+	}
+	sub := strconv.Itoa(testUserID("910815450350"))
+
+	var testTable = []struct {
+		name               string
+		client             string
+		token              string
+		expectedStatusCode int
+		expected           domain.Introspection
+	}{
+		{"own access token", "shop", shop.AccessToken, fasthttp.StatusOK, domain.Introspection{Active: true, ClientID: "shop", Scope: "profile wallets:read", Sub: sub, IIN: "910815450350", TokenType: "Bearer"}},
+		{"own refresh token", "shop", shop.RefreshToken, fasthttp.StatusOK, domain.Introspection{Active: true, ClientID: "shop", Scope: "profile wallets:read", Sub: sub, IIN: "910815450350", TokenType: "refresh_token"}},
+		{"first party token", "shop", access, fasthttp.StatusOK, domain.Introspection{}},
+		{"service introspects client token", "batch", shop.AccessToken, fasthttp.StatusOK, domain.Introspection{Active: true, ClientID: "shop", Scope: "profile wallets:read", Sub: sub, IIN: "910815450350", TokenType: "Bearer"}},
+		{"service introspects first party token", "batch", access, fasthttp.StatusOK, domain.Introspection{Active: true, Scope: "wallets:read wallets:write", Sub: sub, IIN: "910815450350", TokenType: "Bearer"}},
+		{"service introspects own token", "batch", batch.AccessToken, fasthttp.StatusOK, domain.Introspection{Active: true, ClientID: "batch", Scope: domain.PermUsersRead, Sub: "batch", TokenType: "Bearer"}},
+		{"revoked session", "batch", missing, fasthttp.StatusOK, domain.Introspection{}},
+		{"revoked token", "batch", denied, fasthttp.StatusOK, domain.Introspection{}},
+		{"garbage", "batch", "garbage", fasthttp.StatusOK, domain.Introspection{}},
+		{"public client", "app", shop.AccessToken, fasthttp.StatusUnauthorized, domain.Introspection{}},
+		{"no token", "batch", "", fasthttp.StatusBadRequest, domain.Introspection{}},
+	}
+	for _, tt := range testTable {
+		fmt.Println("Testing", tt.name, "******************************************************************************************")
+		header := credentials(tt.client)
+		if tt.client == "app" {
+			header = nil
+		}
+		form := url.Values{"token": {tt.token}}
+		if tt.client == "app" {
+			form.Set("client_id", "app")
+		}
+		res := do("/oauth/introspect", form, header)
+		if res.StatusCode() != tt.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d: %s", tt.name, tt.expectedStatusCode, res.StatusCode(), res.Body())
+		}
+		if tt.expectedStatusCode == fasthttp.StatusOK {
+			var info domain.Introspection
+			if err := json.Unmarshal(res.Body(), &info); err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			if info.Active != tt.expected.Active || info.ClientID != tt.expected.ClientID || info.Scope != tt.expected.Scope ||
+				info.Sub != tt.expected.Sub || info.IIN != tt.expected.IIN || info.TokenType != tt.expected.TokenType {
+				t.Errorf("%s: expected %+v but got %s", tt.name, tt.expected, res.Body())
+			}
+			if info.Active && (info.Exp == 0 || info.Iss != testOAuthPolicy.Issuer) {
+				t.Errorf("%s: expiry and issuer expected in %s", tt.name, res.Body())
+			}
+		}
+		fasthttp.ReleaseResponse(res)
+	}
+
+	var revokeTable = []struct {
+		name               string
+		form               url.Values
+		header             map[string]string
+		expectedStatusCode int
+	}{
+		{"revoke no token", url.Values{}, credentials("shop"), fasthttp.StatusBadRequest},
+		{"revoke wrong secret", url.Values{"token": {shop.RefreshToken}}, map[string]string{"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte("shop:wrong"))}, fasthttp.StatusUnauthorized},
+		{"revoke garbage", url.Values{"token": {"garbage"}}, credentials("shop"), fasthttp.StatusOK},
+		{"revoke token of other client", url.Values{"token": {shop.RefreshToken}, "client_id": {"app"}}, nil, fasthttp.StatusOK},
+		{"revoke first party token", url.Values{"token": {access}}, credentials("shop"), fasthttp.StatusOK},
+		{"revoke own token", url.Values{"token": {shop.RefreshToken}, "token_type_hint": {"refresh_token"}}, credentials("shop"), fasthttp.StatusOK},
+		{"revoke service token", url.Values{"token": {batch.AccessToken}}, credentials("batch"), fasthttp.StatusOK},
+	}
+	for _, tt := range revokeTable {
+		fmt.Println("Testing", tt.name, "******************************************************************************************")
+		res := do("/oauth/revoke", tt.form, tt.header)
+		if res.StatusCode() != tt.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d: %s", tt.name, tt.expectedStatusCode, res.StatusCode(), res.Body())
+		}
+		fasthttp.ReleaseResponse(res)
+	}
+}
@@ -0,0 +1,36 @@
+package delivery
+
+import (
+	"auth/logger"
+	"auth/user/delivery/middleware"
+	"auth/user/delivery/response"
+	"encoding/json"
+
+	"github.com/buaazp/fasthttprouter"
+	"github.com/valyala/fasthttp"
+)
+
+type JWKSHandler struct{}
+
+// JWKS publishes public keys used to verify issued tokens
+func (h *JWKSHandler) JWKS(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("JWKS hit")
+	keys, err := middleware.GetKeysFromCtx(ctx)
+	if err != nil {
+		logger.Ctx(ctx).Error("JWKS handler", "err", err)
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.Response.Header.Set("Cache-Control", "public, max-age=300")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	if err := json.NewEncoder(ctx).Encode(keys.JWKS()); err != nil {
+		logger.Ctx(ctx).Error("JWKS handler", "err", err)
+	}
+}
+
+// NewJWKSHandler sets /.well-known/jwks.json route
+func NewJWKSHandler(r *fasthttprouter.Router) {
+	handler := &JWKSHandler{}
+	r.GET("/.well-known/jwks.json", middleware.SecretMiddleware(handler.JWKS))
+}
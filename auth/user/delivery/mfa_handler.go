@@ -0,0 +1,274 @@
+package delivery
+
+import (
+	"auth/domain"
+	"auth/logger"
+	"auth/myerrors"
+	"auth/user/delivery/middleware"
+	"auth/user/delivery/render"
+	"auth/user/delivery/response"
+	"auth/user/usecase"
+	"encoding/base64"
+	"encoding/json"
+	"text/template"
+	"time"
+
+	"github.com/buaazp/fasthttprouter"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/skip2/go-qrcode"
+	"github.com/valyala/fasthttp"
+)
+
+// signMFAToken signs short-lived token proving the password step of login, it can't be used as access token
+func signMFAToken(ctx *fasthttp.RequestCtx, user *domain.User, rememberMe bool) (string, error) {
+	keys, err := middleware.GetKeysFromCtx(ctx)
+	if err != nil {
+		return "", err
+	}
+	policy, err := middleware.GetPolicyFromCtx(ctx)
+	if err != nil {
+		return "", err
+	}
+	jti, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	return keys.Sign(jwt.MapClaims{
+		"iin":      user.IIN,
+		"username": user.Username,
+		"exp":      time.Now().Add(policy.MFATtl).Unix(),
+		"jti":      jti,
+		"rem":      rememberMe,
+		"typ":      "mfa",
+	})
+}
+
+// requireMFA finishes password step of login. API clients get the pending token in the body, browsers in a cookie
+func (h *LoginHandler) requireMFA(ctx *fasthttp.RequestCtx, user *domain.User, rememberMe bool) {
+	token, err := signMFAToken(ctx, user, rememberMe)
+	if err != nil {
+		logger.Ctx(ctx).Error("Couldn't sign two-factor token", "err", err)
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	logger.Ctx(ctx).Info("Two-factor code required", "iin", user.IIN)
+	middleware.SetAuditOutcome(ctx, domain.AuditMFARequired)
+	if response.WantsJSON(ctx) {
+		response.ResponseMFARequired(ctx, token)
+		return
+	}
+	policy, err := middleware.GetPolicyFromCtx(ctx)
+	if err != nil {
+		logger.Ctx(ctx).Error("Login handler", "err", err)
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	ctx.Response.Header.SetCookie(makeCookie(policy, "mfa", token, int(policy.MFATtl.Seconds())))
+	response.ResponseMFARequired(ctx, "")
+}
+
+// LogInMFA handles second step of login: verifies TOTP or recovery code for the pending token and issues real tokens
+func (h *LoginHandler) LogInMFA(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("LogInMFA hit")
+	token := string(ctx.FormValue("mfa_token"))
+	if token == "" {
+		token = string(ctx.Request.Header.Cookie("mfa"))
+	}
+	if token == "" {
+		response.RespondWithError(ctx, fasthttp.StatusUnauthorized, "login first")
+		return
+	}
+	username, rememberMe, err := middleware.ParseMFAToken(ctx, token)
+	if err != nil {
+		logger.Ctx(ctx).Error("Invalid two-factor token", "err", err)
+		response.RespondWithError(ctx, fasthttp.StatusUnauthorized, "login session expired, please login again")
+		return
+	}
+	middleware.SetAuditTarget(ctx, username)
+	IP := ctx.RemoteIP().String()
+	if retryAfter, err := h.attempts.CheckAllowed(username, IP); err != nil {
+		logger.Ctx(ctx).Error("Login not allowed", "err", err)
+		if err == myerrors.ErrLoginLocked || err == myerrors.ErrTooManyAttempts {
+			response.RespondTooManyRequests(ctx, retryAfter, "too many login attempts, please wait and try again")
+			return
+		}
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	user, err := h.uc.GetUser(username)
+	if err != nil {
+		logger.Ctx(ctx).Error("Couldn't find user", "err", err)
+		if err == myerrors.ErrUserNotFound {
+			response.RespondWithError(ctx, fasthttp.StatusUnauthorized, "login session expired, please login again")
+			return
+		}
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	middleware.SetAuditActor(ctx, user.IIN)
+	if user.Locked {
+		logger.Ctx(ctx).Error("Login attempt to locked account", "iin", user.IIN)
+		response.RespondWithError(ctx, fasthttp.StatusForbidden, "account is locked, please contact support")
+		return
+	}
+	if err := h.mfa.Verify(user, string(ctx.FormValue("code"))); err != nil {
+		logger.Ctx(ctx).Error("Two-factor verification failed", "err", err)
+		if err != myerrors.ErrInvalidMFACode {
+			response.RespondInternalServerError(ctx)
+			return
+		}
+		h.loginFailed(username, IP)
+		response.RespondWithError(ctx, fasthttp.StatusUnauthorized, "invalid code")
+		return
+	}
+	if err := h.attempts.LoginSucceeded(username); err != nil {
+		logger.Ctx(ctx).Error("Couldn't reset failed login attempts", "err", err)
+	}
+	if policy, err := middleware.GetPolicyFromCtx(ctx); err == nil {
+		mfaCookie := makeCookie(policy, "mfa", "", 0)
+		mfaCookie.SetExpire(fasthttp.CookieExpireDelete)
+		ctx.Response.Header.SetCookie(mfaCookie)
+	}
+	// password isn't known at this step, so it isn't checked against the policy
+	h.startSession(ctx, user, rememberMe, nil)
+}
+
+type MFALoginPageHandler struct {
+	t *template.Template
+}
+
+// MFALoginPage serves page asking for two-factor code
+func (h *MFALoginPageHandler) MFALoginPage(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("MFALoginPage hit")
+	if err := render.RenderTemplate(ctx, fasthttp.StatusOK, h.t, nil); err != nil {
+		logger.Ctx(ctx).Error("Executing template", "err", err)
+	}
+}
+
+// NewMFALoginPageHandler sets /login/mfa GET route
+func NewMFALoginPageHandler(r *fasthttprouter.Router, t *template.Template) {
+	handler := &MFALoginPageHandler{
+		t: t,
+	}
+	r.GET("/login/mfa", handler.MFALoginPage)
+}
+
+type MFAHandler struct {
+	uc usecase.MFAUsecase
+	t  *template.Template
+}
+
+// GetMFA shows two-factor settings of the user
+func (h *MFAHandler) GetMFA(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("GetMFA hit")
+	IIN, ok := ctx.Value("iin").(string)
+	if !ok {
+		logger.Ctx(ctx).Error("IIN is nil")
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	status, err := h.uc.Status(IIN)
+	if err != nil {
+		logger.Ctx(ctx).Error("Couldn't get two-factor status", "err", err)
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	if response.WantsJSON(ctx) {
+		json.NewEncoder(ctx).Encode(status)
+		return
+	}
+	if err := render.RenderTemplate(ctx, fasthttp.StatusOK, h.t, status); err != nil {
+		logger.Ctx(ctx).Error("Executing template", "err", err)
+	}
+}
+
+// Enroll starts enrollment, returning secret, provisioning URI and its QR code
+func (h *MFAHandler) Enroll(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("Enroll hit")
+	IIN, ok := ctx.Value("iin").(string)
+	if !ok {
+		logger.Ctx(ctx).Error("IIN is nil")
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	enrollment, err := h.uc.Enroll(IIN)
+	if err != nil {
+		logger.Ctx(ctx).Error("Couldn't enroll two-factor authentication", "err", err)
+		if err == myerrors.ErrMFAEnabled {
+			response.RespondWithError(ctx, fasthttp.StatusConflict, "two-factor authentication is already enabled")
+			return
+		}
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	png, err := qrcode.Encode(enrollment.URI, qrcode.Medium, 256)
+	if err != nil {
+		logger.Ctx(ctx).Error("Couldn't encode QR code", "err", err)
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	enrollment.QRCode = "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)
+	ctx.SetContentType("application/json")
+	ctx.Response.Header.Set("Cache-Control", "no-store")
+	json.NewEncoder(ctx).Encode(enrollment)
+}
+
+// Confirm enables two-factor authentication with the first code and returns recovery codes
+func (h *MFAHandler) Confirm(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("Confirm hit")
+	IIN, ok := ctx.Value("iin").(string)
+	if !ok {
+		logger.Ctx(ctx).Error("IIN is nil")
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	codes, err := h.uc.Confirm(IIN, string(ctx.FormValue("code")))
+	if err != nil {
+		logger.Ctx(ctx).Error("Couldn't confirm two-factor authentication", "err", err)
+		h.respondMFAError(ctx, err)
+		return
+	}
+	response.ResponseRecoveryCodes(ctx, codes)
+}
+
+// Disable turns two-factor authentication off, current code or recovery code is required
+func (h *MFAHandler) Disable(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("Disable hit")
+	IIN, ok := ctx.Value("iin").(string)
+	if !ok {
+		logger.Ctx(ctx).Error("IIN is nil")
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	if err := h.uc.Disable(IIN, string(ctx.FormValue("code"))); err != nil {
+		logger.Ctx(ctx).Error("Couldn't disable two-factor authentication", "err", err)
+		h.respondMFAError(ctx, err)
+		return
+	}
+	response.ResponseJSON(ctx, "two-factor authentication disabled")
+}
+
+func (h *MFAHandler) respondMFAError(ctx *fasthttp.RequestCtx, err error) {
+	switch err {
+	case myerrors.ErrInvalidMFACode:
+		response.RespondWithError(ctx, fasthttp.StatusBadRequest, "invalid code")
+	case myerrors.ErrMFANotEnrolled:
+		response.RespondWithError(ctx, fasthttp.StatusBadRequest, "two-factor authentication is not enrolled")
+	case myerrors.ErrMFAEnabled:
+		response.RespondWithError(ctx, fasthttp.StatusConflict, "two-factor authentication is already enabled")
+	default:
+		response.RespondInternalServerError(ctx)
+	}
+}
+
+// NewMFAHandler sets /mfa routes
+func NewMFAHandler(r *fasthttprouter.Router, uc usecase.MFAUsecase, t *template.Template) {
+	handler := &MFAHandler{
+		uc: uc,
+		t:  t,
+	}
+	r.GET("/mfa", middleware.SecretMiddleware(middleware.CheckAuthMiddleware(handler.GetMFA)))
+	r.POST("/mfa/enroll", middleware.SecretMiddleware(middleware.CheckAuthMiddleware(handler.Enroll)))
+	r.POST("/mfa/confirm", middleware.SecretMiddleware(middleware.CheckAuthMiddleware(middleware.Audit(domain.AuditMFAEnable, handler.Confirm))))
+	r.POST("/mfa/disable", middleware.SecretMiddleware(middleware.CheckAuthMiddleware(middleware.Audit(domain.AuditMFADisable, handler.Disable))))
+}
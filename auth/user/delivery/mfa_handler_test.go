@@ -0,0 +1,217 @@
+package delivery
+
+import (
+	"auth/domain"
+	"auth/totp"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+// wrongCode returns code that is not valid for any step accepted now
+func wrongCode() string {
+	code := []byte(totp.Code(testMFASecret, totp.Step(time.Now())))
+	for {
+		code[0] = '0' + (code[0]-'0'+1)%10
+		if _, ok := totp.Validate(testMFASecret, string(code), time.Now()); !ok {
+			return string(code)
+		}
+	}
+}
+
+func TestLoginMFA(t *testing.T) {
+	r := getRoutes()
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	s := &fasthttp.Server{
+		Handler: r,
+	}
+
+	go s.Serve(ln) //nolint:errcheck
+	c := &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	post := func(path string, form url.Values, json bool) *fasthttp.Response {
+		req, res := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseRequest(req)
+		req.Header.SetMethod(fasthttp.MethodPost)
+		req.SetRequestURI(URI + path)
+		req.Header.SetContentType("application/x-www-form-urlencoded")
+		if json {
+			req.Header.Set("Accept", "application/json")
+		}
+		req.SetBodyString(form.Encode())
+		setTestCSRF(req)
+		if err := c.Do(req, res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	login := func(json bool) *fasthttp.Response {
+		return post("/login", url.Values{"login": {"mfa"}, "password": {PASSWORD}}, json)
+	}
+
+	// API client gets pending token instead of access token
+	res := login(true)
+	var body domain.Response
+	if err := json.Unmarshal(res.Body(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode() != fasthttp.StatusOK || !body.MFARequired || body.MFAToken == "" {
+		t.Fatalf("expected two-factor step but got %d %s", res.StatusCode(), res.Body())
+	}
+	if strings.Contains(string(res.Body()), "access_token") {
+		t.Error("access token issued before two-factor step")
+	}
+	mfaToken := body.MFAToken
+	fasthttp.ReleaseResponse(res)
+
+	// browser gets pending token in a cookie only
+	res = login(false)
+	var cookie fasthttp.Cookie
+	cookie.SetKey("mfa")
+	if !res.Header.Cookie(&cookie) || len(cookie.Value()) == 0 {
+		t.Error("expected mfa cookie")
+	}
+	if strings.Contains(string(res.Body()), "mfaToken") {
+		t.Error("pending token leaked to browser body")
+	}
+	fasthttp.ReleaseResponse(res)
+
+	access, _, err := GenerateTestTokens("mfa")
+	if err != nil {
+		t.Fatal("Couldn't generate token", err)
+	}
+	var testTable = []struct {
+		name               string
+		form               url.Values
+		expectedStatusCode int
+	}{
+		{"no token", url.Values{"code": {totp.Code(testMFASecret, totp.Step(time.Now()))}}, fasthttp.StatusUnauthorized},
+		{"access token", url.Values{"mfa_token": {access}, "code": {totp.Code(testMFASecret, totp.Step(time.Now()))}}, fasthttp.StatusUnauthorized},
+		{"wrong code", url.Values{"mfa_token": {mfaToken}, "code": {wrongCode()}}, fasthttp.StatusUnauthorized},
+		{"wrong recovery code", url.Values{"mfa_token": {mfaToken}, "code": {"zzzzz-zzzzz"}}, fasthttp.StatusUnauthorized},
+		{"totp code", url.Values{"mfa_token": {mfaToken}, "code": {totp.Code(testMFASecret, totp.Step(time.Now()))}}, fasthttp.StatusOK},
+		{"recovery code", url.Values{"mfa_token": {mfaToken}, "code": {testRecoveryCode}}, fasthttp.StatusOK},
+	}
+	for _, tt := range testTable {
+		fmt.Println("Testing", tt.name, "******************************************************************************************")
+		res := post("/login/mfa", tt.form, true)
+		if res.StatusCode() != tt.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d", tt.name, tt.expectedStatusCode, res.StatusCode())
+		}
+		if tt.expectedStatusCode == fasthttp.StatusOK {
+			var tokens domain.TokenResponse
+			if err := json.Unmarshal(res.Body(), &tokens); err != nil || tokens.AccessToken == "" {
+				t.Errorf("%s: expected tokens but got %s", tt.name, res.Body())
+			}
+		}
+		fasthttp.ReleaseResponse(res)
+	}
+
+	// user without two-factor authentication logs in in one step
+	res = post("/login", url.Values{"login": {"plain"}, "password": {PASSWORD}}, true)
+	defer fasthttp.ReleaseResponse(res)
+	var tokens domain.TokenResponse
+	if err := json.Unmarshal(res.Body(), &tokens); err != nil || tokens.AccessToken == "" {
+		t.Errorf("expected tokens but got %s", res.Body())
+	}
+	// PASSWORD has no digit required by the current policy
+	if len(tokens.PasswordRules) != 1 || tokens.PasswordRules[0].Rule != "digit" {
+		t.Errorf("expected warning about password policy but got %s", res.Body())
+	}
+}
+
+var testTableMFA = []struct {
+	name               string
+	url                string
+	method             string
+	IIN                string
+	body               url.Values
+	json               bool
+	expectedStatusCode int
+}{
+	{"get-mfa", "/mfa", "GET", "910815450350", nil, false, fasthttp.StatusOK},
+	{"get-mfa json", "/mfa", "GET", "mfa", nil, true, fasthttp.StatusOK},
+	{"get-mfa sth wrong", "/mfa", "GET", "sthwrong", nil, false, fasthttp.StatusInternalServerError},
+	{"post-enroll", "/mfa/enroll", "POST", "910815450350", nil, true, fasthttp.StatusOK},
+	{"post-enroll enabled", "/mfa/enroll", "POST", "mfa", nil, true, fasthttp.StatusConflict},
+	{"post-confirm", "/mfa/confirm", "POST", "pending", url.Values{"code": {totp.Code(testMFASecret, totp.Step(time.Now()))}}, true, fasthttp.StatusOK},
+	{"post-confirm wrong code", "/mfa/confirm", "POST", "pending", url.Values{"code": {wrongCode()}}, true, fasthttp.StatusBadRequest},
+	{"post-confirm not enrolled", "/mfa/confirm", "POST", "910815450350", url.Values{"code": {"123456"}}, true, fasthttp.StatusBadRequest},
+	{"post-disable", "/mfa/disable", "POST", "mfa", url.Values{"code": {testRecoveryCode}}, true, fasthttp.StatusOK},
+	{"post-disable wrong code", "/mfa/disable", "POST", "mfa", url.Values{"code": {wrongCode()}}, true, fasthttp.StatusBadRequest},
+}
+
+func TestMFAHandlers(t *testing.T) {
+	r := getRoutes()
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	s := &fasthttp.Server{
+		Handler: r,
+	}
+
+	go s.Serve(ln) //nolint:errcheck
+	c := &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+
+	for _, tt := range testTableMFA {
+		fmt.Println("Testing", tt.name, "******************************************************************************************")
+		access, _, err := GenerateTestTokens(tt.IIN)
+		if err != nil {
+			t.Fatal("Couldn't generate token", err)
+		}
+		req, res := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		req.Header.SetMethod(tt.method)
+		req.SetRequestURI(URI + tt.url)
+		req.Header.SetCookie("access", access)
+		if tt.json {
+			req.Header.Set("Accept", "application/json")
+		}
+		if tt.body != nil {
+			req.Header.SetContentType("application/x-www-form-urlencoded")
+			req.SetBodyString(tt.body.Encode())
+		}
+		setTestCSRF(req)
+		if err := c.Do(req, res); err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode() != tt.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d: %s", tt.name, tt.expectedStatusCode, res.StatusCode(), res.Body())
+		}
+		if tt.name == "post-enroll" {
+			var enrollment domain.MFAEnrollment
+			if err := json.Unmarshal(res.Body(), &enrollment); err != nil || !strings.HasPrefix(enrollment.QRCode, "data:image/png;base64,") {
+				t.Errorf("%s: unexpected enrollment %s", tt.name, res.Body())
+			}
+		}
+		if tt.name == "post-confirm" {
+			var body domain.Response
+			if err := json.Unmarshal(res.Body(), &body); err != nil || len(body.RecoveryCodes) != totp.RecoveryCodes {
+				t.Errorf("%s: expected recovery codes but got %s", tt.name, res.Body())
+			}
+		}
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(res)
+	}
+}
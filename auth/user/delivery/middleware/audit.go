@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"auth/domain"
+	"auth/logger"
+	"auth/user/repository"
+
+	"github.com/valyala/fasthttp"
+)
+
+// auditLog stores events recorded by Audit and RecordAudit, set on startup with SetAuditLog
+var auditLog repository.AuditRepository
+
+// SetAuditLog sets store of security audit events
+func SetAuditLog(a repository.AuditRepository) {
+	auditLog = a
+}
+
+// RecordAudit stores audit event. Failures are only logged, so that a request isn't lost because of the audit log
+func RecordAudit(event *domain.AuditEvent) {
+	logger.Security("Audit", "action", event.Action, "outcome", event.Outcome, "actor_iin", event.ActorIIN, "target", event.Target, "ip", event.IP)
+	if auditLog == nil {
+		logger.Error("Audit log is not set")
+		return
+	}
+	if err := auditLog.RecordEvent(event); err != nil {
+		logger.Error("Couldn't record audit event", "err", err)
+	}
+}
+
+// Audit records action of the request after next answers it. Outcome follows response status unless the handler
+// sets it with SetAuditOutcome, actor is the signed in user or the one set with SetAuditActor.
+// Wrapped by CheckAuthMiddleware, requests rejected as unauthenticated aren't recorded
+func Audit(action string, next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		next(ctx)
+		RecordAudit(auditEvent(ctx, action))
+	}
+}
+
+// auditEvent makes event of the answered request
+func auditEvent(ctx *fasthttp.RequestCtx, action string) *domain.AuditEvent {
+	event := &domain.AuditEvent{
+		Action:    action,
+		IP:        ctx.RemoteIP().String(),
+		UserAgent: string(ctx.UserAgent()),
+	}
+	if event.ActorIIN, _ = ctx.UserValue("auditActor").(string); event.ActorIIN == "" {
+		event.ActorIIN, _ = ctx.UserValue("iin").(string)
+	}
+	event.Target, _ = ctx.UserValue("auditTarget").(string)
+	if event.Outcome, _ = ctx.UserValue("auditOutcome").(string); event.Outcome == "" {
+		event.Outcome = statusOutcome(ctx.Response.StatusCode())
+	}
+	return event
+}
+
+// statusOutcome returns outcome of the request answered with status
+func statusOutcome(status int) string {
+	switch {
+	case status >= 500:
+		return domain.AuditError
+	case status >= 400:
+		return domain.AuditFailure
+	}
+	return domain.AuditSuccess
+}
+
+// SetAuditActor sets IIN of the user acting in the request, when they aren't signed in yet
+func SetAuditActor(ctx *fasthttp.RequestCtx, IIN string) {
+	ctx.SetUserValue("auditActor", IIN)
+}
+
+// SetAuditTarget sets what the audited action is done to
+func SetAuditTarget(ctx *fasthttp.RequestCtx, target string) {
+	ctx.SetUserValue("auditTarget", target)
+}
+
+// SetAuditOutcome sets outcome of the audited action that response status doesn't tell, like redirect after failure
+func SetAuditOutcome(ctx *fasthttp.RequestCtx, outcome string) {
+	ctx.SetUserValue("auditOutcome", outcome)
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"auth/domain"
+	"errors"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+// recordingAudit keeps the last recorded event, failing when err is set
+type recordingAudit struct {
+	event *domain.AuditEvent
+	err   error
+}
+
+func (a *recordingAudit) RecordEvent(event *domain.AuditEvent) error {
+	a.event = event
+	return a.err
+}
+
+func (a *recordingAudit) ListEvents(filter *domain.AuditFilter) ([]domain.AuditEvent, int, error) {
+	return nil, 0, nil
+}
+
+var auditTestTable = []struct {
+	name     string
+	handler  fasthttp.RequestHandler
+	expected domain.AuditEvent
+}{
+	{"success", func(ctx *fasthttp.RequestCtx) {
+		ctx.SetUserValue("iin", "910815450350")
+	}, domain.AuditEvent{ActorIIN: "910815450350", Outcome: domain.AuditSuccess}},
+	{"failure", func(ctx *fasthttp.RequestCtx) {
+		SetAuditTarget(ctx, "user")
+		ctx.SetStatusCode(fasthttp.StatusUnauthorized)
+	}, domain.AuditEvent{Target: "user", Outcome: domain.AuditFailure}},
+	{"error", func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+	}, domain.AuditEvent{Outcome: domain.AuditError}},
+	{"actor set by handler", func(ctx *fasthttp.RequestCtx) {
+		ctx.SetUserValue("iin", "0")
+		SetAuditActor(ctx, "910815450350")
+		SetAuditTarget(ctx, "KZT0000000001")
+	}, domain.AuditEvent{ActorIIN: "910815450350", Target: "KZT0000000001", Outcome: domain.AuditSuccess}},
+	{"outcome set by handler", func(ctx *fasthttp.RequestCtx) {
+		SetAuditOutcome(ctx, domain.AuditFailure)
+		ctx.SetStatusCode(fasthttp.StatusSeeOther)
+	}, domain.AuditEvent{Outcome: domain.AuditFailure}},
+}
+
+func TestAudit(t *testing.T) {
+	audit := &recordingAudit{}
+	SetAuditLog(audit)
+	defer SetAuditLog(nil)
+
+	for _, tt := range auditTestTable {
+		audit.event = nil
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetUserAgent("test-agent")
+		Audit(domain.AuditLogin, tt.handler)(ctx)
+		event := audit.event
+		if event == nil {
+			t.Errorf("for %s, event wasn't recorded", tt.name)
+			continue
+		}
+		if event.Action != domain.AuditLogin || event.ActorIIN != tt.expected.ActorIIN || event.Target != tt.expected.Target ||
+			event.Outcome != tt.expected.Outcome || event.UserAgent != "test-agent" || event.IP == "" {
+			t.Errorf("for %s, unexpected event %+v", tt.name, event)
+		}
+	}
+
+	// failing audit log doesn't change the answer
+	audit.err = errors.New("db error")
+	ctx := &fasthttp.RequestCtx{}
+	Audit(domain.AuditTopup, func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(fasthttp.StatusOK)
+	})(ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusOK {
+		t.Errorf("expected %d but got %d", fasthttp.StatusOK, ctx.Response.StatusCode())
+	}
+}
@@ -1,99 +1,295 @@
 package middleware
 
 import (
+	"auth/config"
 	"auth/domain"
+	"auth/logger"
 	"auth/myerrors"
+	"auth/signing"
 	"auth/user/delivery/response"
 	"fmt"
-	"log"
-	"os"
+	"net/url"
+	"strings"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/valyala/fasthttp"
 )
 
-// SecretMiddleware gets token secrets and ttl from .env and populates them to RequestCtx
+// TokenDenylist reports access tokens revoked before their expiration
+type TokenDenylist interface {
+	IsTokenDenied(jti string) (bool, error)
+}
+
+// denylist is consulted by CheckAuthMiddleware, set on startup with SetDenylist
+var denylist TokenDenylist
+
+// SetDenylist sets store of revoked access tokens
+func SetDenylist(d TokenDenylist) {
+	denylist = d
+}
+
+// SecretMiddleware gets token signing keys and token policy and populates them to RequestCtx
 func SecretMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
 	return func(ctx *fasthttp.RequestCtx) {
-		accessSecret := os.Getenv("ACCESS_SECRET")
-		refreshSecret := os.Getenv("REFRESH_SECRET")
-
-		if accessSecret == "" || refreshSecret == "" {
-			log.Println("ERROR|Error retrieving secret")
+		keys, err := signing.Default()
+		if err != nil {
+			logger.Ctx(ctx).Error("Error retrieving signing keys", "err", err)
 			response.RespondInternalServerError(ctx)
 			return
 		}
-		accessTtl := time.Second * 20
-		refreshTtl := time.Minute * 10
-		ctx.SetUserValue("accessSecret", accessSecret)
-		ctx.SetUserValue("refreshSecret", refreshSecret)
-		ctx.SetUserValue("accessTtl", accessTtl)
-		ctx.SetUserValue("refreshTtl", refreshTtl)
+		policy, err := config.DefaultTokenPolicy()
+		if err != nil {
+			logger.Ctx(ctx).Error("Error retrieving token policy", "err", err)
+			response.RespondInternalServerError(ctx)
+			return
+		}
+		ctx.SetUserValue("keys", keys)
+		ctx.SetUserValue("policy", policy)
+		ctx.SetUserValue("accessTtl", policy.AccessTtl)
+		ctx.SetUserValue("refreshTtl", policy.RefreshTtl)
 		next(ctx)
 	}
 }
 
+// CheckAuthMiddleware lets through requests of signed in users. Tokens issued to OAuth clients are rejected
 func CheckAuthMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
-	log.Println("INFO|CheckAuthMiddleware hit")
+	logger.Debug("CheckAuthMiddleware hit")
+	return checkAuth(next, false)
+}
+
+// CheckDelegatedAuthMiddleware also lets through tokens issued to OAuth clients,
+// those carry only permissions granted as scopes
+func CheckDelegatedAuthMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return checkAuth(next, true)
+}
+
+func checkAuth(next fasthttp.RequestHandler, allowClients bool) fasthttp.RequestHandler {
 	return func(ctx *fasthttp.RequestCtx) {
-		log.Println("INFO|Middleware hit")
-		accessSecret, refreshSecret, err := GetSecretFromCtx(ctx)
+		logger.Ctx(ctx).Debug("Middleware hit")
+		keys, err := GetKeysFromCtx(ctx)
 		if err != nil {
-			log.Println("ERROR|error retrieving secrets")
+			logger.Ctx(ctx).Error("error retrieving signing keys")
 			response.RespondInternalServerError(ctx)
 			return
 		}
 
 		access, err := ExtractToken(ctx, true)
 		if err != nil {
-			log.Println("ERROR|Extracting token:", err)
-			ctx.SetStatusCode(fasthttp.StatusSeeOther)
-			ctx.Response.Header.Add("Location", "/login")
-			return
+			logger.Ctx(ctx).Error("Extracting token", "err", err)
+			var ok bool
+			if access, ok = refreshAccess(ctx); !ok {
+				return
+			}
 		}
-		refresh, _ := ExtractToken(ctx, false)
-		log.Println("INFO|Extracted following tokens:", access, "\n", refresh)
 		IIN, isAdmin, err := ParseToken(ctx, access, true)
+		if err != nil && err.Error() == "Token is expired" {
+			if _, bearerErr := bearerToken(ctx); bearerErr != nil {
+				logger.Ctx(ctx).Info("Access cookie expired, refreshing it in place")
+				var ok bool
+				if access, ok = refreshAccess(ctx); !ok {
+					return
+				}
+				IIN, isAdmin, err = ParseToken(ctx, access, true)
+			}
+		}
 		if err != nil {
 			if err.Error() == "Token is expired" {
-				log.Println("ERROR|Access token expired, redirecting to update")
-				ctx.Redirect("/update", fasthttp.StatusSeeOther)
+				logger.Ctx(ctx).Error("Access token expired, redirecting to update")
+				unauthorized(ctx, "/update")
 				return
 			}
-			log.Printf("ERROR|Parse access token error: %v", err)
-			ctx.SetStatusCode(fasthttp.StatusSeeOther)
-			ctx.Response.Header.Add("Location", "/login")
+			logger.Ctx(ctx).Error("Parse access token error", "err", err)
+			unauthorized(ctx, "/login")
+			return
+		}
+		if clientID := ClientID(access); clientID != "" && !allowClients {
+			logger.Ctx(ctx).Error("Token of OAuth client used on first party route", "client_id", clientID)
+			unauthorized(ctx, "/login")
+			return
+		}
+		if err := CheckDenylist(access); err != nil {
+			logger.Ctx(ctx).Error("Access token rejected", "err", err)
+			if err == myerrors.ErrTokenRevoked {
+				unauthorized(ctx, "/login")
+				return
+			}
+			response.RespondInternalServerError(ctx)
 			return
 		}
 
 		// Authorization success
-		ctx.SetUserValue("accessSecret", accessSecret)
-		ctx.SetUserValue("refreshSecret", refreshSecret)
+		ctx.SetUserValue("keys", keys)
 		ctx.SetUserValue("access", access)
 		ctx.SetUserValue("iin", IIN)
 		ctx.SetUserValue("admin", isAdmin)
+		ctx.SetUserValue("principal", domain.Principal{Kind: domain.PrincipalUser, IIN: IIN, ClientID: ClientID(access), Scopes: Scope(access)})
 		SetValueFromToken(ctx, access)
-		log.Println("INFO|Middleware auth passed")
+		logger.Ctx(ctx).Debug("Middleware auth passed")
 		next(ctx)
 	}
 }
 
+// refreshAccess mints new access token from refresh cookie of a browser, so the request goes on whatever its method.
+// If it can't, the request is answered and false is returned: /update is only a fallback for failures other than
+// invalid session
+func refreshAccess(ctx *fasthttp.RequestCtx) (string, bool) {
+	refreshToken, err := ExtractToken(ctx, false)
+	if err != nil {
+		unauthorized(ctx, "/login")
+		return "", false
+	}
+	access, err := refreshSession(ctx, refreshToken)
+	if err == myerrors.ErrInvalidToken {
+		logger.Ctx(ctx).Error("Refresh cookie rejected, redirecting to login")
+		unauthorized(ctx, "/login")
+		return "", false
+	}
+	if err == myerrors.ErrTokenRotated {
+		// the concurrent request sets the new cookies, /update sends the browser back once they are there
+		logger.Ctx(ctx).Info("Refresh cookie was just rotated, redirecting to update")
+		location := "/update"
+		if ctx.IsGet() {
+			location += "?next=" + url.QueryEscape(string(ctx.RequestURI()))
+		}
+		unauthorized(ctx, location)
+		return "", false
+	}
+	if err != nil {
+		logger.Ctx(ctx).Error("Couldn't refresh access token in place, redirecting to update", "err", err)
+		unauthorized(ctx, "/update")
+		return "", false
+	}
+	logger.Ctx(ctx).Info("Access token refreshed in place")
+	return access, true
+}
+
+// CheckDenylist returns ErrTokenRevoked if an already verified access token was revoked on logout
+func CheckDenylist(access string) error {
+	if denylist == nil {
+		return fmt.Errorf("token denylist is not set")
+	}
+	jti, _, err := TokenID(access)
+	if err != nil {
+		return myerrors.ErrTokenRevoked
+	}
+	denied, err := denylist.IsTokenDenied(jti)
+	if err != nil {
+		return err
+	}
+	if denied {
+		return myerrors.ErrTokenRevoked
+	}
+	return nil
+}
+
+// unauthorized redirects browsers to location. API clients using bearer tokens or asking for JSON get 401 instead
+func unauthorized(ctx *fasthttp.RequestCtx, location string) {
+	if _, err := bearerToken(ctx); err == nil || response.WantsJSON(ctx) {
+		ctx.Response.Header.Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		response.RespondWithError(ctx, fasthttp.StatusUnauthorized, "invalid or expired token")
+		return
+	}
+	ctx.Redirect(location, fasthttp.StatusSeeOther)
+}
+
 // SetValueFromToken sets user as ctx.UserValue
 func SetValueFromToken(ctx *fasthttp.RequestCtx, token string) {
 	claims := jwt.MapClaims{}
 	p := jwt.Parser{}
 	if _, _, err := p.ParseUnverified(token, &claims); err != nil {
-		fmt.Println(err)
+		logger.Ctx(ctx).Error("Parse token error", "err", err)
 		return
 	}
 	user := domain.User{
-		IIN:      claims["iin"].(string),
-		Username: claims["username"].(string),
-		Ts:       claims["createdAt"].(string),
-		IsAdmin:  claims["admin"].(bool),
+		IIN:         claims["iin"].(string),
+		Username:    claims["username"].(string),
+		Ts:          claims["createdAt"].(string),
+		IsAdmin:     claims["admin"].(bool),
+		Roles:       claimStrings(claims, "roles"),
+		Permissions: claimStrings(claims, "perms"),
 	}
 	ctx.SetUserValue("user", user)
+	if sessionID, ok := claims["sid"].(string); ok {
+		ctx.SetUserValue("sid", sessionID)
+	}
+}
+
+// claimStrings returns string list claim, missing or malformed claim gives empty list
+func claimStrings(claims jwt.MapClaims, key string) []string {
+	values, _ := claims[key].([]interface{})
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if str, ok := v.(string); ok {
+			result = append(result, str)
+		}
+	}
+	return result
+}
+
+// SessionID returns session ID of an already verified token
+func SessionID(token string) (string, error) {
+	claims := jwt.MapClaims{}
+	p := jwt.Parser{}
+	if _, _, err := p.ParseUnverified(token, &claims); err != nil {
+		return "", err
+	}
+	sessionID, ok := claims["sid"].(string)
+	if !ok || sessionID == "" {
+		return "", fmt.Errorf("Field sid not found")
+	}
+	return sessionID, nil
+}
+
+// TokenID returns jti and expiration time of an already verified token
+func TokenID(token string) (string, time.Time, error) {
+	claims := jwt.MapClaims{}
+	p := jwt.Parser{}
+	if _, _, err := p.ParseUnverified(token, &claims); err != nil {
+		return "", time.Time{}, err
+	}
+	jti, ok := claims["jti"].(string)
+	if !ok || jti == "" {
+		return "", time.Time{}, fmt.Errorf("Field jti not found")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return "", time.Time{}, fmt.Errorf("Field exp not found")
+	}
+	return jti, time.Unix(int64(exp), 0), nil
+}
+
+// ClientID returns OAuth client an already verified token was issued to, empty for first party tokens
+func ClientID(token string) string {
+	claims := jwt.MapClaims{}
+	p := jwt.Parser{}
+	if _, _, err := p.ParseUnverified(token, &claims); err != nil {
+		return ""
+	}
+	clientID, _ := claims["cid"].(string)
+	return clientID
+}
+
+// Scope returns scopes granted to OAuth client in an already verified token
+func Scope(token string) []string {
+	claims := jwt.MapClaims{}
+	p := jwt.Parser{}
+	if _, _, err := p.ParseUnverified(token, &claims); err != nil {
+		return nil
+	}
+	scope, _ := claims["scope"].(string)
+	return strings.Fields(scope)
+}
+
+// RememberMe reports whether an already verified refresh token was issued in "remember me" mode
+func RememberMe(token string) bool {
+	claims := jwt.MapClaims{}
+	p := jwt.Parser{}
+	if _, _, err := p.ParseUnverified(token, &claims); err != nil {
+		return false
+	}
+	rememberMe, _ := claims["rem"].(bool)
+	return rememberMe
 }
 
 // extractCredential extracts login credentials
@@ -101,47 +297,57 @@ func extractCredential(ctx *fasthttp.RequestCtx) (login string, pass string) {
 	return string(ctx.FormValue("login")), string(ctx.FormValue("password"))
 }
 
-// GetSecretFromCtx retrieves token secrets
-func GetSecretFromCtx(ctx *fasthttp.RequestCtx) (accessSecret, refreshSecret string, err error) {
-	accessSecret, accessOk := ctx.Value("accessSecret").(string)
-	refreshSecret, refreshOk := ctx.Value("refreshSecret").(string)
-	if accessOk && refreshOk {
-		return
+// GetPolicyFromCtx retrieves token policy
+func GetPolicyFromCtx(ctx *fasthttp.RequestCtx) (*config.TokenPolicy, error) {
+	policy, ok := ctx.Value("policy").(*config.TokenPolicy)
+	if !ok {
+		return nil, fmt.Errorf("Error getting token policy")
 	}
-	err = fmt.Errorf("Error getting secret")
-	return
+	return policy, nil
+}
+
+// GetKeysFromCtx retrieves token signing keys
+func GetKeysFromCtx(ctx *fasthttp.RequestCtx) (*signing.KeySet, error) {
+	keys, ok := ctx.Value("keys").(*signing.KeySet)
+	if !ok {
+		return nil, fmt.Errorf("Error getting signing keys")
+	}
+	return keys, nil
 }
 
 // ParseToken parses tokens and claims
 func ParseToken(ctx *fasthttp.RequestCtx, token string, isAccess bool) (string, bool, error) {
-	log.Println("INFO|ParseToken hit")
-	accessSecret, refreshSecret, err := GetSecretFromCtx(ctx)
+	logger.Ctx(ctx).Debug("ParseToken hit")
+	keys, err := GetKeysFromCtx(ctx)
 	if err != nil {
 		return "", false, err
 	}
+	return VerifyToken(keys, token, isAccess)
+}
 
-	JWTToken, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Failed to extract token metadata, unexpected signing method: %v", token.Header["alg"])
-		}
-		if isAccess {
-			return []byte(accessSecret), nil
-		}
-		return []byte(refreshSecret), nil
-	})
+// VerifyToken is ParseToken for callers outside of fasthttp, returns IIN and admin flag of a valid token
+func VerifyToken(keys *signing.KeySet, token string, isAccess bool) (string, bool, error) {
+	JWTToken, err := jwt.Parse(token, keys.Keyfunc)
 
 	if err != nil {
-		log.Printf("ERROR|Native parse err:%q", err.Error())
+		logger.Error("Native parse error", "err", err)
 		return "", false, err
 	}
-	log.Println("INFO|Middleware: Extracting claims")
+	logger.Debug("Middleware: Extracting claims")
 	claims, ok := JWTToken.Claims.(jwt.MapClaims)
 
 	var IIN string
 	var admin bool
 
 	if ok && JWTToken.Valid {
-		log.Println("INFO|Middlewrare: token ok & valid")
+		logger.Debug("Middleware: token ok & valid")
+		expectedType := "refresh"
+		if isAccess {
+			expectedType = "access"
+		}
+		if typ, _ := claims["typ"].(string); typ != expectedType {
+			return "", false, fmt.Errorf("Unexpected token type %q", typ)
+		}
 		admin, ok = claims["admin"].(bool)
 		if !ok {
 			return "", false, fmt.Errorf("Field admin not found")
@@ -169,15 +375,54 @@ func ParseToken(ctx *fasthttp.RequestCtx, token string, isAccess bool) (string,
 		if time.Now().After(expiredTime) {
 			return "", false, myerrors.ErrTokenExpired
 		}
-		log.Println("INFO|Middleware: everything ok, passing IIN and role from parsed token")
+		logger.Debug("Middleware: everything ok, passing IIN and role from parsed token")
 		return string(IIN), admin, nil
 	}
-	log.Println("INFO|Middleware: token not ok or not valid")
+	logger.Debug("Middleware: token not ok or not valid")
 	return "", false, myerrors.ErrInvalidToken
 }
 
-// ExtractToken extracts access token value from cookies if isAccess is true and refresh token value otherwise
+// ParseMFAToken parses token issued between password and two-factor steps of login
+func ParseMFAToken(ctx *fasthttp.RequestCtx, token string) (username string, rememberMe bool, err error) {
+	keys, err := GetKeysFromCtx(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	JWTToken, err := jwt.Parse(token, keys.Keyfunc)
+	if err != nil {
+		logger.Ctx(ctx).Error("Native parse error", "err", err)
+		return "", false, myerrors.ErrInvalidToken
+	}
+	claims, ok := JWTToken.Claims.(jwt.MapClaims)
+	if !ok || !JWTToken.Valid {
+		return "", false, myerrors.ErrInvalidToken
+	}
+	if typ, _ := claims["typ"].(string); typ != "mfa" {
+		return "", false, fmt.Errorf("Unexpected token type %q", typ)
+	}
+	username, ok = claims["username"].(string)
+	if !ok || username == "" {
+		return "", false, fmt.Errorf("Field username not found")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return "", false, fmt.Errorf("Field exp not found")
+	}
+	if time.Now().After(time.Unix(int64(exp), 0)) {
+		return "", false, myerrors.ErrTokenExpired
+	}
+	rememberMe, _ = claims["rem"].(bool)
+	return username, rememberMe, nil
+}
+
+// ExtractToken extracts access token value from Authorization header or cookies if isAccess is true
+// and refresh token value from cookies otherwise
 func ExtractToken(ctx *fasthttp.RequestCtx, isAccess bool) (string, error) {
+	if isAccess {
+		if token, err := bearerToken(ctx); err == nil {
+			return token, nil
+		}
+	}
 	var token string
 	token = string(ctx.Request.Header.Cookie("access"))
 	if !isAccess {
@@ -188,3 +433,16 @@ func ExtractToken(ctx *fasthttp.RequestCtx, isAccess bool) (string, error) {
 	}
 	return token, nil
 }
+
+// bearerToken extracts token from "Authorization: Bearer" header
+func bearerToken(ctx *fasthttp.RequestCtx) (string, error) {
+	header := string(ctx.Request.Header.Peek(fasthttp.HeaderAuthorization))
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return "", myerrors.ErrBearerNotFound
+	}
+	token := strings.TrimSpace(header[7:])
+	if token == "" {
+		return "", myerrors.ErrBearerNotFound
+	}
+	return token, nil
+}
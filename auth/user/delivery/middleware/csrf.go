@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"auth/config"
+	"auth/logger"
+	"auth/user/delivery/response"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"regexp"
+
+	"github.com/valyala/fasthttp"
+)
+
+// CSRF token is kept in CSRFCookie and must be repeated in CSRFHeader by scripts or in CSRFField by forms
+const (
+	CSRFCookie = "csrf"
+	CSRFHeader = "X-CSRF-Token"
+	CSRFField  = "csrf_token"
+)
+
+var csrfPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{43}$`)
+
+// CSRF protects state-changing requests of browsers by double-submit token: every request other than GET, HEAD
+// and OPTIONS must repeat value of the csrf cookie. The cookie is issued on the first visit and the token is kept
+// in ctx.UserValue("csrf") for render.RenderTemplate. Requests with a Bearer token, which ExtractToken prefers to the
+// access cookie, carry no ambient credentials and are let through, as well as exempt paths authenticating clients
+// by the request body only
+func CSRF(next fasthttp.RequestHandler, exempt ...string) fasthttp.RequestHandler {
+	exemptPaths := map[string]bool{}
+	for _, path := range exempt {
+		exemptPaths[path] = true
+	}
+	return func(ctx *fasthttp.RequestCtx) {
+		token := string(ctx.Request.Header.Cookie(CSRFCookie))
+		issued := false
+		if !csrfPattern.MatchString(token) {
+			var err error
+			if token, err = newCSRFToken(); err != nil {
+				logger.Ctx(ctx).Error("Couldn't generate CSRF token", "err", err)
+				response.RespondInternalServerError(ctx)
+				return
+			}
+			issued = true
+		}
+		if !safeMethod(ctx) && !exemptPaths[string(ctx.Path())] && !hasBearerToken(ctx) {
+			submitted := ctx.Request.Header.Peek(CSRFHeader)
+			if len(submitted) == 0 {
+				submitted = ctx.FormValue(CSRFField)
+			}
+			if issued || subtle.ConstantTimeCompare(submitted, []byte(token)) != 1 {
+				logger.Ctx(ctx).Security("CSRF token mismatch", "method", string(ctx.Method()), "path", string(ctx.Path()), "ip", ctx.RemoteIP().String())
+				response.RespondWithError(ctx, fasthttp.StatusForbidden, "invalid csrf token, reload the page and try again")
+				return
+			}
+		}
+		if issued {
+			if err := setCSRFCookie(ctx, token); err != nil {
+				logger.Ctx(ctx).Error("Couldn't set CSRF cookie", "err", err)
+				response.RespondInternalServerError(ctx)
+				return
+			}
+		}
+		ctx.SetUserValue("csrf", token)
+		next(ctx)
+	}
+}
+
+// hasBearerToken reports whether the request is authorized by Bearer token, other Authorization schemes don't
+// stop the cookies from being used
+func hasBearerToken(ctx *fasthttp.RequestCtx) bool {
+	_, err := bearerToken(ctx)
+	return err == nil
+}
+
+func safeMethod(ctx *fasthttp.RequestCtx) bool {
+	return ctx.IsGet() || ctx.IsHead() || ctx.IsOptions()
+}
+
+func newCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// setCSRFCookie sets browser session cookie holding CSRF token, scripts get the token from the page and not the cookie
+func setCSRFCookie(ctx *fasthttp.RequestCtx, token string) error {
+	policy, err := config.DefaultTokenPolicy()
+	if err != nil {
+		return err
+	}
+	cookie := fasthttp.AcquireCookie()
+	defer fasthttp.ReleaseCookie(cookie)
+	cookie.SetKey(CSRFCookie)
+	cookie.SetValue(token)
+	cookie.SetHTTPOnly(true)
+	cookie.SetSecure(policy.Cookie.Secure)
+	cookie.SetDomain(policy.Cookie.Domain)
+	cookie.SetPath(policy.Cookie.Path)
+	cookie.SetSameSite(policy.Cookie.SameSite)
+	ctx.Response.Header.SetCookie(cookie)
+	return nil
+}
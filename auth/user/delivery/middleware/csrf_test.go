@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"net"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestCSRF(t *testing.T) {
+	t.Parallel()
+
+	ln := fasthttputil.NewInmemoryListener()
+	// handler answers with the token passed to templates
+	handler := func(ctx *fasthttp.RequestCtx) {
+		token, _ := ctx.UserValue("csrf").(string)
+		ctx.SetBodyString(token)
+	}
+	s := &fasthttp.Server{Handler: CSRF(handler, "/exempt")}
+	go s.Serve(ln) //nolint:errcheck
+	c := &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	const token = "Y3NyZi10b2tlbi1mb3ItbWlkZGxld2FyZS10ZXN0c19"
+	const other = "b3RoZXItdG9rZW4tZm9yLW1pZGRsZXdhcmUtdGVzdHM"
+
+	var testTable = []struct {
+		name     string
+		method   string
+		path     string
+		cookie   string
+		header   string
+		field    string
+		auth     string
+		expected int
+		issued   bool
+	}{
+		{"get issues token", "GET", "/page", "", "", "", "", fasthttp.StatusOK, true},
+		{"get keeps token", "GET", "/page", token, "", "", "", fasthttp.StatusOK, false},
+		{"get malformed cookie", "GET", "/page", "short", "", "", "", fasthttp.StatusOK, true},
+		{"post no token", "POST", "/transfer", "", "", "", "", fasthttp.StatusForbidden, false},
+		{"post no submitted token", "POST", "/transfer", token, "", "", "", fasthttp.StatusForbidden, false},
+		{"post header mismatch", "POST", "/transfer", token, other, "", "", fasthttp.StatusForbidden, false},
+		{"post field mismatch", "POST", "/transfer", token, "", other, "", fasthttp.StatusForbidden, false},
+		{"post no cookie", "POST", "/transfer", "", token, "", "", fasthttp.StatusForbidden, false},
+		{"post header", "POST", "/transfer", token, token, "", "", fasthttp.StatusOK, false},
+		{"post field", "POST", "/transfer", token, "", token, "", fasthttp.StatusOK, false},
+		{"delete header", "DELETE", "/sessions", token, token, "", "", fasthttp.StatusOK, false},
+		{"delete no token", "DELETE", "/sessions", token, "", "", "", fasthttp.StatusForbidden, false},
+		{"post bearer", "POST", "/transfer", "", "", "", "Bearer token", fasthttp.StatusOK, true},
+		// other schemes and empty bearer are not used by ExtractToken, cookies still authorize the request
+		{"post basic auth", "POST", "/transfer", token, "", "", "Basic dXNlcjpwYXNz", fasthttp.StatusForbidden, false},
+		{"post empty bearer", "POST", "/transfer", token, "", "", "Bearer ", fasthttp.StatusForbidden, false},
+		{"post exempt", "POST", "/exempt", "", "", "", "", fasthttp.StatusOK, true},
+	}
+	for _, tt := range testTable {
+		req, res := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		req.Header.SetMethod(tt.method)
+		req.SetRequestURI("http://test.com" + tt.path)
+		if tt.cookie != "" {
+			req.Header.SetCookie(CSRFCookie, tt.cookie)
+		}
+		if tt.header != "" {
+			req.Header.Set(CSRFHeader, tt.header)
+		}
+		if tt.auth != "" {
+			req.Header.Set(fasthttp.HeaderAuthorization, tt.auth)
+		}
+		req.Header.SetContentType("application/x-www-form-urlencoded")
+		if tt.field != "" {
+			req.SetBodyString(CSRFField + "=" + tt.field)
+		}
+		if err := c.Do(req, res); err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode() != tt.expected {
+			t.Errorf("%s: unexpected status code %d. Expecting %d", tt.name, res.StatusCode(), tt.expected)
+		}
+		cookie := fasthttp.AcquireCookie()
+		cookie.SetKey(CSRFCookie)
+		issued := res.Header.Cookie(cookie)
+		if issued != tt.issued {
+			t.Errorf("%s: cookie issued %t. Expecting %t", tt.name, issued, tt.issued)
+		}
+		if res.StatusCode() == fasthttp.StatusOK {
+			expected := tt.cookie
+			if issued {
+				expected = string(cookie.Value())
+				if !cookie.HTTPOnly() || len(expected) != 43 {
+					t.Errorf("%s: unexpected cookie %s", tt.name, cookie.String())
+				}
+			}
+			if string(res.Body()) != expected {
+				t.Errorf("%s: template token %q. Expecting %q", tt.name, res.Body(), expected)
+			}
+		}
+		fasthttp.ReleaseCookie(cookie)
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(res)
+	}
+}
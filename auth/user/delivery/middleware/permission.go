@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"auth/domain"
+	"auth/logger"
+	"auth/user/delivery/response"
+
+	"github.com/valyala/fasthttp"
+)
+
+// RequirePermission lets request through only if authorized user has the permission
+// or service client was granted it as scope. Must be wrapped by one of auth middlewares
+func RequirePermission(permission string, next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if principal, ok := GetPrincipal(ctx); ok && principal.Kind == domain.PrincipalService {
+			if !hasScope(principal.Scopes, permission) {
+				logger.Ctx(ctx).Error("Permission denied", "permission", permission, "client_id", principal.ClientID)
+				response.RespondWithError(ctx, fasthttp.StatusForbidden, "forbidden")
+				return
+			}
+			next(ctx)
+			return
+		}
+		user, ok := ctx.UserValue("user").(domain.User)
+		if !ok || !HasPermission(user, permission) {
+			logger.Ctx(ctx).Error("Permission denied", "permission", permission, "iin", ctx.UserValue("iin"))
+			response.RespondWithError(ctx, fasthttp.StatusForbidden, "forbidden")
+			return
+		}
+		next(ctx)
+	}
+}
+
+// HasPermission reports whether user was granted the permission
+func HasPermission(user domain.User, permission string) bool {
+	return hasScope(user.Permissions, permission)
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"auth/domain"
+	"net"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+var permissionTestTable = []struct {
+	name               string
+	permission         string
+	expectedStatusCode int
+}{
+	{"granted", domain.PermWalletsRead, fasthttp.StatusOK},
+	{"not granted", domain.PermUsersWrite, fasthttp.StatusForbidden},
+}
+
+func TestRequirePermission(t *testing.T) {
+	t.Parallel()
+
+	token, err := GenerateToken()
+	if err != nil {
+		t.Fatal("Failed to generate token", err)
+	}
+	for _, tt := range permissionTestTable {
+		ln := fasthttputil.NewInmemoryListener()
+		s := &fasthttp.Server{
+			Handler: SecretMiddleware(CheckAuthMiddleware(RequirePermission(tt.permission, func(ctx *fasthttp.RequestCtx) {}))),
+		}
+		go s.Serve(ln) //nolint:errcheck
+		c := &fasthttp.Client{
+			Dial: func(addr string) (net.Conn, error) {
+				return ln.Dial()
+			},
+		}
+		req, res := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		req.Header.SetMethod(fasthttp.MethodGet)
+		req.SetRequestURI("http://test.com")
+		req.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+token)
+		if err := c.Do(req, res); err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode() != tt.expectedStatusCode {
+			t.Errorf("%s: unexpected status code %d. Expecting %d", tt.name, res.StatusCode(), tt.expectedStatusCode)
+		}
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(res)
+		ln.Close()
+	}
+}
+
+func TestHasPermission(t *testing.T) {
+	user := domain.User{Permissions: []string{domain.PermUsersRead}}
+	if !HasPermission(user, domain.PermUsersRead) {
+		t.Error("expected users:read to be granted")
+	}
+	if HasPermission(user, domain.PermUsersWrite) {
+		t.Error("expected users:write not to be granted")
+	}
+}
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"auth/config"
+	"auth/domain"
+	"auth/logger"
+	"auth/user/delivery/render"
+	"auth/user/delivery/response"
+	"auth/user/repository"
+	"bytes"
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// rateLimiter and rateLimits are consulted by RateLimit, set on startup with SetRateLimiter
+var (
+	rateLimiter   repository.RateLimiter
+	rateLimits    map[string]config.RateLimit
+	rateLimitPage *template.Template
+)
+
+// SetRateLimiter sets limiter backend, limits per route and page rendered to browsers that are limited
+func SetRateLimiter(l repository.RateLimiter, limits map[string]config.RateLimit, page *template.Template) {
+	rateLimiter = l
+	rateLimits = limits
+	rateLimitPage = page
+}
+
+// RateLimit limits requests to the route by the key configured for it.
+// Limits by IIN must be wrapped by CheckAuthMiddleware. Limiter errors let requests through
+func RateLimit(route string, next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		limit, ok := rateLimits[route]
+		if rateLimiter == nil || !ok || limit.Limit <= 0 {
+			next(ctx)
+			return
+		}
+		key := fmt.Sprintf("%s:%s", route, rateKey(ctx, limit.Key))
+		allowed, retryAfter, err := rateLimiter.Allow(key, limit.Limit, limit.Window)
+		if err != nil {
+			logger.Ctx(ctx).Error("Rate limiter", "err", err)
+			next(ctx)
+			return
+		}
+		if !allowed {
+			logger.Ctx(ctx).Warn("Rate limit exceeded", "route", route, "limit", limit.Limit, "window", limit.Window, "key", key)
+			tooManyRequests(ctx, retryAfter)
+			return
+		}
+		next(ctx)
+	}
+}
+
+// rateKey returns value requests are counted by, falling back to client IP when it's missing
+func rateKey(ctx *fasthttp.RequestCtx, key string) string {
+	switch key {
+	case config.RateKeyIIN:
+		if IIN, ok := ctx.UserValue("iin").(string); ok && IIN != "" {
+			return "iin:" + IIN
+		}
+	case config.RateKeyAPIKey:
+		if apiKey := ctx.Request.Header.Peek("X-API-Key"); len(apiKey) > 0 {
+			return "apikey:" + string(apiKey)
+		}
+	}
+	return "ip:" + ctx.RemoteIP().String()
+}
+
+// tooManyRequests renders rate limit page to browsers and responds with JSON otherwise
+func tooManyRequests(ctx *fasthttp.RequestCtx, retryAfter time.Duration) {
+	message := fmt.Sprintf("too many requests, try again in %v", retryAfter.Round(time.Second))
+	if response.WantsJSON(ctx) || !bytes.Contains(ctx.Request.Header.Peek("Accept"), []byte("text/html")) || rateLimitPage == nil {
+		response.RespondTooManyRequests(ctx, retryAfter, message)
+		return
+	}
+	response.SetRetryAfter(ctx, retryAfter)
+	if err := render.RenderTemplate(ctx, fasthttp.StatusTooManyRequests, rateLimitPage, domain.Info{Error: message}); err != nil {
+		logger.Ctx(ctx).Error("Executing template", "err", err)
+	}
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"auth/config"
+	"auth/user/repository/memory"
+	"net"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+var rateLimitTestTable = []struct {
+	name               string
+	route              string
+	headers            map[string]string
+	expectedStatusCode int
+	expectedBody       string
+}{
+	{"first", "login", nil, fasthttp.StatusOK, ""},
+	{"second", "login", nil, fasthttp.StatusOK, ""},
+	{"limited json", "login", map[string]string{"Accept": "application/json"}, fasthttp.StatusTooManyRequests, `"ok":false`},
+	{"limited html", "login", map[string]string{"Accept": "text/html"}, fasthttp.StatusTooManyRequests, "<p>too many requests"},
+	{"other api key", "api", map[string]string{"X-API-Key": "first"}, fasthttp.StatusOK, ""},
+	{"same api key", "api", map[string]string{"X-API-Key": "first"}, fasthttp.StatusTooManyRequests, ""},
+	{"another api key", "api", map[string]string{"X-API-Key": "second"}, fasthttp.StatusOK, ""},
+	{"not limited", "logout", nil, fasthttp.StatusOK, ""},
+}
+
+func TestRateLimit(t *testing.T) {
+	page := template.Must(template.New("ratelimit").Parse("<p>{{.Error}}</p>"))
+	SetRateLimiter(memory.NewMemoryRateLimiter(), map[string]config.RateLimit{
+		"login": {Limit: 2, Window: time.Minute, Key: config.RateKeyIP},
+		"api":   {Limit: 1, Window: time.Minute, Key: config.RateKeyAPIKey},
+	}, page)
+	defer SetRateLimiter(nil, nil, nil)
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	s := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			RateLimit(string(ctx.Path()[1:]), func(ctx *fasthttp.RequestCtx) {})(ctx)
+		},
+	}
+	go s.Serve(ln) //nolint:errcheck
+	c := &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	for _, tt := range rateLimitTestTable {
+		req, res := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		req.SetRequestURI("http://test.com/" + tt.route)
+		for key, value := range tt.headers {
+			req.Header.Set(key, value)
+		}
+		if err := c.Do(req, res); err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode() != tt.expectedStatusCode {
+			t.Errorf("%s: unexpected status code %d. Expecting %d", tt.name, res.StatusCode(), tt.expectedStatusCode)
+		}
+		if tt.expectedStatusCode == fasthttp.StatusTooManyRequests && len(res.Header.Peek("Retry-After")) == 0 {
+			t.Errorf("%s: expected Retry-After header", tt.name)
+		}
+		if !strings.Contains(string(res.Body()), tt.expectedBody) {
+			t.Errorf("%s: unexpected body %q", tt.name, res.Body())
+		}
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(res)
+	}
+}
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"fmt"
+
+	"github.com/valyala/fasthttp"
+)
+
+// TokenRefresher rotates refresh token of a browser session, setting new token cookies on ctx.
+// It returns the new access token, ErrInvalidToken if the user has to log in again or ErrTokenRotated
+// if a concurrent request of the page has just rotated the token
+type TokenRefresher interface {
+	RefreshSession(ctx *fasthttp.RequestCtx, refreshToken string) (string, error)
+}
+
+// refresher is used by CheckAuthMiddleware to refresh expired access tokens in place, set on startup with SetRefresher.
+// Without it browsers are redirected to /update
+var refresher TokenRefresher
+
+// SetRefresher sets refresher of browser sessions
+func SetRefresher(r TokenRefresher) {
+	refresher = r
+}
+
+func refreshSession(ctx *fasthttp.RequestCtx, refreshToken string) (string, error) {
+	if refresher == nil {
+		return "", fmt.Errorf("session refresher is not set")
+	}
+	return refresher.RefreshSession(ctx, refreshToken)
+}
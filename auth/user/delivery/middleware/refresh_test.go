@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"net"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestCheckAuthMiddlewareRefresh(t *testing.T) {
+	t.Parallel()
+
+	ln := fasthttputil.NewInmemoryListener()
+	// handler answers with the access token the request went on with
+	handler := func(ctx *fasthttp.RequestCtx) {
+		access, _ := ctx.UserValue("access").(string)
+		ctx.SetBodyString(access)
+	}
+	s := &fasthttp.Server{Handler: SecretMiddleware(CheckAuthMiddleware(handler))}
+	go s.Serve(ln) //nolint:errcheck
+	c := &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	expired, err := GenerateExpiredToken()
+	if err != nil {
+		t.Fatal("Failed to generate token", err)
+	}
+	do := func(method, access, bearer, refresh string) (*fasthttp.Response, error) {
+		req, res := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseRequest(req)
+		req.Header.SetMethod(method)
+		req.SetRequestURI("http://test.com/transfer?from=1")
+		if access != "" {
+			req.Header.SetCookie("access", access)
+		}
+		if bearer != "" {
+			req.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+bearer)
+		}
+		if refresh != "" {
+			req.Header.SetCookie("refresh", refresh)
+		}
+		req.SetBodyString("from=1&to=2&amount=10")
+		return res, c.Do(req, res)
+	}
+
+	var testTable = []struct {
+		name     string
+		method   string
+		access   string
+		bearer   string
+		refresh  string
+		expected int
+		location string
+		calls    int
+	}{
+		{"expired access", "POST", expired, "", "valid-expired", fasthttp.StatusOK, "", 1},
+		{"no access cookie", "POST", "", "", "valid-missing", fasthttp.StatusOK, "", 1},
+		{"no refresh cookie", "POST", expired, "", "", fasthttp.StatusSeeOther, "/login", 0},
+		{"revoked session", "POST", expired, "", "revoked", fasthttp.StatusSeeOther, "/login", 1},
+		{"refresh failed", "POST", expired, "", "down", fasthttp.StatusSeeOther, "/update", 1},
+		{"refresh failed again", "POST", expired, "", "down", fasthttp.StatusSeeOther, "/update", 2},
+		{"just rotated", "POST", expired, "", "rotated", fasthttp.StatusSeeOther, "/update", 1},
+		{"just rotated page", "GET", expired, "", "rotated", fasthttp.StatusSeeOther, "/update?next=%2Ftransfer%3Ffrom%3D1", 2},
+		{"expired bearer", "POST", "", expired, "valid-bearer", fasthttp.StatusUnauthorized, "", 0},
+	}
+	for _, tt := range testTable {
+		res, err := do(tt.method, tt.access, tt.bearer, tt.refresh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode() != tt.expected {
+			t.Errorf("%s: unexpected status code %d. Expecting %d", tt.name, res.StatusCode(), tt.expected)
+		}
+		if location := string(res.Header.Peek(fasthttp.HeaderLocation)); tt.location != "" && location != "http://test.com"+tt.location {
+			t.Errorf("%s: unexpected redirect to %s. Expecting %s", tt.name, location, tt.location)
+		}
+		if tt.expected == fasthttp.StatusOK && (len(res.Body()) == 0 || string(res.Body()) == expired) {
+			t.Errorf("%s: request went on without new access token", tt.name)
+		}
+		if calls := refresherCalls.count(tt.refresh); calls != tt.calls {
+			t.Errorf("%s: session refreshed %d times. Expecting %d", tt.name, calls, tt.calls)
+		}
+		fasthttp.ReleaseResponse(res)
+	}
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"auth/logger"
+	"context"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RequestIDHeader carries ID of the request, a valid one sent by the client is kept so that logs of services match
+const RequestIDHeader = "X-Request-ID"
+
+// requestID returns ID sent by the client when it is safe to log, a new one otherwise
+func requestID(sent string) string {
+	if logger.ValidRequestID(sent) {
+		return sent
+	}
+	return logger.NewRequestID()
+}
+
+// RequestLog gives the request an ID, which logger.Ctx adds to every line logged for the request,
+// and logs the answered request
+func RequestLog(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		id := requestID(string(ctx.Request.Header.Peek(RequestIDHeader)))
+		ctx.SetUserValue(logger.RequestIDKey, id)
+		ctx.Response.Header.Set(RequestIDHeader, id)
+		start := time.Now()
+		next(ctx)
+		logger.Ctx(ctx).Info("Request", "method", string(ctx.Method()), "path", string(ctx.Path()),
+			"status", ctx.Response.StatusCode(), "duration", time.Since(start), "ip", ctx.RemoteIP().String())
+	}
+}
+
+// UnaryRequestLog is RequestLog of gRPC calls, ID is read from and returned in x-request-id metadata
+func UnaryRequestLog(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	var sent string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(RequestIDHeader); len(values) != 0 {
+			sent = values[0]
+		}
+	}
+	id := requestID(sent)
+	ctx = logger.WithRequestID(ctx, id)
+	if err := grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, id)); err != nil {
+		logger.Ctx(ctx).Error("Couldn't set request ID header", "err", err)
+	}
+	start := time.Now()
+	res, err := handler(ctx, req)
+	logger.Ctx(ctx).Info("gRPC call", "method", info.FullMethod, "status", status.Code(err).String(), "duration", time.Since(start))
+	return res, err
+}
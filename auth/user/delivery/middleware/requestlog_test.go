@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"auth/logger"
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+var requestLogTestTable = []struct {
+	name     string
+	sent     string
+	expected string
+}{
+	{"kept", "3f2a-9c1b", "3f2a-9c1b"},
+	{"missing", "", ""},
+	{"invalid", "id with spaces", ""},
+	{"too long", strings.Repeat("a", 65), ""},
+}
+
+func TestRequestLog(t *testing.T) {
+	var out bytes.Buffer
+	defer logger.SetDefault(logger.Default())
+	logger.SetDefault(logger.New(&out, logger.LevelInfo, logger.FormatText))
+
+	for _, tt := range requestLogTestTable {
+		out.Reset()
+		var logged string
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/login?password=hunter2")
+		if tt.sent != "" {
+			ctx.Request.Header.Set(RequestIDHeader, tt.sent)
+		}
+		RequestLog(func(ctx *fasthttp.RequestCtx) {
+			logger.Ctx(ctx).Info("in handler")
+			logged = logger.RequestID(ctx)
+			ctx.SetStatusCode(fasthttp.StatusUnauthorized)
+		})(ctx)
+
+		id := string(ctx.Response.Header.Peek(RequestIDHeader))
+		if !logger.ValidRequestID(id) || logged != id || (tt.expected != "" && id != tt.expected) {
+			t.Errorf("for %s, unexpected request ID %q, handler got %q", tt.name, id, logged)
+		}
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if len(lines) != 2 || !strings.Contains(lines[0], "request_id="+id) || !strings.Contains(lines[1], "request_id="+id) ||
+			!strings.Contains(lines[1], "path=/login status=401") || strings.Contains(out.String(), "hunter2") {
+			t.Errorf("for %s, unexpected log %q", tt.name, out.String())
+		}
+	}
+}
+
+func TestUnaryRequestLog(t *testing.T) {
+	var out bytes.Buffer
+	defer logger.SetDefault(logger.Default())
+	logger.SetDefault(logger.New(&out, logger.LevelInfo, logger.FormatText))
+
+	for _, tt := range requestLogTestTable {
+		out.Reset()
+		ctx := context.Background()
+		if tt.sent != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(RequestIDHeader, tt.sent))
+		}
+		var logged string
+		_, err := UnaryRequestLog(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/auth.AuthService/Login"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			logged = logger.RequestID(ctx)
+			return nil, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !logger.ValidRequestID(logged) || (tt.expected != "" && logged != tt.expected) {
+			t.Errorf("for %s, unexpected request ID %q", tt.name, logged)
+		}
+		if !strings.Contains(out.String(), "method=/auth.AuthService/Login status=OK") || !strings.Contains(out.String(), "request_id="+logged) {
+			t.Errorf("for %s, unexpected log %q", tt.name, out.String())
+		}
+	}
+}
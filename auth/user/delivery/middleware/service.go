@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"auth/domain"
+	"auth/logger"
+	"auth/myerrors"
+	"auth/user/delivery/response"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/valyala/fasthttp"
+)
+
+// CheckServiceAuthMiddleware lets through requests of service clients holding client_credentials tokens
+func CheckServiceAuthMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		logger.Ctx(ctx).Debug("Service middleware hit")
+		keys, err := GetKeysFromCtx(ctx)
+		if err != nil {
+			logger.Ctx(ctx).Error("error retrieving signing keys")
+			response.RespondInternalServerError(ctx)
+			return
+		}
+		access, err := bearerToken(ctx)
+		if err != nil {
+			logger.Ctx(ctx).Error("Extracting service token", "err", err)
+			unauthorized(ctx, "/login")
+			return
+		}
+		clientID, scopes, err := ParseServiceToken(ctx, access)
+		if err != nil {
+			logger.Ctx(ctx).Error("Parse service token error", "err", err)
+			unauthorized(ctx, "/login")
+			return
+		}
+		if err := CheckDenylist(access); err != nil {
+			logger.Ctx(ctx).Error("Service token rejected", "err", err)
+			if err == myerrors.ErrTokenRevoked {
+				unauthorized(ctx, "/login")
+				return
+			}
+			response.RespondInternalServerError(ctx)
+			return
+		}
+		ctx.SetUserValue("keys", keys)
+		ctx.SetUserValue("access", access)
+		ctx.SetUserValue("principal", domain.Principal{Kind: domain.PrincipalService, ClientID: clientID, Scopes: scopes})
+		logger.Ctx(ctx).Info("Service client authorized", "client_id", clientID)
+		next(ctx)
+	}
+}
+
+// CheckAnyAuthMiddleware lets through signed in users and service clients, GetPrincipal tells them apart.
+// Tokens of OAuth clients acting for users are rejected like in CheckAuthMiddleware
+func CheckAnyAuthMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	service := CheckServiceAuthMiddleware(next)
+	user := checkAuth(next, false)
+	return func(ctx *fasthttp.RequestCtx) {
+		if access, err := bearerToken(ctx); err == nil && tokenType(access) == "service" {
+			service(ctx)
+			return
+		}
+		user(ctx)
+	}
+}
+
+// GetPrincipal returns who made the request, set by the auth middlewares
+func GetPrincipal(ctx *fasthttp.RequestCtx) (domain.Principal, bool) {
+	principal, ok := ctx.UserValue("principal").(domain.Principal)
+	return principal, ok
+}
+
+// ParseServiceToken verifies access token of a service client and returns the client ID and granted scopes
+func ParseServiceToken(ctx *fasthttp.RequestCtx, token string) (string, []string, error) {
+	keys, err := GetKeysFromCtx(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	JWTToken, err := jwt.Parse(token, keys.Keyfunc)
+	if err != nil {
+		return "", nil, err
+	}
+	claims, ok := JWTToken.Claims.(jwt.MapClaims)
+	if !ok || !JWTToken.Valid {
+		return "", nil, myerrors.ErrInvalidToken
+	}
+	if typ, _ := claims["typ"].(string); typ != "service" {
+		return "", nil, fmt.Errorf("Unexpected token type %q", typ)
+	}
+	clientID, ok := claims["cid"].(string)
+	if !ok || clientID == "" {
+		return "", nil, fmt.Errorf("Field cid not found")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return "", nil, fmt.Errorf("Field exp not found")
+	}
+	if time.Now().After(time.Unix(int64(exp), 0)) {
+		return "", nil, myerrors.ErrTokenExpired
+	}
+	return clientID, Scope(token), nil
+}
+
+// tokenType returns typ claim of a token without verifying it
+func tokenType(token string) string {
+	claims := jwt.MapClaims{}
+	p := jwt.Parser{}
+	if _, _, err := p.ParseUnverified(token, &claims); err != nil {
+		return ""
+	}
+	typ, _ := claims["typ"].(string)
+	return typ
+}
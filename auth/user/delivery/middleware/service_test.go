@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"auth/domain"
+	"net"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestCheckServiceAuthMiddleware(t *testing.T) {
+	t.Parallel()
+
+	ln := fasthttputil.NewInmemoryListener()
+	// handlers answer 200 for users and 202 for service clients
+	handler := func(ctx *fasthttp.RequestCtx) {
+		principal, ok := GetPrincipal(ctx)
+		if !ok {
+			ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+			return
+		}
+		if principal.Kind == domain.PrincipalService {
+			ctx.SetStatusCode(fasthttp.StatusAccepted)
+		}
+	}
+	r := func(ctx *fasthttp.RequestCtx) {
+		switch string(ctx.Path()) {
+		case "/service":
+			SecretMiddleware(CheckServiceAuthMiddleware(handler))(ctx)
+		case "/any":
+			SecretMiddleware(CheckAnyAuthMiddleware(handler))(ctx)
+		case "/users":
+			SecretMiddleware(CheckAnyAuthMiddleware(RequirePermission(domain.PermUsersRead, handler)))(ctx)
+		default:
+			SecretMiddleware(CheckAnyAuthMiddleware(RequirePermission(domain.PermWalletsWrite, handler)))(ctx)
+		}
+	}
+	s := &fasthttp.Server{Handler: r}
+	go s.Serve(ln) //nolint:errcheck
+	c := &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	token, err := GenerateToken()
+	if err != nil {
+		t.Fatal("Failed to generate token", err)
+	}
+	clientToken, err := GenerateClientToken("client")
+	if err != nil {
+		t.Fatal("Failed to generate token", err)
+	}
+	serviceToken, err := GenerateServiceToken("batch", "batch-jti")
+	if err != nil {
+		t.Fatal("Failed to generate token", err)
+	}
+	deniedToken, err := GenerateServiceToken("batch", "denied")
+	if err != nil {
+		t.Fatal("Failed to generate token", err)
+	}
+
+	var testTable = []struct {
+		name     string
+		path     string
+		token    string
+		expected int
+	}{
+		{"service", "/service", serviceToken, fasthttp.StatusAccepted},
+		{"service user token", "/service", token, fasthttp.StatusUnauthorized},
+		{"service client token", "/service", clientToken, fasthttp.StatusUnauthorized},
+		{"service revoked", "/service", deniedToken, fasthttp.StatusUnauthorized},
+		{"service no token", "/service", "", fasthttp.StatusUnauthorized},
+		{"any service", "/any", serviceToken, fasthttp.StatusAccepted},
+		{"any user", "/any", token, fasthttp.StatusOK},
+		{"any client token", "/any", clientToken, fasthttp.StatusUnauthorized},
+		{"scope granted", "/users", serviceToken, fasthttp.StatusAccepted},
+		{"scope not granted", "/wallets", serviceToken, fasthttp.StatusForbidden},
+	}
+	for _, tt := range testTable {
+		req, res := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		req.Header.SetMethod(fasthttp.MethodGet)
+		req.SetRequestURI("http://test.com" + tt.path)
+		if tt.token != "" {
+			req.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+tt.token)
+		}
+		req.Header.Set("Accept", "application/json")
+		if err := c.Do(req, res); err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode() != tt.expected {
+			t.Errorf("%s: unexpected status code %d. Expecting %d", tt.name, res.StatusCode(), tt.expected)
+		}
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(res)
+	}
+}
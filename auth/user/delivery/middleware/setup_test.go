@@ -1,36 +1,136 @@
 package middleware
 
 import (
+	"auth/domain"
+	"auth/myerrors"
+	"auth/signing"
+	"fmt"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/valyala/fasthttp"
 )
 
+type testDenylist struct{}
+
+func (d *testDenylist) IsTokenDenied(jti string) (bool, error) {
+	return jti == "denied", nil
+}
+
+// testRefresher refreshes sessions of refresh tokens starting with "valid", "revoked" ones are
+// rejected, "rotated" ones were just rotated by a concurrent request and "down" ones fail as if Redis was unavailable
+type testRefresher struct {
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (r *testRefresher) RefreshSession(ctx *fasthttp.RequestCtx, refreshToken string) (string, error) {
+	r.mu.Lock()
+	r.calls[refreshToken]++
+	r.mu.Unlock()
+	switch {
+	case strings.HasPrefix(refreshToken, "valid"):
+		return GenerateTokenWithID(refreshToken + "-access")
+	case refreshToken == "revoked":
+		return "", myerrors.ErrInvalidToken
+	case refreshToken == "rotated":
+		return "", myerrors.ErrTokenRotated
+	}
+	return "", fmt.Errorf("redis is down")
+}
+
+func (r *testRefresher) count(refreshToken string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.calls[refreshToken]
+}
+
+var refresherCalls = &testRefresher{calls: map[string]int{}}
+
 func TestMain(m *testing.M) {
-	os.Setenv("ACCESS_SECRET", ACCESS_SECRET)
-	os.Setenv("REFRESH_SECRET", REFRESH_SECRET)
+	os.Setenv("JWT_DEV_EPHEMERAL_KEY", "true")
+	SetDenylist(&testDenylist{})
+	SetRefresher(refresherCalls)
 	// os.Setenv("ACCESS_TTL", "20s")
 	// os.Setenv("REFRESH_TTL", "10m")
 	os.Exit(m.Run())
 }
 
 func GenerateToken() (string, error) {
+	return GenerateTokenWithID("910815450350")
+}
+
+func GenerateTokenWithID(jti string) (string, error) {
+	return generateToken(jti, "")
+}
+
+// GenerateClientToken generates token issued to OAuth client
+func GenerateClientToken(clientID string) (string, error) {
+	return generateToken(clientID+"-jti", clientID)
+}
+
+func generateToken(jti, clientID string) (string, error) {
+	keys, err := signing.Default()
+	if err != nil {
+		return "", err
+	}
 	accessTokenExp := time.Now().Add(20 * time.Second).Unix()
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	claims := jwt.MapClaims{
 		"admin":     false,
 		"exp":       accessTokenExp,
 		"iat":       1640024899,
 		"iin":       "910815450350",
 		"username":  "sth",
 		"createdAt": "2021-12-31 19:36:36",
-	})
+		"jti":       jti,
+		"typ":       "access",
+		"roles":     []string{"user"},
+		"perms":     []string{domain.PermWalletsRead},
+	}
+	if clientID != "" {
+		claims["cid"] = clientID
+		claims["scope"] = domain.PermWalletsRead
+	}
+	accessTokenString, err := keys.Sign(claims)
+	if err != nil {
+		return "", err
+	}
+	return accessTokenString, nil
+}
 
-	accessTokenString, err := accessToken.SignedString([]byte(ACCESS_SECRET))
+// GenerateExpiredToken generates access token expired a minute ago
+func GenerateExpiredToken() (string, error) {
+	keys, err := signing.Default()
+	if err != nil {
+		return "", err
+	}
+	return keys.Sign(jwt.MapClaims{
+		"admin":     false,
+		"exp":       time.Now().Add(-time.Minute).Unix(),
+		"iin":       "910815450350",
+		"username":  "sth",
+		"createdAt": "2021-12-31 19:36:36",
+		"jti":       "expired",
+		"typ":       "access",
+	})
+}
 
+// GenerateServiceToken generates client_credentials token of service client
+func GenerateServiceToken(clientID, jti string) (string, error) {
+	keys, err := signing.Default()
 	if err != nil {
 		return "", err
 	}
-	return accessTokenString, nil
+	return keys.Sign(jwt.MapClaims{
+		"sub":   clientID,
+		"cid":   clientID,
+		"scope": domain.PermUsersRead,
+		"exp":   time.Now().Add(20 * time.Second).Unix(),
+		"jti":   jti,
+		"typ":   "service",
+	})
 }
@@ -0,0 +1,485 @@
+package delivery
+
+import (
+	"auth/domain"
+	"auth/logger"
+	"auth/myerrors"
+	"auth/user/delivery/middleware"
+	"auth/user/delivery/render"
+	"auth/user/delivery/response"
+	"auth/user/usecase"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/buaazp/fasthttprouter"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/valyala/fasthttp"
+)
+
+// CSRFExemptPaths take no cookies, clients authenticate by credentials and tokens in the request body
+var CSRFExemptPaths = []string{"/refresh", "/oauth/token", "/oauth/introspect", "/oauth/revoke"}
+
+type OAuthHandler struct {
+	uc usecase.OAuthUsecase
+	t  *template.Template
+}
+
+// authorizationRequest reads authorization request from query of /oauth/authorize or from consent form
+func authorizationRequest(args *fasthttp.Args) *domain.AuthorizationRequest {
+	return &domain.AuthorizationRequest{
+		ResponseType:        string(args.Peek("response_type")),
+		ClientID:            string(args.Peek("client_id")),
+		RedirectURI:         string(args.Peek("redirect_uri")),
+		Scope:               string(args.Peek("scope")),
+		State:               string(args.Peek("state")),
+		CodeChallenge:       string(args.Peek("code_challenge")),
+		CodeChallengeMethod: string(args.Peek("code_challenge_method")),
+		Nonce:               string(args.Peek("nonce")),
+	}
+}
+
+// Authorize asks signed in user to let the client sign them in
+func (h *OAuthHandler) Authorize(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("Authorize hit")
+	req := authorizationRequest(ctx.QueryArgs())
+	client, scopes, err := h.uc.ValidateAuthorization(req)
+	if err != nil {
+		h.authorizationFailed(ctx, req, err)
+		return
+	}
+	consent := domain.OAuthConsent{Client: client, Request: *req}
+	for _, scope := range scopes {
+		consent.Scopes = append(consent.Scopes, domain.OAuthScopes[scope])
+	}
+	// the request is shown on the page, it must not be framed by other sites
+	ctx.Response.Header.Set("X-Frame-Options", "DENY")
+	if err := render.RenderTemplate(ctx, fasthttp.StatusOK, h.t, consent); err != nil {
+		logger.Ctx(ctx).Error("Executing template", "err", err)
+	}
+}
+
+// Approve redirects back to the client with authorization code or access_denied error
+func (h *OAuthHandler) Approve(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("Approve hit")
+	req := authorizationRequest(ctx.PostArgs())
+	middleware.SetAuditTarget(ctx, req.ClientID)
+	client, scopes, err := h.uc.ValidateAuthorization(req)
+	if err != nil {
+		h.authorizationFailed(ctx, req, err)
+		return
+	}
+	if string(ctx.PostArgs().Peek("decision")) != "approve" {
+		logger.Ctx(ctx).Info("OAuth client denied by user", "client_id", client.ClientID)
+		middleware.SetAuditOutcome(ctx, domain.AuditFailure)
+		redirectToClient(ctx, req, map[string]string{"error": "access_denied"})
+		return
+	}
+	IIN, ok := ctx.Value("iin").(string)
+	if !ok {
+		logger.Ctx(ctx).Error("IIN is nil")
+		redirectToClient(ctx, req, map[string]string{"error": "server_error"})
+		return
+	}
+	code, err := h.uc.IssueCode(&domain.AuthCode{
+		ClientID:      client.ClientID,
+		IIN:           IIN,
+		RedirectURI:   req.RedirectURI,
+		Scope:         strings.Join(scopes, " "),
+		CodeChallenge: req.CodeChallenge,
+		Nonce:         req.Nonce,
+	})
+	if err != nil {
+		logger.Ctx(ctx).Error("Couldn't issue authorization code", "err", err)
+		middleware.SetAuditOutcome(ctx, domain.AuditError)
+		redirectToClient(ctx, req, map[string]string{"error": "server_error"})
+		return
+	}
+	redirectToClient(ctx, req, map[string]string{"code": code})
+}
+
+// authorizationFailed redirects error back to the client, unless the client or its redirect URI can't be trusted
+func (h *OAuthHandler) authorizationFailed(ctx *fasthttp.RequestCtx, req *domain.AuthorizationRequest, err error) {
+	logger.Ctx(ctx).Error("Invalid authorization request", "err", err)
+	switch err {
+	case myerrors.ErrResponseType:
+		redirectToClient(ctx, req, map[string]string{"error": "unsupported_response_type"})
+	case myerrors.ErrInvalidPKCE:
+		redirectToClient(ctx, req, map[string]string{"error": "invalid_request", "error_description": "code_challenge with S256 method is required"})
+	case myerrors.ErrInvalidScope:
+		redirectToClient(ctx, req, map[string]string{"error": "invalid_scope"})
+	case myerrors.ErrClientNotFound, myerrors.ErrInvalidRedirect:
+		h.renderError(ctx, fasthttp.StatusBadRequest, "Приложение не найдено или адрес возврата не зарегистрирован")
+	default:
+		h.renderError(ctx, fasthttp.StatusInternalServerError, InternalServerErrorMessage)
+	}
+}
+
+func (h *OAuthHandler) renderError(ctx *fasthttp.RequestCtx, status int, message string) {
+	if err := render.RenderTemplate(ctx, status, h.t, domain.OAuthConsent{Error: message}); err != nil {
+		logger.Ctx(ctx).Error("Executing template", "err", err)
+	}
+}
+
+// redirectToClient redirects to the validated redirect URI adding params and state of the request
+func redirectToClient(ctx *fasthttp.RequestCtx, req *domain.AuthorizationRequest, params map[string]string) {
+	u, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		logger.Ctx(ctx).Error("Parsing redirect uri", "err", err)
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	query := u.Query()
+	for key, value := range params {
+		query.Set(key, value)
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	u.RawQuery = query.Encode()
+	ctx.SetStatusCode(fasthttp.StatusSeeOther)
+	ctx.Response.Header.Set("Location", u.String())
+}
+
+// Token exchanges authorization code or refresh token for a new token pair,
+// service clients get an access token of their own for client credentials
+func (h *OAuthHandler) Token(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("Token hit")
+	client, ok := h.authenticateClient(ctx)
+	if !ok {
+		return
+	}
+	switch string(ctx.PostArgs().Peek("grant_type")) {
+	case "authorization_code":
+		h.exchangeCode(ctx, client)
+	case "refresh_token":
+		h.refreshToken(ctx, client)
+	case "client_credentials":
+		h.serviceToken(ctx, client)
+	default:
+		response.RespondOAuthError(ctx, fasthttp.StatusBadRequest, "unsupported_grant_type", "")
+	}
+}
+
+// clientCredentials reads client ID and secret from Basic authorization or from the form
+func clientCredentials(ctx *fasthttp.RequestCtx) (string, string) {
+	header := string(ctx.Request.Header.Peek(fasthttp.HeaderAuthorization))
+	if len(header) > 6 && strings.EqualFold(header[:6], "Basic ") {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(header[6:]))
+		if err != nil {
+			return "", ""
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return "", ""
+		}
+		// both parts are form encoded, RFC 6749 section 2.3.1
+		ID, errID := url.QueryUnescape(parts[0])
+		secret, errSecret := url.QueryUnescape(parts[1])
+		if errID != nil || errSecret != nil {
+			return "", ""
+		}
+		return ID, secret
+	}
+	return string(ctx.PostArgs().Peek("client_id")), string(ctx.PostArgs().Peek("client_secret"))
+}
+
+// exchangeCode starts a session of the client for authorization code
+func (h *OAuthHandler) exchangeCode(ctx *fasthttp.RequestCtx, client *domain.OAuthClient) {
+	args := ctx.PostArgs()
+	grant, err := h.uc.ExchangeCode(client, string(args.Peek("code")), string(args.Peek("redirect_uri")), string(args.Peek("code_verifier")))
+	if err != nil {
+		logger.Ctx(ctx).Error("Exchanging authorization code", "err", err)
+		if err == myerrors.ErrInvalidGrant {
+			response.RespondOAuthError(ctx, fasthttp.StatusBadRequest, "invalid_grant", "authorization code is invalid or expired")
+			return
+		}
+		response.RespondOAuthError(ctx, fasthttp.StatusInternalServerError, "server_error", "")
+		return
+	}
+	scopes := strings.Fields(grant.Scope)
+	user, err := h.uc.GetUser(grant.IIN, scopes)
+	if err != nil {
+		h.grantFailed(ctx, err)
+		return
+	}
+	sessionID, err := newTokenID()
+	if err != nil {
+		h.grantFailed(ctx, err)
+		return
+	}
+	access, refresh, err := signClientTokens(ctx, user, sessionID, client.ClientID, grant.Scope, h.uc.Policy().RefreshTtl)
+	if err != nil {
+		h.grantFailed(ctx, err)
+		return
+	}
+	idToken, err := h.signIDToken(ctx, user, client.ClientID, grant.Nonce, scopes)
+	if err != nil {
+		h.grantFailed(ctx, err)
+		return
+	}
+	now := time.Now()
+	session := &domain.Session{
+		ID:        sessionID,
+		IIN:       user.IIN,
+		UserAgent: "OAuth: " + client.Name,
+		IP:        ctx.RemoteIP().String(),
+		CreatedAt: now,
+		LastUsed:  now,
+	}
+	if err := h.uc.CreateSession(session, refresh); err != nil {
+		h.grantFailed(ctx, err)
+		return
+	}
+	logger.Ctx(ctx).Security("Tokens issued to OAuth client", "client_id", client.ClientID, "iin", user.IIN)
+	middleware.SetAuditActor(ctx, user.IIN)
+	h.respondTokens(ctx, access, refresh, idToken, grant.Scope)
+}
+
+// refreshToken rotates refresh token of the client session keeping its scope
+func (h *OAuthHandler) refreshToken(ctx *fasthttp.RequestCtx, client *domain.OAuthClient) {
+	token := string(ctx.PostArgs().Peek("refresh_token"))
+	IIN, _, err := middleware.ParseToken(ctx, token, false)
+	if err != nil {
+		logger.Ctx(ctx).Error("Parse refresh token error", "err", err)
+		response.RespondOAuthError(ctx, fasthttp.StatusBadRequest, "invalid_grant", "refresh token is invalid or expired")
+		return
+	}
+	if middleware.ClientID(token) != client.ClientID {
+		logger.Ctx(ctx).Security("Refresh token presented by another client", "client_id", client.ClientID)
+		response.RespondOAuthError(ctx, fasthttp.StatusBadRequest, "invalid_grant", "refresh token is invalid or expired")
+		return
+	}
+	sessionID, err := middleware.SessionID(token)
+	if err != nil {
+		logger.Ctx(ctx).Error("Parse refresh token error", "err", err)
+		response.RespondOAuthError(ctx, fasthttp.StatusBadRequest, "invalid_grant", "refresh token is invalid or expired")
+		return
+	}
+	scopes := middleware.Scope(token)
+	user, err := h.uc.GetUser(IIN, scopes)
+	if err != nil {
+		h.grantFailed(ctx, err)
+		return
+	}
+	scope := strings.Join(scopes, " ")
+	access, refresh, err := signClientTokens(ctx, user, sessionID, client.ClientID, scope, h.uc.Policy().RefreshTtl)
+	if err != nil {
+		h.grantFailed(ctx, err)
+		return
+	}
+	idToken, err := h.signIDToken(ctx, user, client.ClientID, "", scopes)
+	if err != nil {
+		h.grantFailed(ctx, err)
+		return
+	}
+	if err := h.uc.RotateToken(IIN, sessionID, token, refresh); err != nil {
+		h.grantFailed(ctx, err)
+		return
+	}
+	middleware.SetAuditActor(ctx, IIN)
+	h.respondTokens(ctx, access, refresh, idToken, scope)
+}
+
+// serviceToken issues access token to service client acting on its own, there is no refresh token as
+// the client can always authenticate again
+func (h *OAuthHandler) serviceToken(ctx *fasthttp.RequestCtx, client *domain.OAuthClient) {
+	scopes, err := h.uc.AuthorizeService(client, string(ctx.PostArgs().Peek("scope")))
+	if err != nil {
+		logger.Ctx(ctx).Error("Client credentials grant", "err", err)
+		switch err {
+		case myerrors.ErrUnauthorizedClient:
+			response.RespondOAuthError(ctx, fasthttp.StatusBadRequest, "unauthorized_client", "client_credentials grant is allowed for service clients only")
+		case myerrors.ErrInvalidScope:
+			response.RespondOAuthError(ctx, fasthttp.StatusBadRequest, "invalid_scope", "")
+		default:
+			response.RespondOAuthError(ctx, fasthttp.StatusInternalServerError, "server_error", "")
+		}
+		return
+	}
+	keys, err := middleware.GetKeysFromCtx(ctx)
+	if err != nil {
+		h.grantFailed(ctx, err)
+		return
+	}
+	jti, err := newTokenID()
+	if err != nil {
+		h.grantFailed(ctx, err)
+		return
+	}
+	ttl := h.uc.Policy().ServiceTokenTtl
+	scope := strings.Join(scopes, " ")
+	now := time.Now()
+	access, err := keys.Sign(jwt.MapClaims{
+		"sub":   client.ClientID,
+		"cid":   client.ClientID,
+		"scope": scope,
+		"iat":   now.Unix(),
+		"exp":   now.Add(ttl).Unix(),
+		"jti":   jti,
+		"typ":   "service",
+	})
+	if err != nil {
+		h.grantFailed(ctx, err)
+		return
+	}
+	logger.Ctx(ctx).Security("Service token issued to OAuth client", "client_id", client.ClientID, "scope", scope)
+	response.ResponseOAuthTokens(ctx, access, "", "", ttl, scope)
+}
+
+// grantFailed answers invalid_grant if the user has to authorize the client again
+func (h *OAuthHandler) grantFailed(ctx *fasthttp.RequestCtx, err error) {
+	logger.Ctx(ctx).Error("OAuth grant failed", "err", err)
+	if isUnauthorized(err) {
+		response.RespondOAuthError(ctx, fasthttp.StatusBadRequest, "invalid_grant", "grant is invalid or revoked")
+		return
+	}
+	response.RespondOAuthError(ctx, fasthttp.StatusInternalServerError, "server_error", "")
+}
+
+func (h *OAuthHandler) respondTokens(ctx *fasthttp.RequestCtx, access, refresh, idToken, scope string) {
+	policy, err := middleware.GetPolicyFromCtx(ctx)
+	if err != nil {
+		h.grantFailed(ctx, err)
+		return
+	}
+	response.ResponseOAuthTokens(ctx, access, refresh, idToken, policy.AccessTtl, scope)
+}
+
+// signIDToken signs OpenID Connect ID token for the client, only if it was granted openid scope
+func (h *OAuthHandler) signIDToken(ctx *fasthttp.RequestCtx, user *domain.User, clientID, nonce string, scopes []string) (string, error) {
+	if !hasScope(scopes, domain.ScopeOpenID) {
+		return "", nil
+	}
+	keys, err := middleware.GetKeysFromCtx(ctx)
+	if err != nil {
+		return "", err
+	}
+	policy := h.uc.Policy()
+	now := time.Now()
+	claims := userInfoClaims(user, scopes)
+	claims["iss"] = policy.Issuer
+	claims["aud"] = clientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(policy.IDTokenTtl).Unix()
+	claims["typ"] = "id"
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	return keys.Sign(claims)
+}
+
+// signClientTokens signs tokens of the client session. The client ID and scope are kept in both tokens,
+// user's permissions must already be limited to the scope
+func signClientTokens(ctx *fasthttp.RequestCtx, user *domain.User, sessionID, clientID, scope string, refreshTtl time.Duration) (string, string, error) {
+	access := jwt.MapClaims{
+		"iin":       user.IIN,
+		"username":  user.Username,
+		"createdAt": user.Ts,
+		"admin":     false,
+		"roles":     user.Roles,
+		"perms":     user.Permissions,
+		"cid":       clientID,
+		"scope":     scope,
+	}
+	refresh := jwt.MapClaims{
+		"iin":       user.IIN,
+		"username":  user.Username,
+		"createdAt": user.Ts,
+		"admin":     false,
+		"rem":       false,
+		"cid":       clientID,
+		"scope":     scope,
+	}
+	return signTokenPair(ctx, sessionID, access, refresh, refreshTtl)
+}
+
+// RegisterClient registers OAuth client, the secret is shown only in this response.
+// Service clients are registered with service flag and scope instead of redirect URIs
+func (h *OAuthHandler) RegisterClient(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("RegisterClient hit")
+	args := ctx.PostArgs()
+	var redirectURIs []string
+	for _, redirectURI := range args.PeekMulti("redirect_uri") {
+		redirectURIs = append(redirectURIs, string(redirectURI))
+	}
+	var client *domain.OAuthClient
+	var err error
+	if isChecked(args.Peek("service")) {
+		client, err = h.uc.RegisterServiceClient(string(args.Peek("name")), strings.Fields(string(args.Peek("scope"))))
+	} else {
+		client, err = h.uc.RegisterClient(string(args.Peek("name")), redirectURIs, strings.Fields(string(args.Peek("scope"))), isChecked(args.Peek("public")))
+	}
+	if err != nil {
+		logger.Ctx(ctx).Error("Couldn't register OAuth client", "err", err)
+		switch err {
+		case myerrors.ErrInvalidInput:
+			response.RespondWithError(ctx, fasthttp.StatusBadRequest, "name and redirect_uri or scope of service client are required")
+		case myerrors.ErrInvalidRedirect:
+			response.RespondWithError(ctx, fasthttp.StatusBadRequest, "redirect_uri must be an absolute https uri")
+		case myerrors.ErrInvalidScope:
+			response.RespondWithError(ctx, fasthttp.StatusBadRequest, "unknown scope")
+		default:
+			response.RespondInternalServerError(ctx)
+		}
+		return
+	}
+	logger.Ctx(ctx).Security("OAuth client registered", "client_id", client.ClientID, "iin", ctx.UserValue("iin"))
+	middleware.SetAuditTarget(ctx, client.ClientID)
+	ctx.SetStatusCode(fasthttp.StatusCreated)
+	ctx.SetContentType("application/json")
+	ctx.Response.Header.Set("Cache-Control", "no-store")
+	json.NewEncoder(ctx).Encode(client)
+}
+
+// ListClients lists registered OAuth clients
+func (h *OAuthHandler) ListClients(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("ListClients hit")
+	clients, err := h.uc.ListClients()
+	if err != nil {
+		logger.Ctx(ctx).Error("Couldn't list OAuth clients", "err", err)
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	ctx.SetContentType("application/json")
+	json.NewEncoder(ctx).Encode(clients)
+}
+
+// requireLogin sends browsers without a valid access token to log in first, coming back to the same request afterwards.
+// Expired access token is refreshed in place by CheckAuthMiddleware
+func requireLogin(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if access, err := middleware.ExtractToken(ctx, true); err == nil {
+			if _, _, err := middleware.ParseToken(ctx, access, true); err == nil {
+				next(ctx)
+				return
+			}
+		}
+		if _, err := middleware.ExtractToken(ctx, false); err == nil {
+			next(ctx)
+			return
+		}
+		ctx.Redirect("/login?next="+url.QueryEscape(string(ctx.RequestURI())), fasthttp.StatusSeeOther)
+	}
+}
+
+// NewOAuthHandler sets /oauth routes including introspection and revocation and /admin/oauth/clients, registering clients requires clients:write permission
+func NewOAuthHandler(r *fasthttprouter.Router, uc usecase.OAuthUsecase, t *template.Template) {
+	handler := &OAuthHandler{
+		uc: uc,
+		t:  t,
+	}
+	admin := func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return middleware.SecretMiddleware(middleware.CheckAuthMiddleware(middleware.RequirePermission(domain.PermClientsWrite, next)))
+	}
+	r.GET("/oauth/authorize", middleware.SecretMiddleware(requireLogin(middleware.CheckAuthMiddleware(handler.Authorize))))
+	r.POST("/oauth/authorize", middleware.SecretMiddleware(middleware.CheckAuthMiddleware(middleware.Audit(domain.AuditOAuthConsent, handler.Approve))))
+	r.POST("/oauth/token", middleware.SecretMiddleware(middleware.Audit(domain.AuditOAuthToken, middleware.RateLimit("token", handler.Token))))
+	r.POST("/oauth/introspect", middleware.SecretMiddleware(middleware.RateLimit("token", handler.Introspect)))
+	r.POST("/oauth/revoke", middleware.SecretMiddleware(middleware.Audit(domain.AuditOAuthRevoke, middleware.RateLimit("token", handler.Revoke))))
+	r.GET("/admin/oauth/clients", admin(handler.ListClients))
+	r.POST("/admin/oauth/clients", admin(middleware.Audit(domain.AuditOAuthClientRegister, handler.RegisterClient)))
+}
@@ -0,0 +1,433 @@
+package delivery
+
+import (
+	"auth/domain"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+const testVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+func testChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// authorizeQuery returns valid authorization request of the client with changes applied
+func authorizeQuery(clientID string, changes url.Values) url.Values {
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {"profile wallets:read"},
+		"state":                 {"xyz"},
+		"code_challenge":        {testChallenge(testVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+	for key, value := range changes {
+		query[key] = value
+	}
+	return query
+}
+
+func TestOAuthAuthorize(t *testing.T) {
+	r := getRoutes()
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	s := &fasthttp.Server{
+		Handler: r,
+	}
+
+	go s.Serve(ln) //nolint:errcheck
+	c := &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	access, refresh, err := GenerateTestTokens("910815450350")
+	if err != nil {
+		t.Fatal("Couldn't generate token", err)
+	}
+
+	var testTable = []struct {
+		name               string
+		method             string
+		cookies            map[string]string
+		query              url.Values
+		expectedStatusCode int
+		// expectedLocation is prefix of Location header, empty if there must be no redirect
+		expectedLocation string
+	}{
+		{"get not logged in", "GET", nil, authorizeQuery("shop", nil), fasthttp.StatusSeeOther, "http://test.com/login?next=%2Foauth%2Fauthorize%3F"},
+		{"get access refreshed", "GET", map[string]string{"refresh": refresh}, authorizeQuery("shop", nil), fasthttp.StatusOK, ""},
+		{"get consent", "GET", map[string]string{"access": access}, authorizeQuery("shop", nil), fasthttp.StatusOK, ""},
+		{"get default scope", "GET", map[string]string{"access": access}, authorizeQuery("app", url.Values{"scope": nil}), fasthttp.StatusOK, ""},
+		{"get unknown client", "GET", map[string]string{"access": access}, authorizeQuery("unknown", nil), fasthttp.StatusBadRequest, ""},
+		{"get wrong redirect", "GET", map[string]string{"access": access}, authorizeQuery("shop", url.Values{"redirect_uri": {"https://evil.example.com/cb"}}), fasthttp.StatusBadRequest, ""},
+		{"get sth wrong", "GET", map[string]string{"access": access}, authorizeQuery("sthwrong", nil), fasthttp.StatusInternalServerError, ""},
+		{"get no pkce", "GET", map[string]string{"access": access}, authorizeQuery("shop", url.Values{"code_challenge": nil}), fasthttp.StatusSeeOther, testRedirectURI + "?error=invalid_request"},
+		{"get plain pkce", "GET", map[string]string{"access": access}, authorizeQuery("shop", url.Values{"code_challenge_method": {"plain"}}), fasthttp.StatusSeeOther, testRedirectURI + "?error=invalid_request"},
+		{"get token response", "GET", map[string]string{"access": access}, authorizeQuery("shop", url.Values{"response_type": {"token"}}), fasthttp.StatusSeeOther, testRedirectURI + "?error=unsupported_response_type"},
+		{"get admin scope", "GET", map[string]string{"access": access}, authorizeQuery("shop", url.Values{"scope": {"users:write"}}), fasthttp.StatusSeeOther, testRedirectURI + "?error=invalid_scope"},
+		{"post deny", "POST", map[string]string{"access": access}, authorizeQuery("shop", url.Values{"decision": {"deny"}}), fasthttp.StatusSeeOther, testRedirectURI + "?error=access_denied&state=xyz"},
+		{"post approve", "POST", map[string]string{"access": access}, authorizeQuery("shop", url.Values{"decision": {"approve"}}), fasthttp.StatusSeeOther, testRedirectURI + "?code="},
+		{"post not logged in", "POST", nil, authorizeQuery("shop", url.Values{"decision": {"approve"}}), fasthttp.StatusSeeOther, "http://test.com/login"},
+	}
+	for _, tt := range testTable {
+		fmt.Println("Testing", tt.name, "******************************************************************************************")
+		req, res := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		req.Header.SetMethod(tt.method)
+		if tt.method == "GET" {
+			req.SetRequestURI(URI + "/oauth/authorize?" + tt.query.Encode())
+		} else {
+			req.SetRequestURI(URI + "/oauth/authorize")
+			req.Header.SetContentType("application/x-www-form-urlencoded")
+			req.SetBodyString(tt.query.Encode())
+		}
+		for key, value := range tt.cookies {
+			req.Header.SetCookie(key, value)
+		}
+		setTestCSRF(req)
+		if err := c.Do(req, res); err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode() != tt.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d: %s", tt.name, tt.expectedStatusCode, res.StatusCode(), res.Body())
+		}
+		location := string(res.Header.Peek("Location"))
+		if tt.expectedLocation == "" && location != "" {
+			t.Errorf("%s: unexpected redirect to %s", tt.name, location)
+		}
+		if !strings.HasPrefix(location, tt.expectedLocation) {
+			t.Errorf("%s: expected redirect to %s but got %s", tt.name, tt.expectedLocation, location)
+		}
+		if tt.name == "get consent" && !strings.Contains(string(res.Body()), "просмотр счетов и операций") {
+			t.Errorf("%s: requested scopes are not shown", tt.name)
+		}
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(res)
+	}
+}
+
+func TestOAuthToken(t *testing.T) {
+	r := getRoutes()
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	s := &fasthttp.Server{
+		Handler: r,
+	}
+
+	go s.Serve(ln) //nolint:errcheck
+	c := &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	access, _, err := GenerateTestTokens("910815450350")
+	if err != nil {
+		t.Fatal("Couldn't generate token", err)
+	}
+	do := func(method, path string, form url.Values, header map[string]string) *fasthttp.Response {
+		req, res := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseRequest(req)
+		req.Header.SetMethod(method)
+		req.SetRequestURI(URI + path)
+		req.Header.SetContentType("application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		for key, value := range header {
+			req.Header.Set(key, value)
+		}
+		req.SetBodyString(form.Encode())
+		setTestCSRF(req)
+		if err := c.Do(req, res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	// authorize returns code approved by the user
+	authorize := func(clientID string) string {
+		res := do("POST", "/oauth/authorize", authorizeQuery(clientID, url.Values{"decision": {"approve"}}), map[string]string{"Cookie": "access=" + access})
+		defer fasthttp.ReleaseResponse(res)
+		location, err := url.Parse(string(res.Header.Peek("Location")))
+		if err != nil || location.Query().Get("code") == "" {
+			t.Fatalf("expected code but got %d %s", res.StatusCode(), res.Header.Peek("Location"))
+		}
+		return location.Query().Get("code")
+	}
+	basic := map[string]string{"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte("shop:"+testClientSecret))}
+	exchange := func(code string) url.Values {
+		return url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {testRedirectURI}, "code_verifier": {testVerifier}}
+	}
+
+	code := authorize("shop")
+	var testTable = []struct {
+		name               string
+		form               url.Values
+		header             map[string]string
+		expectedStatusCode int
+		expectedError      string
+	}{
+		{"no client", exchange(code), nil, fasthttp.StatusUnauthorized, "invalid_client"},
+		{"wrong secret", exchange(code), map[string]string{"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte("shop:wrong"))}, fasthttp.StatusUnauthorized, "invalid_client"},
+		{"public client with secret", exchange(code), map[string]string{"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte("app:secret"))}, fasthttp.StatusUnauthorized, "invalid_client"},
+		{"unknown grant", url.Values{"grant_type": {"password"}}, basic, fasthttp.StatusBadRequest, "unsupported_grant_type"},
+		// failed exchange uses the code up
+		{"wrong verifier", url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {testRedirectURI}, "code_verifier": {strings.Repeat("a", 43)}}, basic, fasthttp.StatusBadRequest, "invalid_grant"},
+		{"used code", exchange(code), basic, fasthttp.StatusBadRequest, "invalid_grant"},
+		{"code of other client", exchange(authorize("app")), basic, fasthttp.StatusBadRequest, "invalid_grant"},
+		{"wrong redirect", url.Values{"grant_type": {"authorization_code"}, "code": {authorize("shop")}, "redirect_uri": {"https://shop.example.com/other"}, "code_verifier": {testVerifier}}, basic, fasthttp.StatusBadRequest, "invalid_grant"},
+		{"client secret in form", func() url.Values {
+			form := exchange(authorize("shop"))
+			form.Set("client_id", "shop")
+			form.Set("client_secret", testClientSecret)
+			return form
+		}(), nil, fasthttp.StatusOK, ""},
+		{"public client", func() url.Values {
+			form := exchange(authorize("app"))
+			form.Set("client_id", "app")
+			return form
+		}(), nil, fasthttp.StatusOK, ""},
+	}
+	for _, tt := range testTable {
+		fmt.Println("Testing", tt.name, "******************************************************************************************")
+		res := do("POST", "/oauth/token", tt.form, tt.header)
+		if res.StatusCode() != tt.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d: %s", tt.name, tt.expectedStatusCode, res.StatusCode(), res.Body())
+		}
+		var body domain.OAuthError
+		if err := json.Unmarshal(res.Body(), &body); err != nil || body.Error != tt.expectedError {
+			t.Errorf("%s: expected error %q but got %s", tt.name, tt.expectedError, res.Body())
+		}
+		fasthttp.ReleaseResponse(res)
+	}
+
+	res := do("POST", "/oauth/token", exchange(authorize("shop")), basic)
+	var tokens domain.TokenResponse
+	if err := json.Unmarshal(res.Body(), &tokens); err != nil || tokens.AccessToken == "" || tokens.RefreshToken == "" {
+		t.Fatalf("expected tokens but got %d %s", res.StatusCode(), res.Body())
+	}
+	fasthttp.ReleaseResponse(res)
+	if tokens.Scope != "profile wallets:read" {
+		t.Errorf("unexpected scope %q", tokens.Scope)
+	}
+	bearer := map[string]string{"Authorization": "Bearer " + tokens.AccessToken}
+
+	var routeTable = []struct {
+		name               string
+		method             string
+		path               string
+		form               url.Values
+		header             map[string]string
+		expectedStatusCode int
+	}{
+		{"client reads wallets", "GET", "/info", nil, bearer, fasthttp.StatusOK},
+		{"client can't write wallets", "POST", "/add", nil, bearer, fasthttp.StatusUnauthorized},
+		{"client can't change profile", "POST", "/profile/username", url.Values{"login": {"renamed"}}, bearer, fasthttp.StatusUnauthorized},
+		{"first party refresh", "POST", "/refresh", url.Values{"refresh_token": {tokens.RefreshToken}}, nil, fasthttp.StatusUnauthorized},
+		{"refresh by other client", "POST", "/oauth/token", url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}, "client_id": {"app"}}, nil, fasthttp.StatusBadRequest},
+		{"refresh", "POST", "/oauth/token", url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}}, basic, fasthttp.StatusOK},
+	}
+	for _, tt := range routeTable {
+		fmt.Println("Testing", tt.name, "******************************************************************************************")
+		res := do(tt.method, tt.path, tt.form, tt.header)
+		if res.StatusCode() != tt.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d: %s", tt.name, tt.expectedStatusCode, res.StatusCode(), res.Body())
+		}
+		if tt.name == "refresh" {
+			var refreshed domain.TokenResponse
+			if err := json.Unmarshal(res.Body(), &refreshed); err != nil || refreshed.AccessToken == "" || refreshed.Scope != tokens.Scope {
+				t.Errorf("%s: expected tokens of the same scope but got %s", tt.name, res.Body())
+			}
+		}
+		fasthttp.ReleaseResponse(res)
+	}
+}
+
+func TestOAuthClientCredentials(t *testing.T) {
+	r := getRoutes()
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	s := &fasthttp.Server{
+		Handler: r,
+	}
+
+	go s.Serve(ln) //nolint:errcheck
+	c := &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	do := func(method, path string, form url.Values, header map[string]string) *fasthttp.Response {
+		req, res := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseRequest(req)
+		req.Header.SetMethod(method)
+		req.SetRequestURI(URI + path)
+		req.Header.SetContentType("application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		for key, value := range header {
+			req.Header.Set(key, value)
+		}
+		req.SetBodyString(form.Encode())
+		setTestCSRF(req)
+		if err := c.Do(req, res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	credentials := func(clientID string) map[string]string {
+		return map[string]string{"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte(clientID+":"+testClientSecret))}
+	}
+	grant := url.Values{"grant_type": {"client_credentials"}}
+
+	var testTable = []struct {
+		name               string
+		form               url.Values
+		header             map[string]string
+		expectedStatusCode int
+		expectedError      string
+	}{
+		{"user client", grant, credentials("shop"), fasthttp.StatusBadRequest, "unauthorized_client"},
+		{"wrong secret", grant, map[string]string{"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte("batch:wrong"))}, fasthttp.StatusUnauthorized, "invalid_client"},
+		{"scope not registered", url.Values{"grant_type": {"client_credentials"}, "scope": {"wallets:write"}}, credentials("batch"), fasthttp.StatusBadRequest, "invalid_scope"},
+		{"authorization code", url.Values{"grant_type": {"authorization_code"}, "code": {"code"}, "redirect_uri": {testRedirectURI}, "code_verifier": {testVerifier}}, credentials("batch"), fasthttp.StatusBadRequest, "invalid_grant"},
+	}
+	for _, tt := range testTable {
+		fmt.Println("Testing", tt.name, "******************************************************************************************")
+		res := do("POST", "/oauth/token", tt.form, tt.header)
+		if res.StatusCode() != tt.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d: %s", tt.name, tt.expectedStatusCode, res.StatusCode(), res.Body())
+		}
+		var body domain.OAuthError
+		if err := json.Unmarshal(res.Body(), &body); err != nil || body.Error != tt.expectedError {
+			t.Errorf("%s: expected error %q but got %s", tt.name, tt.expectedError, res.Body())
+		}
+		fasthttp.ReleaseResponse(res)
+	}
+
+	res := do("POST", "/oauth/token", grant, credentials("batch"))
+	var tokens domain.TokenResponse
+	if err := json.Unmarshal(res.Body(), &tokens); err != nil || tokens.AccessToken == "" {
+		t.Fatalf("expected token but got %d %s", res.StatusCode(), res.Body())
+	}
+	fasthttp.ReleaseResponse(res)
+	if tokens.RefreshToken != "" || tokens.Scope != domain.PermUsersRead || tokens.ExpiresIn != 300 {
+		t.Errorf("unexpected token response %+v", tokens)
+	}
+	bearer := map[string]string{"Authorization": "Bearer " + tokens.AccessToken}
+
+	var routeTable = []struct {
+		name               string
+		method             string
+		path               string
+		expectedStatusCode int
+	}{
+		{"service searches users", "GET", "/admin/users?q=910815450350", fasthttp.StatusOK},
+		{"service can't lock users", "POST", "/admin/user/lock", fasthttp.StatusUnauthorized},
+		{"service can't read user wallets", "GET", "/admin/user?iin=910815450350", fasthttp.StatusUnauthorized},
+		{"service has no wallets", "GET", "/info", fasthttp.StatusUnauthorized},
+		{"service has no profile", "GET", "/profile", fasthttp.StatusUnauthorized},
+		{"service has no userinfo", "GET", "/userinfo", fasthttp.StatusUnauthorized},
+	}
+	for _, tt := range routeTable {
+		fmt.Println("Testing", tt.name, "******************************************************************************************")
+		res := do(tt.method, tt.path, nil, bearer)
+		if res.StatusCode() != tt.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d: %s", tt.name, tt.expectedStatusCode, res.StatusCode(), res.Body())
+		}
+		fasthttp.ReleaseResponse(res)
+	}
+}
+
+var testTableOAuthClients = []struct {
+	name               string
+	method             string
+	admin              bool
+	body               url.Values
+	expectedStatusCode int
+}{
+	{"post-client", "POST", true, url.Values{"name": {"Shop"}, "redirect_uri": {testRedirectURI, "http://localhost:3000/cb"}, "scope": {"profile wallets:read"}}, fasthttp.StatusCreated},
+	{"post-client public", "POST", true, url.Values{"name": {"App"}, "redirect_uri": {"com.example.app:/cb"}, "public": {"on"}}, fasthttp.StatusCreated},
+	{"post-client service", "POST", true, url.Values{"name": {"Batch"}, "service": {"on"}, "scope": {"users:read"}}, fasthttp.StatusCreated},
+	{"post-client service no scope", "POST", true, url.Values{"name": {"Batch"}, "service": {"on"}}, fasthttp.StatusBadRequest},
+	{"post-client no name", "POST", true, url.Values{"redirect_uri": {testRedirectURI}}, fasthttp.StatusBadRequest},
+	{"post-client http", "POST", true, url.Values{"name": {"Shop"}, "redirect_uri": {"http://shop.example.com/cb"}}, fasthttp.StatusBadRequest},
+	{"post-client unknown scope", "POST", true, url.Values{"name": {"Shop"}, "redirect_uri": {testRedirectURI}, "scope": {"users:write"}}, fasthttp.StatusBadRequest},
+	{"post-client not admin", "POST", false, url.Values{"name": {"Shop"}, "redirect_uri": {testRedirectURI}}, fasthttp.StatusForbidden},
+	{"get-clients", "GET", true, nil, fasthttp.StatusOK},
+	{"get-clients not admin", "GET", false, nil, fasthttp.StatusForbidden},
+}
+
+func TestOAuthClients(t *testing.T) {
+	r := getRoutes()
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	s := &fasthttp.Server{
+		Handler: r,
+	}
+
+	go s.Serve(ln) //nolint:errcheck
+	c := &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+
+	for _, tt := range testTableOAuthClients {
+		fmt.Println("Testing", tt.name, "******************************************************************************************")
+		access, _, err := GenerateTestTokens("910815450350")
+		if tt.admin {
+			access, _, err = GenerateAdminTestTokens("admin")
+		}
+		if err != nil {
+			t.Fatal("Couldn't generate token", err)
+		}
+		req, res := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		req.Header.SetMethod(tt.method)
+		req.SetRequestURI(URI + "/admin/oauth/clients")
+		req.Header.SetCookie("access", access)
+		if tt.body != nil {
+			req.Header.SetContentType("application/x-www-form-urlencoded")
+			req.SetBodyString(tt.body.Encode())
+		}
+		setTestCSRF(req)
+		if err := c.Do(req, res); err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode() != tt.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d: %s", tt.name, tt.expectedStatusCode, res.StatusCode(), res.Body())
+		}
+		if tt.expectedStatusCode == fasthttp.StatusCreated {
+			var client domain.OAuthClient
+			if err := json.Unmarshal(res.Body(), &client); err != nil || client.ClientID == "" || (client.ClientSecret == "") != client.Public {
+				t.Errorf("%s: unexpected client %s", tt.name, res.Body())
+			}
+		}
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(res)
+	}
+}
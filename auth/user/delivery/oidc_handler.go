@@ -0,0 +1,123 @@
+package delivery
+
+import (
+	"auth/domain"
+	"auth/logger"
+	"auth/user/delivery/middleware"
+	"auth/user/delivery/response"
+	"auth/user/usecase"
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/buaazp/fasthttprouter"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/valyala/fasthttp"
+)
+
+type OIDCHandler struct {
+	uc usecase.OAuthUsecase
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// userInfoClaims are claims about the user released for the scopes, shared by ID tokens and /userinfo.
+// sub is the user ID, which unlike the username never changes
+func userInfoClaims(user *domain.User, scopes []string) jwt.MapClaims {
+	claims := jwt.MapClaims{"sub": strconv.Itoa(user.ID)}
+	if hasScope(scopes, domain.ScopeProfile) {
+		claims["preferred_username"] = user.Username
+		claims["iin"] = user.IIN
+		claims["createdAt"] = user.Ts
+	}
+	return claims
+}
+
+// Discovery publishes OpenID Connect provider metadata, so client libraries configure themselves from the issuer
+func (h *OIDCHandler) Discovery(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("Discovery hit")
+	keys, err := middleware.GetKeysFromCtx(ctx)
+	if err != nil {
+		logger.Ctx(ctx).Error("Discovery handler", "err", err)
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	issuer := h.uc.Policy().Issuer
+	scopes := make([]string, 0, len(domain.OAuthScopes))
+	for scope := range domain.OAuthScopes {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+	ctx.SetContentType("application/json")
+	ctx.Response.Header.Set("Cache-Control", "public, max-age=300")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	err = json.NewEncoder(ctx).Encode(domain.OpenIDConfiguration{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/oauth/authorize",
+		TokenEndpoint:                     issuer + "/oauth/token",
+		UserInfoEndpoint:                  issuer + "/userinfo",
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		IntrospectionEndpoint:             issuer + "/oauth/introspect",
+		RevocationEndpoint:                issuer + "/oauth/revoke",
+		ScopesSupported:                   scopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token", "client_credentials"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{keys.Alg()},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "nonce", "preferred_username", "iin", "createdAt"},
+	})
+	if err != nil {
+		logger.Ctx(ctx).Error("Discovery handler", "err", err)
+	}
+}
+
+// UserInfo returns claims about the user to a client holding access token with openid scope
+func (h *OIDCHandler) UserInfo(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("UserInfo hit")
+	access, _ := ctx.UserValue("access").(string)
+	scopes := middleware.Scope(access)
+	if !hasScope(scopes, domain.ScopeOpenID) {
+		logger.Ctx(ctx).Error("UserInfo requested without openid scope")
+		ctx.Response.Header.Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+		response.RespondOAuthError(ctx, fasthttp.StatusForbidden, "insufficient_scope", "openid scope is required")
+		return
+	}
+	IIN, ok := ctx.Value("iin").(string)
+	if !ok {
+		logger.Ctx(ctx).Error("IIN is nil")
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	user, err := h.uc.GetUser(IIN, scopes)
+	if err != nil {
+		logger.Ctx(ctx).Error("UserInfo", "err", err)
+		if isUnauthorized(err) {
+			ctx.Response.Header.Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			response.RespondOAuthError(ctx, fasthttp.StatusUnauthorized, "invalid_token", "")
+			return
+		}
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.Response.Header.Set("Cache-Control", "no-store")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	json.NewEncoder(ctx).Encode(userInfoClaims(user, scopes))
+}
+
+// NewOIDCHandler sets OpenID Connect discovery and /userinfo routes, the latter accepts only client tokens with openid scope
+func NewOIDCHandler(r *fasthttprouter.Router, uc usecase.OAuthUsecase) {
+	handler := &OIDCHandler{uc: uc}
+	r.GET("/.well-known/openid-configuration", middleware.SecretMiddleware(handler.Discovery))
+	r.GET("/userinfo", middleware.SecretMiddleware(middleware.CheckDelegatedAuthMiddleware(handler.UserInfo)))
+	r.POST("/userinfo", middleware.SecretMiddleware(middleware.CheckDelegatedAuthMiddleware(handler.UserInfo)))
+}
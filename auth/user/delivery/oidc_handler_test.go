@@ -0,0 +1,185 @@
+package delivery
+
+import (
+	"auth/domain"
+	"auth/signing"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestOIDCDiscovery(t *testing.T) {
+	r := getRoutes()
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	s := &fasthttp.Server{
+		Handler: r,
+	}
+
+	go s.Serve(ln) //nolint:errcheck
+	c := &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	req, res := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(res)
+	req.SetRequestURI(URI + "/.well-known/openid-configuration")
+	setTestCSRF(req)
+	if err := c.Do(req, res); err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("expected 200 but got %d", res.StatusCode())
+	}
+	var config domain.OpenIDConfiguration
+	if err := json.Unmarshal(res.Body(), &config); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := signing.Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Issuer != testOAuthPolicy.Issuer || config.JWKSURI != testOAuthPolicy.Issuer+"/.well-known/jwks.json" ||
+		config.UserInfoEndpoint != testOAuthPolicy.Issuer+"/userinfo" || config.TokenEndpoint != testOAuthPolicy.Issuer+"/oauth/token" {
+		t.Errorf("unexpected endpoints %s", res.Body())
+	}
+	if len(config.IDTokenSigningAlgValuesSupported) != 1 || config.IDTokenSigningAlgValuesSupported[0] != keys.Alg() {
+		t.Errorf("expected %s signing alg but got %v", keys.Alg(), config.IDTokenSigningAlgValuesSupported)
+	}
+	if !hasScope(config.ScopesSupported, domain.ScopeOpenID) {
+		t.Errorf("openid scope is not supported: %v", config.ScopesSupported)
+	}
+}
+
+func TestOIDCFlow(t *testing.T) {
+	r := getRoutes()
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	s := &fasthttp.Server{
+		Handler: r,
+	}
+
+	go s.Serve(ln) //nolint:errcheck
+	c := &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	access, _, err := GenerateTestTokens("910815450350")
+	if err != nil {
+		t.Fatal("Couldn't generate token", err)
+	}
+	do := func(method, path string, form url.Values, header map[string]string) *fasthttp.Response {
+		req, res := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseRequest(req)
+		req.Header.SetMethod(method)
+		req.SetRequestURI(URI + path)
+		req.Header.SetContentType("application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		for key, value := range header {
+			req.Header.Set(key, value)
+		}
+		req.SetBodyString(form.Encode())
+		setTestCSRF(req)
+		if err := c.Do(req, res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	basic := map[string]string{"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte("shop:"+testClientSecret))}
+	// tokens signs the user in to the shop for the scope
+	tokens := func(scope string) domain.TokenResponse {
+		res := do("POST", "/oauth/authorize", authorizeQuery("shop", url.Values{"decision": {"approve"}, "scope": {scope}, "nonce": {"n-0S6_WzA2Mj"}}), map[string]string{"Cookie": "access=" + access})
+		location, err := url.Parse(string(res.Header.Peek("Location")))
+		fasthttp.ReleaseResponse(res)
+		if err != nil || location.Query().Get("code") == "" {
+			t.Fatalf("expected code but got %s", location)
+		}
+		form := url.Values{"grant_type": {"authorization_code"}, "code": {location.Query().Get("code")}, "redirect_uri": {testRedirectURI}, "code_verifier": {testVerifier}}
+		res = do("POST", "/oauth/token", form, basic)
+		defer fasthttp.ReleaseResponse(res)
+		var tokens domain.TokenResponse
+		if err := json.Unmarshal(res.Body(), &tokens); err != nil || tokens.AccessToken == "" {
+			t.Fatalf("expected tokens but got %d %s", res.StatusCode(), res.Body())
+		}
+		return tokens
+	}
+	keys, err := signing.Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := strconv.Itoa(testUserID("910815450350"))
+
+	openid := tokens("openid profile")
+	token, err := jwt.Parse(openid.IDToken, keys.Keyfunc)
+	if err != nil || !token.Valid {
+		t.Fatalf("expected valid ID token but got %v", err)
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	expected := map[string]interface{}{"iss": testOAuthPolicy.Issuer, "aud": "shop", "sub": sub, "nonce": "n-0S6_WzA2Mj", "preferred_username": "910815450350", "iin": "910815450350"}
+	for key, value := range expected {
+		if claims[key] != value {
+			t.Errorf("ID token claim %s: expected %v but got %v", key, value, claims[key])
+		}
+	}
+	if tokens("wallets:read").IDToken != "" {
+		t.Error("ID token issued without openid scope")
+	}
+	first, _, err := GenerateTestTokens("910815450350")
+	if err != nil {
+		t.Fatal("Couldn't generate token", err)
+	}
+
+	var testTable = []struct {
+		name               string
+		method             string
+		header             map[string]string
+		expectedStatusCode int
+		expectedClaims     map[string]interface{}
+	}{
+		{"userinfo", "GET", map[string]string{"Authorization": "Bearer " + openid.AccessToken}, fasthttp.StatusOK, map[string]interface{}{"sub": sub, "preferred_username": "910815450350"}},
+		{"userinfo post", "POST", map[string]string{"Authorization": "Bearer " + openid.AccessToken}, fasthttp.StatusOK, map[string]interface{}{"sub": sub}},
+		{"userinfo without profile", "GET", map[string]string{"Authorization": "Bearer " + tokens("openid").AccessToken}, fasthttp.StatusOK, map[string]interface{}{"sub": sub, "preferred_username": nil}},
+		{"userinfo without openid", "GET", map[string]string{"Authorization": "Bearer " + tokens("profile").AccessToken}, fasthttp.StatusForbidden, nil},
+		{"userinfo first party token", "GET", map[string]string{"Authorization": "Bearer " + first}, fasthttp.StatusForbidden, nil},
+		{"userinfo ID token", "GET", map[string]string{"Authorization": "Bearer " + openid.IDToken}, fasthttp.StatusUnauthorized, nil},
+		{"userinfo no token", "GET", nil, fasthttp.StatusUnauthorized, nil},
+	}
+	for _, tt := range testTable {
+		fmt.Println("Testing", tt.name, "******************************************************************************************")
+		res := do(tt.method, "/userinfo", nil, tt.header)
+		if res.StatusCode() != tt.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d: %s", tt.name, tt.expectedStatusCode, res.StatusCode(), res.Body())
+		}
+		if tt.expectedClaims != nil {
+			var claims map[string]interface{}
+			if err := json.Unmarshal(res.Body(), &claims); err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			for key, value := range tt.expectedClaims {
+				if claims[key] != value {
+					t.Errorf("%s: claim %s: expected %v but got %v", tt.name, key, value, claims[key])
+				}
+			}
+		}
+		fasthttp.ReleaseResponse(res)
+	}
+}
@@ -0,0 +1,93 @@
+package delivery
+
+import (
+	"auth/domain"
+	"auth/logger"
+	"auth/myerrors"
+	"auth/user/delivery/middleware"
+	"auth/user/delivery/render"
+	"auth/user/delivery/response"
+	"auth/user/usecase"
+	"strings"
+	"text/template"
+
+	"github.com/buaazp/fasthttprouter"
+	"github.com/valyala/fasthttp"
+)
+
+type PasswordResetHandler struct {
+	uc      usecase.PasswordResetUsecase
+	tForgot *template.Template
+	tReset  *template.Template
+}
+
+// ForgotPage serves page asking for username to send reset link to
+func (h *PasswordResetHandler) ForgotPage(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("ForgotPage hit")
+	if err := render.RenderTemplate(ctx, fasthttp.StatusOK, h.tForgot, nil); err != nil {
+		logger.Ctx(ctx).Error("Executing template", "err", err)
+	}
+}
+
+// Forgot mails reset link. The answer is the same whether the account exists or not
+func (h *PasswordResetHandler) Forgot(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("Forgot hit")
+	username := strings.TrimSpace(string(ctx.FormValue("login")))
+	middleware.SetAuditTarget(ctx, username)
+	if username == "" {
+		response.RespondWithError(ctx, fasthttp.StatusBadRequest, "username not specified")
+		return
+	}
+	if err := h.uc.RequestReset(username); err != nil {
+		logger.Ctx(ctx).Error("Couldn't send reset link", "err", err)
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	response.ResponseJSON(ctx, "If the account exists and has an email, a reset link has been sent to it")
+}
+
+// ResetPage serves page setting new password with its requirements, token is read from the link by the page itself
+func (h *PasswordResetHandler) ResetPage(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("ResetPage hit")
+	// keep the token in the link from leaking to third party scripts through Referer
+	ctx.Response.Header.Set("Referrer-Policy", "no-referrer")
+	if err := render.RenderTemplate(ctx, fasthttp.StatusOK, h.tReset, h.uc.PasswordRules()); err != nil {
+		logger.Ctx(ctx).Error("Executing template", "err", err)
+	}
+}
+
+// Reset sets new password for a valid reset token and revokes all sessions of the user
+func (h *PasswordResetHandler) Reset(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("Reset hit")
+	token, password := string(ctx.FormValue("token")), string(ctx.FormValue("password"))
+	if token == "" {
+		response.RespondWithError(ctx, fasthttp.StatusBadRequest, "reset link is invalid or expired")
+		return
+	}
+	if err := h.uc.ResetPassword(token, password); err != nil {
+		logger.Ctx(ctx).Error("Couldn't reset password", "err", err)
+		if respondPasswordPolicy(ctx, err) {
+			return
+		}
+		if err == myerrors.ErrResetTokenInvalid || err == myerrors.ErrUserNotFound {
+			response.RespondWithError(ctx, fasthttp.StatusBadRequest, "reset link is invalid or expired")
+			return
+		}
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	response.ResponseJSON(ctx, "Password changed, please log in")
+}
+
+// NewPasswordResetHandler sets /password/forgot and /password/reset routes
+func NewPasswordResetHandler(r *fasthttprouter.Router, uc usecase.PasswordResetUsecase, tForgot, tReset *template.Template) {
+	handler := &PasswordResetHandler{
+		uc:      uc,
+		tForgot: tForgot,
+		tReset:  tReset,
+	}
+	r.GET("/password/forgot", handler.ForgotPage)
+	r.POST("/password/forgot", middleware.Audit(domain.AuditPasswordResetRequest, middleware.RateLimit("forgot", handler.Forgot)))
+	r.GET("/password/reset", handler.ResetPage)
+	r.POST("/password/reset", middleware.Audit(domain.AuditPasswordReset, middleware.RateLimit("forgot", handler.Reset)))
+}
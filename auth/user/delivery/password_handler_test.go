@@ -0,0 +1,115 @@
+package delivery
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+var resetLinkRe = regexp.MustCompile(`/password/reset\?token=([0-9a-f]+)`)
+
+func TestPasswordReset(t *testing.T) {
+	r := getRoutes()
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	s := &fasthttp.Server{
+		Handler: r,
+	}
+
+	go s.Serve(ln) //nolint:errcheck
+	c := &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	do := func(method, path string, form url.Values) *fasthttp.Response {
+		req, res := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseRequest(req)
+		req.Header.SetMethod(method)
+		req.SetRequestURI(URI + path)
+		req.Header.Set("Accept", "application/json")
+		if form != nil {
+			req.Header.SetContentType("application/x-www-form-urlencoded")
+			req.SetBodyString(form.Encode())
+		}
+		setTestCSRF(req)
+		if err := c.Do(req, res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	for _, path := range []string{"/password/forgot", "/password/reset?token=abc"} {
+		res := do("GET", path, nil)
+		if res.StatusCode() != fasthttp.StatusOK {
+			t.Errorf("%s: expected %d but got %d", path, fasthttp.StatusOK, res.StatusCode())
+		}
+		fasthttp.ReleaseResponse(res)
+	}
+
+	var testTableForgot = []struct {
+		name               string
+		login              string
+		expectedStatusCode int
+		mailTo             string
+	}{
+		{"no login", "", fasthttp.StatusBadRequest, ""},
+		{"unknown user", "unknown", fasthttp.StatusOK, ""},
+		{"no email", "noemail", fasthttp.StatusOK, ""},
+		{"first link", "resetme", fasthttp.StatusOK, "resetme@example.com"},
+		{"second link", "resetme", fasthttp.StatusOK, "resetme@example.com"},
+	}
+	var links []string
+	for _, tt := range testTableForgot {
+		fmt.Println("Testing", tt.name, "******************************************************************************************")
+		sent := len(mailer.sent)
+		res := do("POST", "/password/forgot", url.Values{"login": {tt.login}})
+		if res.StatusCode() != tt.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d", tt.name, tt.expectedStatusCode, res.StatusCode())
+		}
+		fasthttp.ReleaseResponse(res)
+		if tt.mailTo == "" {
+			if len(mailer.sent) != sent {
+				t.Errorf("%s: unexpected mail", tt.name)
+			}
+			continue
+		}
+		mail, ok := mailer.last(tt.mailTo)
+		match := resetLinkRe.FindStringSubmatch(mail.Body)
+		if !ok || match == nil {
+			t.Fatalf("%s: expected reset link but got %q", tt.name, mail.Body)
+		}
+		links = append(links, match[1])
+	}
+
+	var testTableReset = []struct {
+		name               string
+		token              string
+		password           string
+		expectedStatusCode int
+	}{
+		{"no token", "", "new-password1!", fasthttp.StatusBadRequest},
+		{"replaced token", links[0], "new-password1!", fasthttp.StatusBadRequest},
+		// invalid password doesn't spend the token
+		{"invalid password", links[1], "weak", fasthttp.StatusBadRequest},
+		{"reset", links[1], "new-password1!", fasthttp.StatusOK},
+		{"reused token", links[1], "new-password1!", fasthttp.StatusBadRequest},
+	}
+	for _, tt := range testTableReset {
+		fmt.Println("Testing", tt.name, "******************************************************************************************")
+		res := do("POST", "/password/reset", url.Values{"token": {tt.token}, "password": {tt.password}})
+		if res.StatusCode() != tt.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d: %s", tt.name, tt.expectedStatusCode, res.StatusCode(), res.Body())
+		}
+		fasthttp.ReleaseResponse(res)
+	}
+}
@@ -0,0 +1,151 @@
+package delivery
+
+import (
+	"auth/domain"
+	"auth/logger"
+	"auth/myerrors"
+	"auth/user/delivery/middleware"
+	"auth/user/delivery/render"
+	"auth/user/delivery/response"
+	"auth/user/usecase"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/buaazp/fasthttprouter"
+	"github.com/valyala/fasthttp"
+)
+
+type ProfileHandler struct {
+	uc       usecase.ProfileUsecase
+	attempts usecase.LoginAttemptsUsecase
+	t        *template.Template
+}
+
+// GetProfile shows username and email of the user, the page also lists password requirements
+func (h *ProfileHandler) GetProfile(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("GetProfile hit")
+	IIN, ok := ctx.Value("iin").(string)
+	if !ok {
+		logger.Ctx(ctx).Error("IIN is nil")
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	user, err := h.uc.GetUser(IIN)
+	if err != nil {
+		logger.Ctx(ctx).Error("Couldn't get user", "err", err)
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	user.Password = ""
+	if response.WantsJSON(ctx) {
+		json.NewEncoder(ctx).Encode(user)
+		return
+	}
+	page := struct {
+		*domain.User
+		PasswordRules []domain.PasswordRule
+	}{user, h.uc.PasswordRules()}
+	if err := render.RenderTemplate(ctx, fasthttp.StatusOK, h.t, page); err != nil {
+		logger.Ctx(ctx).Error("Executing template", "err", err)
+	}
+}
+
+// ChangePassword sets new password after checking the current one and signs out other sessions
+func (h *ProfileHandler) ChangePassword(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("ChangePassword hit")
+	IIN, ok := ctx.Value("iin").(string)
+	if !ok {
+		logger.Ctx(ctx).Error("IIN is nil")
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	current, password := string(ctx.FormValue("current_password")), string(ctx.FormValue("password"))
+	if password == current {
+		response.RespondWithError(ctx, fasthttp.StatusBadRequest, "new password must differ from the current one")
+		return
+	}
+	sessionID, ok := currentSessionID(ctx)
+	if !ok {
+		return
+	}
+	IP := ctx.RemoteIP().String()
+	if retryAfter, err := h.attempts.CheckPasswordAllowed(IIN, IP); err != nil {
+		logger.Ctx(ctx).Error("Password check not allowed", "err", err)
+		switch err {
+		case myerrors.ErrLoginLocked:
+			response.RespondTooManyRequests(ctx, retryAfter, fmt.Sprintf("password change is temporarily locked after too many failed attempts, try again in %v", retryAfter.Round(time.Second)))
+		case myerrors.ErrTooManyAttempts:
+			response.RespondTooManyRequests(ctx, retryAfter, "too many attempts, please wait and try again")
+		default:
+			response.RespondInternalServerError(ctx)
+		}
+		return
+	}
+	if err := h.uc.ChangePassword(IIN, current, password, sessionID); err != nil {
+		logger.Ctx(ctx).Error("Couldn't change password", "err", err)
+		if respondPasswordPolicy(ctx, err) {
+			return
+		}
+		if err == myerrors.ErrWrongPassword {
+			if err := h.attempts.PasswordCheckFailed(IIN, IP); err != nil {
+				logger.Ctx(ctx).Error("Couldn't count failed password check", "err", err)
+			}
+			response.RespondWithError(ctx, fasthttp.StatusBadRequest, "current password is incorrect")
+			return
+		}
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	if err := h.attempts.PasswordCheckSucceeded(IIN); err != nil {
+		logger.Ctx(ctx).Error("Couldn't reset failed password checks", "err", err)
+	}
+	response.ResponseJSON(ctx, "Password changed, other sessions were signed out")
+}
+
+// ChangeUsername renames the user and signs out other sessions
+func (h *ProfileHandler) ChangeUsername(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("ChangeUsername hit")
+	IIN, ok := ctx.Value("iin").(string)
+	if !ok {
+		logger.Ctx(ctx).Error("IIN is nil")
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	username := strings.Trim(string(ctx.FormValue("login")), " ")
+	if username == "" || !strIsPrint(username) {
+		response.RespondWithError(ctx, fasthttp.StatusBadRequest, "invalid username")
+		return
+	}
+	sessionID, ok := currentSessionID(ctx)
+	if !ok {
+		return
+	}
+	if err := h.uc.ChangeUsername(IIN, username, sessionID); err != nil {
+		logger.Ctx(ctx).Error("Couldn't change username", "err", err)
+		switch err {
+		case myerrors.ErrDuplicateUser:
+			response.RespondWithError(ctx, fasthttp.StatusBadRequest, "username already exists")
+		case myerrors.ErrUserNotFound:
+			response.RespondWithError(ctx, fasthttp.StatusNotFound, "user not found")
+		default:
+			response.RespondInternalServerError(ctx)
+		}
+		return
+	}
+	response.ResponseJSON(ctx, "Username changed, other sessions were signed out")
+}
+
+// NewProfileHandler sets /profile routes
+func NewProfileHandler(r *fasthttprouter.Router, uc usecase.ProfileUsecase, attempts usecase.LoginAttemptsUsecase, t *template.Template) {
+	handler := &ProfileHandler{
+		uc:       uc,
+		attempts: attempts,
+		t:        t,
+	}
+	r.GET("/profile", middleware.SecretMiddleware(middleware.CheckAuthMiddleware(handler.GetProfile)))
+	r.POST("/profile/password", middleware.SecretMiddleware(middleware.CheckAuthMiddleware(middleware.Audit(domain.AuditPasswordChange, middleware.RateLimit("login", handler.ChangePassword)))))
+	r.POST("/profile/username", middleware.SecretMiddleware(middleware.CheckAuthMiddleware(middleware.Audit(domain.AuditUsernameChange, handler.ChangeUsername))))
+}
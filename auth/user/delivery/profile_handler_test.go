@@ -0,0 +1,112 @@
+package delivery
+
+import (
+	"auth/domain"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+var testTableProfile = []struct {
+	name               string
+	url                string
+	method             string
+	IIN                string
+	body               url.Values
+	json               bool
+	expectedStatusCode int
+}{
+	{"get-profile", "/profile", "GET", "910815450350", nil, false, fasthttp.StatusOK},
+	{"get-profile json", "/profile", "GET", "910815450350", nil, true, fasthttp.StatusOK},
+	{"get-profile sth wrong", "/profile", "GET", "sthwrong", nil, false, fasthttp.StatusInternalServerError},
+	{"post-password", "/profile/password", "POST", "910815450350", url.Values{"current_password": {PASSWORD}, "password": {"new password 1"}}, true, fasthttp.StatusOK},
+	{"post-password wrong current", "/profile/password", "POST", "910815450350", url.Values{"current_password": {"wrong "}, "password": {"new password 1"}}, true, fasthttp.StatusBadRequest},
+	{"post-password invalid", "/profile/password", "POST", "910815450350", url.Values{"current_password": {PASSWORD}, "password": {"nospecial"}}, true, fasthttp.StatusBadRequest},
+	{"post-password same", "/profile/password", "POST", "910815450350", url.Values{"current_password": {PASSWORD}, "password": {PASSWORD}}, true, fasthttp.StatusBadRequest},
+	{"post-password no user", "/profile/password", "POST", "nonexistent", url.Values{"current_password": {PASSWORD}, "password": {"new password 1"}}, true, fasthttp.StatusInternalServerError},
+	{"post-username", "/profile/username", "POST", "910815450350", url.Values{"login": {"renamed"}}, true, fasthttp.StatusOK},
+	{"post-username exists", "/profile/username", "POST", "910815450350", url.Values{"login": {"exists"}}, true, fasthttp.StatusBadRequest},
+	{"post-username empty", "/profile/username", "POST", "910815450350", url.Values{"login": {"  "}}, true, fasthttp.StatusBadRequest},
+	{"post-username no user", "/profile/username", "POST", "nonexistent", url.Values{"login": {"renamed"}}, true, fasthttp.StatusNotFound},
+	{"unauthorized", "/profile/username", "POST", "", url.Values{"login": {"renamed"}}, true, fasthttp.StatusUnauthorized},
+	// token of user "nosid" has no session, other sessions can't be revoked without revoking the current one
+	{"post-password no session", "/profile/password", "POST", "nosid", url.Values{"current_password": {PASSWORD}, "password": {"new password 1"}}, true, fasthttp.StatusUnauthorized},
+	{"post-username no session", "/profile/username", "POST", "nosid", url.Values{"login": {"renamed"}}, true, fasthttp.StatusUnauthorized},
+	{"post-revokeOtherSessions no session", "/sessions/revoke-others", "POST", "nosid", nil, true, fasthttp.StatusUnauthorized},
+	// current password is locked like login after 3 failed checks, even when the next one is right
+	{"post-password wrong current 1", "/profile/password", "POST", "920101450350", url.Values{"current_password": {"wrong "}, "password": {"new password 1"}}, true, fasthttp.StatusBadRequest},
+	{"post-password wrong current 2", "/profile/password", "POST", "920101450350", url.Values{"current_password": {"wrong "}, "password": {"new password 1"}}, true, fasthttp.StatusBadRequest},
+	{"post-password wrong current 3", "/profile/password", "POST", "920101450350", url.Values{"current_password": {"wrong "}, "password": {"new password 1"}}, true, fasthttp.StatusBadRequest},
+	{"post-password locked", "/profile/password", "POST", "920101450350", url.Values{"current_password": {PASSWORD}, "password": {"new password 1"}}, true, fasthttp.StatusTooManyRequests},
+}
+
+func TestProfileHandlers(t *testing.T) {
+	r := getRoutes()
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	s := &fasthttp.Server{
+		Handler: r,
+	}
+
+	go s.Serve(ln) //nolint:errcheck
+	c := &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+
+	for _, tt := range testTableProfile {
+		fmt.Println("Testing", tt.name, "******************************************************************************************")
+		req, res := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		req.Header.SetMethod(tt.method)
+		req.SetRequestURI(URI + tt.url)
+		if tt.IIN != "" {
+			sessionID := tt.IIN
+			if tt.IIN == "nosid" {
+				sessionID = ""
+			}
+			access, _, err := GenerateSessionTestTokens(tt.IIN, sessionID)
+			if err != nil {
+				t.Fatal("Couldn't generate token", err)
+			}
+			req.Header.SetCookie("access", access)
+		}
+		if tt.json {
+			req.Header.Set("Accept", "application/json")
+		}
+		if tt.body != nil {
+			req.Header.SetContentType("application/x-www-form-urlencoded")
+			req.SetBodyString(tt.body.Encode())
+		}
+		setTestCSRF(req)
+		if err := c.Do(req, res); err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode() != tt.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d: %s", tt.name, tt.expectedStatusCode, res.StatusCode(), res.Body())
+		}
+		if tt.name == "post-password invalid" {
+			var body domain.Response
+			if err := json.Unmarshal(res.Body(), &body); err != nil || len(body.PasswordRules) != 2 {
+				t.Errorf("%s: expected failed digit and special rules but got %s", tt.name, res.Body())
+			}
+		}
+		if tt.name == "get-profile json" {
+			var user domain.User
+			if err := json.Unmarshal(res.Body(), &user); err != nil || user.IIN != tt.IIN || user.Password != "" {
+				t.Errorf("%s: unexpected profile %s", tt.name, res.Body())
+			}
+		}
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(res)
+	}
+}
@@ -1,9 +1,9 @@
 package render
 
 import (
+	"auth/logger"
 	"auth/myerrors"
 	"fmt"
-	"log"
 	"path/filepath"
 	"text/template"
 
@@ -12,21 +12,42 @@ import (
 
 var pathToTemplates = "./templates/"
 
-var functions = template.FuncMap{
+// Functions are available in all templates. csrfToken and csrfField are replaced with ones
+// returning CSRF token of the request on rendering
+var Functions = template.FuncMap{
 	"inc": func(i int) int {
 		return i + 1
 	},
+	"csrfToken": func() string {
+		return ""
+	},
+	"csrfField": func() string {
+		return ""
+	},
 }
 
-// RenderTemplate renders a template
+// RenderTemplate renders a template, passing CSRF token set by middleware.CSRF to it
 func RenderTemplate(ctx *fasthttp.RequestCtx, status int, t *template.Template, data interface{}) error {
 	if t == nil {
 		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
 		return myerrors.ErrNilTemplate
 	}
+	token, _ := ctx.UserValue("csrf").(string)
+	page, err := t.Clone()
+	if err != nil {
+		return err
+	}
+	page.Funcs(template.FuncMap{
+		"csrfToken": func() string {
+			return token
+		},
+		"csrfField": func() string {
+			return `<input type="hidden" name="csrf_token" value="` + template.HTMLEscapeString(token) + `">`
+		},
+	})
 	ctx.SetStatusCode(status)
 	ctx.Response.Header.SetContentType("text/html")
-	if err := t.Execute(ctx, data); err != nil {
+	if err := page.Execute(ctx, data); err != nil {
 		return err
 	}
 	return nil
@@ -34,7 +55,7 @@ func RenderTemplate(ctx *fasthttp.RequestCtx, status int, t *template.Template,
 
 // CreateTemplateCache creates a template cache as a map
 func CreateTemplateCache() (map[string]*template.Template, error) {
-	log.Println("INFO|CreateTemplateCache hit")
+	logger.Debug("CreateTemplateCache hit")
 	myCache := map[string]*template.Template{}
 
 	pages, err := filepath.Glob(fmt.Sprintf("%s*.page.html", pathToTemplates))
@@ -44,7 +65,7 @@ func CreateTemplateCache() (map[string]*template.Template, error) {
 
 	for _, page := range pages {
 		name := filepath.Base(page)
-		ts, err := template.New(name).Funcs(functions).ParseFiles(page)
+		ts, err := template.New(name).Funcs(Functions).ParseFiles(page)
 		if err != nil {
 			return myCache, err
 		}
@@ -54,7 +75,7 @@ func CreateTemplateCache() (map[string]*template.Template, error) {
 			return myCache, err
 		}
 		if matches == nil {
-			log.Println("couldnt find files")
+			logger.Warn("Couldn't find layout files")
 		}
 
 		if len(matches) > 0 {
@@ -65,6 +86,6 @@ func CreateTemplateCache() (map[string]*template.Template, error) {
 		}
 		myCache[name] = ts
 	}
-	log.Println("INFO|Template cache:", myCache)
+	logger.Info("Template cache created", "templates", len(myCache))
 	return myCache, nil
 }
@@ -1,7 +1,9 @@
 package render
 
 import (
+	"strings"
 	"testing"
+	"text/template"
 
 	"github.com/valyala/fasthttp"
 )
@@ -24,3 +26,25 @@ func TestCreateTemplateCache(t *testing.T) {
 		return
 	}
 }
+
+func TestRenderTemplateCSRF(t *testing.T) {
+	page := template.Must(template.New("page").Funcs(Functions).Parse(`<meta content="{{csrfToken}}">{{csrfField}}`))
+	ctx := &fasthttp.RequestCtx{}
+	ctx.SetUserValue("csrf", "token")
+	if err := RenderTemplate(ctx, fasthttp.StatusOK, page, nil); err != nil {
+		t.Fatalf("Error when rendering template, %v", err)
+	}
+	expected := `<meta content="token"><input type="hidden" name="csrf_token" value="token">`
+	if body := string(ctx.Response.Body()); body != expected {
+		t.Errorf("unexpected page %s. Expecting %s", body, expected)
+	}
+
+	// the token of one request does not stay in the shared template
+	var shared strings.Builder
+	if err := page.Execute(&shared, nil); err != nil {
+		t.Fatalf("Error when executing template, %v", err)
+	}
+	if strings.Contains(shared.String(), "token") {
+		t.Errorf("token left in shared template: %s", shared.String())
+	}
+}
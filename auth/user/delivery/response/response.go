@@ -2,7 +2,10 @@ package response
 
 import (
 	"auth/domain"
+	"bytes"
 	"encoding/json"
+	"strconv"
+	"time"
 
 	"github.com/valyala/fasthttp"
 )
@@ -47,3 +50,143 @@ func ResponseTransaction(ctx *fasthttp.RequestCtx, account string) { //ts []doma
 		},
 	)
 }
+
+func ResponseSessions(ctx *fasthttp.RequestCtx, sessions []domain.Session) {
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	json.NewEncoder(ctx).Encode(
+		domain.Response{
+			OK:       true,
+			Sessions: sessions,
+		},
+	)
+}
+
+// RespondTooManyRequests asks client to retry after the given duration
+func RespondTooManyRequests(ctx *fasthttp.RequestCtx, retryAfter time.Duration, message string) {
+	SetRetryAfter(ctx, retryAfter)
+	RespondWithError(ctx, fasthttp.StatusTooManyRequests, message)
+}
+
+// SetRetryAfter sets Retry-After header rounded up to seconds
+func SetRetryAfter(ctx *fasthttp.RequestCtx, retryAfter time.Duration) {
+	seconds := int((retryAfter + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	ctx.Response.Header.Set("Retry-After", strconv.Itoa(seconds))
+}
+
+// APIPrefix is path prefix of JSON API, its routes never answer with pages or redirects
+const APIPrefix = "/api/"
+
+// WantsJSON reports whether the client asked for a JSON response or called JSON API
+func WantsJSON(ctx *fasthttp.RequestCtx) bool {
+	return bytes.HasPrefix(ctx.Path(), []byte(APIPrefix)) || bytes.Contains(ctx.Request.Header.Peek("Accept"), []byte("application/json"))
+}
+
+// ResponseAPI returns resource of JSON API with the status, links in it are left unescaped
+func ResponseAPI(ctx *fasthttp.RequestCtx, status int, v interface{}) {
+	ctx.SetStatusCode(status)
+	ctx.SetContentType("application/json")
+	encoder := json.NewEncoder(ctx)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(v)
+}
+
+// ResponseTokens returns tokens in body for clients that don't use cookies
+// rules warn that password used to login doesn't meet the current policy
+func ResponseTokens(ctx *fasthttp.RequestCtx, access, refresh string, accessTtl time.Duration, rules []domain.PasswordRule) {
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.Response.Header.Set("Cache-Control", "no-store")
+	json.NewEncoder(ctx).Encode(
+		domain.TokenResponse{
+			AccessToken:   access,
+			RefreshToken:  refresh,
+			TokenType:     "Bearer",
+			ExpiresIn:     int(accessTtl.Seconds()),
+			PasswordRules: rules,
+		},
+	)
+}
+
+// ResponseMFARequired tells client to finish login with a two-factor code, token is sent only to API clients
+func ResponseMFARequired(ctx *fasthttp.RequestCtx, token string) {
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.Response.Header.Set("Cache-Control", "no-store")
+	json.NewEncoder(ctx).Encode(
+		domain.Response{
+			OK:          true,
+			Message:     "two-factor code required",
+			MFARequired: true,
+			MFAToken:    token,
+		},
+	)
+}
+
+// ResponseRecoveryCodes shows recovery codes once, right after two-factor authentication is enabled
+func ResponseRecoveryCodes(ctx *fasthttp.RequestCtx, codes []string) {
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.Response.Header.Set("Cache-Control", "no-store")
+	json.NewEncoder(ctx).Encode(
+		domain.Response{
+			OK:            true,
+			Message:       "two-factor authentication enabled",
+			RecoveryCodes: codes,
+		},
+	)
+}
+
+// ResponseLoggedIn confirms browser login, rules warn that the password doesn't meet the current policy
+func ResponseLoggedIn(ctx *fasthttp.RequestCtx, rules []domain.PasswordRule) {
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	json.NewEncoder(ctx).Encode(
+		domain.Response{
+			OK:            true,
+			Message:       "Success",
+			PasswordRules: rules,
+		},
+	)
+}
+
+// RespondPasswordRules rejects password, listing the policy rules it fails
+func RespondPasswordRules(ctx *fasthttp.RequestCtx, rules []domain.PasswordRule) {
+	ctx.SetStatusCode(fasthttp.StatusBadRequest)
+	json.NewEncoder(ctx).Encode(
+		domain.Response{
+			OK:            false,
+			Message:       "password doesn't meet the requirements",
+			PasswordRules: rules,
+		},
+	)
+}
+
+// ResponseOAuthTokens returns tokens issued to OAuth client with the granted scope, idToken is set for OpenID Connect clients
+func ResponseOAuthTokens(ctx *fasthttp.RequestCtx, access, refresh, idToken string, accessTtl time.Duration, scope string) {
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.Response.Header.Set("Cache-Control", "no-store")
+	json.NewEncoder(ctx).Encode(
+		domain.TokenResponse{
+			AccessToken:  access,
+			RefreshToken: refresh,
+			TokenType:    "Bearer",
+			ExpiresIn:    int(accessTtl.Seconds()),
+			Scope:        scope,
+			IDToken:      idToken,
+		},
+	)
+}
+
+// RespondOAuthError returns error of OAuth token endpoint, code is one of RFC 6749 error codes
+func RespondOAuthError(ctx *fasthttp.RequestCtx, status int, code, description string) {
+	ctx.SetStatusCode(status)
+	ctx.SetContentType("application/json")
+	ctx.Response.Header.Set("Cache-Control", "no-store")
+	json.NewEncoder(ctx).Encode(
+		domain.OAuthError{
+			Error:       code,
+			Description: description,
+		},
+	)
+}
@@ -0,0 +1,117 @@
+package delivery
+
+import (
+	"auth/domain"
+	"auth/logger"
+	"auth/myerrors"
+	"auth/user/delivery/middleware"
+	"auth/user/delivery/render"
+	"auth/user/delivery/response"
+	"auth/user/usecase"
+	"text/template"
+
+	"github.com/buaazp/fasthttprouter"
+	"github.com/valyala/fasthttp"
+)
+
+type SessionsHandler struct {
+	uc usecase.SessionsUsecase
+	t  *template.Template
+}
+
+// GetSessions lists active sessions of the user as a page or as JSON
+func (h *SessionsHandler) GetSessions(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("GetSessions hit")
+	user, ok := ctx.Value("user").(domain.User)
+	if !ok {
+		logger.Ctx(ctx).Error("User is nil")
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	sessionID, _ := ctx.Value("sid").(string)
+	sessions, err := h.uc.ListSessions(user.IIN, sessionID)
+	if err != nil {
+		logger.Ctx(ctx).Error("Couldn't list sessions", "err", err)
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	if response.WantsJSON(ctx) {
+		response.ResponseSessions(ctx, sessions)
+		return
+	}
+	if err := render.RenderTemplate(ctx, fasthttp.StatusOK, h.t, sessions); err != nil {
+		logger.Ctx(ctx).Error("Executing template", "err", err)
+	}
+}
+
+// RevokeSession revokes one of the user's sessions
+func (h *SessionsHandler) RevokeSession(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("RevokeSession hit")
+	user, ok := ctx.Value("user").(domain.User)
+	if !ok {
+		logger.Ctx(ctx).Error("User is nil")
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	sessionID := string(ctx.FormValue("id"))
+	middleware.SetAuditTarget(ctx, sessionID)
+	if sessionID == "" {
+		response.RespondWithError(ctx, fasthttp.StatusBadRequest, "session not specified")
+		return
+	}
+	if err := h.uc.RevokeSession(user.IIN, sessionID); err != nil {
+		logger.Ctx(ctx).Error("Couldn't revoke session", "err", err)
+		if err == myerrors.ErrSessionNotFound {
+			response.RespondWithError(ctx, fasthttp.StatusNotFound, "session not found")
+			return
+		}
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	response.ResponseJSON(ctx, "Session revoked")
+}
+
+// RevokeOtherSessions revokes all of the user's sessions except the current one
+func (h *SessionsHandler) RevokeOtherSessions(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("RevokeOtherSessions hit")
+	user, ok := ctx.Value("user").(domain.User)
+	if !ok {
+		logger.Ctx(ctx).Error("User is nil")
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	sessionID, ok := currentSessionID(ctx)
+	if !ok {
+		return
+	}
+	middleware.SetAuditTarget(ctx, "others")
+	if err := h.uc.RevokeOtherSessions(user.IIN, sessionID); err != nil {
+		logger.Ctx(ctx).Error("Couldn't revoke sessions", "err", err)
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	response.ResponseJSON(ctx, "All other sessions revoked")
+}
+
+// currentSessionID returns session of the access token. Tokens without one are rejected,
+// the current session couldn't be told from the others
+func currentSessionID(ctx *fasthttp.RequestCtx) (string, bool) {
+	sessionID, _ := ctx.Value("sid").(string)
+	if sessionID == "" {
+		logger.Ctx(ctx).Error("Access token has no session id")
+		response.RespondWithError(ctx, fasthttp.StatusUnauthorized, "token has no session, please log in again")
+		return "", false
+	}
+	return sessionID, true
+}
+
+// NewSessionsHandler sets /sessions routes
+func NewSessionsHandler(r *fasthttprouter.Router, uc usecase.SessionsUsecase, t *template.Template) {
+	handler := &SessionsHandler{
+		uc: uc,
+		t:  t,
+	}
+	r.GET("/sessions", middleware.SecretMiddleware(middleware.CheckAuthMiddleware(handler.GetSessions)))
+	r.POST("/sessions/revoke", middleware.SecretMiddleware(middleware.CheckAuthMiddleware(middleware.Audit(domain.AuditSessionRevoke, handler.RevokeSession))))
+	r.POST("/sessions/revoke-others", middleware.SecretMiddleware(middleware.CheckAuthMiddleware(middleware.Audit(domain.AuditSessionRevoke, handler.RevokeOtherSessions))))
+}
@@ -1,37 +1,74 @@
 package delivery
 
 import (
+	"auth/config"
 	"auth/domain"
 	"auth/myerrors"
+	"auth/passhash"
+	"auth/passpolicy"
+	authv1 "auth/proto/auth/v1"
+	"auth/signing"
+	"auth/totp"
+	"auth/user/delivery/middleware"
+	"auth/user/delivery/render"
 	"auth/user/repository"
+	"auth/user/repository/memory"
 	"auth/user/usecase"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"text/template"
 	"time"
 
 	"github.com/buaazp/fasthttprouter"
-	"github.com/dgrijalva/jwt-go"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/valyala/fasthttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
 )
 
 const (
-	ACCESS_SECRET   = "testingaccess"
-	REFRESH_SECRET  = "testingrefresh"
 	URI             = "http://test.com"
 	HASHED_PASSWORD = "$2a$10$YVWoFp84S4F7TkIkV2KhguNmQ4bkQRhN14fz.MeocFLOO7XBkLxH."
 	PASSWORD        = "password "
 )
 
-var refreshToken string
+// testLoginPolicy locks username after 3 failed logins without delays in between
+var testLoginPolicy = &config.LoginPolicy{
+	MaxFailures:   3,
+	MaxIPFailures: 100,
+	FreeAttempts:  100,
+	Window:        time.Minute,
+	Lockout:       time.Hour,
+}
+
+// users "mfa" and "pending" have enabled and not yet confirmed two-factor authentication
+const (
+	testMFAUserID     = 7
+	testPendingUserID = 8
+	testRecoveryCode  = "abcde-fghij"
+)
+
+var (
+	testMFASecret = []byte("12345678901234567890")
+	testCipher    *totp.Cipher
+)
 
 func TestMain(m *testing.M) {
-	os.Setenv("ACCESS_SECRET", ACCESS_SECRET)
-	os.Setenv("REFRESH_SECRET", REFRESH_SECRET)
+	os.Setenv("JWT_DEV_EPHEMERAL_KEY", "true")
+	var err error
+	if testCipher, err = totp.NewCipher(make([]byte, 32)); err != nil {
+		log.Fatal(err)
+	}
 	os.Exit(m.Run())
 }
 
@@ -50,27 +87,42 @@ func getRoutes() fasthttp.RequestHandler {
 	}
 	log.Println("DB, API, cache", dbConn, api, redis)
 	updateTokenusecase := usecase.NewUpdateTokenUsecase(redis, dbConn)
-	loginUsecase := usecase.NewLoginUsecase(redis, dbConn)
+	loginUsecase := usecase.NewLoginUsecase(redis, dbConn, testHasher, testPasswordPolicy)
+	loginAttemptsUsecase := usecase.NewLoginAttemptsUsecase(redis, testLoginPolicy)
 	addWalletUsecase := usecase.NewAddWalletUsecase(api)
 	getInfoUsecase := usecase.NewGetInfoUsecase(api, dbConn)
-	signupUsecase := usecase.NewSignupUsecase(dbConn)
+	signupUsecase := usecase.NewSignupUsecase(dbConn, testHasher, testPasswordPolicy)
 	topupUsecase := usecase.NewTopupUsecase(api)
 	transferUsecase := usecase.NewTransferUsecase(api)
 	topupPageUsecase := usecase.NewTopupPageUsecase(api)
 	transferPageUsecase := usecase.NewTransferPageUsecase(api)
 	getTransactionsUsecase := usecase.NewGetTransactionsUsecase(api)
+	sessionsUsecase := usecase.NewSessionsUsecase(redis)
+	logoutUsecase := usecase.NewLogoutUsecase(redis)
+	passwordResetUsecase := usecase.NewPasswordResetUsecase(redis, dbConn, mailer, testResetPolicy, testHasher, testPasswordPolicy)
+	adminUsecase := usecase.NewAdminUsecase(redis, dbConn, api, passwordResetUsecase)
+	mfaUsecase := usecase.NewMFAUsecase(dbConn, testCipher, "MyWallet")
+	profileUsecase := usecase.NewProfileUsecase(redis, dbConn, testHasher, testPasswordPolicy)
+	oauthUsecase := usecase.NewOAuthUsecase(redis, dbConn, testOAuthPolicy)
+	auditUsecase := usecase.NewAuditUsecase(auditLog)
+	middleware.SetDenylist(redis)
+	middleware.SetAuditLog(auditLog)
+	middleware.SetRefresher(NewSessionRefresher(updateTokenusecase))
 	tc, err := CreateTestTemplateCache()
 	if err != nil {
 		fmt.Println(err)
 		return nil
 	}
 	NewHomePageHandler(r, tc["home.page.html"])
-	NewLogoutHandler(r)
+	NewLogoutHandler(r, logoutUsecase)
 	NewGetUserInfoHandler(r, getInfoUsecase, tc["info.page.html"])
 	NewGetTransactionsHandler(r, getTransactionsUsecase, tc["transactions.page.html"])
 	NewLoginPageHandler(r, tc["login.page.html"])
-	NewLoginHandler(r, loginUsecase)
-	NewSignupPageHandler(r, tc["signup.page.html"])
+	NewLoginHandler(r, loginUsecase, loginAttemptsUsecase, mfaUsecase)
+	NewMFALoginPageHandler(r, tc["login.mfa.page.html"])
+	NewMFAHandler(r, mfaUsecase, tc["mfa.page.html"])
+	NewPasswordResetHandler(r, passwordResetUsecase, tc["password.forgot.page.html"], tc["password.reset.page.html"])
+	NewSignupPageHandler(r, tc["signup.page.html"], signupUsecase)
 	NewSignupHandler(r, signupUsecase)
 	NewTopupPageHandler(r, tc["topup.page.html"], topupPageUsecase)
 	NewTopupHandler(r, topupUsecase)
@@ -78,65 +130,130 @@ func getRoutes() fasthttp.RequestHandler {
 	NewTransferHandler(r, transferUsecase)
 	NewUpdateHandler(r, updateTokenusecase, tc["update.page.html"])
 	NewAddWalletHandler(r, addWalletUsecase)
-	return r.Handler
+	NewSessionsHandler(r, sessionsUsecase, tc["sessions.page.html"])
+	NewProfileHandler(r, profileUsecase, loginAttemptsUsecase, tc["profile.page.html"])
+	NewAdminHandler(r, adminUsecase, tc["admin.users.page.html"], tc["admin.user.page.html"])
+	NewAuditHandler(r, auditUsecase, tc["admin.audit.page.html"])
+	NewOAuthHandler(r, oauthUsecase, tc["oauth.consent.page.html"])
+	NewOIDCHandler(r, oauthUsecase)
+	NewJWKSHandler(r)
+	NewAPIHandler(r, getInfoUsecase, getTransactionsUsecase, topupUsecase, transferUsecase, addWalletUsecase)
+	return middleware.RequestLog(middleware.CSRF(r.Handler, CSRFExemptPaths...))
 }
 
-var pathToTemplates = "../../cmd/templates/"
+// getGRPCClient serves AuthService over in-memory connection, close stops the server
+func getGRPCClient() (authv1.AuthServiceClient, func()) {
+	dbConn, err := NewMySQLDBInterface()
+	if err != nil {
+		log.Fatalf("Db interface create error: %v", err)
+	}
+	api := NewWalletAPIInterface()
+	redis, err := NewRedisCacheInterface()
+	if err != nil {
+		log.Fatal(err)
+	}
+	middleware.SetDenylist(redis)
+	middleware.SetAuditLog(auditLog)
+	s := grpc.NewServer(grpc.UnaryInterceptor(middleware.UnaryRequestLog))
+	NewGRPCHandler(s,
+		usecase.NewLoginUsecase(redis, dbConn, testHasher, testPasswordPolicy),
+		usecase.NewLoginAttemptsUsecase(redis, testLoginPolicy),
+		usecase.NewMFAUsecase(dbConn, testCipher, "MyWallet"),
+		usecase.NewUpdateTokenUsecase(redis, dbConn),
+		usecase.NewGetInfoUsecase(api, dbConn),
+		usecase.NewSessionsUsecase(redis),
+		usecase.NewLogoutUsecase(redis),
+	)
+	lis := bufconn.Listen(1024 * 1024)
+	go s.Serve(lis) //nolint:errcheck
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return authv1.NewAuthServiceClient(conn), func() {
+		conn.Close()
+		s.Stop()
+	}
+}
 
-var functions = template.FuncMap{
-	"inc": func(i int) int {
-		return i + 1
-	},
+// testCSRFToken is sent by setTestCSRF as both cookie and header, passing middleware.CSRF
+const testCSRFToken = "dGVzdC1jc3JmLXRva2VuLWZvci1kZWxpdmVyeS10ZXM"
+
+func setTestCSRF(req *fasthttp.Request) {
+	req.Header.SetCookie(middleware.CSRFCookie, testCSRFToken)
+	req.Header.Set(middleware.CSRFHeader, testCSRFToken)
 }
 
+var pathToTemplates = "../../cmd/templates/"
+
+var functions = render.Functions
+
 func GenerateWrongToken() (string, string, error) {
-	accessTokenExp := time.Now().Add(20 * time.Second).Unix()
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	return signTestTokens(jwt.MapClaims{
 		"admin":     false,
-		"exp":       accessTokenExp,
+		"exp":       time.Now().Add(20 * time.Second).Unix(),
 		"iat":       1640024899,
 		"iin":       "910815450350",
 		"createdAt": "2021-12-31 19:36:36",
 	})
-
-	accessTokenString, err := accessToken.SignedString([]byte(ACCESS_SECRET))
-
-	if err != nil {
-		return "", "", err
-	}
-
-	refreshTokenString, err := accessToken.SignedString([]byte(REFRESH_SECRET))
-
-	if err != nil {
-		return "", "", err
-	}
-	refreshToken = refreshTokenString
-	return accessTokenString, refreshTokenString, nil
 }
 
 func GenerateTestTokens(IIN string) (string, string, error) {
-	accessTokenExp := time.Now().Add(20 * time.Second).Unix()
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	return GenerateSessionTestTokens(IIN, IIN)
+}
+
+func GenerateSessionTestTokens(IIN, sessionID string) (string, string, error) {
+	return signTestTokens(jwt.MapClaims{
 		"admin":     false,
-		"exp":       accessTokenExp,
+		"exp":       time.Now().Add(20 * time.Second).Unix(),
 		"iat":       1640024899,
 		"iin":       IIN,
 		"username":  "sth",
 		"createdAt": "2021-12-31 19:36:36",
+		"sid":       sessionID,
+		"roles":     []string{"user"},
+		"perms":     []string{domain.PermWalletsRead, domain.PermWalletsWrite},
 	})
+}
 
-	accessTokenString, err := accessToken.SignedString([]byte(ACCESS_SECRET))
+func GenerateAdminTestTokens(IIN string) (string, string, error) {
+	return signTestTokens(jwt.MapClaims{
+		"admin":     true,
+		"exp":       time.Now().Add(20 * time.Second).Unix(),
+		"iat":       1640024899,
+		"iin":       IIN,
+		"username":  "admin",
+		"createdAt": "2021-12-31 19:36:36",
+		"sid":       IIN,
+		"roles":     []string{"admin"},
+		"perms":     []string{domain.PermUsersRead, domain.PermUsersWrite, domain.PermClientsWrite, domain.PermAuditRead},
+	})
+}
 
+// signTestTokens signs claims as access and refresh tokens with the default key set
+func signTestTokens(claims jwt.MapClaims) (string, string, error) {
+	keys, err := signing.Default()
 	if err != nil {
 		return "", "", err
 	}
 
-	refreshTokenString, err := accessToken.SignedString([]byte(REFRESH_SECRET))
+	claims["jti"] = fmt.Sprintf("%v-jti", claims["iin"])
+	claims["typ"] = "access"
+	accessTokenString, err := keys.Sign(claims)
+	if err != nil {
+		return "", "", err
+	}
 
+	claims["typ"] = "refresh"
+	refreshTokenString, err := keys.Sign(claims)
 	if err != nil {
 		return "", "", err
 	}
-	refreshToken = refreshTokenString
 	return accessTokenString, refreshTokenString, nil
 }
 
@@ -178,10 +295,31 @@ func CreateTestTemplateCache() (map[string]*template.Template, error) {
 type testDB struct{}
 
 func (m *testDB) GetUser(username string) (*domain.User, error) {
-	return &domain.User{Password: HASHED_PASSWORD}, nil
+	if username == "unknown" {
+		return nil, myerrors.ErrUserNotFound
+	}
+	return &domain.User{ID: testUserID(username), IIN: username, Username: username, Password: HASHED_PASSWORD, Locked: username == "locked", Email: testEmail(username)}, nil
 }
 
-func (m *testDB) AddUser(IIN, username, password string) error {
+// testEmail returns email of test user, user "noemail" has none
+func testEmail(name string) string {
+	if name == "noemail" {
+		return ""
+	}
+	return name + "@example.com"
+}
+
+func testUserID(name string) int {
+	switch name {
+	case "mfa":
+		return testMFAUserID
+	case "pending":
+		return testPendingUserID
+	}
+	return 0
+}
+
+func (m *testDB) AddUser(IIN, username, password, email string) error {
 	if username == "exists" {
 		return myerrors.ErrDuplicateUser
 	}
@@ -198,22 +336,247 @@ func (m *testDB) GetUserByIIN(IIN string) (*domain.User, error) {
 	if IIN == "sthwrong" {
 		return nil, fmt.Errorf("Some other error")
 	}
-	return &domain.User{Password: HASHED_PASSWORD}, nil
+	return &domain.User{ID: testUserID(IIN), Username: IIN, Password: HASHED_PASSWORD, IIN: IIN, Locked: IIN == "locked", Email: testEmail(IIN)}, nil
+}
+
+func (m *testDB) GetUserRoles(userID int) ([]string, []string, error) {
+	return []string{"user"}, []string{domain.PermWalletsRead, domain.PermWalletsWrite}, nil
+}
+
+func (m *testDB) SearchUsers(query string, limit int) ([]domain.User, error) {
+	if query == "sthwrong" {
+		return nil, fmt.Errorf("Some other error")
+	}
+	if strings.HasPrefix(query, "<") {
+		// username made of markup is stored as it is
+		return []domain.User{{ID: 1, IIN: "910815450350", Username: query}}, nil
+	}
+	return []domain.User{{ID: 1, IIN: "910815450350", Username: "user"}}, nil
+}
+
+func (m *testDB) UpdatePassword(IIN, password string) error {
+	if IIN == "nonexistent" {
+		return myerrors.ErrUserNotFound
+	}
+	return nil
+}
+
+func (m *testDB) UpdateUsername(IIN, username string) error {
+	if username == "exists" {
+		return myerrors.ErrDuplicateUser
+	}
+	if IIN == "nonexistent" {
+		return myerrors.ErrUserNotFound
+	}
+	return nil
+}
+
+func (m *testDB) SetUserLocked(IIN string, locked bool) error {
+	if IIN == "nonexistent" {
+		return myerrors.ErrUserNotFound
+	}
+	return nil
+}
+
+func (m *testDB) RecordAdminAction(action *domain.AdminAction) error {
+	if action.TargetIIN == "recorderr" {
+		return fmt.Errorf("some err")
+	}
+	return nil
+}
+
+func (m *testDB) ListAdminActions(targetIIN string, limit int) ([]domain.AdminAction, error) {
+	return []domain.AdminAction{{ID: 1, AdminIIN: "0", Action: domain.AdminActionLock, TargetIIN: targetIIN}}, nil
+}
+
+func (m *testDB) GetMFA(userID int) (*domain.MFA, error) {
+	if userID != testMFAUserID && userID != testPendingUserID {
+		return nil, myerrors.ErrMFANotEnrolled
+	}
+	secret, err := testCipher.Encrypt(testMFASecret)
+	if err != nil {
+		return nil, err
+	}
+	return &domain.MFA{UserID: userID, Secret: secret, Enabled: userID == testMFAUserID}, nil
+}
+
+func (m *testDB) SaveMFA(userID int, secret []byte) error {
+	return nil
+}
+
+func (m *testDB) EnableMFA(userID int, step int64, codeHashes []string) error {
+	return nil
+}
+
+func (m *testDB) UseMFAStep(userID int, step int64) (bool, error) {
+	return true, nil
+}
+
+func (m *testDB) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	return codeHash == totp.HashRecoveryCode(testRecoveryCode), nil
+}
+
+func (m *testDB) DeleteMFA(userID int) error {
+	return nil
+}
+
+func (m *testDB) AddOAuthClient(client *domain.OAuthClient) error {
+	return nil
+}
+
+// OAuth clients "shop" with testClientSecret and public "app" redirect to testRedirectURI,
+// service client "batch" with testClientSecret may read users
+const (
+	testClientSecret = "shop-secret"
+	testRedirectURI  = "https://shop.example.com/cb"
+)
+
+func (m *testDB) GetOAuthClient(clientID string) (*domain.OAuthClient, error) {
+	client := &domain.OAuthClient{ClientID: clientID, Name: clientID, RedirectURIs: []string{testRedirectURI}, Scopes: []string{domain.ScopeOpenID, domain.ScopeProfile, domain.PermWalletsRead}}
+	switch clientID {
+	case "shop":
+		sum := sha256.Sum256([]byte(testClientSecret))
+		client.SecretHash = hex.EncodeToString(sum[:])
+	case "app":
+		client.Public = true
+	case "batch":
+		sum := sha256.Sum256([]byte(testClientSecret))
+		client.SecretHash = hex.EncodeToString(sum[:])
+		client.RedirectURIs = []string{}
+		client.Scopes = []string{domain.PermUsersRead}
+		client.Service = true
+	case "sthwrong":
+		return nil, fmt.Errorf("Some other error")
+	default:
+		return nil, myerrors.ErrClientNotFound
+	}
+	return client, nil
+}
+
+func (m *testDB) ListOAuthClients() ([]domain.OAuthClient, error) {
+	shop, _ := m.GetOAuthClient("shop")
+	app, _ := m.GetOAuthClient("app")
+	return []domain.OAuthClient{*shop, *app}, nil
 }
 
 func (m *testDB) Close() {}
 
+// testMailer keeps sent mail in memory
+type testMailer struct {
+	mu   sync.Mutex
+	sent []domain.Mail
+}
+
+func (m *testMailer) Send(mail *domain.Mail) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, *mail)
+	return nil
+}
+
+// last returns the latest mail sent to the address
+func (m *testMailer) last(to string) (domain.Mail, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.sent) - 1; i >= 0; i-- {
+		if m.sent[i].To == to {
+			return m.sent[i], true
+		}
+	}
+	return domain.Mail{}, false
+}
+
+var mailer = &testMailer{}
+
+// testAudit keeps audit events in memory, listing events of actor "sthwrong" fails
+type testAudit struct {
+	mu     sync.Mutex
+	events []domain.AuditEvent
+}
+
+func (a *testAudit) RecordEvent(event *domain.AuditEvent) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	event.ID = len(a.events) + 1
+	event.Ts = time.Now().Format("2006-01-02 15:04:05")
+	a.events = append(a.events, *event)
+	return nil
+}
+
+func (a *testAudit) ListEvents(filter *domain.AuditFilter) ([]domain.AuditEvent, int, error) {
+	if filter.ActorIIN == "sthwrong" {
+		return nil, 0, fmt.Errorf("db error")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var found []domain.AuditEvent
+	for i := len(a.events) - 1; i >= 0; i-- {
+		event := a.events[i]
+		if (filter.ActorIIN == "" || event.ActorIIN == filter.ActorIIN) && (filter.Action == "" || event.Action == filter.Action) &&
+			(filter.Target == "" || event.Target == filter.Target) && (filter.Outcome == "" || event.Outcome == filter.Outcome) {
+			found = append(found, event)
+		}
+	}
+	total := len(found)
+	if filter.Offset >= total {
+		return nil, total, nil
+	}
+	found = found[filter.Offset:]
+	if len(found) > filter.Limit {
+		found = found[:filter.Limit]
+	}
+	return found, total, nil
+}
+
+// last returns the latest event of the action
+func (a *testAudit) last(action string) (domain.AuditEvent, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := len(a.events) - 1; i >= 0; i-- {
+		if a.events[i].Action == action {
+			return a.events[i], true
+		}
+	}
+	return domain.AuditEvent{}, false
+}
+
+var auditLog = &testAudit{}
+
+var testOAuthPolicy = &config.OAuthPolicy{CodeTtl: time.Minute, RefreshTtl: time.Hour, IDTokenTtl: 5 * time.Minute, ServiceTokenTtl: 5 * time.Minute, Issuer: "https://id.example.com"}
+
+var testResetPolicy = &config.PasswordResetPolicy{TokenTtl: time.Minute, BaseURL: URI}
+
+// testPasswordPolicy checks shipped breached list, "password " of test users doesn't meet it because of missing digit
+var testPasswordPolicy = func() *passpolicy.Policy {
+	p, err := passpolicy.NewPolicy(&config.PasswordPolicy{MinLength: 8, MaxLength: 72, Classes: []string{passpolicy.RuleLower, passpolicy.RuleDigit, passpolicy.RuleSpecial},
+		BreachedList: "../../cmd/breached-passwords.txt"})
+	if err != nil {
+		panic(err)
+	}
+	return p
+}()
+
+// testHasher uses cheap argon2id parameters, bcrypt HASHED_PASSWORD of test users is upgraded on login
+var testHasher = passhash.NewHasher(&config.PasswordHashPolicy{Algorithm: config.HashArgon2id, BcryptCost: 10, Argon2Memory: 1024, Argon2Time: 1, Argon2Threads: 1})
+
 func NewMySQLDBInterface() (repository.DBInterface, error) {
 	return &testDB{}, nil
 }
 
 type testAPI struct{}
 
+// testAPI fails for IIN "wrong" and account "err", user "many" has 25 wallets and account KZT0000000099 has 3 transactions
 func (w *testAPI) GetTransactions(token, account string) ([]domain.Transaction, error) {
-	if account == "err" {
+	if account == "err" || account == "KZT9999999999" {
 		return nil, fmt.Errorf("some err")
 	}
-	return []domain.Transaction{}, nil
+	transactions := []domain.Transaction{}
+	if account == "KZT0000000099" {
+		for i := 1; i <= 3; i++ {
+			transactions = append(transactions, domain.Transaction{ID: i, Type: "topup", To: account, Amount: i * 100})
+		}
+	}
+	return transactions, nil
 }
 
 func (w *testAPI) GetWallets(IIN, token string) ([]domain.Wallet, error) {
@@ -221,6 +584,11 @@ func (w *testAPI) GetWallets(IIN, token string) ([]domain.Wallet, error) {
 	if IIN == "wrong" {
 		return nil, fmt.Errorf("some err")
 	}
+	if IIN == "many" {
+		for i := 1; i <= 25; i++ {
+			wallets = append(wallets, domain.Wallet{ID: i, AccountNo: fmt.Sprintf("KZT%010d", i), IIN: IIN})
+		}
+	}
 	return wallets, nil
 }
 
@@ -234,11 +602,17 @@ func (w *testAPI) GetWalletList(token string) ([]string, error) {
 }
 
 func (w *testAPI) TopUp(IIN, account, amount, token string) ([]byte, int, error) {
-	resp := domain.Response{OK: true}
+	resp := domain.Response{OK: true, Message: "100"}
+	if IIN == "rejected" {
+		resp = domain.Response{Message: "account not found"}
+	}
 	respBytes, err := json.Marshal(resp)
 	if err != nil {
 		return []byte{}, 0, err
 	}
+	if IIN == "rejected" {
+		return respBytes, fasthttp.StatusBadRequest, nil
+	}
 	if IIN == "err" {
 		return respBytes, 0, fmt.Errorf("some err")
 	}
@@ -263,22 +637,67 @@ func NewWalletAPIInterface() repository.APIInterface {
 	return &testAPI{}
 }
 
-type testCache struct{}
+// testCache fakes sessions and denylist, login attempts are counted in memory
+type testCache struct {
+	repository.CacheInterface
+	// started keeps IDs of sessions inserted by login, they are rotated for real
+	started sync.Map
+}
 
-func (r *testCache) InsertToken(IIN, token string, refreshTtl time.Duration) error {
-	if IIN == "inserterr" {
+func (r *testCache) InsertSession(session *domain.Session, token string, refreshTtl time.Duration) error {
+	if session.IIN == "inserterr" {
 		return fmt.Errorf("err")
 	}
+	// refresh token is kept for introspection
+	r.started.Store(session.ID, true)
+	return r.CacheInterface.InsertSession(session, token, refreshTtl)
+}
+
+func (r *testCache) GetSession(sessionID string) (*domain.Session, error) {
+	if sessionID == "missing" {
+		return nil, myerrors.ErrSessionNotFound
+	}
+	return &domain.Session{ID: sessionID, IIN: "910815450350"}, nil
+}
+
+func (r *testCache) ListSessions(IIN string) ([]domain.Session, error) {
+	if IIN == "wrong" {
+		return nil, fmt.Errorf("some err")
+	}
+	return []domain.Session{
+		{ID: IIN, IIN: IIN, UserAgent: "test", IP: "0.0.0.0"},
+		{ID: "other", IIN: IIN, UserAgent: "test", IP: "0.0.0.0"},
+	}, nil
+}
+
+func (r *testCache) RotateToken(IIN, sessionID, oldToken, newToken string, refreshTtl time.Duration) error {
+	if sessionID == "980124450084" {
+		return myerrors.ErrRefreshNotFound
+	}
+	if sessionID == "reused" {
+		return myerrors.ErrTokenReused
+	}
+	if _, ok := r.started.Load(sessionID); ok {
+		return r.CacheInterface.RotateToken(IIN, sessionID, oldToken, newToken, refreshTtl)
+	}
+	return nil
+}
+
+func (r *testCache) DeleteSession(IIN, sessionID string) error {
+	return r.CacheInterface.DeleteSession(IIN, sessionID)
+}
+
+func (r *testCache) DenyToken(jti string, ttl time.Duration) error {
 	return nil
 }
 
-func (r *testCache) FindToken(IIN, token string) (string, error) {
-	if IIN == "980124450084" {
-		return "", fmt.Errorf("Token not foumnd")
+func (r *testCache) IsTokenDenied(jti string) (bool, error) {
+	if jti == "denyerr-jti" {
+		return false, fmt.Errorf("some err")
 	}
-	return refreshToken, nil
+	return jti == "denied-jti", nil
 }
 
 func NewRedisCacheInterface() (repository.CacheInterface, error) {
-	return &testCache{}, nil
+	return &testCache{CacheInterface: memory.NewMemoryCacheInterface()}, nil
 }
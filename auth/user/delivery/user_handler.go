@@ -1,93 +1,173 @@
 package delivery
 
 import (
+	"auth/config"
 	"auth/domain"
+	"auth/logger"
 	"auth/myerrors"
+	"auth/passpolicy"
+	"auth/signing"
 	"auth/user/delivery/middleware"
 	"auth/user/delivery/render"
 	"auth/user/delivery/response"
 	"auth/user/usecase"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"net/mail"
 	"strconv"
 	"strings"
 	"text/template"
 	"time"
 
 	"github.com/buaazp/fasthttprouter"
-	"github.com/dgrijalva/jwt-go"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/valyala/fasthttp"
-	"golang.org/x/crypto/bcrypt"
 )
 
 const (
 	InternalServerErrorMessage = "что-то пошло не так, попробуйте позже"
 	NoAccount                  = "предоставьте номер счета"
+	UserNotFoundMessage        = "пользователь не найден"
 )
 
-// GenerateTokens generates access and refresh tokens and sets them as cookies and ctx.UserValue
-func GenerateTokens(ctx *fasthttp.RequestCtx, user *domain.User, makeRefresh bool) (string, string, error) {
-	log.Println("INFO|Starting to generate tokens")
-	var refreshTokenString string
-	accessSecret, refreshSecret, err := middleware.GetSecretFromCtx(ctx)
+// GenerateTokens generates access and refresh tokens of the given session and sets them as cookies and ctx.UserValue
+func GenerateTokens(ctx *fasthttp.RequestCtx, user *domain.User, sessionID string, rememberMe bool) (string, string, error) {
+	accessTokenString, refreshTokenString, err := signTokens(ctx, user, sessionID, rememberMe)
 	if err != nil {
 		return "", "", err
 	}
-	ctx.SetUserValue("accessSecret", accessSecret)
-	ctx.SetUserValue("refreshSecret", refreshSecret)
+	if err := setTokenCookies(ctx, user, accessTokenString, refreshTokenString, rememberMe); err != nil {
+		return "", "", err
+	}
+	return accessTokenString, refreshTokenString, nil
+}
 
-	accessTokenExp := time.Now().Add(20 * time.Second).Unix()
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+// signTokens signs access and refresh tokens without setting any cookies
+func signTokens(ctx *fasthttp.RequestCtx, user *domain.User, sessionID string, rememberMe bool) (string, string, error) {
+	keys, err := middleware.GetKeysFromCtx(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	policy, err := middleware.GetPolicyFromCtx(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	ctx.SetUserValue("keys", keys)
+	return signSessionTokens(keys, policy, user, sessionID, rememberMe)
+}
+
+// signSessionTokens signs access and refresh tokens of the given session of a user
+func signSessionTokens(keys *signing.KeySet, policy *config.TokenPolicy, user *domain.User, sessionID string, rememberMe bool) (string, string, error) {
+	access := jwt.MapClaims{
 		"iin":       user.IIN,
 		"username":  user.Username,
 		"createdAt": user.Ts,
 		"admin":     user.IsAdmin,
-		"exp":       accessTokenExp,
-	})
-
-	accessTokenString, err := accessToken.SignedString([]byte(accessSecret))
+		"roles":     user.Roles,
+		"perms":     user.Permissions,
+	}
+	refresh := jwt.MapClaims{
+		"iin":       user.IIN,
+		"username":  user.Username,
+		"createdAt": user.Ts,
+		"admin":     false,
+		"rem":       rememberMe,
+	}
+	return signTokenPairWith(keys, policy, sessionID, access, refresh, policy.RefreshTtlFor(rememberMe))
+}
 
+// signTokenPair adds expiration, session and token IDs to claims and signs them as access and refresh tokens
+func signTokenPair(ctx *fasthttp.RequestCtx, sessionID string, access, refresh jwt.MapClaims, refreshTtl time.Duration) (string, string, error) {
+	keys, err := middleware.GetKeysFromCtx(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	policy, err := middleware.GetPolicyFromCtx(ctx)
 	if err != nil {
 		return "", "", err
 	}
-	if makeRefresh {
-		refreshTokenExp := time.Now().Add(10 * time.Minute).Unix()
+	ctx.SetUserValue("keys", keys)
+	return signTokenPairWith(keys, policy, sessionID, access, refresh, refreshTtl)
+}
 
-		refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"iin":       user.IIN,
-			"username":  user.Username,
-			"createdAt": user.Ts,
-			"admin":     false,
-			"exp":       refreshTokenExp,
-		})
+// signTokenPairWith is signTokenPair with keys and policy given explicitly
+func signTokenPairWith(keys *signing.KeySet, policy *config.TokenPolicy, sessionID string, access, refresh jwt.MapClaims, refreshTtl time.Duration) (string, string, error) {
+	logger.Debug("Starting to generate tokens")
+	accessJti, err := newTokenID()
+	if err != nil {
+		return "", "", err
+	}
+	access["exp"] = time.Now().Add(policy.AccessTtl).Unix()
+	access["sid"] = sessionID
+	access["jti"] = accessJti
+	access["typ"] = "access"
+	accessTokenString, err := keys.Sign(access)
+	if err != nil {
+		return "", "", err
+	}
 
-		refreshTokenString, err = refreshToken.SignedString([]byte(refreshSecret))
-		if err != nil {
-			return "", "", err
-		}
+	jti, err := newTokenID()
+	if err != nil {
+		return "", "", err
+	}
+	refresh["exp"] = time.Now().Add(refreshTtl).Unix()
+	refresh["sid"] = sessionID
+	refresh["jti"] = jti
+	refresh["typ"] = "refresh"
+	refreshTokenString, err := keys.Sign(refresh)
+	if err != nil {
+		return "", "", err
+	}
+	return accessTokenString, refreshTokenString, nil
+}
 
-		refreshCookie := makeCookie("refresh", refreshTokenString, 3600)
-		ctx.Response.Header.SetCookie(refreshCookie)
+// setTokenCookies sets token cookies and ctx.UserValue. Refresh cookie outlives the browser session only in "remember me" mode
+func setTokenCookies(ctx *fasthttp.RequestCtx, user *domain.User, accessTokenString, refreshTokenString string, rememberMe bool) error {
+	policy, err := middleware.GetPolicyFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+	refreshAge := 0
+	if rememberMe {
+		refreshAge = int(policy.RefreshTtlFor(true).Seconds())
 	}
-	accessCookie := makeCookie("access", accessTokenString, 3600)
+	refreshCookie := makeCookie(policy, "refresh", refreshTokenString, refreshAge)
+	ctx.Response.Header.SetCookie(refreshCookie)
+	accessCookie := makeCookie(policy, "access", accessTokenString, int(policy.AccessTtl.Seconds()))
 	ctx.Response.Header.SetCookie(accessCookie)
-	IINCookie := makeCookie("iin", user.IIN, 25)
+	IINCookie := makeCookie(policy, "iin", user.IIN, int(policy.AccessTtl.Seconds()))
 	ctx.Response.Header.SetCookie(IINCookie)
 	ctx.SetUserValue("access", accessTokenString)
 
-	log.Printf("INFO|Generated cookies\nAccess:%s\nRefresh:%s", accessTokenString, refreshTokenString)
-	return accessTokenString, refreshTokenString, nil
+	logger.Ctx(ctx).Debug("Generated token cookies", "iin", user.IIN)
+	return nil
+}
+
+// newTokenID returns random hex string used as token and session ID
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
 }
 
-// makeCookie makes *fasthttp.Cookkies
-func makeCookie(key, value string, age int) *fasthttp.Cookie {
+// makeCookie makes *fasthttp.Cookkies following the cookie policy. Zero age makes a session cookie
+func makeCookie(policy *config.TokenPolicy, key, value string, age int) *fasthttp.Cookie {
 	authCookie := fasthttp.Cookie{}
 	authCookie.SetKey(key)
 	authCookie.SetValue(value)
 	authCookie.SetMaxAge(age)
 	authCookie.SetHTTPOnly(true)
-	authCookie.SetSameSite(fasthttp.CookieSameSiteNoneMode)
+	authCookie.SetSecure(policy.Cookie.Secure)
+	authCookie.SetDomain(policy.Cookie.Domain)
+	authCookie.SetPath(policy.Cookie.Path)
+	authCookie.SetSameSite(policy.Cookie.SameSite)
 	return &authCookie
 }
 
@@ -96,83 +176,219 @@ type UpdateHandler struct {
 	t        *template.Template
 }
 
+// rotateTokens validates refresh token against redis and issues a new token pair of the same session.
+// Errors that require the client to log in again are reported by isUnauthorized
+func (h *UpdateHandler) rotateTokens(ctx *fasthttp.RequestCtx, refreshToken string) (*domain.User, string, string, bool, error) {
+	keys, err := middleware.GetKeysFromCtx(ctx)
+	if err != nil {
+		return nil, "", "", false, err
+	}
+	policy, err := middleware.GetPolicyFromCtx(ctx)
+	if err != nil {
+		return nil, "", "", false, err
+	}
+	ctx.SetUserValue("keys", keys)
+	user, access, refresh, rememberMe, err := rotateSessionTokens(ctx, h.ucUpdate, keys, policy, refreshToken)
+	if err != nil {
+		return nil, "", "", false, err
+	}
+	middleware.SetAuditActor(ctx, user.IIN)
+	return user, access, refresh, rememberMe, nil
+}
+
+// rotateSessionTokens is rotateTokens with keys and policy given explicitly
+func rotateSessionTokens(ctx context.Context, ucUpdate usecase.UpdateTokenUsecase, keys *signing.KeySet, policy *config.TokenPolicy, refreshToken string) (*domain.User, string, string, bool, error) {
+	IIN, _, err := middleware.VerifyToken(keys, refreshToken, false)
+	if err != nil {
+		logger.Ctx(ctx).Error("Parse refresh token error", "err", err)
+		return nil, "", "", false, myerrors.ErrInvalidToken
+	}
+	logger.Ctx(ctx).Debug("Updatetoken: parsed refreshtoken")
+	if clientID := middleware.ClientID(refreshToken); clientID != "" {
+		// client tokens are refreshed only at /oauth/token, keeping their scope
+		logger.Ctx(ctx).Error("Refresh token of OAuth client used on first party route", "client_id", clientID)
+		return nil, "", "", false, myerrors.ErrInvalidToken
+	}
+	sessionID, err := middleware.SessionID(refreshToken)
+	if err != nil {
+		logger.Ctx(ctx).Error("Parse refresh token error", "err", err)
+		return nil, "", "", false, myerrors.ErrInvalidToken
+	}
+
+	user, err := ucUpdate.GetUser(IIN)
+	if err != nil {
+		logger.Ctx(ctx).Error("Couldn't get user to generate token", "err", err)
+		return nil, "", "", false, err
+	}
+	logger.Ctx(ctx).Debug("Generating new refresh token")
+	rememberMe := middleware.RememberMe(refreshToken)
+	access, refresh, err := signSessionTokens(keys, policy, user, sessionID, rememberMe)
+	if err != nil {
+		return nil, "", "", false, err
+	}
+
+	// validate and rotate token through Redis
+	if err := ucUpdate.RotateToken(IIN, sessionID, refreshToken, refresh, policy.RefreshTtlFor(rememberMe)); err != nil {
+		logger.Ctx(ctx).Error("Rotating refresh token failed", "err", err)
+		return nil, "", "", false, err
+	}
+	logger.Ctx(ctx).Debug("Updatetoken: validated and rotated refresh token on Redis")
+	return user, access, refresh, rememberMe, nil
+}
+
+// isUnauthorized reports whether err means the client gets no new tokens for the refresh token.
+// ErrTokenRotated keeps the session, the client is expected to go on with the tokens of the concurrent request
+func isUnauthorized(err error) bool {
+	switch err {
+	case myerrors.ErrInvalidToken, myerrors.ErrUserNotFound, myerrors.ErrRefreshNotFound, myerrors.ErrTokenReused, myerrors.ErrUserLocked, myerrors.ErrTokenRotated:
+		return true
+	}
+	return false
+}
+
 // UpdateToken handles update of access token
 func (h *UpdateHandler) UpdateToken(ctx *fasthttp.RequestCtx) {
-	fmt.Println("INFO|Update endpoint hit, getting refreshtoken")
-	// isAdmin, ok := ctx.Value("admin").(bool)
-	// if !ok {
-	// 	log.Println("ERROR|Failed to retrieve role from ctx")
-	// 	response.RespondInternalServerError(ctx)
-	// 	return
-	// }
+	logger.Ctx(ctx).Debug("Update endpoint hit")
 	refreshToken, err := middleware.ExtractToken(ctx, false)
 	var message string
 
 	if err != nil {
-		log.Println("ERROR|Updating token:", err)
+		logger.Ctx(ctx).Error("Updating token", "err", err)
+		middleware.SetAuditOutcome(ctx, domain.AuditFailure)
 		ctx.SetStatusCode(fasthttp.StatusSeeOther)
 		ctx.Response.Header.Add("Location", "/login")
 		return
 	}
-	log.Println("INFO|Updatetoken: extracted refreshtoken", refreshToken)
-	IIN, _, err := middleware.ParseToken(ctx, refreshToken, false)
-	if err != nil {
-		log.Printf("ERROR|Parse refresh token error: %v", err)
-		ctx.SetStatusCode(fasthttp.StatusSeeOther)
-		ctx.Response.Header.Add("Location", "/login")
-		return
-	}
-	log.Println("INFO|Updatetoken: parsed refreshtoken")
-
-	// validate token through Redis
-	ok := h.ucUpdate.FindToken(IIN, refreshToken)
-	if !ok {
-		log.Printf("ERROR|Getting refresh token failed")
-		ctx.SetStatusCode(fasthttp.StatusSeeOther)
-		ctx.Response.Header.Add("Location", "/login")
+	user, access, refresh, rememberMe, err := h.rotateTokens(ctx, refreshToken)
+	if err == myerrors.ErrTokenRotated {
+		// a concurrent request has just set new cookies, the browser goes back with them
+		middleware.SetAuditOutcome(ctx, domain.AuditFailure)
+		if next := localPath(string(ctx.QueryArgs().Peek("next"))); next != "" {
+			ctx.Redirect(next, fasthttp.StatusSeeOther)
+			return
+		}
+		render.RenderTemplate(ctx, fasthttp.StatusConflict, h.t, "session was just refreshed, please reload the page")
 		return
 	}
-	log.Println("INFO|Updatetoken:Found and validated refresh token on Redis")
-	user, err := h.ucUpdate.GetUser(IIN)
 	if err != nil {
-		log.Println("ERROR|Couldn't get user to generate token:", err)
-		if err == myerrors.ErrUserNotFound {
+		if isUnauthorized(err) {
+			middleware.SetAuditOutcome(ctx, domain.AuditFailure)
 			ctx.SetStatusCode(fasthttp.StatusSeeOther)
 			ctx.Response.Header.Add("Location", "/login")
 			return
 		}
-
-		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
 		message = "couldn't find token, please try login page"
 		render.RenderTemplate(ctx, fasthttp.StatusInternalServerError, h.t, message)
 		return
 	}
-	// if isAdmin {
-	// 	user.IIN = "0"
-	// 	user.IsAdmin = true
-	// }
-	fmt.Println("INFO|Generating new refresh token")
-	access, refresh, err := GenerateTokens(ctx, user, false)
-	if err != nil {
-		log.Println("ERROR|Parse refresh token error:", err)
-		ctx.SetStatusCode(fasthttp.StatusSeeOther)
-		ctx.Response.Header.Add("Location", "/login")
+	if err := setTokenCookies(ctx, user, access, refresh, rememberMe); err != nil {
+		logger.Ctx(ctx).Error("Couldn't set token cookies", "err", err)
+		response.RespondInternalServerError(ctx)
 		return
 	}
 
-	log.Println("INFO|Updated access")
+	logger.Ctx(ctx).Info("Updated access")
+	if next := localPath(string(ctx.QueryArgs().Peek("next"))); next != "" {
+		ctx.Redirect(next, fasthttp.StatusSeeOther)
+		return
+	}
 	message = fmt.Sprintf("access: %s\n\nrefresh: %s\n", access, refresh)
 
 	render.RenderTemplate(ctx, fasthttp.StatusOK, h.t, message)
 }
 
-// NewUpdateHandler sets /update route
+// RefreshSession rotates refresh token of a browser session and sets new token cookies,
+// letting middleware refresh expired access token in place. The refresh is audited apart from the request itself.
+// ErrTokenRotated is returned as it is, no tokens are issued for it
+func (h *UpdateHandler) RefreshSession(ctx *fasthttp.RequestCtx, refreshToken string) (string, error) {
+	event := &domain.AuditEvent{
+		Action:    domain.AuditTokenRefresh,
+		IP:        ctx.RemoteIP().String(),
+		UserAgent: string(ctx.UserAgent()),
+		Outcome:   domain.AuditSuccess,
+	}
+	defer middleware.RecordAudit(event)
+	user, access, refresh, rememberMe, err := h.rotateTokens(ctx, refreshToken)
+	if err == myerrors.ErrTokenRotated {
+		event.Outcome = domain.AuditFailure
+		return "", err
+	}
+	if err != nil {
+		if isUnauthorized(err) {
+			event.Outcome = domain.AuditFailure
+			return "", myerrors.ErrInvalidToken
+		}
+		event.Outcome = domain.AuditError
+		return "", err
+	}
+	event.ActorIIN = user.IIN
+	if err := setTokenCookies(ctx, user, access, refresh, rememberMe); err != nil {
+		return "", err
+	}
+	return access, nil
+}
+
+// NewSessionRefresher returns refresher of browser sessions for middleware.SetRefresher
+func NewSessionRefresher(ucUpdate usecase.UpdateTokenUsecase) middleware.TokenRefresher {
+	return &UpdateHandler{ucUpdate: ucUpdate}
+}
+
+// Refresh rotates refresh token passed in request body and returns new tokens as JSON
+func (h *UpdateHandler) Refresh(ctx *fasthttp.RequestCtx) {
+	logger.Ctx(ctx).Debug("Refresh hit")
+	refreshToken := extractBodyRefreshToken(ctx)
+	if refreshToken == "" {
+		response.RespondWithError(ctx, fasthttp.StatusBadRequest, "refresh_token is required")
+		return
+	}
+	_, access, refresh, _, err := h.rotateTokens(ctx, refreshToken)
+	if err != nil {
+		if isUnauthorized(err) {
+			response.RespondWithError(ctx, fasthttp.StatusUnauthorized, "invalid refresh token")
+			return
+		}
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	policy, err := middleware.GetPolicyFromCtx(ctx)
+	if err != nil {
+		logger.Ctx(ctx).Error("Refresh handler", "err", err)
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	response.ResponseTokens(ctx, access, refresh, policy.AccessTtl, nil)
+}
+
+// localPath returns path if it stays on this site, otherwise empty string. Used to come back after login
+func localPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return ""
+	}
+	return path
+}
+
+// extractBodyRefreshToken reads refresh_token from JSON or form body
+func extractBodyRefreshToken(ctx *fasthttp.RequestCtx) string {
+	if bytes.HasPrefix(ctx.Request.Header.ContentType(), []byte("application/json")) {
+		var body struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		if err := json.Unmarshal(ctx.PostBody(), &body); err != nil {
+			return ""
+		}
+		return body.RefreshToken
+	}
+	return string(ctx.FormValue("refresh_token"))
+}
+
+// NewUpdateHandler sets /update and /refresh routes
 func NewUpdateHandler(r *fasthttprouter.Router, ucUpdate usecase.UpdateTokenUsecase, t *template.Template) {
 	handler := &UpdateHandler{
 		ucUpdate: ucUpdate,
 		t:        t,
 	}
-	r.GET("/update", middleware.SecretMiddleware(handler.UpdateToken))
+	r.GET("/update", middleware.SecretMiddleware(middleware.Audit(domain.AuditTokenRefresh, handler.UpdateToken)))
+	r.POST("/refresh", middleware.SecretMiddleware(middleware.Audit(domain.AuditTokenRefresh, handler.Refresh)))
 }
 
 type AddWalletHandler struct {
@@ -181,19 +397,20 @@ type AddWalletHandler struct {
 
 // AddWallet handles creation of new wallets
 func (h *AddWalletHandler) AddWallet(ctx *fasthttp.RequestCtx) {
-	log.Println("INFO|AddWallet hit")
+	logger.Ctx(ctx).Debug("AddWallet hit")
 	token, ok := ctx.Value("access").(string)
 	if !ok {
-		log.Println("ERROR|Failed to retrieve token for add wallet handler")
+		logger.Ctx(ctx).Error("Failed to retrieve token for add wallet handler")
 		response.RespondInternalServerError(ctx)
 		return
 	}
 	account, err := h.uc.AddWallet(token)
 	if err != nil {
-		log.Println("ERROR|Couldn't add wallet", err)
+		logger.Ctx(ctx).Error("Couldn't add wallet", "err", err)
 		response.RespondInternalServerError(ctx)
 		return
 	}
+	middleware.SetAuditTarget(ctx, account)
 	response.ResponseJSON(ctx, "Created new account under "+account)
 }
 
@@ -202,7 +419,7 @@ func NewAddWalletHandler(r *fasthttprouter.Router, uc usecase.AddWalletUsecase)
 	handler := &AddWalletHandler{
 		uc: uc,
 	}
-	r.POST("/add", middleware.SecretMiddleware(middleware.CheckAuthMiddleware(handler.AddWallet)))
+	r.POST("/add", middleware.SecretMiddleware(middleware.CheckAuthMiddleware(middleware.Audit(domain.AuditWalletCreate, middleware.RateLimit("add", middleware.RequirePermission(domain.PermWalletsWrite, handler.AddWallet))))))
 }
 
 type LoginPageHandler struct {
@@ -211,10 +428,10 @@ type LoginPageHandler struct {
 
 // LoginPageHandler serves static login page
 func (h *LoginPageHandler) LoginPage(ctx *fasthttp.RequestCtx) {
-	log.Println("INFO|LoginPage hit")
+	logger.Ctx(ctx).Debug("LoginPage hit")
 	ctx.Response.Header.SetContentType("text/html")
 	if err := h.t.Execute(ctx, nil); err != nil {
-		log.Println("ERROR|LoginPage handler:", err)
+		logger.Ctx(ctx).Error("LoginPage handler", "err", err)
 		response.RespondInternalServerError(ctx)
 		return
 	}
@@ -229,55 +446,147 @@ func NewLoginPageHandler(r *fasthttprouter.Router, t *template.Template) {
 }
 
 type LoginHandler struct {
-	uc usecase.LoginUsecase
+	uc       usecase.LoginUsecase
+	attempts usecase.LoginAttemptsUsecase
+	mfa      usecase.MFAUsecase
 }
 
 // LogIn handles provided username and password
 func (h *LoginHandler) LogIn(ctx *fasthttp.RequestCtx) {
-	log.Println("INFO|LogIn hit")
+	logger.Ctx(ctx).Debug("LogIn hit")
 	username, password := extractCredential(ctx)
-	fmt.Println("IIN,login,pass:", username, password)
+	middleware.SetAuditTarget(ctx, username)
+	IP := ctx.RemoteIP().String()
+	if retryAfter, err := h.attempts.CheckAllowed(username, IP); err != nil {
+		logger.Ctx(ctx).Error("Login not allowed", "err", err)
+		switch err {
+		case myerrors.ErrLoginLocked:
+			response.RespondTooManyRequests(ctx, retryAfter, fmt.Sprintf("account is temporarily locked after too many failed attempts, try again in %v", retryAfter.Round(time.Second)))
+		case myerrors.ErrTooManyAttempts:
+			response.RespondTooManyRequests(ctx, retryAfter, "too many login attempts, please wait and try again")
+		default:
+			response.RespondInternalServerError(ctx)
+		}
+		return
+	}
 	user, err := h.uc.GetUser(username)
 	if err != nil {
+		logger.Ctx(ctx).Error("Couldn't find user", "err", err)
+		if err != myerrors.ErrUserNotFound {
+			response.RespondInternalServerError(ctx)
+			return
+		}
+		h.loginFailed(username, IP)
 		response.RespondWithError(ctx, fasthttp.StatusBadRequest, "invalid user, try again or sign up")
-		log.Println("ERROR|Couldn't find user", err)
 		return
 	}
-	log.Println("INFO|Succesfully retrieved user:", user)
-	hashedPassword := user.Password
+	logger.Ctx(ctx).Debug("Retrieved user", "iin", user.IIN)
 	ctx.SetUserValue("user", user)
-	fmt.Println("here's USER IN LOGIN:", user)
+	middleware.SetAuditActor(ctx, user.IIN)
 
-	if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)); err != nil {
-		log.Println("ERROR|Invalid password:", err)
+	if err := h.uc.CheckPassword(user, password); err != nil {
+		logger.Ctx(ctx).Error("Invalid password", "err", err)
+		h.loginFailed(username, IP)
 		response.RespondWithError(ctx, fasthttp.StatusUnauthorized, "invalid password")
 		return
 	}
-	if username == "admin" {
-		user.IsAdmin = true
+	if user.Locked {
+		logger.Ctx(ctx).Error("Login attempt to locked account", "iin", user.IIN)
+		response.RespondWithError(ctx, fasthttp.StatusForbidden, "account is locked, please contact support")
+		return
 	}
-	_, refresh, err := GenerateTokens(ctx, user, true)
+	rememberMe := isChecked(ctx.FormValue("remember"))
+	enabled, err := h.mfa.IsEnabled(user)
 	if err != nil {
-		log.Println("ERROR|Login handler:", err)
+		logger.Ctx(ctx).Error("Couldn't check two-factor authentication", "err", err)
 		response.RespondInternalServerError(ctx)
 		return
 	}
+	if enabled {
+		// failed attempts are reset only after the second step, otherwise the password would reset the code counter
+		h.requireMFA(ctx, user, rememberMe)
+		return
+	}
+	if err := h.attempts.LoginSucceeded(username); err != nil {
+		logger.Ctx(ctx).Error("Couldn't reset failed login attempts", "err", err)
+	}
+	h.startSession(ctx, user, rememberMe, h.uc.CheckPasswordPolicy(user, password))
+}
 
-	if err := h.uc.InsertToken(user.IIN, refresh, 10*time.Minute); err != nil {
-		log.Println("ERROR|Couldn't insert token to redis. Error:", err)
+// startSession creates session of the authenticated user and issues its tokens.
+// rules are failed by the password, so that the user is asked to change it
+func (h *LoginHandler) startSession(ctx *fasthttp.RequestCtx, user *domain.User, rememberMe bool, rules []domain.PasswordRule) {
+	sessionID, err := newTokenID()
+	if err != nil {
+		logger.Ctx(ctx).Error("Login handler", "err", err)
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	access, refresh, err := signTokens(ctx, user, sessionID, rememberMe)
+	if err != nil {
+		logger.Ctx(ctx).Error("Login handler", "err", err)
 		response.RespondInternalServerError(ctx)
 		return
 	}
-	log.Println("INFO|Successfully inserted refresh after login, redirecting to info")
-	response.ResponseJSON(ctx, "Success")
+	policy, err := middleware.GetPolicyFromCtx(ctx)
+	if err != nil {
+		logger.Ctx(ctx).Error("Login handler", "err", err)
+		response.RespondInternalServerError(ctx)
+		return
+	}
+
+	now := time.Now()
+	session := &domain.Session{
+		ID:        sessionID,
+		IIN:       user.IIN,
+		UserAgent: string(ctx.UserAgent()),
+		IP:        ctx.RemoteIP().String(),
+		CreatedAt: now,
+		LastUsed:  now,
+	}
+	if err := h.uc.CreateSession(session, refresh, policy.RefreshTtlFor(rememberMe)); err != nil {
+		logger.Ctx(ctx).Error("Couldn't insert token to redis", "err", err)
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	logger.Ctx(ctx).Info("Successfully inserted refresh after login")
+	if response.WantsJSON(ctx) {
+		response.ResponseTokens(ctx, access, refresh, policy.AccessTtl, rules)
+		return
+	}
+	if err := setTokenCookies(ctx, user, access, refresh, rememberMe); err != nil {
+		logger.Ctx(ctx).Error("Login handler", "err", err)
+		response.RespondInternalServerError(ctx)
+		return
+	}
+	response.ResponseLoggedIn(ctx, rules)
+}
+
+// loginFailed counts failed login attempt
+func (h *LoginHandler) loginFailed(username, IP string) {
+	if err := h.attempts.LoginFailed(username, IP); err != nil {
+		logger.Error("Couldn't count failed login attempt", "err", err)
+	}
 }
 
 // NewLoginHandler sets /login POST route
-func NewLoginHandler(r *fasthttprouter.Router, uc usecase.LoginUsecase) {
+func NewLoginHandler(r *fasthttprouter.Router, uc usecase.LoginUsecase, attempts usecase.LoginAttemptsUsecase, mfa usecase.MFAUsecase) {
 	handler := &LoginHandler{
-		uc: uc,
+		uc:       uc,
+		attempts: attempts,
+		mfa:      mfa,
+	}
+	r.POST("/login", middleware.SecretMiddleware(middleware.Audit(domain.AuditLogin, middleware.RateLimit("login", handler.LogIn))))
+	r.POST("/login/mfa", middleware.SecretMiddleware(middleware.Audit(domain.AuditLoginMFA, middleware.RateLimit("login", handler.LogInMFA))))
+}
+
+// isChecked reports whether a form checkbox value is set
+func isChecked(value []byte) bool {
+	switch string(value) {
+	case "on", "true", "1":
+		return true
 	}
-	r.POST("/login", middleware.SecretMiddleware(handler.LogIn))
+	return false
 }
 
 func extractCredential(ctx *fasthttp.RequestCtx) (login string, pass string) {
@@ -291,7 +600,6 @@ func extractToken(ctx *fasthttp.RequestCtx, isAccess bool) (token string, err er
 		token = string(ctx.Request.Header.Cookie("refresh"))
 	}
 
-	fmt.Println("cookie retrieving:", token)
 	if token == "" {
 		err = fmt.Errorf("Token cookie not found")
 		return
@@ -300,24 +608,26 @@ func extractToken(ctx *fasthttp.RequestCtx, isAccess bool) (token string, err er
 }
 
 type SignupPageHandler struct {
-	t *template.Template
+	t  *template.Template
+	uc usecase.SignupUsecase
 }
 
-// SignupPage serves static signup page
+// SignupPage serves signup page listing password requirements
 func (h *SignupPageHandler) SignupPage(ctx *fasthttp.RequestCtx) {
-	log.Println("INFO|SignupPage hit")
+	logger.Ctx(ctx).Debug("SignupPage hit")
 	ctx.Response.Header.SetContentType("text/html")
-	if err := h.t.Execute(ctx, nil); err != nil {
-		log.Println("ERROR|SignupPage:", err)
+	if err := h.t.Execute(ctx, h.uc.PasswordRules()); err != nil {
+		logger.Ctx(ctx).Error("SignupPage", "err", err)
 		response.RespondInternalServerError(ctx)
 		return
 	}
 }
 
 // NewLoginHandler sets /signup GET route
-func NewSignupPageHandler(r *fasthttprouter.Router, t *template.Template) {
+func NewSignupPageHandler(r *fasthttprouter.Router, t *template.Template, uc usecase.SignupUsecase) {
 	handler := &SignupPageHandler{
-		t: t,
+		t:  t,
+		uc: uc,
 	}
 	r.GET("/signup", middleware.SecretMiddleware(handler.SignupPage))
 }
@@ -328,38 +638,40 @@ type SignupHandler struct {
 
 // SignUp handles signup with IIN, username and password
 func (h *SignupHandler) SignUp(ctx *fasthttp.RequestCtx) {
-	log.Println("INFO|SignUp hit")
+	logger.Ctx(ctx).Debug("SignUp hit")
 	IIN, username, password := string(ctx.FormValue("iin")), string(ctx.FormValue("login")), string(ctx.FormValue("password"))
+	email := strings.TrimSpace(string(ctx.FormValue("email")))
+	middleware.SetAuditActor(ctx, IIN)
+	middleware.SetAuditTarget(ctx, strings.TrimSpace(username))
 
 	if !validateIIN(IIN) {
-		log.Println("ERROR|Coudln't validate IIN")
+		logger.Ctx(ctx).Error("Couldn't validate IIN")
 		response.RespondWithError(ctx, fasthttp.StatusBadRequest, "invalid IIN")
 		return
 	}
 	username = strings.Trim(username, " ")
-	if err := validateCreds(username, password); err != nil {
-		log.Println("ERROR|Coudln't validate creds")
-		response.RespondWithError(ctx, fasthttp.StatusBadRequest, "invalid username or password")
+	if username == "" || !strIsPrint(username) {
+		logger.Ctx(ctx).Error("Couldn't validate username")
+		response.RespondWithError(ctx, fasthttp.StatusBadRequest, "invalid username")
+		return
+	}
+	if email != "" && !validateEmail(email) {
+		logger.Ctx(ctx).Error("Couldn't validate email")
+		response.RespondWithError(ctx, fasthttp.StatusBadRequest, "invalid email")
 		return
 	}
 
 	user := domain.User{
 		IIN:      IIN,
 		Username: username,
+		Email:    email,
 	}
 
-	log.Println("INFO|Generating hash from password given as:", password)
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), 10)
-	if err != nil {
-		log.Println("ERROR|Signup handler:", err)
-		response.RespondInternalServerError(ctx)
-		return
-	}
-
-	user.Password = string(hash)
-
-	if err = h.uc.AddUser(user.IIN, user.Username, user.Password); err != nil {
-		log.Println("ERROR|Signup handler:", err)
+	if err := h.uc.AddUser(user.IIN, user.Username, password, user.Email); err != nil {
+		logger.Ctx(ctx).Error("Signup handler", "err", err)
+		if respondPasswordPolicy(ctx, err) {
+			return
+		}
 		if err == myerrors.ErrDuplicateUser {
 			response.RespondWithError(ctx, fasthttp.StatusBadRequest, "username / IIN already exist(s)")
 			return
@@ -370,29 +682,34 @@ func (h *SignupHandler) SignUp(ctx *fasthttp.RequestCtx) {
 	response.ResponseJSON(ctx, "Success, click ok to redirect to login page")
 }
 
-// validateCreds validates login and password
-func validateCreds(login, password string) error {
-	if !strIsPrint(login) {
-		return myerrors.ErrInvalidUsername
-	}
-	if !strIsPrint(password) || !containsSpecialChar(password) {
-		return myerrors.ErrInvalidPassword
+// respondPasswordPolicy responds with failed rules if err is rejection of password by the policy
+func respondPasswordPolicy(ctx *fasthttp.RequestCtx, err error) bool {
+	var policyErr *passpolicy.Error
+	if !errors.As(err, &policyErr) {
+		return false
 	}
-	return nil
+	response.RespondPasswordRules(ctx, policyErr.Rules)
+	return true
+}
+
+// validateEmail accepts bare address without display name
+func validateEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Address == email
 }
 
 // validateIIN validates IIN
 func validateIIN(s string) bool {
 	if len(s) != 12 {
-		log.Println("ERROR|Invalid IIN length")
+		logger.Error("Invalid IIN length")
 		return false
 	}
 	if _, err := strconv.Atoi(s); err != nil || s[0] == '-' || s[0] == '+' {
-		log.Println("ERROR|Non-numeric characters in IIN")
+		logger.Error("Non-numeric characters in IIN")
 		return false
 	}
 	if s[6] == '0' || s[6] > '6' {
-		log.Println("ERROR|Invalid 7th char in IIN")
+		logger.Error("Invalid 7th char in IIN")
 		return false
 	}
 	var mod int
@@ -400,7 +717,7 @@ func validateIIN(s string) bool {
 		mod = (3*int(s[0]-'0') + 4*int(s[1]-'0') + 5*int(s[2]-'0') + 6*int(s[3]-'0') + 7*int(s[4]-'0') + 8*int(s[5]-'0') + 9*int(s[6]-'0') + 10*int(s[7]-'0') + 11*int(s[8]-'0') + int(s[9]-'0') + 2*int(s[10]-'0')) % 11
 	}
 	if mod != int(s[11]-'0') {
-		log.Println("ERROR|Invalid last character in IIN")
+		logger.Error("Invalid last character in IIN")
 		return false
 	}
 	return true
@@ -416,21 +733,11 @@ func strIsPrint(s string) bool {
 	return true
 }
 
-// containsSpecialChar reports whether the string contains a special character
-func containsSpecialChar(s string) bool {
-	for _, char := range s {
-		if char < 48 || (char > 57 && char < 65) || (char > 90 && char < 97) || (char > 122 && char < 127) {
-			return true
-		}
-	}
-	return false
-}
-
 func NewSignupHandler(r *fasthttprouter.Router, uc usecase.SignupUsecase) {
 	handler := &SignupHandler{
 		uc: uc,
 	}
-	r.POST("/signup", middleware.SecretMiddleware(handler.SignUp))
+	r.POST("/signup", middleware.SecretMiddleware(middleware.Audit(domain.AuditSignup, middleware.RateLimit("signup", handler.SignUp))))
 }
 
 type GetUserInfoHandler struct {
@@ -440,32 +747,32 @@ type GetUserInfoHandler struct {
 
 // GetUser retrieves account and wallet information on requested user
 func (h *GetUserInfoHandler) GetUser(ctx *fasthttp.RequestCtx) {
-	log.Println("INFO|Get user hit")
+	logger.Ctx(ctx).Debug("Get user hit")
 	var info domain.Info
 	token, ok := ctx.Value("access").(string)
 	if !ok || token == "" {
-		log.Println("ERROR|Couldn't get token from ctx")
+		logger.Ctx(ctx).Error("Couldn't get token from ctx")
 		ctx.SetStatusCode(fasthttp.StatusSeeOther)
 		ctx.Response.Header.Add("Location", "/login")
 		return
 	}
 	u, ok := ctx.Value("user").(domain.User)
 	if !ok {
-		log.Println("ERROR|User is nil")
+		logger.Ctx(ctx).Error("User is nil")
 		render.RenderTemplate(ctx, fasthttp.StatusInternalServerError, h.t, info)
 		return
 	}
-	log.Printf("INFO|User with IIN %s sent request", u.IIN)
-	user, err := h.uc.GetUserInfo(u.Username)
+	logger.Ctx(ctx).Info("User sent request", "iin", u.IIN)
+	user, err := h.uc.GetUserInfo(u.IIN)
 	if err != nil {
-		log.Println("ERROR|Error getting user info from DB:", err)
+		logger.Ctx(ctx).Error("Error getting user info from DB", "err", err)
 		response.RespondInternalServerError(ctx)
 		render.RenderTemplate(ctx, fasthttp.StatusInternalServerError, h.t, info)
 		return
 	}
 	wallets, err := h.uc.GetWalletInfo(u.IIN, token)
 	if err != nil {
-		log.Println("ERROR|Error getting wallets from DB:", err)
+		logger.Ctx(ctx).Error("Error getting wallets from DB", "err", err)
 		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
 		render.RenderTemplate(ctx, fasthttp.StatusInternalServerError, h.t, info)
 		return
@@ -475,13 +782,13 @@ func (h *GetUserInfoHandler) GetUser(ctx *fasthttp.RequestCtx) {
 	render.RenderTemplate(ctx, fasthttp.StatusOK, h.t, info)
 }
 
-// NewGetInfoHandler sets /info route
+// NewGetInfoHandler sets /info route, also open to OAuth clients granted wallets:read
 func NewGetUserInfoHandler(r *fasthttprouter.Router, uc usecase.GetInfoUsecase, t *template.Template) {
 	handler := &GetUserInfoHandler{
 		uc: uc,
 		t:  t,
 	}
-	r.GET("/info", middleware.SecretMiddleware(middleware.CheckAuthMiddleware(handler.GetUser)))
+	r.GET("/info", middleware.SecretMiddleware(middleware.CheckDelegatedAuthMiddleware(middleware.RequirePermission(domain.PermWalletsRead, handler.GetUser))))
 }
 
 type GetTransactionsHandler struct {
@@ -490,58 +797,53 @@ type GetTransactionsHandler struct {
 }
 
 func (h *GetTransactionsHandler) GetTransactions(ctx *fasthttp.RequestCtx) {
-	log.Println("INFO|Get transactions hit")
+	logger.Ctx(ctx).Debug("Get transactions hit")
 	token, ok := ctx.Value("access").(string)
 	if !ok || token == "" {
-		log.Println("ERROR|Couldn't get token from ctx")
+		logger.Ctx(ctx).Error("Couldn't get token from ctx")
 		ctx.SetStatusCode(fasthttp.StatusSeeOther)
 		ctx.Response.Header.Add("Location", "/login")
 		return
 	}
 	account := string(ctx.FormValue("account"))
 	if account == "" {
-		log.Println("ERROR|GetTransactions handler: Couldn't get accountNo")
+		logger.Ctx(ctx).Error("GetTransactions handler: Couldn't get accountNo")
 		//response.RespondInternalServerError(ctx)
 		if err := render.RenderTemplate(ctx, fasthttp.StatusBadRequest, h.t, domain.Info{Error: NoAccount}); err != nil {
-			log.Println("ERROR|Executing template", err)
+			logger.Ctx(ctx).Error("Executing template", "err", err)
 		}
 		return
 	}
 
 	transactions, err := h.uc.GetTransactions(token, account)
 	if err != nil {
-		log.Println("ERROR|Error getting wallets", err)
+		logger.Ctx(ctx).Error("Error getting wallets", "err", err)
 		//response.RespondInternalServerError(ctx)
 		if err := render.RenderTemplate(ctx, fasthttp.StatusInternalServerError, h.t, domain.Info{Error: InternalServerErrorMessage}); err != nil {
-			log.Println("ERROR|Executing template", err)
+			logger.Ctx(ctx).Error("Executing template", "err", err)
 		}
 		return
 	}
 	if err := render.RenderTemplate(ctx, fasthttp.StatusOK, h.t, domain.Info{AccountNo: account, Transactions: transactions}); err != nil {
-		log.Println("ERROR|Executing template", err)
+		logger.Ctx(ctx).Error("Executing template", "err", err)
 	}
-	log.Println("INFO|Success")
+	logger.Ctx(ctx).Debug("Success")
 }
 
+// NewGetTransactionsHandler sets /transactions route, also open to OAuth clients granted wallets:read
 func NewGetTransactionsHandler(r *fasthttprouter.Router, uc usecase.GetTransactionsUsecase, t *template.Template) {
 	handler := &GetTransactionsHandler{
 		uc: uc,
 		t:  t,
 	}
-	r.GET("/transactions", middleware.SecretMiddleware(middleware.CheckAuthMiddleware(handler.GetTransactions)))
+	r.GET("/transactions", middleware.SecretMiddleware(middleware.CheckDelegatedAuthMiddleware(middleware.RequirePermission(domain.PermWalletsRead, handler.GetTransactions))))
 }
 
 func validAmt(s string) bool {
 	if num, err := strconv.Atoi(s); err != nil || num <= 0 {
-		fmt.Println("Invalid input")
+		logger.Debug("Invalid amount", "amount", s)
 		return false
 	}
-	// for _, char := range s {
-	// 	if char < '0' || char > '9' {
-	// 		log.Println("ERROR|Invalid input")
-	// 		return false
-	// 	}
-	// }
 	return true
 }
 
@@ -561,24 +863,24 @@ type TopupPageHandler struct {
 }
 
 func (h *TopupPageHandler) TopupPage(ctx *fasthttp.RequestCtx) {
-	log.Println("INFO|TopupPage hit")
+	logger.Ctx(ctx).Debug("TopupPage hit")
 	ctx.Response.Header.SetContentType("text/html")
-	token := string(ctx.Request.Header.Cookie("access"))
-	if token == "" {
-		log.Println("ERROR|Couldn't get token from header")
+	token, ok := ctx.Value("access").(string)
+	if !ok || token == "" {
+		logger.Ctx(ctx).Error("Couldn't get token from ctx")
 		response.RespondWithError(ctx, fasthttp.StatusBadRequest, "couldn't find token")
 		// redirect to login page?
 		return
 	}
 	walletList, err := h.uc.GetWallets(token)
 	if err != nil {
-		log.Println("ERROR|TopupPage handler:", err)
+		logger.Ctx(ctx).Error("TopupPage handler", "err", err)
 		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
 		walletList = append(walletList, "shutdown")
 		//return
 	}
 	if err := h.t.Execute(ctx, walletList); err != nil {
-		log.Println("ERROR|TopupPage handler:", err)
+		logger.Ctx(ctx).Error("TopupPage handler", "err", err)
 		response.RespondInternalServerError(ctx)
 		return
 	}
@@ -589,7 +891,7 @@ func NewTopupPageHandler(r *fasthttprouter.Router, t *template.Template, uc usec
 		t:  t,
 		uc: uc,
 	}
-	r.GET("/topup", middleware.SecretMiddleware(middleware.CheckAuthMiddleware(handler.TopupPage)))
+	r.GET("/topup", middleware.SecretMiddleware(middleware.CheckAuthMiddleware(middleware.RequirePermission(domain.PermWalletsRead, handler.TopupPage))))
 }
 
 type TopupHandler struct {
@@ -599,7 +901,7 @@ type TopupHandler struct {
 func extractTopupValues(ctx *fasthttp.RequestCtx) (account string, amount string, err error) {
 	account = string(ctx.FormValue("accountno"))
 	amount = string(ctx.FormValue("amount"))
-	log.Println("INFO|Received folowing account and amount:", account, amount)
+	logger.Ctx(ctx).Debug("Received topup values", "account", account, "amount", amount)
 	if !validAcc(account) || !validAmt(amount) {
 		err = fmt.Errorf("invalid acc or amt")
 		return
@@ -608,36 +910,36 @@ func extractTopupValues(ctx *fasthttp.RequestCtx) (account string, amount string
 }
 
 func (h *TopupHandler) TopUp(ctx *fasthttp.RequestCtx) {
-	log.Println("INFO|Topup handler hit")
+	logger.Ctx(ctx).Debug("Topup handler hit")
 	account, amount, err := extractTopupValues(ctx)
+	middleware.SetAuditTarget(ctx, account)
 	if err != nil {
 		response.RespondWithError(ctx, fasthttp.StatusBadRequest, "Invalid amount")
 		return
 	}
 	token, ok := ctx.Value("access").(string)
 	if !ok {
-		log.Println("ERROR|Couldn't find token")
+		logger.Ctx(ctx).Error("Couldn't find token")
 		response.RespondInternalServerError(ctx)
 		return
 	}
 	user, ok := ctx.Value("user").(domain.User)
 	if !ok {
-		log.Println("ERROR|User is nil")
+		logger.Ctx(ctx).Error("User is nil")
 		response.RespondInternalServerError(ctx)
 		return
 	}
-	log.Println("INFO|Sending topup request from authService, token, IIN:", token, user.IIN)
-	log.Printf("account:%s, amount:%s\n", account, amount)
+	logger.Ctx(ctx).Info("Sending topup request from authService", "iin", user.IIN, "account", account, "amount", amount)
 
 	respBytes, status, err := h.uc.TopUp(user.IIN, account, amount, token)
 	if err != nil {
-		log.Println("ERROR|Coudn't get response from walletService")
+		logger.Ctx(ctx).Error("Couldn't get response from walletService")
 		response.RespondInternalServerError(ctx)
 		return
 	}
 	var resp domain.Response
 	if err := json.Unmarshal(respBytes, &resp); err != nil {
-		log.Println("ERROR|Getting response from walletService: ", err)
+		logger.Ctx(ctx).Error("Getting response from walletService", "err", err)
 		response.RespondInternalServerError(ctx)
 		return
 	}
@@ -654,7 +956,7 @@ func (h *TopupHandler) TopUp(ctx *fasthttp.RequestCtx) {
 
 func NewTopupHandler(r *fasthttprouter.Router, uc usecase.TopupUsecase) {
 	handler := &TopupHandler{uc: uc}
-	r.POST("/topup", middleware.SecretMiddleware(middleware.CheckAuthMiddleware(handler.TopUp)))
+	r.POST("/topup", middleware.SecretMiddleware(middleware.CheckAuthMiddleware(middleware.Audit(domain.AuditTopup, middleware.RateLimit("topup", middleware.RequirePermission(domain.PermWalletsWrite, handler.TopUp))))))
 }
 
 type TransferPageHandler struct {
@@ -663,22 +965,22 @@ type TransferPageHandler struct {
 }
 
 func (h *TransferPageHandler) TransferPage(ctx *fasthttp.RequestCtx) {
-	log.Println("INFO|TransferPage hit")
+	logger.Ctx(ctx).Debug("TransferPage hit")
 	ctx.Response.Header.SetContentType("text/html")
-	token := string(ctx.Request.Header.Cookie("access"))
-	if token == "" {
-		log.Println("ERROR|Couldn't get token from ctx")
+	token, ok := ctx.Value("access").(string)
+	if !ok || token == "" {
+		logger.Ctx(ctx).Error("Couldn't get token from ctx")
 		response.RespondWithError(ctx, fasthttp.StatusBadRequest, "Couldn't find token")
 		return
 	}
 	walletList, err := h.uc.GetWallets(token)
 	if err != nil {
-		log.Println("ERROR|TransferPage handler:", err)
+		logger.Ctx(ctx).Error("TransferPage handler", "err", err)
 		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
 		walletList = append(walletList, "shutdown")
 	}
 	if err := h.t.Execute(ctx, walletList); err != nil {
-		log.Println("ERROR|TransferPage handler:", err)
+		logger.Ctx(ctx).Error("TransferPage handler", "err", err)
 		response.RespondInternalServerError(ctx)
 		return
 	}
@@ -689,7 +991,7 @@ func NewTransferPageHandler(r *fasthttprouter.Router, t *template.Template, uc u
 		t:  t,
 		uc: uc,
 	}
-	r.GET("/transfer", middleware.SecretMiddleware(middleware.CheckAuthMiddleware(handler.TransferPage)))
+	r.GET("/transfer", middleware.SecretMiddleware(middleware.CheckAuthMiddleware(middleware.RequirePermission(domain.PermWalletsRead, handler.TransferPage))))
 }
 
 type TransferHandler struct {
@@ -703,7 +1005,7 @@ func extractTransfervalue(ctx *fasthttp.RequestCtx) (from string, to string, amo
 	if to == "-" {
 		to = string(ctx.FormValue("other"))
 	}
-	log.Println("INFO|Transfering into account", to)
+	logger.Ctx(ctx).Debug("Transferring into account", "to", to)
 	amount = string(ctx.FormValue("amount"))
 	if !validAmt(amount) {
 		err = myerrors.ErrInvalidAmt
@@ -722,47 +1024,46 @@ func extractTransfervalue(ctx *fasthttp.RequestCtx) (from string, to string, amo
 
 // Transfer handles transactions between user wallets
 func (h *TransferHandler) Transfer(ctx *fasthttp.RequestCtx) {
-	log.Println("INFO|Transfer endpoint hit")
+	logger.Ctx(ctx).Debug("Transfer endpoint hit")
 
 	from, to, amount, err := extractTransfervalue(ctx)
+	middleware.SetAuditTarget(ctx, from+" -> "+to)
 
 	if err != nil {
-		log.Println("ERROR|Extracting transfer values:", err)
+		logger.Ctx(ctx).Error("Extracting transfer values", "err", err)
 		response.RespondWithError(ctx, fasthttp.StatusBadRequest, err.Error())
 		return
 	}
 	token, ok := ctx.Value("access").(string)
 	if !ok {
-		fmt.Println("Couldn't find token")
+		logger.Ctx(ctx).Error("Couldn't find token")
 		response.RespondWithError(ctx, fasthttp.StatusBadRequest, "Couldn't find token")
 		return
 	}
-	log.Println("INFO|Transfer handler getting token", token)
 	user, ok := ctx.Value("user").(domain.User)
 	if !ok {
-		log.Println("ERROR|User is nil")
+		logger.Ctx(ctx).Error("User is nil")
 		response.RespondInternalServerError(ctx)
 		return
 	}
-	log.Println("INFO|Sending transfer request from authService")
+	logger.Ctx(ctx).Info("Sending transfer request from authService")
 
 	respBytes, status, err := h.uc.Transfer(user.IIN, from, to, amount, token)
-	log.Println("INFO|Got the following response & status code:", string(respBytes), "\n", status)
+	logger.Ctx(ctx).Debug("Got response from walletService", "status", status, "body", string(respBytes))
 	if err != nil {
-		log.Println("ERROR|Coudn't get response from walletService")
+		logger.Ctx(ctx).Error("Couldn't get response from walletService")
 		response.RespondInternalServerError(ctx)
 		return
 	}
-	log.Println("Received response from walletService")
 	var resp domain.Response
 	if err := json.Unmarshal(respBytes, &resp); err != nil {
-		log.Println("ERROR|Getting response from walletService: ", err)
+		logger.Ctx(ctx).Error("Getting response from walletService", "err", err)
 		response.RespondInternalServerError(ctx)
 		return
 	}
 	if resp.OK {
 		response.ResponseJSON(ctx, resp.Message)
-		log.Println("Everything OK")
+		logger.Ctx(ctx).Info("Transfer succeeded")
 		return
 	}
 	if status == fasthttp.StatusBadRequest {
@@ -776,30 +1077,91 @@ func NewTransferHandler(r *fasthttprouter.Router, uc usecase.TransferUsecase) {
 	handler := &TransferHandler{
 		uc: uc,
 	}
-	r.POST("/transfer", middleware.SecretMiddleware(middleware.CheckAuthMiddleware(handler.Transfer)))
+	r.POST("/transfer", middleware.SecretMiddleware(middleware.CheckAuthMiddleware(middleware.Audit(domain.AuditTransfer, middleware.RateLimit("transfer", middleware.RequirePermission(domain.PermWalletsWrite, handler.Transfer))))))
 }
 
-type LogoutHandler struct{}
+type LogoutHandler struct {
+	uc usecase.LogoutUsecase
+}
 
-// LogOut handles logout by deleting token cookies
+// LogOut handles logout by revoking the session and access token and deleting token cookies
 func (h *LogoutHandler) LogOut(ctx *fasthttp.RequestCtx) {
-	log.Println("INFO|LogOut hit")
-	access := makeCookie("access", "", 0)
-	access.SetExpire(time.Unix(0, 0))
+	logger.Ctx(ctx).Debug("LogOut hit")
+	policy, err := middleware.GetPolicyFromCtx(ctx)
+	if err != nil {
+		logger.Ctx(ctx).Error("Logout handler", "err", err)
+		response.RespondInternalServerError(ctx)
+		return
+	}
 
-	refresh := makeCookie("refresh", "", 0)
-	refresh.SetExpire(time.Unix(0, 0))
+	refreshToken, err := middleware.ExtractToken(ctx, false)
+	if err != nil {
+		refreshToken = extractBodyRefreshToken(ctx)
+	}
+	if refreshToken != "" {
+		h.revokeSession(ctx, refreshToken)
+	}
+	if accessToken, err := middleware.ExtractToken(ctx, true); err == nil {
+		h.denyAccessToken(ctx, accessToken)
+	}
+
+	access := makeCookie(policy, "access", "", 0)
+	access.SetExpire(fasthttp.CookieExpireDelete)
+
+	refresh := makeCookie(policy, "refresh", "", 0)
+	refresh.SetExpire(fasthttp.CookieExpireDelete)
 
 	ctx.Response.Header.SetCookie(access)
 	ctx.Response.Header.SetCookie(refresh)
+	if response.WantsJSON(ctx) {
+		response.ResponseJSON(ctx, "Logged out")
+		return
+	}
 	ctx.SetStatusCode(fasthttp.StatusSeeOther)
 	ctx.Response.Header.Add("Location", "/")
 }
 
+// revokeSession deletes session of a valid refresh token
+func (h *LogoutHandler) revokeSession(ctx *fasthttp.RequestCtx, refreshToken string) {
+	IIN, _, err := middleware.ParseToken(ctx, refreshToken, false)
+	if err != nil {
+		logger.Ctx(ctx).Error("Logout: parse refresh token error", "err", err)
+		return
+	}
+	sessionID, err := middleware.SessionID(refreshToken)
+	if err != nil {
+		logger.Ctx(ctx).Error("Logout: parse refresh token error", "err", err)
+		return
+	}
+	middleware.SetAuditActor(ctx, IIN)
+	middleware.SetAuditTarget(ctx, sessionID)
+	if err := h.uc.RevokeSession(IIN, sessionID); err != nil {
+		logger.Ctx(ctx).Error("Logout: couldn't revoke session", "err", err)
+	}
+}
+
+// denyAccessToken denylists a valid access token until it expires
+func (h *LogoutHandler) denyAccessToken(ctx *fasthttp.RequestCtx, accessToken string) {
+	if _, _, err := middleware.ParseToken(ctx, accessToken, true); err != nil {
+		logger.Ctx(ctx).Error("Logout: parse access token error", "err", err)
+		return
+	}
+	jti, exp, err := middleware.TokenID(accessToken)
+	if err != nil {
+		logger.Ctx(ctx).Error("Logout: parse access token error", "err", err)
+		return
+	}
+	if err := h.uc.DenyToken(jti, time.Until(exp)); err != nil {
+		logger.Ctx(ctx).Error("Logout: couldn't denylist access token", "err", err)
+	}
+}
+
 // NewLogoutHandler sets /logout route
-func NewLogoutHandler(r *fasthttprouter.Router) {
-	handler := &LogoutHandler{}
-	r.GET("/logout", handler.LogOut)
+func NewLogoutHandler(r *fasthttprouter.Router, uc usecase.LogoutUsecase) {
+	handler := &LogoutHandler{
+		uc: uc,
+	}
+	r.POST("/logout", middleware.SecretMiddleware(middleware.Audit(domain.AuditLogout, handler.LogOut)))
 }
 
 type HomePageHandler struct {
@@ -808,10 +1170,10 @@ type HomePageHandler struct {
 
 // Home handles / path
 func (h *HomePageHandler) Home(ctx *fasthttp.RequestCtx) {
-	log.Println("INFO|HomePage hit")
+	logger.Ctx(ctx).Debug("HomePage hit")
 	ctx.Response.Header.SetContentType("text/html")
 	if err := h.t.Execute(ctx, nil); err != nil {
-		log.Println("ERROR|LoginPage handler:", err)
+		logger.Ctx(ctx).Error("LoginPage handler", "err", err)
 		response.RespondInternalServerError(ctx)
 		return
 	}
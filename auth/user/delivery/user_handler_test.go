@@ -1,8 +1,11 @@
 package delivery
 
 import (
+	"auth/domain"
+	"encoding/json"
 	"fmt"
 	"net"
+	"strings"
 	"testing"
 
 	"github.com/valyala/fasthttp"
@@ -27,10 +30,16 @@ var testTable = []struct {
 	{"get-update", "/update", "GET", []postData{}, fasthttp.StatusOK},
 	{"get-topup", "/topup", "GET", []postData{}, fasthttp.StatusOK},
 	{"get-login", "/transfer", "GET", []postData{}, fasthttp.StatusOK},
-	{"get-logout", "/logout", "GET", []postData{}, fasthttp.StatusSeeOther},
 	{"get-home", "/", "GET", []postData{}, fasthttp.StatusOK},
+	{"get-jwks", "/.well-known/jwks.json", "GET", []postData{}, fasthttp.StatusOK},
 	{"get-getTransactions", "/transactions?account=KZT0000000001", "GET", []postData{}, fasthttp.StatusOK},
+	{"post-logout", "/logout", "POST", []postData{}, fasthttp.StatusSeeOther},
 	{"post-addWallet", "/add", "POST", []postData{}, fasthttp.StatusOK},
+	{"get-sessions", "/sessions", "GET", []postData{}, fasthttp.StatusOK},
+	{"post-revokeSession", "/sessions/revoke", "POST", []postData{
+		{key: "id", value: "other"},
+	}, fasthttp.StatusOK},
+	{"post-revokeOtherSessions", "/sessions/revoke-others", "POST", []postData{}, fasthttp.StatusOK},
 	{"post-login", "/login", "POST", []postData{
 		{key: "login", value: "user"},
 		{key: "password", value: PASSWORD},
@@ -38,7 +47,7 @@ var testTable = []struct {
 	{"post-signup", "/signup", "POST", []postData{
 		{key: "iin", value: "980124450084"},
 		{key: "login", value: "user"},
-		{key: "password", value: "password "},
+		{key: "password", value: "wallet-2022"},
 	}, fasthttp.StatusOK},
 	{"post-topup", "/topup", "POST", []postData{
 		{key: "accountno", value: "KZT0000000001"},
@@ -91,6 +100,7 @@ func TestUserHandlers(t *testing.T) {
 			req.SetRequestURI("http://test.com" + tt.url)
 			// req.SetBodyString("test")
 
+			setTestCSRF(req)
 			err := c.Do(req, res)
 			if err != nil {
 				t.Fatal(err)
@@ -118,6 +128,7 @@ func TestUserHandlers(t *testing.T) {
 			req.SetRequestURI(URIWithArgs)
 			// req.SetBodyString("test")
 
+			setTestCSRF(req)
 			err := c.Do(req, res)
 			if err != nil {
 				t.Fatal(err)
@@ -162,9 +173,26 @@ var testTableErr = []struct {
 	{"get-update-no token", "/update", "GET", []postData{}, fasthttp.StatusSeeOther, "", false, false},
 	{"get-update-wrong token", "/update", "GET", []postData{}, fasthttp.StatusSeeOther, "980124450084", true, true},
 	{"get-update-wrong token", "/update", "GET", []postData{}, fasthttp.StatusSeeOther, "980124450084", true, false},
+	{"get-update-reused token", "/update", "GET", []postData{}, fasthttp.StatusSeeOther, "reused", true, false},
 	{"get-update-nonexistent user", "/update", "GET", []postData{}, fasthttp.StatusSeeOther, "nonexistent", true, false},
 	{"get-update-sth wrong", "/update", "GET", []postData{}, fasthttp.StatusInternalServerError, "sthwrong", true, false},
+	{"get-update-locked user", "/update", "GET", []postData{}, fasthttp.StatusSeeOther, "locked", true, false},
+	{"post-login-locked user", "/login", "POST", []postData{
+		{key: "login", value: "locked"},
+		{key: "password", value: PASSWORD},
+	}, fasthttp.StatusForbidden, "", false, false},
 	{"get-info some err", "/info", "GET", []postData{}, fasthttp.StatusInternalServerError, "wrong", true, false},
+	{"get-info revoked token", "/info", "GET", []postData{}, fasthttp.StatusSeeOther, "denied", true, false},
+	{"get-info denylist err", "/info", "GET", []postData{}, fasthttp.StatusInternalServerError, "denyerr", true, false},
+	{"get-logout", "/logout", "GET", []postData{}, fasthttp.StatusMethodNotAllowed, "", true, false},
+	{"get-sessions some err", "/sessions", "GET", []postData{}, fasthttp.StatusInternalServerError, "wrong", true, false},
+	{"post-revokeSession-no id", "/sessions/revoke", "POST", []postData{}, fasthttp.StatusBadRequest, "", true, false},
+	{"post-revokeSession-missing", "/sessions/revoke", "POST", []postData{
+		{key: "id", value: "missing"},
+	}, fasthttp.StatusNotFound, "", true, false},
+	{"post-revokeSession-other user", "/sessions/revoke", "POST", []postData{
+		{key: "id", value: "other"},
+	}, fasthttp.StatusNotFound, "601119400567", true, false},
 	{"get-transactions-no acc", "/transactions", "GET", []postData{}, fasthttp.StatusBadRequest, "", true, false},
 	{"get-transactions-some err", "/transactions", "GET", []postData{
 		{key: "account", value: "err"},
@@ -204,15 +232,30 @@ var testTableErr = []struct {
 		{key: "login", value: "user"},
 		{key: "password", value: "passsword"},
 	}, fasthttp.StatusBadRequest, "", true, false},
+	{"post-signup-short password", "/signup", "POST", []postData{
+		{key: "iin", value: "980124450084"},
+		{key: "login", value: "user"},
+		{key: "password", value: "!"},
+	}, fasthttp.StatusBadRequest, "", true, false},
+	{"post-signup-breached password", "/signup", "POST", []postData{
+		{key: "iin", value: "980124450084"},
+		{key: "login", value: "user"},
+		{key: "password", value: "p@ssw0rd1"},
+	}, fasthttp.StatusBadRequest, "", true, false},
+	{"post-signup-password with username", "/signup", "POST", []postData{
+		{key: "iin", value: "980124450084"},
+		{key: "login", value: "walletuser"},
+		{key: "password", value: "walletuser-1"},
+	}, fasthttp.StatusBadRequest, "", true, false},
 	{"post-signup-duplicate user", "/signup", "POST", []postData{
 		{key: "iin", value: "980124450084"},
 		{key: "login", value: "exists"},
-		{key: "password", value: "password "},
+		{key: "password", value: "wallet-2022"},
 	}, fasthttp.StatusBadRequest, "", true, false},
 	{"post-signup-duplicate user", "/signup", "POST", []postData{
 		{key: "iin", value: "980124450084"},
 		{key: "login", value: "other"},
-		{key: "password", value: "password "},
+		{key: "password", value: "wallet-2022"},
 	}, fasthttp.StatusInternalServerError, "", true, false},
 	{"post-topup wrong amt", "/topup", "POST", []postData{
 		{key: "accountno", value: "KZT0000000001"},
@@ -323,6 +366,7 @@ func TestUserHandlersError(t *testing.T) {
 
 			// req.SetBodyString("test")
 
+			setTestCSRF(req)
 			err := c.Do(req, res)
 			if err != nil {
 				t.Fatal(err)
@@ -344,6 +388,7 @@ func TestUserHandlersError(t *testing.T) {
 			req.SetRequestURI(URIWithArgs)
 			// req.SetBodyString("test")
 
+			setTestCSRF(req)
 			err := c.Do(req, res)
 			if err != nil {
 				t.Fatal(err)
@@ -356,3 +401,263 @@ func TestUserHandlersError(t *testing.T) {
 		fasthttp.ReleaseResponse(res)
 	}
 }
+
+var testTableBearer = []struct {
+	name               string
+	url                string
+	method             string
+	headers            map[string]string
+	body               string
+	expectedStatusCode int
+	expectTokens       bool
+}{
+	{"get-info bearer", "/info", "GET", map[string]string{"Authorization": "Bearer {access}"}, "", fasthttp.StatusOK, false},
+	{"get-info wrong bearer", "/info", "GET", map[string]string{"Authorization": "Bearer wrong"}, "", fasthttp.StatusUnauthorized, false},
+	{"get-info no token json", "/info", "GET", map[string]string{"Accept": "application/json"}, "", fasthttp.StatusUnauthorized, false},
+	{"post-login json", "/login", "POST", map[string]string{"Accept": "application/json", "Content-Type": "application/x-www-form-urlencoded"}, "login=user&password=" + PASSWORD, fasthttp.StatusOK, true},
+	{"post-refresh json", "/refresh", "POST", map[string]string{"Content-Type": "application/json"}, `{"refresh_token":"{refresh}"}`, fasthttp.StatusOK, true},
+	{"post-refresh form", "/refresh", "POST", map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, "refresh_token={refresh}", fasthttp.StatusOK, true},
+	{"post-refresh no token", "/refresh", "POST", map[string]string{"Content-Type": "application/json"}, `{}`, fasthttp.StatusBadRequest, false},
+	{"post-refresh access token", "/refresh", "POST", map[string]string{"Content-Type": "application/json"}, `{"refresh_token":"{access}"}`, fasthttp.StatusUnauthorized, false},
+	{"post-logout json", "/logout", "POST", map[string]string{"Accept": "application/json", "Authorization": "Bearer {access}", "Content-Type": "application/json"}, `{"refresh_token":"{refresh}"}`, fasthttp.StatusOK, false},
+	{"post-refresh revoked session", "/refresh", "POST", map[string]string{"Content-Type": "application/json"}, `{"refresh_token":"{revoked}"}`, fasthttp.StatusUnauthorized, false},
+}
+
+func TestBearerHandlers(t *testing.T) {
+	r := getRoutes()
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	s := &fasthttp.Server{
+		Handler: r,
+	}
+
+	go s.Serve(ln) //nolint:errcheck
+	c := &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	access, refresh, err := GenerateTestTokens("910815450350")
+	if err != nil {
+		t.Fatal("Couldn't generate token", err)
+	}
+	_, revoked, err := GenerateTestTokens("980124450084")
+	if err != nil {
+		t.Fatal("Couldn't generate token", err)
+	}
+	replacer := strings.NewReplacer("{access}", access, "{refresh}", refresh, "{revoked}", revoked)
+
+	for _, tt := range testTableBearer {
+		fmt.Println("Testing", tt.name, "******************************************************************************************")
+		req, res := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		req.Header.SetMethod(tt.method)
+		req.SetRequestURI(URI + tt.url)
+		for key, value := range tt.headers {
+			req.Header.Set(key, replacer.Replace(value))
+		}
+		req.SetBodyString(replacer.Replace(tt.body))
+
+		setTestCSRF(req)
+		if err := c.Do(req, res); err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode() != tt.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", tt.name, tt.expectedStatusCode, res.StatusCode())
+		}
+		if tt.expectTokens {
+			var tokens domain.TokenResponse
+			if err := json.Unmarshal(res.Body(), &tokens); err != nil {
+				t.Errorf("for %s, couldn't unmarshal tokens: %v", tt.name, err)
+			}
+			if tokens.AccessToken == "" || tokens.RefreshToken == "" || tokens.TokenType != "Bearer" {
+				t.Errorf("for %s, unexpected tokens %+v", tt.name, tokens)
+			}
+			if len(res.Header.PeekCookie("access")) != 0 {
+				t.Errorf("for %s, token cookies set in token-in-body mode", tt.name)
+			}
+		}
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(res)
+	}
+}
+
+func TestRefreshTokenReplay(t *testing.T) {
+	r := getRoutes()
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	s := &fasthttp.Server{
+		Handler: r,
+	}
+
+	go s.Serve(ln) //nolint:errcheck
+	c := &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	do := func(url, body string) (int, string) {
+		req, res := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseRequest(req)
+		defer fasthttp.ReleaseResponse(res)
+		req.Header.SetMethod(fasthttp.MethodPost)
+		req.SetRequestURI(URI + url)
+		req.Header.Set("Accept", "application/json")
+		req.Header.SetContentType("application/x-www-form-urlencoded")
+		req.SetBodyString(body)
+		setTestCSRF(req)
+		if err := c.Do(req, res); err != nil {
+			t.Fatal(err)
+		}
+		var tokens domain.TokenResponse
+		_ = json.Unmarshal(res.Body(), &tokens)
+		return res.StatusCode(), tokens.RefreshToken
+	}
+
+	status, first := do("/login", "login=user&password="+PASSWORD)
+	if status != fasthttp.StatusOK || first == "" {
+		t.Fatalf("login: expected %d but got %d", fasthttp.StatusOK, status)
+	}
+	status, second := do("/refresh", "refresh_token="+first)
+	if status != fasthttp.StatusOK || second == "" {
+		t.Fatalf("first refresh: expected %d but got %d", fasthttp.StatusOK, status)
+	}
+	// concurrent request with the token just rotated out gets no tokens, the session stays
+	if status, refresh := do("/refresh", "refresh_token="+first); status != fasthttp.StatusUnauthorized || refresh != "" {
+		t.Errorf("just rotated: expected %d without tokens but got %d", fasthttp.StatusUnauthorized, status)
+	}
+	status, third := do("/refresh", "refresh_token="+second)
+	if status != fasthttp.StatusOK || third == "" {
+		t.Fatalf("second refresh: expected %d but got %d", fasthttp.StatusOK, status)
+	}
+	// replay of the old token revokes the session
+	if status, refresh := do("/refresh", "refresh_token="+first); status != fasthttp.StatusUnauthorized || refresh != "" {
+		t.Errorf("replay: expected %d without tokens but got %d", fasthttp.StatusUnauthorized, status)
+	}
+	if status, _ := do("/refresh", "refresh_token="+third); status != fasthttp.StatusUnauthorized {
+		t.Errorf("after replay: expected revoked session but got %d", status)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	r := getRoutes()
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	s := &fasthttp.Server{
+		Handler: r,
+	}
+
+	go s.Serve(ln) //nolint:errcheck
+	c := &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	login := func(password string) *fasthttp.Response {
+		req, res := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseRequest(req)
+		req.Header.SetMethod(fasthttp.MethodPost)
+		req.SetRequestURI(URI + "/login")
+		req.Header.SetContentType("application/x-www-form-urlencoded")
+		req.SetBodyString("login=lockme&password=" + password)
+		setTestCSRF(req)
+		if err := c.Do(req, res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	for i := 0; i < testLoginPolicy.MaxFailures; i++ {
+		res := login("wrong!")
+		if res.StatusCode() != fasthttp.StatusUnauthorized {
+			t.Errorf("attempt %d: expected %d but got %d", i+1, fasthttp.StatusUnauthorized, res.StatusCode())
+		}
+		fasthttp.ReleaseResponse(res)
+	}
+	// correct password is rejected while locked out
+	res := login(PASSWORD)
+	defer fasthttp.ReleaseResponse(res)
+	if res.StatusCode() != fasthttp.StatusTooManyRequests {
+		t.Errorf("expected %d but got %d", fasthttp.StatusTooManyRequests, res.StatusCode())
+	}
+	if len(res.Header.Peek("Retry-After")) == 0 {
+		t.Error("expected Retry-After header")
+	}
+	var resp domain.Response
+	if err := json.Unmarshal(res.Body(), &resp); err != nil || !strings.Contains(resp.Message, "temporarily locked") {
+		t.Errorf("unexpected lockout response %q", res.Body())
+	}
+}
+
+func TestTransparentRefresh(t *testing.T) {
+	r := getRoutes()
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	s := &fasthttp.Server{
+		Handler: r,
+	}
+
+	go s.Serve(ln) //nolint:errcheck
+	c := &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	_, refresh, err := GenerateSessionTestTokens("910815450350", "transparent")
+	if err != nil {
+		t.Fatal("Couldn't generate token", err)
+	}
+	_, lockedRefresh, err := GenerateTestTokens("locked")
+	if err != nil {
+		t.Fatal("Couldn't generate token", err)
+	}
+
+	var testTable = []struct {
+		name               string
+		refresh            string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"post-transfer refreshed", refresh, fasthttp.StatusOK, ""},
+		{"post-transfer locked user", lockedRefresh, fasthttp.StatusSeeOther, URI + "/login"},
+	}
+	for _, tt := range testTable {
+		req, res := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		// access cookie has expired and is no longer sent by the browser
+		req.Header.SetMethod(fasthttp.MethodPost)
+		req.SetRequestURI(URI + "/transfer")
+		req.Header.SetCookie("refresh", tt.refresh)
+		req.Header.SetContentType("application/x-www-form-urlencoded")
+		req.SetBodyString("from=KZT0000000001&to=KZT0000000002&amount=1")
+		setTestCSRF(req)
+		if err := c.Do(req, res); err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode() != tt.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", tt.name, tt.expectedStatusCode, res.StatusCode())
+		}
+		if location := string(res.Header.Peek(fasthttp.HeaderLocation)); location != tt.expectedLocation {
+			t.Errorf("for %s, expected redirect to %q but got %q", tt.name, tt.expectedLocation, location)
+		}
+		if refreshed := len(res.Header.PeekCookie("access")) != 0; refreshed != (tt.expectedStatusCode == fasthttp.StatusOK) {
+			t.Errorf("for %s, access cookie set: %t", tt.name, refreshed)
+		}
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(res)
+	}
+}
@@ -5,18 +5,75 @@ import (
 	"time"
 )
 
+// RotationGrace is how long the refresh token just rotated out of a session is answered with ErrTokenRotated
+// instead of being taken for a reuse, so that concurrent requests of a page don't revoke the session
+const RotationGrace = 10 * time.Second
+
 type CacheInterface interface {
-	InsertToken(string, string, time.Duration) error
-	FindToken(string, string) (string, error)
+	InsertSession(session *domain.Session, token string, refreshTtl time.Duration) error
+	GetSession(sessionID string) (*domain.Session, error)
+	// GetSessionToken returns current refresh token of the session
+	GetSessionToken(sessionID string) (string, error)
+	ListSessions(IIN string) ([]domain.Session, error)
+	// RotateToken replaces current refresh token of the session. The previous token presented within RotationGrace
+	// gives ErrTokenRotated and any other rotated out token ErrTokenReused, neither changes the session
+	RotateToken(IIN, sessionID, oldToken, newToken string, refreshTtl time.Duration) error
+	DeleteSession(IIN, sessionID string) error
+	DenyToken(jti string, ttl time.Duration) error
+	IsTokenDenied(jti string) (bool, error)
+	AddLoginFailure(key string, ttl time.Duration) (*domain.LoginAttempts, error)
+	LockLogin(key string, until time.Time) error
+	GetLoginAttempts(key string) (*domain.LoginAttempts, error)
+	ResetLoginAttempts(key string) error
+	// InsertResetToken stores hash of password reset token, earlier token of the user stops working
+	InsertResetToken(IIN, tokenHash string, ttl time.Duration) error
+	// GetResetToken returns IIN of reset token without using it up
+	GetResetToken(tokenHash string) (string, error)
+	// ConsumeResetToken deletes reset token and returns its IIN, so that a token works only once
+	ConsumeResetToken(tokenHash string) (string, error)
+	// InsertAuthCode stores OAuth authorization code by hash of the code
+	InsertAuthCode(codeHash string, code *domain.AuthCode, ttl time.Duration) error
+	// ConsumeAuthCode deletes authorization code and returns it, so that a code works only once
+	ConsumeAuthCode(codeHash string) (*domain.AuthCode, error)
+}
+
+// RateLimiter counts requests per key in a sliding window
+type RateLimiter interface {
+	// Allow registers request if key made less than limit requests within window,
+	// otherwise reports how long to wait until the next one is allowed
+	Allow(key string, limit int, window time.Duration) (bool, time.Duration, error)
 }
 
 type DBInterface interface {
 	GetUser(username string) (*domain.User, error)
 	GetUserByIIN(IIN string) (*domain.User, error)
-	AddUser(IIN, username, password string) error
+	AddUser(IIN, username, password, email string) error
+	GetUserRoles(userID int) (roles []string, permissions []string, err error)
+	SearchUsers(query string, limit int) ([]domain.User, error)
+	UpdatePassword(IIN, password string) error
+	UpdateUsername(IIN, username string) error
+	SetUserLocked(IIN string, locked bool) error
+	RecordAdminAction(action *domain.AdminAction) error
+	ListAdminActions(targetIIN string, limit int) ([]domain.AdminAction, error)
+	GetMFA(userID int) (*domain.MFA, error)
+	SaveMFA(userID int, secret []byte) error
+	EnableMFA(userID int, step int64, codeHashes []string) error
+	UseMFAStep(userID int, step int64) (bool, error)
+	UseRecoveryCode(userID int, codeHash string) (bool, error)
+	DeleteMFA(userID int) error
+	AddOAuthClient(client *domain.OAuthClient) error
+	GetOAuthClient(clientID string) (*domain.OAuthClient, error)
+	ListOAuthClients() ([]domain.OAuthClient, error)
 	Close()
 }
 
+// AuditRepository keeps security audit log
+type AuditRepository interface {
+	RecordEvent(event *domain.AuditEvent) error
+	// ListEvents returns events matching filter newest first together with total number of matching events
+	ListEvents(filter *domain.AuditFilter) ([]domain.AuditEvent, int, error)
+}
+
 type APIInterface interface {
 	GetWallets(IIN, token string) ([]domain.Wallet, error)
 	GetTransactions(token, account string) ([]domain.Transaction, error)
@@ -25,3 +82,8 @@ type APIInterface interface {
 	Transfer(IIN, from, to, amount, token string) ([]byte, int, error)
 	AddWallet(token string) (string, error)
 }
+
+// Mailer delivers mail to users
+type Mailer interface {
+	Send(mail *domain.Mail) error
+}
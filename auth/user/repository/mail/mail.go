@@ -0,0 +1,52 @@
+package mail
+
+import (
+	"auth/domain"
+	"auth/logger"
+	"auth/user/repository"
+	"bytes"
+	"fmt"
+	"mime"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+)
+
+// NewMailer returns SMTP mailer when SMTP_ADDR is set and outbox mailer writing to MAIL_OUTBOX_DIR otherwise
+func NewMailer() (repository.Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "no-reply@localhost"
+	}
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		return NewSMTPMailer(addr, os.Getenv("SMTP_USER"), os.Getenv("SMTP_PASSWORD"), from)
+	}
+	dir := os.Getenv("MAIL_OUTBOX_DIR")
+	if dir == "" {
+		dir = "./outbox"
+	}
+	logger.Warn("SMTP_ADDR is not set, mail is written", "dir", dir)
+	return NewOutboxMailer(dir, from)
+}
+
+// buildMessage formats mail as RFC 5322 message with UTF-8 text body
+func buildMessage(from string, m *domain.Mail, now time.Time) ([]byte, error) {
+	if strings.ContainsAny(m.To+m.Subject, "\r\n") {
+		return nil, fmt.Errorf("invalid mail header")
+	}
+	if _, err := mail.ParseAddress(m.To); err != nil {
+		return nil, fmt.Errorf("invalid recipient: %w", err)
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+	return b.Bytes(), nil
+}
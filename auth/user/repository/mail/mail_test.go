@@ -0,0 +1,106 @@
+package mail
+
+import (
+	"auth/domain"
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testMail = &domain.Mail{To: "user@example.com", Subject: "Сброс пароля", Body: "line 1\nline 2"}
+
+func TestBuildMessage(t *testing.T) {
+	msg, err := buildMessage("no-reply@example.com", testMail, time.Unix(1640024899, 0))
+	assert.NoError(t, err)
+	text := string(msg)
+	assert.Contains(t, text, "To: user@example.com\r\n")
+	assert.Contains(t, text, "Subject: =?utf-8?q?")
+	assert.True(t, strings.HasSuffix(text, "\r\n\r\nline 1\r\nline 2"))
+
+	_, err = buildMessage("no-reply@example.com", &domain.Mail{To: "user@example.com\r\nBcc: x@example.com", Subject: "s"}, time.Now())
+	assert.Error(t, err)
+	_, err = buildMessage("no-reply@example.com", &domain.Mail{To: "not an address", Subject: "s"}, time.Now())
+	assert.Error(t, err)
+}
+
+func TestOutboxMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	mailer, err := NewOutboxMailer(dir, "no-reply@example.com")
+	assert.NoError(t, err)
+	assert.NoError(t, mailer.Send(testMail))
+	assert.NoError(t, mailer.Send(testMail))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+	msg, err := os.ReadFile(files[0])
+	assert.NoError(t, err)
+	assert.Contains(t, string(msg), "line 2")
+}
+
+// serveSMTP accepts one SMTP session and returns received DATA
+func serveSMTP(t *testing.T, ln net.Listener, data chan<- string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+	reply("220 localhost ESMTP")
+	var body strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil || line == ".\r\n" {
+					break
+				}
+				body.WriteString(line)
+			}
+			data <- body.String()
+			reply("250 ok")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestSMTPMailer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	data := make(chan string, 1)
+	go serveSMTP(t, ln, data)
+
+	mailer, err := NewSMTPMailer(ln.Addr().String(), "", "", "Wallet <no-reply@example.com>")
+	assert.NoError(t, err)
+	assert.NoError(t, mailer.Send(testMail))
+	msg := <-data
+	assert.Contains(t, msg, "From: Wallet <no-reply@example.com>\r\n")
+	assert.Contains(t, msg, "line 1\r\nline 2")
+
+	_, err = NewSMTPMailer("no port", "", "", "no-reply@example.com")
+	assert.Error(t, err)
+}
@@ -0,0 +1,45 @@
+package mail
+
+import (
+	"auth/domain"
+	"auth/logger"
+	"auth/user/repository"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// outboxMailer writes every message to its own .eml file instead of sending it, for local runs and tests
+type outboxMailer struct {
+	dir  string
+	from string
+}
+
+func (o *outboxMailer) Send(m *domain.Mail) error {
+	now := time.Now()
+	msg, err := buildMessage(o.from, m, now)
+	if err != nil {
+		return err
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	name := filepath.Join(o.dir, fmt.Sprintf("%s-%s.eml", now.Format("20060102T150405.000000000"), hex.EncodeToString(suffix)))
+	if err := os.WriteFile(name, msg, 0600); err != nil {
+		return err
+	}
+	logger.Info("Mail written to outbox", "file", name)
+	return nil
+}
+
+// NewOutboxMailer returns mailer writing messages to dir, creating it if needed
+func NewOutboxMailer(dir, from string) (repository.Mailer, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &outboxMailer{dir: dir, from: from}, nil
+}
@@ -0,0 +1,45 @@
+package mail
+
+import (
+	"auth/domain"
+	"auth/user/repository"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+)
+
+type smtpMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+func (s *smtpMailer) Send(m *domain.Mail) error {
+	msg, err := buildMessage(s.from, m, time.Now())
+	if err != nil {
+		return err
+	}
+	from, err := mail.ParseAddress(s.from)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(s.addr, s.auth, from.Address, []string{m.To}, msg)
+}
+
+// NewSMTPMailer returns mailer sending through SMTP server at addr, PLAIN auth is used when username is set
+func NewSMTPMailer(addr, username, password, from string) (repository.Mailer, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("SMTP_ADDR: %w", err)
+	}
+	if _, err := mail.ParseAddress(from); err != nil {
+		return nil, fmt.Errorf("MAIL_FROM: %w", err)
+	}
+	mailer := &smtpMailer{addr: addr, from: from}
+	if username != "" {
+		mailer.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return mailer, nil
+}
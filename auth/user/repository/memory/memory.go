@@ -0,0 +1,289 @@
+package memory
+
+import (
+	"auth/domain"
+	"auth/myerrors"
+	"auth/user/repository"
+	"sync"
+	"time"
+)
+
+type sessionEntry struct {
+	session domain.Session
+	token   string
+	expires time.Time
+	// previous is the token rotated out at rotatedAt
+	previous  string
+	rotatedAt time.Time
+}
+
+type resetEntry struct {
+	IIN     string
+	expires time.Time
+}
+
+type authCodeEntry struct {
+	code    domain.AuthCode
+	expires time.Time
+}
+
+type attemptsEntry struct {
+	attempts domain.LoginAttempts
+	expires  time.Time
+}
+
+// memoryCacheInterface keeps sessions, denylist and login counters in process memory.
+// It is meant for tests and single instance local runs
+type memoryCacheInterface struct {
+	mu           sync.Mutex
+	now          func() time.Time
+	sessions     map[string]*sessionEntry
+	userSessions map[string]map[string]struct{}
+	denied       map[string]time.Time
+	attempts     map[string]*attemptsEntry
+	resets       map[string]*resetEntry
+	userResets   map[string]string
+	authCodes    map[string]*authCodeEntry
+}
+
+func (m *memoryCacheInterface) InsertSession(session *domain.Session, token string, refreshTtl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[session.ID] = &sessionEntry{
+		session: *session,
+		token:   token,
+		expires: m.now().Add(refreshTtl),
+	}
+	if m.userSessions[session.IIN] == nil {
+		m.userSessions[session.IIN] = map[string]struct{}{}
+	}
+	m.userSessions[session.IIN][session.ID] = struct{}{}
+	return nil
+}
+
+// getSession returns live session entry, expired one is dropped. Must be called with mu held
+func (m *memoryCacheInterface) getSession(sessionID string) *sessionEntry {
+	entry, ok := m.sessions[sessionID]
+	if !ok {
+		return nil
+	}
+	if !m.now().Before(entry.expires) {
+		delete(m.sessions, sessionID)
+		delete(m.userSessions[entry.session.IIN], sessionID)
+		return nil
+	}
+	return entry
+}
+
+func (m *memoryCacheInterface) GetSession(sessionID string) (*domain.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry := m.getSession(sessionID)
+	if entry == nil {
+		return nil, myerrors.ErrSessionNotFound
+	}
+	session := entry.session
+	return &session, nil
+}
+
+func (m *memoryCacheInterface) GetSessionToken(sessionID string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry := m.getSession(sessionID)
+	if entry == nil {
+		return "", myerrors.ErrSessionNotFound
+	}
+	return entry.token, nil
+}
+
+func (m *memoryCacheInterface) ListSessions(IIN string) ([]domain.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sessions := make([]domain.Session, 0, len(m.userSessions[IIN]))
+	for ID := range m.userSessions[IIN] {
+		if entry := m.getSession(ID); entry != nil {
+			sessions = append(sessions, entry.session)
+		}
+	}
+	return sessions, nil
+}
+
+func (m *memoryCacheInterface) RotateToken(IIN, sessionID, oldToken, newToken string, refreshTtl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry := m.getSession(sessionID)
+	if entry == nil {
+		return myerrors.ErrRefreshNotFound
+	}
+	now := m.now()
+	if entry.token != oldToken {
+		if entry.previous == oldToken && !now.After(entry.rotatedAt.Add(repository.RotationGrace)) {
+			return myerrors.ErrTokenRotated
+		}
+		return myerrors.ErrTokenReused
+	}
+	entry.previous, entry.rotatedAt = oldToken, now
+	entry.token = newToken
+	entry.session.LastUsed = time.Unix(now.Unix(), 0)
+	entry.expires = now.Add(refreshTtl)
+	return nil
+}
+
+func (m *memoryCacheInterface) DeleteSession(IIN, sessionID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, sessionID)
+	delete(m.userSessions[IIN], sessionID)
+	return nil
+}
+
+func (m *memoryCacheInterface) DenyToken(jti string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.denied[jti] = m.now().Add(ttl)
+	return nil
+}
+
+func (m *memoryCacheInterface) IsTokenDenied(jti string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	expires, ok := m.denied[jti]
+	if ok && !m.now().Before(expires) {
+		delete(m.denied, jti)
+		return false, nil
+	}
+	return ok, nil
+}
+
+// getAttempts returns live login counter, creating it if needed. Must be called with mu held
+func (m *memoryCacheInterface) getAttempts(key string) *attemptsEntry {
+	entry, ok := m.attempts[key]
+	if !ok || !m.now().Before(entry.expires) {
+		entry = &attemptsEntry{}
+		m.attempts[key] = entry
+	}
+	return entry
+}
+
+func (m *memoryCacheInterface) AddLoginFailure(key string, ttl time.Duration) (*domain.LoginAttempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	entry := m.getAttempts(key)
+	entry.attempts.Failures++
+	entry.attempts.LastFailure = now
+	if expires := now.Add(ttl); expires.After(entry.expires) {
+		entry.expires = expires
+	}
+	attempts := entry.attempts
+	return &attempts, nil
+}
+
+func (m *memoryCacheInterface) LockLogin(key string, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry := m.getAttempts(key)
+	entry.attempts.LockedUntil = until
+	if until.After(entry.expires) {
+		entry.expires = until
+	}
+	return nil
+}
+
+func (m *memoryCacheInterface) GetLoginAttempts(key string) (*domain.LoginAttempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.attempts[key]
+	if !ok || !m.now().Before(entry.expires) {
+		delete(m.attempts, key)
+		return &domain.LoginAttempts{}, nil
+	}
+	attempts := entry.attempts
+	return &attempts, nil
+}
+
+func (m *memoryCacheInterface) ResetLoginAttempts(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.attempts, key)
+	return nil
+}
+
+func (m *memoryCacheInterface) InsertResetToken(IIN, tokenHash string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if previous, ok := m.userResets[IIN]; ok {
+		delete(m.resets, previous)
+	}
+	m.resets[tokenHash] = &resetEntry{IIN: IIN, expires: m.now().Add(ttl)}
+	m.userResets[IIN] = tokenHash
+	return nil
+}
+
+func (m *memoryCacheInterface) GetResetToken(tokenHash string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.resets[tokenHash]
+	if !ok || !m.now().Before(entry.expires) {
+		return "", myerrors.ErrResetTokenInvalid
+	}
+	return entry.IIN, nil
+}
+
+func (m *memoryCacheInterface) ConsumeResetToken(tokenHash string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.resets[tokenHash]
+	if !ok {
+		return "", myerrors.ErrResetTokenInvalid
+	}
+	delete(m.resets, tokenHash)
+	delete(m.userResets, entry.IIN)
+	if !m.now().Before(entry.expires) {
+		return "", myerrors.ErrResetTokenInvalid
+	}
+	return entry.IIN, nil
+}
+
+func (m *memoryCacheInterface) InsertAuthCode(codeHash string, code *domain.AuthCode, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.authCodes[codeHash] = &authCodeEntry{code: *code, expires: m.now().Add(ttl)}
+	return nil
+}
+
+func (m *memoryCacheInterface) ConsumeAuthCode(codeHash string) (*domain.AuthCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.authCodes[codeHash]
+	if !ok {
+		return nil, myerrors.ErrInvalidGrant
+	}
+	delete(m.authCodes, codeHash)
+	if !m.now().Before(entry.expires) {
+		return nil, myerrors.ErrInvalidGrant
+	}
+	code := entry.code
+	return &code, nil
+}
+
+// NewMemoryCacheInterface returns CacheInterface kept in process memory
+func NewMemoryCacheInterface() repository.CacheInterface {
+	return newMemoryCache(time.Now)
+}
+
+func newMemoryCache(now func() time.Time) *memoryCacheInterface {
+	return &memoryCacheInterface{
+		now:          now,
+		sessions:     map[string]*sessionEntry{},
+		userSessions: map[string]map[string]struct{}{},
+		denied:       map[string]time.Time{},
+		attempts:     map[string]*attemptsEntry{},
+		resets:       map[string]*resetEntry{},
+		userResets:   map[string]string{},
+		authCodes:    map[string]*authCodeEntry{},
+	}
+}
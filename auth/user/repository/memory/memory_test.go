@@ -0,0 +1,191 @@
+package memory
+
+import (
+	"auth/domain"
+	"auth/myerrors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// clock is a manually advanced time source
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func newTestCache() (*memoryCacheInterface, *clock) {
+	c := &clock{now: time.Unix(1640024899, 0)}
+	return newMemoryCache(c.Now), c
+}
+
+func newTestSession(ID, IIN string, now time.Time) *domain.Session {
+	return &domain.Session{ID: ID, IIN: IIN, UserAgent: "test-agent", IP: "127.0.0.1", CreatedAt: now, LastUsed: now}
+}
+
+func TestSessions(t *testing.T) {
+	m, c := newTestCache()
+	IIN := "910815450350"
+	assert.NoError(t, m.InsertSession(newTestSession("laptop", IIN, c.now), "first", time.Minute))
+	assert.NoError(t, m.InsertSession(newTestSession("phone", IIN, c.now), "second", 2*time.Minute))
+
+	session, err := m.GetSession("laptop")
+	assert.NoError(t, err)
+	assert.Equal(t, newTestSession("laptop", IIN, c.now), session)
+
+	sessions, err := m.ListSessions(IIN)
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 2)
+
+	// laptop session expires
+	c.now = c.now.Add(90 * time.Second)
+	_, err = m.GetSession("laptop")
+	assert.Equal(t, myerrors.ErrSessionNotFound, err)
+	sessions, err = m.ListSessions(IIN)
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 1)
+
+	assert.NoError(t, m.DeleteSession(IIN, "phone"))
+	sessions, err = m.ListSessions(IIN)
+	assert.NoError(t, err)
+	assert.Empty(t, sessions)
+}
+
+func TestRotateToken(t *testing.T) {
+	m, c := newTestCache()
+	IIN, sessionID := "980124450084", "rotate"
+	assert.NoError(t, m.InsertSession(newTestSession(sessionID, IIN, c.now), "first", time.Minute))
+
+	c.now = c.now.Add(50 * time.Second)
+	assert.NoError(t, m.RotateToken(IIN, sessionID, "first", "second", time.Minute))
+	token, err := m.GetSessionToken(sessionID)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", token)
+	// concurrent request with the previous token gets no tokens but keeps the session
+	assert.Equal(t, myerrors.ErrTokenRotated, m.RotateToken(IIN, sessionID, "first", "other", time.Minute))
+	token, err = m.GetSessionToken(sessionID)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", token)
+	// rotation extends session
+	c.now = c.now.Add(50 * time.Second)
+	session, err := m.GetSession(sessionID)
+	assert.NoError(t, err)
+	assert.Equal(t, c.now.Add(-50*time.Second), session.LastUsed)
+
+	// previous token after the grace
+	assert.Equal(t, myerrors.ErrTokenReused, m.RotateToken(IIN, sessionID, "first", "third", time.Minute))
+	// older tokens are reused whenever they come
+	assert.NoError(t, m.RotateToken(IIN, sessionID, "second", "third", time.Minute))
+	assert.NoError(t, m.RotateToken(IIN, sessionID, "third", "fourth", time.Minute))
+	assert.Equal(t, myerrors.ErrTokenReused, m.RotateToken(IIN, sessionID, "second", "fifth", time.Minute))
+	assert.NoError(t, m.DeleteSession(IIN, sessionID))
+	assert.Equal(t, myerrors.ErrRefreshNotFound, m.RotateToken(IIN, sessionID, "fourth", "fifth", time.Minute))
+	_, err = m.GetSessionToken(sessionID)
+	assert.Equal(t, myerrors.ErrSessionNotFound, err)
+}
+
+func TestDenyToken(t *testing.T) {
+	m, c := newTestCache()
+	assert.NoError(t, m.DenyToken("jti", time.Minute))
+	assert.NoError(t, m.DenyToken("expired", -time.Second))
+
+	denied, err := m.IsTokenDenied("jti")
+	assert.NoError(t, err)
+	assert.True(t, denied)
+	denied, err = m.IsTokenDenied("expired")
+	assert.NoError(t, err)
+	assert.False(t, denied)
+
+	c.now = c.now.Add(time.Minute)
+	denied, err = m.IsTokenDenied("jti")
+	assert.NoError(t, err)
+	assert.False(t, denied)
+}
+
+func TestLoginAttempts(t *testing.T) {
+	m, c := newTestCache()
+	for i := 1; i <= 3; i++ {
+		attempts, err := m.AddLoginFailure("user:a", time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, i, attempts.Failures)
+	}
+	assert.NoError(t, m.LockLogin("user:a", c.now.Add(time.Hour)))
+	attempts, err := m.GetLoginAttempts("user:a")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts.Failures)
+	assert.True(t, attempts.Locked(c.now))
+
+	// counter is kept while locked
+	c.now = c.now.Add(30 * time.Minute)
+	attempts, err = m.GetLoginAttempts("user:a")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts.Failures)
+
+	c.now = c.now.Add(time.Hour)
+	attempts, err = m.GetLoginAttempts("user:a")
+	assert.NoError(t, err)
+	assert.Equal(t, &domain.LoginAttempts{}, attempts)
+
+	_, err = m.AddLoginFailure("user:b", time.Minute)
+	assert.NoError(t, err)
+	assert.NoError(t, m.ResetLoginAttempts("user:b"))
+	attempts, err = m.GetLoginAttempts("user:b")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, attempts.Failures)
+}
+
+func TestResetTokens(t *testing.T) {
+	m, c := newTestCache()
+	_, err := m.ConsumeResetToken("unknown")
+	assert.Equal(t, myerrors.ErrResetTokenInvalid, err)
+
+	assert.NoError(t, m.InsertResetToken("1", "first", time.Minute))
+	// newer token replaces the earlier one
+	assert.NoError(t, m.InsertResetToken("1", "second", time.Minute))
+	_, err = m.ConsumeResetToken("first")
+	assert.Equal(t, myerrors.ErrResetTokenInvalid, err)
+
+	IIN, err := m.GetResetToken("second")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", IIN)
+	_, err = m.GetResetToken("first")
+	assert.Equal(t, myerrors.ErrResetTokenInvalid, err)
+
+	IIN, err = m.ConsumeResetToken("second")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", IIN)
+	// token works only once
+	_, err = m.ConsumeResetToken("second")
+	assert.Equal(t, myerrors.ErrResetTokenInvalid, err)
+	_, err = m.GetResetToken("second")
+	assert.Equal(t, myerrors.ErrResetTokenInvalid, err)
+
+	assert.NoError(t, m.InsertResetToken("1", "third", time.Minute))
+	c.now = c.now.Add(time.Minute)
+	_, err = m.ConsumeResetToken("third")
+	assert.Equal(t, myerrors.ErrResetTokenInvalid, err)
+}
+
+func TestAuthCodes(t *testing.T) {
+	m, c := newTestCache()
+	_, err := m.ConsumeAuthCode("unknown")
+	assert.Equal(t, myerrors.ErrInvalidGrant, err)
+
+	code := &domain.AuthCode{ClientID: "client", IIN: "1", RedirectURI: "https://shop.example.com/cb", CodeChallenge: "challenge"}
+	assert.NoError(t, m.InsertAuthCode("first", code, time.Minute))
+	res, err := m.ConsumeAuthCode("first")
+	assert.NoError(t, err)
+	assert.Equal(t, code, res)
+	// code works only once
+	_, err = m.ConsumeAuthCode("first")
+	assert.Equal(t, myerrors.ErrInvalidGrant, err)
+
+	assert.NoError(t, m.InsertAuthCode("second", code, time.Minute))
+	c.now = c.now.Add(time.Minute)
+	_, err = m.ConsumeAuthCode("second")
+	assert.Equal(t, myerrors.ErrInvalidGrant, err)
+}
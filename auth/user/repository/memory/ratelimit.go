@@ -0,0 +1,45 @@
+package memory
+
+import (
+	"auth/user/repository"
+	"sync"
+	"time"
+)
+
+// memoryRateLimiter keeps request timestamps per key in process memory
+type memoryRateLimiter struct {
+	mu       sync.Mutex
+	now      func() time.Time
+	requests map[string][]time.Time
+}
+
+func (m *memoryRateLimiter) Allow(key string, limit int, window time.Duration) (bool, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	requests := m.requests[key]
+	// drop requests that left the window
+	i := 0
+	for i < len(requests) && !requests[i].After(now.Add(-window)) {
+		i++
+	}
+	requests = requests[i:]
+	if len(requests) >= limit {
+		m.requests[key] = requests
+		return false, requests[0].Add(window).Sub(now), nil
+	}
+	m.requests[key] = append(requests, now)
+	return true, 0, nil
+}
+
+// NewMemoryRateLimiter returns RateLimiter for a single app instance
+func NewMemoryRateLimiter() repository.RateLimiter {
+	return newMemoryRateLimiter(time.Now)
+}
+
+func newMemoryRateLimiter(now func() time.Time) *memoryRateLimiter {
+	return &memoryRateLimiter{
+		now:      now,
+		requests: map[string][]time.Time{},
+	}
+}
@@ -0,0 +1,33 @@
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	c := &clock{now: time.Unix(1640024899, 0)}
+	m := newMemoryRateLimiter(c.Now)
+	for i := 0; i < 3; i++ {
+		allowed, _, err := m.Allow("login:ip:127.0.0.1", 3, time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, allowed)
+		c.now = c.now.Add(10 * time.Second)
+	}
+	allowed, retryAfter, err := m.Allow("login:ip:127.0.0.1", 3, time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, 30*time.Second, retryAfter)
+
+	allowed, _, err = m.Allow("login:ip:127.0.0.2", 3, time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+
+	// first request leaves the window
+	c.now = c.now.Add(30 * time.Second)
+	allowed, _, err = m.Allow("login:ip:127.0.0.1", 3, time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+}
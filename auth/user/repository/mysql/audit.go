@@ -0,0 +1,82 @@
+package mysql
+
+import (
+	"auth/domain"
+	"auth/user/repository"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// maxUserAgent is size of audit_log.user_agent column
+const maxUserAgent = 512
+
+type mySQLAuditRepository struct {
+	db *sql.DB
+}
+
+// RecordEvent stores audit event, overlong user agent is cut to fit the column
+func (m *mySQLAuditRepository) RecordEvent(event *domain.AuditEvent) error {
+	userAgent := event.UserAgent
+	if len(userAgent) > maxUserAgent {
+		userAgent = userAgent[:maxUserAgent]
+	}
+	_, err := m.db.Exec("insert into audit_log (actor_iin, action, target, ip, user_agent, outcome) values(?, ?, ?, ?, ?, ?)",
+		event.ActorIIN, event.Action, event.Target, event.IP, userAgent, event.Outcome)
+	return err
+}
+
+// ListEvents returns page of events matching filter newest first and total number of matching events
+func (m *mySQLAuditRepository) ListEvents(filter *domain.AuditFilter) ([]domain.AuditEvent, int, error) {
+	where, args := auditWhere(filter)
+	var total int
+	if err := m.db.QueryRow("select count(*) from audit_log"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := m.db.Query("select id, ts, actor_iin, action, target, ip, user_agent, outcome from audit_log"+where+" order by id desc limit ? offset ?",
+		append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	events := []domain.AuditEvent{}
+	for rows.Next() {
+		var event domain.AuditEvent
+		if err := rows.Scan(&event.ID, &event.Ts, &event.ActorIIN, &event.Action, &event.Target, &event.IP, &event.UserAgent, &event.Outcome); err != nil {
+			return nil, 0, err
+		}
+		events = append(events, event)
+	}
+	return events, total, rows.Err()
+}
+
+// auditWhere makes where clause of filter, To date is included whole
+func auditWhere(filter *domain.AuditFilter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition, value string) {
+		if value != "" {
+			conditions = append(conditions, condition)
+			args = append(args, value)
+		}
+	}
+	add("actor_iin=?", filter.ActorIIN)
+	add("action=?", filter.Action)
+	add("target=?", filter.Target)
+	add("outcome=?", filter.Outcome)
+	add("ts>=?", filter.From)
+	add("ts<date_add(?, interval 1 day)", filter.To)
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " where " + strings.Join(conditions, " and "), args
+}
+
+// NewMySQLAuditRepository returns AuditRepository sharing connections of dbConn made by NewMySQLDBInterface
+func NewMySQLAuditRepository(dbConn repository.DBInterface) (repository.AuditRepository, error) {
+	m, ok := dbConn.(*mySQLDBInterface)
+	if !ok {
+		return nil, fmt.Errorf("audit log needs MySQL connection, got %T", dbConn)
+	}
+	return &mySQLAuditRepository{db: m.db}, nil
+}
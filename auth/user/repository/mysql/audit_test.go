@@ -0,0 +1,88 @@
+package mysql
+
+import (
+	"auth/domain"
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordEvent(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+	repo := &mySQLAuditRepository{db}
+
+	query := "insert into audit_log (actor_iin, action, target, ip, user_agent, outcome) values(?, ?, ?, ?, ?, ?)"
+	event := &domain.AuditEvent{ActorIIN: u.IIN, Action: domain.AuditLogin, Target: "user", IP: "10.0.0.1", UserAgent: "curl", Outcome: domain.AuditSuccess}
+
+	mock.ExpectExec(query).WithArgs(u.IIN, "login", "user", "10.0.0.1", "curl", "success").WillReturnResult(sqlmock.NewResult(1, 1))
+	assert.NoError(t, repo.RecordEvent(event))
+
+	// user agent is cut to fit the column
+	long := &domain.AuditEvent{Action: domain.AuditLogin, UserAgent: strings.Repeat("a", maxUserAgent+10), Outcome: domain.AuditFailure}
+	mock.ExpectExec(query).WithArgs("", "login", "", "", strings.Repeat("a", maxUserAgent), "failure").WillReturnResult(sqlmock.NewResult(2, 1))
+	assert.NoError(t, repo.RecordEvent(long))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	db.Close()
+	assert.EqualError(t, repo.RecordEvent(event), "sql: database is closed")
+}
+
+func TestListEvents(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+	repo := &mySQLAuditRepository{db}
+
+	columns := []string{"id", "ts", "actor_iin", "action", "target", "ip", "user_agent", "outcome"}
+	row := []driver.Value{5, "2022-01-13 19:45:20", u.IIN, "topup", "KZT0000000001", "10.0.0.1", "curl", "success"}
+	expected := []domain.AuditEvent{{ID: 5, Ts: "2022-01-13 19:45:20", ActorIIN: u.IIN, Action: "topup", Target: "KZT0000000001", IP: "10.0.0.1", UserAgent: "curl", Outcome: "success"}}
+
+	// no filter
+	mock.ExpectQuery("select count(*) from audit_log").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery("select id, ts, actor_iin, action, target, ip, user_agent, outcome from audit_log order by id desc limit ? offset ?").
+		WithArgs(50, 0).WillReturnRows(sqlmock.NewRows(columns).AddRow(row...))
+	events, total, err := repo.ListEvents(&domain.AuditFilter{Limit: 50})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, expected, events)
+
+	// every filter
+	where := " where actor_iin=? and action=? and target=? and outcome=? and ts>=? and ts<date_add(?, interval 1 day)"
+	filter := &domain.AuditFilter{ActorIIN: u.IIN, Action: "topup", Target: "KZT0000000001", Outcome: "success", From: "2022-01-01", To: "2022-01-31", Limit: 10, Offset: 20}
+	args := []driver.Value{u.IIN, "topup", "KZT0000000001", "success", "2022-01-01", "2022-01-31"}
+	mock.ExpectQuery("select count(*) from audit_log" + where).WithArgs(args...).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(21))
+	mock.ExpectQuery("select id, ts, actor_iin, action, target, ip, user_agent, outcome from audit_log" + where + " order by id desc limit ? offset ?").
+		WithArgs(append(args, 10, 20)...).WillReturnRows(sqlmock.NewRows(columns).AddRow(row...))
+	events, total, err = repo.ListEvents(filter)
+	assert.NoError(t, err)
+	assert.Equal(t, 21, total)
+	assert.Equal(t, expected, events)
+
+	// only some filters
+	mock.ExpectQuery("select count(*) from audit_log where action=? and ts>=?").WithArgs("login", "2022-01-01").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery("select id, ts, actor_iin, action, target, ip, user_agent, outcome from audit_log where action=? and ts>=? order by id desc limit ? offset ?").
+		WithArgs("login", "2022-01-01", 50, 0).WillReturnRows(sqlmock.NewRows(columns))
+	events, total, err = repo.ListEvents(&domain.AuditFilter{Action: "login", From: "2022-01-01", Limit: 50})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, total)
+	assert.Equal(t, []domain.AuditEvent{}, events)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	db.Close()
+	_, _, err = repo.ListEvents(filter)
+	assert.EqualError(t, err, "sql: database is closed")
+}
+
+func TestNewMySQLAuditRepository(t *testing.T) {
+	db, _ := NewMock()
+	defer db.Close()
+	repo, err := NewMySQLAuditRepository(&mySQLDBInterface{db})
+	assert.NoError(t, err)
+	assert.NotNil(t, repo)
+
+	_, err = NewMySQLAuditRepository(nil)
+	assert.Error(t, err)
+}
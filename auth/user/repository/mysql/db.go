@@ -2,12 +2,13 @@ package mysql
 
 import (
 	"auth/domain"
+	"auth/logger"
 	"auth/myerrors"
 	"auth/user/repository"
 	"database/sql"
 	"errors"
-	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
@@ -19,44 +20,186 @@ type mySQLDBInterface struct {
 
 func (m *mySQLDBInterface) GetUser(username string) (*domain.User, error) {
 	user := new(domain.User)
-	err := m.db.QueryRow("select * from users where username=?", username).Scan(&user.ID, &user.Ts, &user.IIN, &user.Username, &user.Password)
+	err := m.db.QueryRow("select id, ts, iin, username, password, locked, email from users where username=?", username).Scan(&user.ID, &user.Ts, &user.IIN, &user.Username, &user.Password, &user.Locked, &user.Email)
 	if err == sql.ErrNoRows {
 		return user, myerrors.ErrUserNotFound
 	}
 	return user, err
 }
 
-func (m *mySQLDBInterface) AddUser(IIN, username, password string) error {
+// DefaultRole is assigned to every new user
+const DefaultRole = "user"
+
+func (m *mySQLDBInterface) AddUser(IIN, username, password, email string) error {
 	if IIN == "" || username == "" || password == "" {
 		return myerrors.ErrInvalidInput
 	}
-	insForm, err := m.db.Prepare("insert into users (iin, username, password) values(?, ?, ?)")
+	tx, err := m.db.Begin()
 	if err != nil {
 		return err
 	}
-	_, err = insForm.Exec(IIN, username, password)
+	res, err := tx.Exec("insert into users (iin, username, password, email) values(?, ?, ?, ?)", IIN, username, password, email)
 	var mysqlErr *mysql.MySQLError
 	if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+		tx.Rollback()
 		return myerrors.ErrDuplicateUser
 	}
-	return err
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	userID, err := res.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec("insert into user_roles (user_id, role_id) select ?, id from roles where name=?", userID, DefaultRole); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *mySQLDBInterface) GetUserRoles(userID int) ([]string, []string, error) {
+	rows, err := m.db.Query("select r.name, p.name from user_roles ur join roles r on r.id = ur.role_id left join role_permissions rp on rp.role_id = r.id left join permissions p on p.id = rp.permission_id where ur.user_id=? order by r.name, p.name", userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	roles, permissions := []string{}, []string{}
+	seen := map[string]bool{}
+	for rows.Next() {
+		var role string
+		var permission sql.NullString
+		if err := rows.Scan(&role, &permission); err != nil {
+			return nil, nil, err
+		}
+		if !seen["role:"+role] {
+			seen["role:"+role] = true
+			roles = append(roles, role)
+		}
+		if permission.Valid && !seen["permission:"+permission.String] {
+			seen["permission:"+permission.String] = true
+			permissions = append(permissions, permission.String)
+		}
+	}
+	return roles, permissions, rows.Err()
 }
 
 func (m *mySQLDBInterface) GetUserByIIN(IIN string) (*domain.User, error) {
 	user := new(domain.User)
-	err := m.db.QueryRow("select * from users where iin=?", IIN).Scan(&user.ID, &user.Ts, &user.IIN, &user.Username, &user.Password)
+	err := m.db.QueryRow("select id, ts, iin, username, password, locked, email from users where iin=?", IIN).Scan(&user.ID, &user.Ts, &user.IIN, &user.Username, &user.Password, &user.Locked, &user.Email)
 	if err == sql.ErrNoRows {
 		return user, myerrors.ErrUserNotFound
 	}
 	return user, err
 }
 
+// SearchUsers finds users whose username or IIN starts with query
+func (m *mySQLDBInterface) SearchUsers(query string, limit int) ([]domain.User, error) {
+	pattern := escapeLike(query) + "%"
+	rows, err := m.db.Query("select id, ts, iin, username, locked from users where username like ? or iin like ? order by username limit ?", pattern, pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := []domain.User{}
+	for rows.Next() {
+		var user domain.User
+		if err := rows.Scan(&user.ID, &user.Ts, &user.IIN, &user.Username, &user.Locked); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// UpdatePassword sets new password hash of the user
+func (m *mySQLDBInterface) UpdatePassword(IIN, password string) error {
+	if password == "" {
+		return myerrors.ErrInvalidInput
+	}
+	res, err := m.db.Exec("update users set password=?, ts=ts where iin=?", password, IIN)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return myerrors.ErrUserNotFound
+	}
+	return err
+}
+
+// UpdateUsername renames the user, ErrDuplicateUser is returned if the username is taken
+func (m *mySQLDBInterface) UpdateUsername(IIN, username string) error {
+	if username == "" {
+		return myerrors.ErrInvalidInput
+	}
+	res, err := m.db.Exec("update users set username=?, ts=ts where iin=?", username, IIN)
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+		return myerrors.ErrDuplicateUser
+	}
+	if err != nil {
+		return err
+	}
+	// unchanged username affects no rows too
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		if _, err := m.GetUserByIIN(IIN); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetUserLocked locks or unlocks user account, creation time is kept as is
+func (m *mySQLDBInterface) SetUserLocked(IIN string, locked bool) error {
+	res, err := m.db.Exec("update users set locked=?, ts=ts where iin=?", locked, IIN)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		if _, err := m.GetUserByIIN(IIN); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordAdminAction stores who did what to which user
+func (m *mySQLDBInterface) RecordAdminAction(action *domain.AdminAction) error {
+	_, err := m.db.Exec("insert into admin_actions (admin_iin, action, target_iin, details) values(?, ?, ?, ?)", action.AdminIIN, action.Action, action.TargetIIN, action.Details)
+	return err
+}
+
+// ListAdminActions returns latest admin actions on the user
+func (m *mySQLDBInterface) ListAdminActions(targetIIN string, limit int) ([]domain.AdminAction, error) {
+	rows, err := m.db.Query("select id, ts, admin_iin, action, target_iin, details from admin_actions where target_iin=? order by id desc limit ?", targetIIN, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	actions := []domain.AdminAction{}
+	for rows.Next() {
+		var action domain.AdminAction
+		if err := rows.Scan(&action.ID, &action.Ts, &action.AdminIIN, &action.Action, &action.TargetIIN, &action.Details); err != nil {
+			return nil, err
+		}
+		actions = append(actions, action)
+	}
+	return actions, rows.Err()
+}
+
+// escapeLike escapes LIKE wildcards in user input
+func escapeLike(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(s)
+}
+
 func NewMySQLDBInterface() (repository.DBInterface, error) {
 	db, err := sql.Open("mysql", os.Getenv("DATA_SOURCE"))
 	if err != nil {
 		return nil, err
 	}
-	log.Println("INFO|Success in opening DB")
+	logger.Info("Success in opening DB")
 	db.SetMaxOpenConns(25)
 	db.SetMaxIdleConns(25)
 	db.SetConnMaxLifetime(time.Minute * 5)
@@ -64,11 +207,11 @@ func NewMySQLDBInterface() (repository.DBInterface, error) {
 	start := time.Now()
 	for db.Ping() != nil {
 		if time.Now().After(start.Add(time.Minute * 20)) {
-			log.Println("ERROR|Failed to connect after 20 minutes")
+			logger.Error("Failed to connect after 20 minutes")
 			return nil, db.Ping()
 		}
 	}
-	log.Println("INFO|DB Pong", db.Ping() == nil)
+	logger.Info("DB connected")
 	return &mySQLDBInterface{db: db}, nil
 }
 
@@ -2,12 +2,14 @@ package mysql
 
 import (
 	"auth/domain"
+	"auth/myerrors"
 	"database/sql"
 	"fmt"
 	"log"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -26,6 +28,7 @@ var u = &domain.User{
 	IIN:      "910815450350",
 	Username: "user",
 	Password: "password",
+	Email:    "user@example.com",
 }
 
 var empty_u = &domain.User{}
@@ -61,10 +64,10 @@ func TestGetUser(t *testing.T) {
 	defer db.Close()
 	repo := &mySQLDBInterface{db}
 
-	query := "select * from users where username=?"
+	query := "select id, ts, iin, username, password, locked, email from users where username=?"
 
-	rows := sqlmock.NewRows([]string{"id", "ts", "iin", "username", "password"}).
-		AddRow(u.ID, u.Ts, u.IIN, u.Username, u.Password)
+	rows := sqlmock.NewRows([]string{"id", "ts", "iin", "username", "password", "locked", "email"}).
+		AddRow(u.ID, u.Ts, u.IIN, u.Username, u.Password, false, u.Email)
 
 	mock.ExpectQuery(query).WithArgs(u.Username).WillReturnRows(rows)
 	user, err := repo.GetUser(u.Username)
@@ -77,8 +80,8 @@ func TestGetUserError(t *testing.T) {
 	defer db.Close()
 	repo := &mySQLDBInterface{db}
 
-	query := "select * from users where username=?"
-	rows := sqlmock.NewRows([]string{"id", "ts", "iin", "username", "password"})
+	query := "select id, ts, iin, username, password, locked, email from users where username=?"
+	rows := sqlmock.NewRows([]string{"id", "ts", "iin", "username", "password", "locked", "email"})
 	for _, tt := range getTestTable {
 		if tt.closeDb {
 			db.Close()
@@ -105,13 +108,35 @@ func TestAddUser(t *testing.T) {
 	defer db.Close()
 	repo := &mySQLDBInterface{db}
 
-	query := "insert into users (iin, username, password) values(?, ?, ?)"
+	query := "insert into users (iin, username, password, email) values(?, ?, ?, ?)"
+	roleQuery := "insert into user_roles (user_id, role_id) select ?, id from roles where name=?"
 
-	prep := mock.ExpectPrepare(query)
-	prep.ExpectExec().WithArgs(u.IIN, u.Username, u.Password).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	mock.ExpectExec(query).WithArgs(u.IIN, u.Username, u.Password, u.Email).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(roleQuery).WithArgs(1, DefaultRole).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
 
-	err := repo.AddUser(u.IIN, u.Username, u.Password)
+	err := repo.AddUser(u.IIN, u.Username, u.Password, u.Email)
 	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAddUserRoleError(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+	repo := &mySQLDBInterface{db}
+
+	query := "insert into users (iin, username, password, email) values(?, ?, ?, ?)"
+	roleQuery := "insert into user_roles (user_id, role_id) select ?, id from roles where name=?"
+
+	mock.ExpectBegin()
+	mock.ExpectExec(query).WithArgs(u.IIN, u.Username, u.Password, u.Email).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(roleQuery).WithArgs(1, DefaultRole).WillReturnError(sql.ErrConnDone)
+	mock.ExpectRollback()
+
+	err := repo.AddUser(u.IIN, u.Username, u.Password, u.Email)
+	assert.EqualError(t, err, sql.ErrConnDone.Error())
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
 func TestAddUserError(t *testing.T) {
@@ -120,31 +145,55 @@ func TestAddUserError(t *testing.T) {
 	defer db.Close()
 	repo := &mySQLDBInterface{db}
 	user = empty_u
-	query := "insert into users (iin, username, password) values(?, ?, ?)"
+	query := "insert into users (iin, username, password, email) values(?, ?, ?, ?)"
 	for _, tt := range addTestTable {
 		fmt.Println("Running AddUser:", tt.name, "******************************************************************************************************")
 		if tt.closeDb {
 			db.Close()
 			user = u
 		}
-		prep := mock.ExpectPrepare(query)
-		prep.ExpectExec().WithArgs(user.IIN, user.Username, user.Password).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectBegin()
+		mock.ExpectExec(query).WithArgs(user.IIN, user.Username, user.Password, user.Email).WillReturnResult(sqlmock.NewResult(0, 0))
 
-		err := repo.AddUser(user.IIN, user.Username, user.Password)
+		err := repo.AddUser(user.IIN, user.Username, user.Password, user.Email)
 		assert.EqualError(t, err, tt.ErrMessage)
 	}
 
 }
 
+func TestGetUserRoles(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+	repo := &mySQLDBInterface{db}
+
+	query := "select r.name, p.name from user_roles ur join roles r on r.id = ur.role_id left join role_permissions rp on rp.role_id = r.id left join permissions p on p.id = rp.permission_id where ur.user_id=? order by r.name, p.name"
+
+	rows := sqlmock.NewRows([]string{"role", "permission"}).
+		AddRow("admin", "users:read").
+		AddRow("admin", "users:write").
+		AddRow("support", "users:read").
+		AddRow("guest", nil)
+
+	mock.ExpectQuery(query).WithArgs(u.ID).WillReturnRows(rows)
+	roles, permissions, err := repo.GetUserRoles(u.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"admin", "support", "guest"}, roles)
+	assert.Equal(t, []string{"users:read", "users:write"}, permissions)
+
+	db.Close()
+	_, _, err = repo.GetUserRoles(u.ID)
+	assert.EqualError(t, err, "sql: database is closed")
+}
+
 func TestGetUserByIIN(t *testing.T) {
 	db, mock := NewMock()
 	defer db.Close()
 	repo := &mySQLDBInterface{db}
 
-	query := "select * from users where iin=?"
+	query := "select id, ts, iin, username, password, locked, email from users where iin=?"
 
-	rows := sqlmock.NewRows([]string{"id", "ts", "iin", "username", "password"}).
-		AddRow(u.ID, u.Ts, u.IIN, u.Username, u.Password)
+	rows := sqlmock.NewRows([]string{"id", "ts", "iin", "username", "password", "locked", "email"}).
+		AddRow(u.ID, u.Ts, u.IIN, u.Username, u.Password, false, u.Email)
 
 	mock.ExpectQuery(query).WithArgs(u.IIN).WillReturnRows(rows)
 	user, err := repo.GetUserByIIN(u.IIN)
@@ -157,9 +206,9 @@ func TestGetUserByIINError(t *testing.T) {
 	defer db.Close()
 	repo := &mySQLDBInterface{db}
 
-	query := "select * from users where iin=?"
+	query := "select id, ts, iin, username, password, locked, email from users where iin=?"
 
-	rows := sqlmock.NewRows([]string{"id", "ts", "iin", "username", "password"})
+	rows := sqlmock.NewRows([]string{"id", "ts", "iin", "username", "password", "locked", "email"})
 	for _, tt := range getTestTable {
 		fmt.Println("Running GetUserByIIN:", tt.name, "******************************************************************************************************")
 		if tt.closeDb {
@@ -184,3 +233,119 @@ func TestGetUserByIINError(t *testing.T) {
 	// assert.Empty(t, user)
 	// assert.Error(t, err, err.Error())
 }
+
+func TestSearchUsers(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+	repo := &mySQLDBInterface{db}
+
+	query := "select id, ts, iin, username, locked from users where username like ? or iin like ? order by username limit ?"
+
+	rows := sqlmock.NewRows([]string{"id", "ts", "iin", "username", "locked"}).
+		AddRow(u.ID, u.Ts, u.IIN, u.Username, true)
+
+	mock.ExpectQuery(query).WithArgs("us\\_er%", "us\\_er%", 20).WillReturnRows(rows)
+	users, err := repo.SearchUsers("us_er", 20)
+	assert.NoError(t, err)
+	assert.Len(t, users, 1)
+	assert.True(t, users[0].Locked)
+
+	db.Close()
+	_, err = repo.SearchUsers("us_er", 20)
+	assert.EqualError(t, err, "sql: database is closed")
+}
+
+func TestUpdatePassword(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+	repo := &mySQLDBInterface{db}
+
+	query := "update users set password=?, ts=ts where iin=?"
+
+	mock.ExpectExec(query).WithArgs("newhash", u.IIN).WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, repo.UpdatePassword(u.IIN, "newhash"))
+
+	mock.ExpectExec(query).WithArgs("newhash", "nonexistent").WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.EqualError(t, repo.UpdatePassword("nonexistent", "newhash"), "user not found")
+
+	assert.EqualError(t, repo.UpdatePassword(u.IIN, ""), "invalid input")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateUsername(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+	repo := &mySQLDBInterface{db}
+
+	query := "update users set username=?, ts=ts where iin=?"
+	getQuery := "select id, ts, iin, username, password, locked, email from users where iin=?"
+	columns := []string{"id", "ts", "iin", "username", "password", "locked", "email"}
+
+	mock.ExpectExec(query).WithArgs("renamed", u.IIN).WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, repo.UpdateUsername(u.IIN, "renamed"))
+
+	// same username
+	mock.ExpectExec(query).WithArgs(u.Username, u.IIN).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(getQuery).WithArgs(u.IIN).WillReturnRows(sqlmock.NewRows(columns).
+		AddRow(u.ID, u.Ts, u.IIN, u.Username, u.Password, false, u.Email))
+	assert.NoError(t, repo.UpdateUsername(u.IIN, u.Username))
+
+	// user doesn't exist
+	mock.ExpectExec(query).WithArgs("renamed", "nonexistent").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(getQuery).WithArgs("nonexistent").WillReturnRows(sqlmock.NewRows(columns))
+	assert.EqualError(t, repo.UpdateUsername("nonexistent", "renamed"), "user not found")
+
+	// username taken
+	mock.ExpectExec(query).WithArgs("taken", u.IIN).WillReturnError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"})
+	assert.Equal(t, myerrors.ErrDuplicateUser, repo.UpdateUsername(u.IIN, "taken"))
+
+	assert.EqualError(t, repo.UpdateUsername(u.IIN, ""), "invalid input")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSetUserLocked(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+	repo := &mySQLDBInterface{db}
+
+	query := "update users set locked=?, ts=ts where iin=?"
+	getQuery := "select id, ts, iin, username, password, locked, email from users where iin=?"
+
+	mock.ExpectExec(query).WithArgs(true, u.IIN).WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, repo.SetUserLocked(u.IIN, true))
+
+	// nothing changed but user exists
+	mock.ExpectExec(query).WithArgs(true, u.IIN).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(getQuery).WithArgs(u.IIN).WillReturnRows(sqlmock.NewRows([]string{"id", "ts", "iin", "username", "password", "locked", "email"}).
+		AddRow(u.ID, u.Ts, u.IIN, u.Username, u.Password, true, u.Email))
+	assert.NoError(t, repo.SetUserLocked(u.IIN, true))
+
+	// user doesn't exist
+	mock.ExpectExec(query).WithArgs(false, "nonexistent").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(getQuery).WithArgs("nonexistent").WillReturnRows(sqlmock.NewRows([]string{"id", "ts", "iin", "username", "password", "locked", "email"}))
+	assert.EqualError(t, repo.SetUserLocked("nonexistent", false), "user not found")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAdminActions(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+	repo := &mySQLDBInterface{db}
+
+	insertQuery := "insert into admin_actions (admin_iin, action, target_iin, details) values(?, ?, ?, ?)"
+	listQuery := "select id, ts, admin_iin, action, target_iin, details from admin_actions where target_iin=? order by id desc limit ?"
+	action := &domain.AdminAction{AdminIIN: "0", Action: domain.AdminActionLock, TargetIIN: u.IIN}
+
+	mock.ExpectExec(insertQuery).WithArgs(action.AdminIIN, action.Action, action.TargetIIN, action.Details).WillReturnResult(sqlmock.NewResult(1, 1))
+	assert.NoError(t, repo.RecordAdminAction(action))
+
+	rows := sqlmock.NewRows([]string{"id", "ts", "admin_iin", "action", "target_iin", "details"}).
+		AddRow(1, "2022-01-13 19:45:20", action.AdminIIN, action.Action, action.TargetIIN, action.Details)
+	mock.ExpectQuery(listQuery).WithArgs(u.IIN, 50).WillReturnRows(rows)
+	actions, err := repo.ListAdminActions(u.IIN, 50)
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.AdminAction{{ID: 1, Ts: "2022-01-13 19:45:20", AdminIIN: "0", Action: domain.AdminActionLock, TargetIIN: u.IIN}}, actions)
+
+	db.Close()
+	assert.EqualError(t, repo.RecordAdminAction(action), "sql: database is closed")
+}
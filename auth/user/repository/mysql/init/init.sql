@@ -5,6 +5,8 @@ CREATE TABLE IF NOT EXISTS `users`
     iin varchar(255) NOT NULL UNIQUE,
     username varchar(255) NOT NULL UNIQUE,
     password varchar(255) NOT NULL,
+    locked boolean NOT NULL DEFAULT FALSE,
+    email varchar(255) NOT NULL DEFAULT '',
     PRIMARY KEY (`id`)
 );
 
@@ -14,4 +16,119 @@ VALUES
     ('910815450350', 'a', '$2a$10$fygvHR0NpECM.rKeIWtSYuL6SNY8SZEs83jWiUji5LPFYzLT6MAdO', '2021-12-07 14:01:03'),
     ('601119400567', 'm', '$2a$10$fygvHR0NpECM.rKeIWtSYuL6SNY8SZEs83jWiUji5LPFYzLT6MAdO', '2021-12-08 16:56:29'),
     ('980124450084', 'r', '$2a$10$fygvHR0NpECM.rKeIWtSYuL6SNY8SZEs83jWiUji5LPFYzLT6MAdO', '2021-12-25 02:59:41'),
-    ('980124450072', 'mr', '$2a$10$fygvHR0NpECM.rKeIWtSYuL6SNY8SZEs83jWiUji5LPFYzLT6MAdO', '2022-01-13 19:45:20');
\ No newline at end of file
+    ('980124450072', 'mr', '$2a$10$fygvHR0NpECM.rKeIWtSYuL6SNY8SZEs83jWiUji5LPFYzLT6MAdO', '2022-01-13 19:45:20');
+
+CREATE TABLE IF NOT EXISTS `roles`
+(
+    id bigint auto_increment,
+    name varchar(64) NOT NULL UNIQUE,
+    PRIMARY KEY (`id`)
+);
+
+CREATE TABLE IF NOT EXISTS `permissions`
+(
+    id bigint auto_increment,
+    name varchar(64) NOT NULL UNIQUE,
+    PRIMARY KEY (`id`)
+);
+
+CREATE TABLE IF NOT EXISTS `role_permissions`
+(
+    role_id bigint NOT NULL,
+    permission_id bigint NOT NULL,
+    PRIMARY KEY (`role_id`, `permission_id`),
+    FOREIGN KEY (`role_id`) REFERENCES roles(`id`) ON DELETE CASCADE,
+    FOREIGN KEY (`permission_id`) REFERENCES permissions(`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS `user_roles`
+(
+    user_id bigint NOT NULL,
+    role_id bigint NOT NULL,
+    PRIMARY KEY (`user_id`, `role_id`),
+    FOREIGN KEY (`user_id`) REFERENCES users(`id`) ON DELETE CASCADE,
+    FOREIGN KEY (`role_id`) REFERENCES roles(`id`) ON DELETE CASCADE
+);
+
+INSERT INTO roles (`name`)
+VALUES ('user'), ('support'), ('admin');
+
+INSERT INTO permissions (`name`)
+VALUES ('wallets:read'), ('wallets:write'), ('users:read'), ('users:write'), ('clients:write'), ('audit:read');
+
+INSERT INTO role_permissions (`role_id`, `permission_id`)
+SELECT r.id, p.id FROM roles r JOIN permissions p
+ON (r.name = 'user' AND p.name IN ('wallets:read', 'wallets:write'))
+OR (r.name = 'support' AND p.name IN ('users:read'))
+OR (r.name = 'admin' AND p.name IN ('users:read', 'users:write', 'clients:write', 'audit:read'));
+
+INSERT INTO user_roles (`user_id`, `role_id`)
+SELECT u.id, r.id FROM users u JOIN roles r
+ON (u.username = 'admin' AND r.name = 'admin')
+OR (u.username <> 'admin' AND r.name = 'user');
+
+CREATE TABLE IF NOT EXISTS `admin_actions`
+(
+    id bigint auto_increment,
+    ts TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    admin_iin varchar(255) NOT NULL,
+    action varchar(64) NOT NULL,
+    target_iin varchar(255) NOT NULL,
+    details varchar(255) NOT NULL DEFAULT '',
+    PRIMARY KEY (`id`),
+    INDEX (`target_iin`)
+);
+
+-- actor_iin is empty when the actor is unknown, outcome is success, failure, error or mfa_required
+CREATE TABLE IF NOT EXISTS `audit_log`
+(
+    id bigint auto_increment,
+    ts TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    actor_iin varchar(255) NOT NULL DEFAULT '',
+    action varchar(64) NOT NULL,
+    target varchar(255) NOT NULL DEFAULT '',
+    ip varchar(64) NOT NULL DEFAULT '',
+    user_agent varchar(512) NOT NULL DEFAULT '',
+    outcome varchar(32) NOT NULL,
+    PRIMARY KEY (`id`),
+    INDEX (`ts`),
+    INDEX (`actor_iin`, `ts`),
+    INDEX (`action`, `ts`)
+);
+
+CREATE TABLE IF NOT EXISTS `user_mfa`
+(
+    user_id bigint NOT NULL,
+    secret varbinary(255) NOT NULL, -- AES-GCM encrypted TOTP secret
+    enabled boolean NOT NULL DEFAULT FALSE,
+    last_step bigint NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`user_id`),
+    FOREIGN KEY (`user_id`) REFERENCES users(`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS `mfa_recovery_codes`
+(
+    id bigint auto_increment,
+    user_id bigint NOT NULL,
+    code_hash char(64) NOT NULL,
+    used_at TIMESTAMP NULL DEFAULT NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE (`user_id`, `code_hash`),
+    FOREIGN KEY (`user_id`) REFERENCES users(`id`) ON DELETE CASCADE
+);
+
+-- applications users sign in to through /oauth/authorize, public clients have empty secret_hash
+CREATE TABLE IF NOT EXISTS `oauth_clients`
+(
+    id bigint auto_increment,
+    ts TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    client_id varchar(64) NOT NULL,
+    secret_hash varchar(64) NOT NULL DEFAULT '', -- SHA-256 hex of client secret
+    name varchar(255) NOT NULL,
+    redirect_uris text NOT NULL, -- one per line
+    scopes varchar(255) NOT NULL DEFAULT '', -- space separated
+    service BOOLEAN NOT NULL DEFAULT FALSE, -- machine client using client_credentials grant only
+    PRIMARY KEY (`id`),
+    UNIQUE (`client_id`)
+);
@@ -0,0 +1,89 @@
+package mysql
+
+import (
+	"auth/domain"
+	"auth/myerrors"
+	"database/sql"
+)
+
+func (m *mySQLDBInterface) GetMFA(userID int) (*domain.MFA, error) {
+	mfa := &domain.MFA{UserID: userID}
+	err := m.db.QueryRow("select secret, enabled, last_step from user_mfa where user_id=?", userID).Scan(&mfa.Secret, &mfa.Enabled, &mfa.LastStep)
+	if err == sql.ErrNoRows {
+		return nil, myerrors.ErrMFANotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	return mfa, nil
+}
+
+// SaveMFA stores new secret as pending until EnableMFA
+func (m *mySQLDBInterface) SaveMFA(userID int, secret []byte) error {
+	_, err := m.db.Exec("insert into user_mfa (user_id, secret, enabled, last_step) values(?, ?, false, 0) on duplicate key update secret=values(secret), enabled=false, last_step=0", userID, secret)
+	return err
+}
+
+// EnableMFA enables pending secret, marks step of confirming code as used and replaces recovery codes
+func (m *mySQLDBInterface) EnableMFA(userID int, step int64, codeHashes []string) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec("update user_mfa set enabled=true, last_step=? where user_id=?", step, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		tx.Rollback()
+		return myerrors.ErrMFANotEnrolled
+	}
+	if _, err := tx.Exec("delete from mfa_recovery_codes where user_id=?", userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec("insert into mfa_recovery_codes (user_id, code_hash) values(?, ?)", userID, hash); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UseMFAStep marks time step as used, false means the step or a later one was already used
+func (m *mySQLDBInterface) UseMFAStep(userID int, step int64) (bool, error) {
+	res, err := m.db.Exec("update user_mfa set last_step=? where user_id=? and enabled=true and last_step<?", step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// UseRecoveryCode marks recovery code as used, false means there is no such unused code
+func (m *mySQLDBInterface) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	res, err := m.db.Exec("update mfa_recovery_codes set used_at=CURRENT_TIMESTAMP where user_id=? and code_hash=? and used_at is null", userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (m *mySQLDBInterface) DeleteMFA(userID int) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("delete from mfa_recovery_codes where user_id=?", userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec("delete from user_mfa where user_id=?", userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
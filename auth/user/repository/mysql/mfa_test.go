@@ -0,0 +1,118 @@
+package mysql
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetMFA(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+	repo := &mySQLDBInterface{db}
+
+	query := "select secret, enabled, last_step from user_mfa where user_id=?"
+
+	mock.ExpectQuery(query).WithArgs(u.ID).WillReturnRows(sqlmock.NewRows([]string{"secret", "enabled", "last_step"}).
+		AddRow([]byte("encrypted"), true, 42))
+	mfa, err := repo.GetMFA(u.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("encrypted"), mfa.Secret)
+	assert.True(t, mfa.Enabled)
+	assert.Equal(t, int64(42), mfa.LastStep)
+
+	mock.ExpectQuery(query).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"secret", "enabled", "last_step"}))
+	_, err = repo.GetMFA(2)
+	assert.EqualError(t, err, "two-factor authentication is not enrolled")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSaveMFA(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+	repo := &mySQLDBInterface{db}
+
+	query := "insert into user_mfa (user_id, secret, enabled, last_step) values(?, ?, false, 0) on duplicate key update secret=values(secret), enabled=false, last_step=0"
+
+	mock.ExpectExec(query).WithArgs(u.ID, []byte("encrypted")).WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, repo.SaveMFA(u.ID, []byte("encrypted")))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestEnableMFA(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+	repo := &mySQLDBInterface{db}
+
+	enableQuery := "update user_mfa set enabled=true, last_step=? where user_id=?"
+	deleteQuery := "delete from mfa_recovery_codes where user_id=?"
+	insertQuery := "insert into mfa_recovery_codes (user_id, code_hash) values(?, ?)"
+
+	mock.ExpectBegin()
+	mock.ExpectExec(enableQuery).WithArgs(100, u.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(deleteQuery).WithArgs(u.ID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(insertQuery).WithArgs(u.ID, "hash1").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(insertQuery).WithArgs(u.ID, "hash2").WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+	assert.NoError(t, repo.EnableMFA(u.ID, 100, []string{"hash1", "hash2"}))
+
+	// not enrolled
+	mock.ExpectBegin()
+	mock.ExpectExec(enableQuery).WithArgs(100, 2).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	assert.EqualError(t, repo.EnableMFA(2, 100, []string{"hash1"}), "two-factor authentication is not enrolled")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUseMFAStep(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+	repo := &mySQLDBInterface{db}
+
+	query := "update user_mfa set last_step=? where user_id=? and enabled=true and last_step<?"
+
+	mock.ExpectExec(query).WithArgs(101, u.ID, 101).WillReturnResult(sqlmock.NewResult(0, 1))
+	ok, err := repo.UseMFAStep(u.ID, 101)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// replayed step
+	mock.ExpectExec(query).WithArgs(101, u.ID, 101).WillReturnResult(sqlmock.NewResult(0, 0))
+	ok, err = repo.UseMFAStep(u.ID, 101)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUseRecoveryCode(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+	repo := &mySQLDBInterface{db}
+
+	query := "update mfa_recovery_codes set used_at=CURRENT_TIMESTAMP where user_id=? and code_hash=? and used_at is null"
+
+	mock.ExpectExec(query).WithArgs(u.ID, "hash").WillReturnResult(sqlmock.NewResult(0, 1))
+	ok, err := repo.UseRecoveryCode(u.ID, "hash")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	mock.ExpectExec(query).WithArgs(u.ID, "hash").WillReturnResult(sqlmock.NewResult(0, 0))
+	ok, err = repo.UseRecoveryCode(u.ID, "hash")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteMFA(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+	repo := &mySQLDBInterface{db}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("delete from mfa_recovery_codes where user_id=?").WithArgs(u.ID).WillReturnResult(sqlmock.NewResult(0, 10))
+	mock.ExpectExec("delete from user_mfa where user_id=?").WithArgs(u.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	assert.NoError(t, repo.DeleteMFA(u.ID))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
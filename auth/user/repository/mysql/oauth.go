@@ -0,0 +1,65 @@
+package mysql
+
+import (
+	"auth/domain"
+	"auth/myerrors"
+	"database/sql"
+	"strings"
+)
+
+// redirect URIs are stored one per line, scopes space separated like in OAuth requests
+
+func (m *mySQLDBInterface) AddOAuthClient(client *domain.OAuthClient) error {
+	if client.ClientID == "" || client.Name == "" || (len(client.RedirectURIs) == 0 && !client.Service) {
+		return myerrors.ErrInvalidInput
+	}
+	res, err := m.db.Exec("insert into oauth_clients (client_id, secret_hash, name, redirect_uris, scopes, service) values(?, ?, ?, ?, ?, ?)",
+		client.ClientID, client.SecretHash, client.Name, strings.Join(client.RedirectURIs, "\n"), strings.Join(client.Scopes, " "), client.Service)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	client.ID = int(id)
+	return nil
+}
+
+func (m *mySQLDBInterface) GetOAuthClient(clientID string) (*domain.OAuthClient, error) {
+	row := m.db.QueryRow("select id, ts, client_id, secret_hash, name, redirect_uris, scopes, service from oauth_clients where client_id=?", clientID)
+	client, err := scanOAuthClient(row)
+	if err == sql.ErrNoRows {
+		return nil, myerrors.ErrClientNotFound
+	}
+	return client, err
+}
+
+func (m *mySQLDBInterface) ListOAuthClients() ([]domain.OAuthClient, error) {
+	rows, err := m.db.Query("select id, ts, client_id, secret_hash, name, redirect_uris, scopes, service from oauth_clients order by id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	clients := []domain.OAuthClient{}
+	for rows.Next() {
+		client, err := scanOAuthClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, *client)
+	}
+	return clients, rows.Err()
+}
+
+func scanOAuthClient(row interface{ Scan(...interface{}) error }) (*domain.OAuthClient, error) {
+	client := new(domain.OAuthClient)
+	var redirectURIs, scopes string
+	if err := row.Scan(&client.ID, &client.Ts, &client.ClientID, &client.SecretHash, &client.Name, &redirectURIs, &scopes, &client.Service); err != nil {
+		return nil, err
+	}
+	client.RedirectURIs = strings.Fields(redirectURIs)
+	client.Scopes = strings.Fields(scopes)
+	client.Public = client.SecretHash == ""
+	return client, nil
+}
@@ -0,0 +1,83 @@
+package mysql
+
+import (
+	"auth/domain"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var testClient = &domain.OAuthClient{
+	ClientID:     "client",
+	SecretHash:   "hash",
+	Name:         "Shop",
+	RedirectURIs: []string{"https://shop.example.com/callback", "https://shop.example.com/cb"},
+	Scopes:       []string{"profile", "wallets:read"},
+}
+
+func TestAddOAuthClient(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+	repo := &mySQLDBInterface{db}
+
+	query := "insert into oauth_clients (client_id, secret_hash, name, redirect_uris, scopes, service) values(?, ?, ?, ?, ?, ?)"
+
+	mock.ExpectExec(query).WithArgs("client", "hash", "Shop", "https://shop.example.com/callback\nhttps://shop.example.com/cb", "profile wallets:read", false).
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	client := *testClient
+	assert.NoError(t, repo.AddOAuthClient(&client))
+	assert.Equal(t, 3, client.ID)
+
+	assert.EqualError(t, repo.AddOAuthClient(&domain.OAuthClient{ClientID: "client", Name: "Shop"}), "invalid input")
+
+	// service clients have no redirect URIs
+	mock.ExpectExec(query).WithArgs("batch", "hash", "Batch", "", "users:read", true).
+		WillReturnResult(sqlmock.NewResult(4, 1))
+	assert.NoError(t, repo.AddOAuthClient(&domain.OAuthClient{ClientID: "batch", SecretHash: "hash", Name: "Batch", Scopes: []string{"users:read"}, Service: true}))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetOAuthClient(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+	repo := &mySQLDBInterface{db}
+
+	query := "select id, ts, client_id, secret_hash, name, redirect_uris, scopes, service from oauth_clients where client_id=?"
+	columns := []string{"id", "ts", "client_id", "secret_hash", "name", "redirect_uris", "scopes", "service"}
+
+	mock.ExpectQuery(query).WithArgs("client").WillReturnRows(sqlmock.NewRows(columns).
+		AddRow(3, "2022-01-01 00:00:00", "client", "", "Shop", "https://shop.example.com/callback\nhttps://shop.example.com/cb", "profile wallets:read", false))
+	client, err := repo.GetOAuthClient("client")
+	assert.NoError(t, err)
+	assert.Equal(t, testClient.RedirectURIs, client.RedirectURIs)
+	assert.Equal(t, testClient.Scopes, client.Scopes)
+	assert.True(t, client.Public)
+
+	mock.ExpectQuery(query).WithArgs("unknown").WillReturnRows(sqlmock.NewRows(columns))
+	_, err = repo.GetOAuthClient("unknown")
+	assert.EqualError(t, err, "oauth client not found")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListOAuthClients(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+	repo := &mySQLDBInterface{db}
+
+	query := "select id, ts, client_id, secret_hash, name, redirect_uris, scopes, service from oauth_clients order by id"
+	columns := []string{"id", "ts", "client_id", "secret_hash", "name", "redirect_uris", "scopes", "service"}
+
+	mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows(columns).
+		AddRow(3, "2022-01-01 00:00:00", "client", "hash", "Shop", "https://shop.example.com/callback", "profile", false).
+		AddRow(4, "2022-01-02 00:00:00", "app", "", "App", "myapp://callback", "", false).
+		AddRow(5, "2022-01-03 00:00:00", "batch", "hash", "Batch", "", "users:read", true))
+	clients, err := repo.ListOAuthClients()
+	assert.NoError(t, err)
+	assert.Len(t, clients, 3)
+	assert.False(t, clients[0].Public)
+	assert.Equal(t, []string{}, clients[1].Scopes)
+	assert.True(t, clients[2].Service)
+	assert.Empty(t, clients[2].RedirectURIs)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,59 @@
+package redis
+
+import (
+	"auth/user/repository"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// slidingWindowScript keeps request timestamps of a key in a sorted set. ARGV: now ms, window ms, limit, member.
+// Returns {1, 0} if request is allowed, {0, ms to wait} otherwise
+var slidingWindowScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+if redis.call("ZCARD", KEYS[1]) >= tonumber(ARGV[3]) then
+	local oldest = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+	return {0, tonumber(oldest[2]) + window - now}
+end
+redis.call("ZADD", KEYS[1], now, ARGV[4])
+redis.call("PEXPIRE", KEYS[1], window)
+return {1, 0}
+`)
+
+type redisRateLimiter struct {
+	redisConn *redis.Client
+}
+
+// rateKey returns redis key of the sorted set holding recent requests
+func rateKey(key string) string {
+	return "rate:" + key
+}
+
+func (r *redisRateLimiter) Allow(key string, limit int, window time.Duration) (bool, time.Duration, error) {
+	now := time.Now().UnixMilli()
+	member := fmt.Sprintf("%d-%d", now, rand.Int63())
+	res, err := slidingWindowScript.Run(r.redisConn, []string{rateKey(key)}, now, window.Milliseconds(), limit, member).Result()
+	if err != nil {
+		return false, 0, err
+	}
+	values, ok := res.([]interface{})
+	if !ok || len(values) != 2 {
+		return false, 0, fmt.Errorf("unexpected rate limit script result %v", res)
+	}
+	allowed, _ := values[0].(int64)
+	wait, _ := values[1].(int64)
+	return allowed == 1, time.Duration(wait) * time.Millisecond, nil
+}
+
+// NewRedisRateLimiter returns RateLimiter shared by all app instances
+func NewRedisRateLimiter() (repository.RateLimiter, error) {
+	client, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+	return &redisRateLimiter{redisConn: client}, nil
+}
@@ -0,0 +1,26 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	r := &redisRateLimiter{client}
+	for i := 0; i < 3; i++ {
+		allowed, _, err := r.Allow("login:ip:127.0.0.1", 3, time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, allowed)
+	}
+	allowed, retryAfter, err := r.Allow("login:ip:127.0.0.1", 3, time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+	assert.True(t, retryAfter > 0 && retryAfter <= time.Minute)
+
+	// other keys have their own window
+	allowed, _, err = r.Allow("login:ip:127.0.0.2", 3, time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+}
@@ -1,35 +1,358 @@
 package redis
 
 import (
+	"auth/domain"
+	"auth/logger"
 	"auth/myerrors"
 	"auth/user/repository"
+	"encoding/json"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/go-redis/redis"
 )
 
+// rotateScript swaps the current refresh token of a session only if the presented
+// token is still the current one, remembering the replaced one and when it was replaced (ARGV[5] ms).
+// Expiry of the session index KEYS[2] is extended, never shortened.
+// Returns 1 on success, 0 if the session is unknown, -2 if the presented token was replaced
+// less than ARGV[6] ms ago and -1 if it was rotated out before.
+var rotateScript = redis.NewScript(`
+local current = redis.call("HGET", KEYS[1], "token")
+if not current then
+	return 0
+end
+if current ~= ARGV[1] then
+	local rotated = redis.call("HMGET", KEYS[1], "previous", "rotated_at")
+	if rotated[1] == ARGV[1] and tonumber(ARGV[5]) - tonumber(rotated[2]) <= tonumber(ARGV[6]) then
+		return -2
+	end
+	return -1
+end
+redis.call("HMSET", KEYS[1], "token", ARGV[2], "last_used", ARGV[4], "previous", ARGV[1], "rotated_at", ARGV[5])
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+if redis.call("PTTL", KEYS[2]) < tonumber(ARGV[3]) then
+	redis.call("PEXPIRE", KEYS[2], ARGV[3])
+end
+return 1
+`)
+
+// insertScript stores session hash KEYS[1] for ARGV[7] ms and adds its ID ARGV[8] to the index of the user KEYS[2].
+// The index outlives every session in it, so its expiry is only ever extended: a shorter session started
+// after a remember-me one must not hide the latter from listing and revocation
+var insertScript = redis.NewScript(`
+redis.call("HMSET", KEYS[1], "iin", ARGV[1], "token", ARGV[2], "user_agent", ARGV[3], "ip", ARGV[4], "created_at", ARGV[5], "last_used", ARGV[6])
+redis.call("PEXPIRE", KEYS[1], ARGV[7])
+redis.call("SADD", KEYS[2], ARGV[8])
+if redis.call("PTTL", KEYS[2]) < tonumber(ARGV[7]) then
+	redis.call("PEXPIRE", KEYS[2], ARGV[7])
+end
+return 1
+`)
+
+// failureScript counts a failed login and keeps the counter for at least ARGV[2] ms,
+// a longer expiry set by a lockout is preserved. Returns failures and lock expiry.
+var failureScript = redis.NewScript(`
+local failures = redis.call("HINCRBY", KEYS[1], "failures", 1)
+redis.call("HSET", KEYS[1], "last_failure", ARGV[1])
+if redis.call("PTTL", KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return {failures, redis.call("HGET", KEYS[1], "locked_until") or "0"}
+`)
+
+// lockScript locks logins until ARGV[1] ms timestamp, extending counter expiry if needed
+var lockScript = redis.NewScript(`
+redis.call("HSET", KEYS[1], "locked_until", ARGV[1])
+if redis.call("PTTL", KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call("PEXPIREAT", KEYS[1], ARGV[1])
+end
+return 1
+`)
+
+// resetScript stores reset token hash KEYS[1] of IIN ARGV[1] for ARGV[3] ms, replacing the previous token of
+// the user remembered at KEYS[2]. ARGV[2] is the new hash
+var resetScript = redis.NewScript(`
+local previous = redis.call("GET", KEYS[2])
+if previous then
+	redis.call("DEL", "reset:" .. previous)
+end
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[3])
+redis.call("SET", KEYS[2], ARGV[2], "PX", ARGV[3])
+return 1
+`)
+
+// consumeResetScript deletes reset token KEYS[1] and returns its IIN, false if there is no such token
+var consumeResetScript = redis.NewScript(`
+local IIN = redis.call("GET", KEYS[1])
+if not IIN then
+	return false
+end
+redis.call("DEL", KEYS[1])
+redis.call("DEL", "reset_user:" .. IIN)
+return IIN
+`)
+
+// consumeScript deletes KEYS[1] and returns its value, false if there is no such key
+var consumeScript = redis.NewScript(`
+local value = redis.call("GET", KEYS[1])
+if value then
+	redis.call("DEL", KEYS[1])
+end
+return value
+`)
+
 type redisCacheInterface struct {
 	redisConn *redis.Client
 }
 
-func (r *redisCacheInterface) InsertToken(IIN, token string, refreshTtl time.Duration) error {
-	return r.redisConn.Set(IIN, token, refreshTtl).Err()
+// sessionKey returns redis key of the hash holding session data and its current refresh token
+func sessionKey(sessionID string) string {
+	return "session:" + sessionID
 }
 
-func (r *redisCacheInterface) FindToken(IIN, token string) (string, error) {
-	value, err := r.redisConn.Get(IIN).Result()
+// userSessionsKey returns redis key of the set holding session IDs of a user
+func userSessionsKey(IIN string) string {
+	return "sessions:" + IIN
+}
+
+func (r *redisCacheInterface) InsertSession(session *domain.Session, token string, refreshTtl time.Duration) error {
+	keys := []string{sessionKey(session.ID), userSessionsKey(session.IIN)}
+	return insertScript.Run(r.redisConn, keys, session.IIN, token, session.UserAgent, session.IP,
+		session.CreatedAt.Unix(), session.LastUsed.Unix(), refreshTtl.Milliseconds(), session.ID).Err()
+}
+
+func (r *redisCacheInterface) GetSession(sessionID string) (*domain.Session, error) {
+	values, err := r.redisConn.HGetAll(sessionKey(sessionID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, myerrors.ErrSessionNotFound
+	}
+	createdAt, _ := strconv.ParseInt(values["created_at"], 10, 64)
+	lastUsed, _ := strconv.ParseInt(values["last_used"], 10, 64)
+	return &domain.Session{
+		ID:        sessionID,
+		IIN:       values["iin"],
+		UserAgent: values["user_agent"],
+		IP:        values["ip"],
+		CreatedAt: time.Unix(createdAt, 0),
+		LastUsed:  time.Unix(lastUsed, 0),
+	}, nil
+}
+
+func (r *redisCacheInterface) GetSessionToken(sessionID string) (string, error) {
+	token, err := r.redisConn.HGet(sessionKey(sessionID), "token").Result()
 	if err == redis.Nil {
-		return "", myerrors.ErrRefreshNotFound
+		return "", myerrors.ErrSessionNotFound
+	}
+	return token, err
+}
+
+func (r *redisCacheInterface) ListSessions(IIN string) ([]domain.Session, error) {
+	IDs, err := r.redisConn.SMembers(userSessionsKey(IIN)).Result()
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]domain.Session, 0, len(IDs))
+	for _, ID := range IDs {
+		session, err := r.GetSession(ID)
+		if err == myerrors.ErrSessionNotFound {
+			// session expired, drop it from the index
+			r.redisConn.SRem(userSessionsKey(IIN), ID)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	return sessions, nil
+}
+
+func (r *redisCacheInterface) RotateToken(IIN, sessionID, oldToken, newToken string, refreshTtl time.Duration) error {
+	keys := []string{sessionKey(sessionID), userSessionsKey(IIN)}
+	now := time.Now()
+	res, err := rotateScript.Run(r.redisConn, keys, oldToken, newToken, refreshTtl.Milliseconds(), now.Unix(),
+		now.UnixNano()/int64(time.Millisecond), repository.RotationGrace.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+	switch res {
+	case 0:
+		return myerrors.ErrRefreshNotFound
+	case -1:
+		return myerrors.ErrTokenReused
+	case -2:
+		return myerrors.ErrTokenRotated
+	}
+	return nil
+}
+
+func (r *redisCacheInterface) DeleteSession(IIN, sessionID string) error {
+	_, err := r.redisConn.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Del(sessionKey(sessionID))
+		pipe.SRem(userSessionsKey(IIN), sessionID)
+		return nil
+	})
+	return err
+}
+
+// deniedKey returns redis key marking a revoked access token
+func deniedKey(jti string) string {
+	return "denied:" + jti
+}
+
+func (r *redisCacheInterface) DenyToken(jti string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+	return r.redisConn.Set(deniedKey(jti), 1, ttl).Err()
+}
+
+func (r *redisCacheInterface) IsTokenDenied(jti string) (bool, error) {
+	n, err := r.redisConn.Exists(deniedKey(jti)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// loginAttemptsKey returns redis key of the hash counting failed logins
+func loginAttemptsKey(key string) string {
+	return "login_failures:" + key
+}
+
+func (r *redisCacheInterface) AddLoginFailure(key string, ttl time.Duration) (*domain.LoginAttempts, error) {
+	now := time.Now()
+	res, err := failureScript.Run(r.redisConn, []string{loginAttemptsKey(key)}, now.UnixMilli(), ttl.Milliseconds()).Result()
+	if err != nil {
+		return nil, err
+	}
+	values, ok := res.([]interface{})
+	if !ok || len(values) != 2 {
+		return nil, fmt.Errorf("unexpected login failure script result %v", res)
+	}
+	failures, _ := values[0].(int64)
+	lockedUntil, _ := strconv.ParseInt(fmt.Sprint(values[1]), 10, 64)
+	return &domain.LoginAttempts{
+		Failures:    int(failures),
+		LastFailure: fromMillis(now.UnixMilli()),
+		LockedUntil: fromMillis(lockedUntil),
+	}, nil
+}
+
+func (r *redisCacheInterface) LockLogin(key string, until time.Time) error {
+	return lockScript.Run(r.redisConn, []string{loginAttemptsKey(key)}, until.UnixMilli(), time.Until(until).Milliseconds()).Err()
+}
+
+func (r *redisCacheInterface) GetLoginAttempts(key string) (*domain.LoginAttempts, error) {
+	values, err := r.redisConn.HGetAll(loginAttemptsKey(key)).Result()
+	if err != nil {
+		return nil, err
+	}
+	failures, _ := strconv.Atoi(values["failures"])
+	lastFailure, _ := strconv.ParseInt(values["last_failure"], 10, 64)
+	lockedUntil, _ := strconv.ParseInt(values["locked_until"], 10, 64)
+	return &domain.LoginAttempts{
+		Failures:    failures,
+		LastFailure: fromMillis(lastFailure),
+		LockedUntil: fromMillis(lockedUntil),
+	}, nil
+}
+
+// fromMillis converts unix milliseconds to time, 0 means not set
+func fromMillis(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+func (r *redisCacheInterface) ResetLoginAttempts(key string) error {
+	return r.redisConn.Del(loginAttemptsKey(key)).Err()
+}
+
+// resetTokenKey returns redis key holding IIN of a password reset token hash
+func resetTokenKey(tokenHash string) string {
+	return "reset:" + tokenHash
+}
+
+// userResetKey returns redis key holding hash of the latest reset token of a user
+func userResetKey(IIN string) string {
+	return "reset_user:" + IIN
+}
+
+func (r *redisCacheInterface) InsertResetToken(IIN, tokenHash string, ttl time.Duration) error {
+	return resetScript.Run(r.redisConn, []string{resetTokenKey(tokenHash), userResetKey(IIN)}, IIN, tokenHash, ttl.Milliseconds()).Err()
+}
+
+func (r *redisCacheInterface) GetResetToken(tokenHash string) (string, error) {
+	IIN, err := r.redisConn.Get(resetTokenKey(tokenHash)).Result()
+	if err == redis.Nil {
+		return "", myerrors.ErrResetTokenInvalid
+	}
+	return IIN, err
+}
+
+func (r *redisCacheInterface) ConsumeResetToken(tokenHash string) (string, error) {
+	res, err := consumeResetScript.Run(r.redisConn, []string{resetTokenKey(tokenHash)}).Result()
+	if err == redis.Nil {
+		return "", myerrors.ErrResetTokenInvalid
 	}
 	if err != nil {
 		return "", err
 	}
-	return value, nil
+	IIN, ok := res.(string)
+	if !ok {
+		return "", fmt.Errorf("unexpected reset token script result %v", res)
+	}
+	return IIN, nil
+}
+
+// authCodeKey returns redis key holding OAuth authorization code as JSON
+func authCodeKey(codeHash string) string {
+	return "authcode:" + codeHash
+}
+
+func (r *redisCacheInterface) InsertAuthCode(codeHash string, code *domain.AuthCode, ttl time.Duration) error {
+	value, err := json.Marshal(code)
+	if err != nil {
+		return err
+	}
+	return r.redisConn.Set(authCodeKey(codeHash), value, ttl).Err()
+}
+
+func (r *redisCacheInterface) ConsumeAuthCode(codeHash string) (*domain.AuthCode, error) {
+	res, err := consumeScript.Run(r.redisConn, []string{authCodeKey(codeHash)}).Result()
+	if err == redis.Nil {
+		return nil, myerrors.ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, err
+	}
+	value, ok := res.(string)
+	if !ok {
+		return nil, fmt.Errorf("unexpected authorization code script result %v", res)
+	}
+	code := new(domain.AuthCode)
+	if err := json.Unmarshal([]byte(value), code); err != nil {
+		return nil, err
+	}
+	return code, nil
 }
 
 func NewRedisCacheInterface() (repository.CacheInterface, error) {
+	client, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+	return &redisCacheInterface{redisConn: client}, nil
+}
 
+func newClient() (*redis.Client, error) {
 	client := redis.NewClient(&redis.Options{
 		Addr:     "redis:6379",
 		Password: "",
@@ -40,6 +363,6 @@ func NewRedisCacheInterface() (repository.CacheInterface, error) {
 		return nil, err
 	}
 
-	fmt.Println(pong)
-	return &redisCacheInterface{redisConn: client}, nil
+	logger.Info("Redis ping", "reply", pong)
+	return client, nil
 }
@@ -1,31 +1,236 @@
 package redis
 
 import (
+	"auth/config"
+	"auth/domain"
+	"auth/myerrors"
 	"testing"
 	"time"
 
-	"github.com/elliotchance/redismock"
-	"github.com/go-redis/redis"
 	"github.com/stretchr/testify/assert"
 )
 
+func newTestSession(ID, IIN string) *domain.Session {
+	now := time.Unix(time.Now().Unix(), 0)
+	return &domain.Session{
+		ID:        ID,
+		IIN:       IIN,
+		UserAgent: "test-agent",
+		IP:        "127.0.0.1",
+		CreatedAt: now,
+		LastUsed:  now,
+	}
+}
+
 func TestSet(t *testing.T) {
-	exp := time.Duration(0)
+	r := &redisCacheInterface{client}
+	err := r.InsertSession(newTestSession(key, "910815450350"), val, time.Minute)
+	assert.NoError(t, err)
+}
+
+func TestGet(t *testing.T) {
+	r := &redisCacheInterface{client}
+	session := newTestSession(key, "910815450350")
+	err := r.InsertSession(session, val, time.Minute)
+	assert.NoError(t, err)
 
-	mock := redismock.NewNiceMock(client)
-	mock.On("Set", key, val, exp).Return(redis.NewStatusResult("", nil))
+	res, err := r.GetSession(key)
+	assert.NoError(t, err)
+	assert.Equal(t, session, res)
+
+	_, err = r.GetSession("nonexistent")
+	assert.Equal(t, myerrors.ErrSessionNotFound, err)
+}
 
+func TestListAndDeleteSessions(t *testing.T) {
 	r := &redisCacheInterface{client}
-	err := r.InsertToken(key, val, exp)
+	IIN := "601119400567"
+	assert.NoError(t, r.InsertSession(newTestSession("laptop", IIN), "first", time.Minute))
+	assert.NoError(t, r.InsertSession(newTestSession("phone", IIN), "second", time.Minute))
+
+	sessions, err := r.ListSessions(IIN)
 	assert.NoError(t, err)
+	assert.Len(t, sessions, 2)
+
+	assert.NoError(t, r.DeleteSession(IIN, "laptop"))
+	sessions, err = r.ListSessions(IIN)
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 1)
+	assert.Equal(t, "phone", sessions[0].ID)
 }
 
-func TestGet(t *testing.T) {
-	mock := redismock.NewNiceMock(client)
-	mock.On("Get", key).Return(redis.NewStringResult(val, nil))
+func TestSessionIndexTTL(t *testing.T) {
+	r := &redisCacheInterface{client}
+	IIN := "850605300421"
+	assert.NoError(t, r.InsertSession(newTestSession("long", IIN), "long-token", time.Hour))
+	// shorter sessions started or rotated later don't shorten the index
+	assert.NoError(t, r.InsertSession(newTestSession("short", IIN), "short-token", 10*time.Minute))
+	assert.NoError(t, r.RotateToken(IIN, "short", "short-token", "short-token-2", 10*time.Minute))
+
+	mr.FastForward(11 * time.Minute)
+	sessions, err := r.ListSessions(IIN)
+	assert.NoError(t, err)
+	if assert.Len(t, sessions, 1) {
+		assert.Equal(t, "long", sessions[0].ID)
+	}
+	assert.NoError(t, r.DeleteSession(IIN, "long"))
+	_, err = r.GetSession("long")
+	assert.Equal(t, myerrors.ErrSessionNotFound, err)
+}
+
+func TestRotateToken(t *testing.T) {
+	r := &redisCacheInterface{client}
+	IIN, sessionID := "980124450084", "rotate"
+	err := r.InsertSession(newTestSession(sessionID, IIN), "first", time.Minute)
+	assert.NoError(t, err)
+
+	err = r.RotateToken(IIN, sessionID, "first", "second", time.Minute)
+	assert.NoError(t, err)
+	token, err := r.GetSessionToken(sessionID)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", token)
+
+	// concurrent request with the previous token gets no tokens but keeps the session
+	err = r.RotateToken(IIN, sessionID, "first", "other", time.Minute)
+	assert.Equal(t, myerrors.ErrTokenRotated, err)
+	token, err = r.GetSessionToken(sessionID)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", token)
+
+	// rotated out token presented again after the next rotation
+	err = r.RotateToken(IIN, sessionID, "second", "third", time.Minute)
+	assert.NoError(t, err)
+	err = r.RotateToken(IIN, sessionID, "first", "fourth", time.Minute)
+	assert.Equal(t, myerrors.ErrTokenReused, err)
+
+	err = r.DeleteSession(IIN, sessionID)
+	assert.NoError(t, err)
+	err = r.RotateToken(IIN, sessionID, "second", "third", time.Minute)
+	assert.Equal(t, myerrors.ErrRefreshNotFound, err)
+	_, err = r.GetSessionToken(sessionID)
+	assert.Equal(t, myerrors.ErrSessionNotFound, err)
+}
+
+func TestDenyToken(t *testing.T) {
+	r := &redisCacheInterface{client}
+	denied, err := r.IsTokenDenied("jti")
+	assert.NoError(t, err)
+	assert.False(t, denied)
+
+	assert.NoError(t, r.DenyToken("jti", time.Minute))
+	denied, err = r.IsTokenDenied("jti")
+	assert.NoError(t, err)
+	assert.True(t, denied)
+
+	// already expired tokens are not stored
+	assert.NoError(t, r.DenyToken("expired", -time.Second))
+	denied, err = r.IsTokenDenied("expired")
+	assert.NoError(t, err)
+	assert.False(t, denied)
+}
+
+func TestLoginAttempts(t *testing.T) {
+	r := &redisCacheInterface{client}
+	attempts, err := r.GetLoginAttempts("user:a")
+	assert.NoError(t, err)
+	assert.Equal(t, &domain.LoginAttempts{}, attempts)
 
+	for i := 1; i <= 3; i++ {
+		attempts, err = r.AddLoginFailure("user:a", time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, i, attempts.Failures)
+		assert.False(t, attempts.Locked(time.Now()))
+	}
+
+	until := time.UnixMilli(time.Now().Add(time.Hour).UnixMilli())
+	assert.NoError(t, r.LockLogin("user:a", until))
+	attempts, err = r.AddLoginFailure("user:a", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, attempts.Failures)
+	assert.Equal(t, until, attempts.LockedUntil)
+	assert.True(t, attempts.Locked(time.Now()))
+	// lockout outlives the failure window
+	ttl, err := client.PTTL(loginAttemptsKey("user:a")).Result()
+	assert.NoError(t, err)
+	assert.True(t, ttl > time.Minute)
+
+	assert.NoError(t, r.ResetLoginAttempts("user:a"))
+	attempts, err = r.GetLoginAttempts("user:a")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, attempts.Failures)
+}
+
+func TestResetTokens(t *testing.T) {
 	r := &redisCacheInterface{client}
-	res, err := r.FindToken(key, val)
+	_, err := r.ConsumeResetToken("unknown")
+	assert.Equal(t, myerrors.ErrResetTokenInvalid, err)
+
+	assert.NoError(t, r.InsertResetToken("910815450350", "first", time.Minute))
+	// newer token replaces the earlier one
+	assert.NoError(t, r.InsertResetToken("910815450350", "second", time.Minute))
+	_, err = r.ConsumeResetToken("first")
+	assert.Equal(t, myerrors.ErrResetTokenInvalid, err)
+
+	ttl, err := client.PTTL(resetTokenKey("second")).Result()
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0 && ttl <= time.Minute)
+
+	IIN, err := r.GetResetToken("second")
+	assert.NoError(t, err)
+	assert.Equal(t, "910815450350", IIN)
+	_, err = r.GetResetToken("first")
+	assert.Equal(t, myerrors.ErrResetTokenInvalid, err)
+
+	IIN, err = r.ConsumeResetToken("second")
+	assert.NoError(t, err)
+	assert.Equal(t, "910815450350", IIN)
+	// token works only once
+	_, err = r.ConsumeResetToken("second")
+	assert.Equal(t, myerrors.ErrResetTokenInvalid, err)
+	_, err = r.GetResetToken("second")
+	assert.Equal(t, myerrors.ErrResetTokenInvalid, err)
+	n, err := client.Exists(userResetKey("910815450350")).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+}
+
+func TestAuthCodes(t *testing.T) {
+	r := &redisCacheInterface{client}
+	_, err := r.ConsumeAuthCode("unknown")
+	assert.Equal(t, myerrors.ErrInvalidGrant, err)
+
+	code := &domain.AuthCode{ClientID: "client", IIN: "910815450350", RedirectURI: "https://shop.example.com/cb", Scope: "profile", CodeChallenge: "challenge"}
+	assert.NoError(t, r.InsertAuthCode("hash", code, time.Minute))
+	ttl, err := client.PTTL(authCodeKey("hash")).Result()
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0 && ttl <= time.Minute)
+
+	res, err := r.ConsumeAuthCode("hash")
+	assert.NoError(t, err)
+	assert.Equal(t, code, res)
+	// code works only once
+	_, err = r.ConsumeAuthCode("hash")
+	assert.Equal(t, myerrors.ErrInvalidGrant, err)
+}
+
+func TestRememberMeSessionRevocable(t *testing.T) {
+	r := &redisCacheInterface{client}
+	policy := &config.TokenPolicy{RefreshTtl: 10 * time.Minute, RememberMeTtl: 720 * time.Hour}
+	IIN := "770101300123"
+	// remember-me login on a laptop, then a normal login on a phone
+	assert.NoError(t, r.InsertSession(newTestSession("laptop", IIN), "laptop-token", policy.RefreshTtlFor(true)))
+	assert.NoError(t, r.InsertSession(newTestSession("phone", IIN), "phone-token", policy.RefreshTtlFor(false)))
+
+	mr.FastForward(policy.RefreshTtl + time.Minute)
+	// revoking all sessions, as password reset or admin lock does, still finds the laptop
+	sessions, err := r.ListSessions(IIN)
 	assert.NoError(t, err)
-	assert.Equal(t, val, res)
+	for _, session := range sessions {
+		assert.NoError(t, r.DeleteSession(IIN, session.ID))
+	}
+	if assert.Len(t, sessions, 1) {
+		assert.Equal(t, "laptop", sessions[0].ID)
+	}
+	_, err = r.GetSessionToken("laptop")
+	assert.Equal(t, myerrors.ErrSessionNotFound, err)
 }
@@ -11,6 +11,8 @@ import (
 
 var (
 	client *redis.Client
+	// mr lets tests fast forward expiry of keys
+	mr *miniredis.Miniredis
 )
 
 var (
@@ -19,7 +21,8 @@ var (
 )
 
 func TestMain(m *testing.M) {
-	mr, err := miniredis.Run()
+	var err error
+	mr, err = miniredis.Run()
 	if err != nil {
 		log.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
@@ -2,9 +2,9 @@ package walletservice
 
 import (
 	"auth/domain"
+	"auth/logger"
 	"auth/user/repository"
 	"encoding/json"
-	"log"
 	"time"
 
 	"github.com/valyala/fasthttp"
@@ -25,13 +25,12 @@ func (w *WalletAPIInterface) GetTransactions(token, account string) ([]domain.Tr
 	if err := json.Unmarshal(respBytes, &resp); err != nil {
 		return nil, err
 	}
-	log.Println(resp)
-	log.Println("\n", resp.Transactions)
+	logger.Debug("Got transactions from walletService", "account", account, "count", len(resp.Transactions))
 	return resp.Transactions, nil
 }
 
 func (w *WalletAPIInterface) GetWallets(IIN, token string) ([]domain.Wallet, error) {
-	respBytes, _, err := w.doRequest("/info", map[string]string{"token": token})
+	respBytes, _, err := w.doRequest("/info", map[string]string{"iin": IIN, "token": token})
 	if err != nil {
 		return nil, err
 	}
@@ -76,7 +75,7 @@ func (w *WalletAPIInterface) TopUp(IIN, account, amount, token string) ([]byte,
 }
 
 func (w *WalletAPIInterface) doRequest(endpoint string, m map[string]string) ([]byte, int, error) {
-	log.Println("INFO|do request hit")
+	logger.Debug("do request hit")
 	req := fasthttp.AcquireRequest()
 	for key, value := range m {
 		req.Header.Add(key, value)
@@ -121,6 +121,39 @@ func TestGetWallets(t *testing.T) {
 	}
 }
 
+// TestGetWalletsOfIIN checks that wallets are asked for the given IIN, admins read wallets of other users
+// with their own token
+func TestGetWalletsOfIIN(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/info" || r.Header.Get("token") != "admin-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		var wallets []domain.Wallet
+		if r.Header.Get("iin") == "910815450350" {
+			wallets = []domain.Wallet{{ID: 1, AccountNo: "KZT0000000001", Amount: 1}}
+		}
+		json.NewEncoder(w).Encode(domain.Response{OK: true, Wallets: wallets})
+	}))
+	defer ts.Close()
+	api := &WalletAPIInterface{host: ts.URL, client: newClient(ts.URL[7:])}
+	wallets, err := api.GetWallets("910815450350", "admin-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wallets) != 1 || wallets[0].AccountNo != "KZT0000000001" {
+		t.Errorf("Expecting wallets of 910815450350, got %+v", wallets)
+	}
+	wallets, err = api.GetWallets("010101000000", "admin-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wallets) != 0 {
+		t.Errorf("Expecting no wallets of 010101000000, got %+v", wallets)
+	}
+}
+
 func TestGetWalletsErr(t *testing.T) {
 	// incorrect response
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,167 @@
+package usecase
+
+import (
+	"auth/domain"
+	"auth/logger"
+	"auth/myerrors"
+	"auth/user/repository"
+	"strings"
+)
+
+const (
+	adminSearchLimit  = 50
+	adminActionsLimit = 50
+)
+
+type AdminUsecase interface {
+	SearchUsers(query string) ([]domain.User, error)
+	GetUser(IIN string) (*domain.User, error)
+	GetWallets(IIN, token string) ([]domain.Wallet, error)
+	GetTransactions(token, account string) ([]domain.Transaction, error)
+	ListSessions(IIN string) ([]domain.Session, error)
+	ListActions(IIN string) ([]domain.AdminAction, error)
+	GetLoginAttempts(username string) (*domain.LoginAttempts, error)
+	LockUser(admin domain.User, IIN string) error
+	UnlockUser(admin domain.User, IIN string) error
+	ForceLogout(admin domain.User, IIN string) error
+	TriggerPasswordReset(admin domain.User, IIN string) error
+}
+
+type adminUsecaseImpl struct {
+	cacheConn repository.CacheInterface
+	dbConn    repository.DBInterface
+	api       repository.APIInterface
+	reset     PasswordResetUsecase
+}
+
+// SearchUsers finds users by username or IIN prefix
+func (uc *adminUsecaseImpl) SearchUsers(query string) ([]domain.User, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return []domain.User{}, nil
+	}
+	return uc.dbConn.SearchUsers(query, adminSearchLimit)
+}
+
+// GetUser gets user by IIN together with roles and permissions
+func (uc *adminUsecaseImpl) GetUser(IIN string) (*domain.User, error) {
+	user, err := uc.dbConn.GetUserByIIN(IIN)
+	if err != nil {
+		return nil, err
+	}
+	if err := loadRoles(uc.dbConn, user); err != nil {
+		return nil, err
+	}
+	user.Password = ""
+	return user, nil
+}
+
+// GetWallets retrieves accounts of the user from api on behalf of admin
+func (uc *adminUsecaseImpl) GetWallets(IIN, token string) ([]domain.Wallet, error) {
+	return uc.api.GetWallets(IIN, token)
+}
+
+// GetTransactions retrieves account transactions from api on behalf of admin
+func (uc *adminUsecaseImpl) GetTransactions(token, account string) ([]domain.Transaction, error) {
+	return uc.api.GetTransactions(token, account)
+}
+
+// ListSessions retrieves active sessions of the user
+func (uc *adminUsecaseImpl) ListSessions(IIN string) ([]domain.Session, error) {
+	return uc.cacheConn.ListSessions(IIN)
+}
+
+// ListActions retrieves latest admin actions on the user
+func (uc *adminUsecaseImpl) ListActions(IIN string) ([]domain.AdminAction, error) {
+	return uc.dbConn.ListAdminActions(IIN, adminActionsLimit)
+}
+
+// GetLoginAttempts retrieves failed logins and temporary lockout of the user
+func (uc *adminUsecaseImpl) GetLoginAttempts(username string) (*domain.LoginAttempts, error) {
+	return uc.cacheConn.GetLoginAttempts(userAttemptsKey(username))
+}
+
+// LockUser locks user account and ends all of its sessions
+func (uc *adminUsecaseImpl) LockUser(admin domain.User, IIN string) error {
+	if admin.IIN == IIN {
+		return myerrors.ErrInvalidInput
+	}
+	if err := uc.dbConn.SetUserLocked(IIN, true); err != nil {
+		return err
+	}
+	if err := revokeAllSessions(uc.cacheConn, IIN); err != nil {
+		return err
+	}
+	return uc.record(admin, domain.AdminActionLock, IIN, "")
+}
+
+// UnlockUser unlocks user account and lifts temporary lockout after failed logins
+func (uc *adminUsecaseImpl) UnlockUser(admin domain.User, IIN string) error {
+	user, err := uc.dbConn.GetUserByIIN(IIN)
+	if err != nil {
+		return err
+	}
+	if err := uc.dbConn.SetUserLocked(IIN, false); err != nil {
+		return err
+	}
+	if err := uc.cacheConn.ResetLoginAttempts(userAttemptsKey(user.Username)); err != nil {
+		return err
+	}
+	return uc.record(admin, domain.AdminActionUnlock, IIN, "")
+}
+
+// ForceLogout ends all sessions of the user
+func (uc *adminUsecaseImpl) ForceLogout(admin domain.User, IIN string) error {
+	if _, err := uc.dbConn.GetUserByIIN(IIN); err != nil {
+		return err
+	}
+	if err := revokeAllSessions(uc.cacheConn, IIN); err != nil {
+		return err
+	}
+	return uc.record(admin, domain.AdminActionForceLogout, IIN, "")
+}
+
+// TriggerPasswordReset ends all sessions of the user and mails a reset link. ErrNoEmail is returned
+// after recording the action if the user has no email
+func (uc *adminUsecaseImpl) TriggerPasswordReset(admin domain.User, IIN string) error {
+	user, err := uc.dbConn.GetUserByIIN(IIN)
+	if err != nil {
+		return err
+	}
+	if err := revokeAllSessions(uc.cacheConn, IIN); err != nil {
+		return err
+	}
+	err = uc.reset.SendResetLink(user)
+	if err != nil && err != myerrors.ErrNoEmail {
+		return err
+	}
+	details := "sessions revoked, reset link sent"
+	if err == myerrors.ErrNoEmail {
+		details = "sessions revoked, user has no email"
+	}
+	if recordErr := uc.record(admin, domain.AdminActionPasswordReset, IIN, details); recordErr != nil {
+		return recordErr
+	}
+	return err
+}
+
+// record stores admin action, an action that couldn't be recorded is reported as failed
+func (uc *adminUsecaseImpl) record(admin domain.User, action, IIN, details string) error {
+	logger.Info("Admin action", "admin_iin", admin.IIN, "action", action, "iin", IIN)
+	return uc.dbConn.RecordAdminAction(&domain.AdminAction{
+		AdminIIN:  admin.IIN,
+		Action:    action,
+		TargetIIN: IIN,
+		Details:   details,
+	})
+}
+
+// NewAdminUsecase returns new AdminUsecase
+func NewAdminUsecase(c repository.CacheInterface, db repository.DBInterface, api repository.APIInterface, reset PasswordResetUsecase) AdminUsecase {
+	return &adminUsecaseImpl{
+		cacheConn: c,
+		dbConn:    db,
+		api:       api,
+		reset:     reset,
+	}
+}
@@ -0,0 +1,119 @@
+package usecase
+
+import (
+	"auth/config"
+	"auth/logger"
+	"auth/myerrors"
+	"auth/user/repository"
+	"time"
+)
+
+// userAttemptsKey returns key of failed login counter of a username
+func userAttemptsKey(username string) string {
+	return "user:" + username
+}
+
+// ipAttemptsKey returns key of failed login counter of an IP
+func ipAttemptsKey(IP string) string {
+	return "ip:" + IP
+}
+
+// iinAttemptsKey counts failed current password checks of the signed in user, separately from login by username
+func iinAttemptsKey(IIN string) string {
+	return "iin:" + IIN
+}
+
+type LoginAttemptsUsecase interface {
+	CheckAllowed(username, IP string) (time.Duration, error)
+	LoginFailed(username, IP string) error
+	LoginSucceeded(username string) error
+	CheckPasswordAllowed(IIN, IP string) (time.Duration, error)
+	PasswordCheckFailed(IIN, IP string) error
+	PasswordCheckSucceeded(IIN string) error
+}
+
+type loginAttemptsUsecaseImpl struct {
+	cacheConn repository.CacheInterface
+	policy    *config.LoginPolicy
+}
+
+// CheckAllowed returns ErrLoginLocked if the username is locked out, ErrTooManyAttempts if the IP is locked out
+// or next attempt is delayed. Returned duration is how long to wait
+func (uc *loginAttemptsUsecaseImpl) CheckAllowed(username, IP string) (time.Duration, error) {
+	return uc.checkAllowed(userAttemptsKey(username), IP)
+}
+
+func (uc *loginAttemptsUsecaseImpl) checkAllowed(key, IP string) (time.Duration, error) {
+	now := time.Now()
+	userAttempts, err := uc.cacheConn.GetLoginAttempts(key)
+	if err != nil {
+		return 0, err
+	}
+	if userAttempts.Locked(now) {
+		return userAttempts.LockedUntil.Sub(now), myerrors.ErrLoginLocked
+	}
+	ipAttempts, err := uc.cacheConn.GetLoginAttempts(ipAttemptsKey(IP))
+	if err != nil {
+		return 0, err
+	}
+	if ipAttempts.Locked(now) {
+		return ipAttempts.LockedUntil.Sub(now), myerrors.ErrTooManyAttempts
+	}
+	if next := userAttempts.LastFailure.Add(uc.policy.Delay(userAttempts.Failures)); now.Before(next) {
+		return next.Sub(now), myerrors.ErrTooManyAttempts
+	}
+	return 0, nil
+}
+
+// LoginFailed counts failed attempt for the username and the IP, locking them out after too many failures
+func (uc *loginAttemptsUsecaseImpl) LoginFailed(username, IP string) error {
+	return uc.failed(userAttemptsKey(username), IP)
+}
+
+func (uc *loginAttemptsUsecaseImpl) failed(key, IP string) error {
+	if err := uc.addFailure(key, uc.policy.MaxFailures); err != nil {
+		return err
+	}
+	return uc.addFailure(ipAttemptsKey(IP), uc.policy.MaxIPFailures)
+}
+
+func (uc *loginAttemptsUsecaseImpl) addFailure(key string, maxFailures int) error {
+	attempts, err := uc.cacheConn.AddLoginFailure(key, uc.policy.Window)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if attempts.Failures < maxFailures || attempts.Locked(now) {
+		return nil
+	}
+	logger.Security("Too many failed logins, locking", "failures", attempts.Failures, "key", key, "lockout", uc.policy.Lockout)
+	return uc.cacheConn.LockLogin(key, now.Add(uc.policy.Lockout))
+}
+
+// LoginSucceeded clears failed attempts of the username
+func (uc *loginAttemptsUsecaseImpl) LoginSucceeded(username string) error {
+	return uc.cacheConn.ResetLoginAttempts(userAttemptsKey(username))
+}
+
+// CheckPasswordAllowed is CheckAllowed for the current password check of the signed in user
+func (uc *loginAttemptsUsecaseImpl) CheckPasswordAllowed(IIN, IP string) (time.Duration, error) {
+	return uc.checkAllowed(iinAttemptsKey(IIN), IP)
+}
+
+// PasswordCheckFailed counts wrong current password of the user and the IP, locking them out like LoginFailed
+func (uc *loginAttemptsUsecaseImpl) PasswordCheckFailed(IIN, IP string) error {
+	return uc.failed(iinAttemptsKey(IIN), IP)
+}
+
+// PasswordCheckSucceeded clears failed current password checks of the user
+func (uc *loginAttemptsUsecaseImpl) PasswordCheckSucceeded(IIN string) error {
+	return uc.cacheConn.ResetLoginAttempts(iinAttemptsKey(IIN))
+}
+
+// NewLoginAttemptsUsecase returns new LoginAttemptsUsecase
+func NewLoginAttemptsUsecase(c repository.CacheInterface, policy *config.LoginPolicy) LoginAttemptsUsecase {
+	return &loginAttemptsUsecaseImpl{
+		cacheConn: c,
+		policy:    policy,
+	}
+}
@@ -0,0 +1,88 @@
+package usecase
+
+import (
+	"auth/config"
+	"auth/myerrors"
+	"auth/user/repository/memory"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLoginPolicy() *config.LoginPolicy {
+	return &config.LoginPolicy{
+		MaxFailures:   3,
+		MaxIPFailures: 5,
+		FreeAttempts:  10,
+		Window:        time.Minute,
+		Lockout:       time.Hour,
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	uc := NewLoginAttemptsUsecase(memory.NewMemoryCacheInterface(), newTestLoginPolicy())
+	for i := 0; i < 3; i++ {
+		_, err := uc.CheckAllowed("user", "10.0.0.1")
+		assert.NoError(t, err)
+		assert.NoError(t, uc.LoginFailed("user", "10.0.0.1"))
+	}
+	retryAfter, err := uc.CheckAllowed("user", "10.0.0.1")
+	assert.Equal(t, myerrors.ErrLoginLocked, err)
+	assert.True(t, retryAfter > 59*time.Minute)
+
+	// other users from the same IP are not locked until IP limit is reached
+	_, err = uc.CheckAllowed("other", "10.0.0.1")
+	assert.NoError(t, err)
+	assert.NoError(t, uc.LoginFailed("other", "10.0.0.1"))
+	assert.NoError(t, uc.LoginFailed("other", "10.0.0.1"))
+	_, err = uc.CheckAllowed("third", "10.0.0.1")
+	assert.Equal(t, myerrors.ErrTooManyAttempts, err)
+	_, err = uc.CheckAllowed("third", "10.0.0.2")
+	assert.NoError(t, err)
+}
+
+func TestLoginSucceededResets(t *testing.T) {
+	uc := NewLoginAttemptsUsecase(memory.NewMemoryCacheInterface(), newTestLoginPolicy())
+	assert.NoError(t, uc.LoginFailed("user", "10.0.0.1"))
+	assert.NoError(t, uc.LoginFailed("user", "10.0.0.1"))
+	assert.NoError(t, uc.LoginSucceeded("user"))
+	assert.NoError(t, uc.LoginFailed("user", "10.0.0.1"))
+	_, err := uc.CheckAllowed("user", "10.0.0.1")
+	assert.NoError(t, err)
+}
+
+func TestLoginDelay(t *testing.T) {
+	policy := newTestLoginPolicy()
+	policy.FreeAttempts = 1
+	policy.BaseDelay = time.Minute
+	policy.MaxDelay = time.Hour
+	uc := NewLoginAttemptsUsecase(memory.NewMemoryCacheInterface(), policy)
+	assert.NoError(t, uc.LoginFailed("user", "10.0.0.1"))
+	_, err := uc.CheckAllowed("user", "10.0.0.1")
+	assert.NoError(t, err)
+	assert.NoError(t, uc.LoginFailed("user", "10.0.0.1"))
+	retryAfter, err := uc.CheckAllowed("user", "10.0.0.1")
+	assert.Equal(t, myerrors.ErrTooManyAttempts, err)
+	assert.True(t, retryAfter > 59*time.Second && retryAfter <= time.Minute)
+}
+
+func TestPasswordCheckLockout(t *testing.T) {
+	uc := NewLoginAttemptsUsecase(memory.NewMemoryCacheInterface(), newTestLoginPolicy())
+	for i := 0; i < 3; i++ {
+		_, err := uc.CheckPasswordAllowed("910815450350", "10.0.0.1")
+		assert.NoError(t, err)
+		assert.NoError(t, uc.PasswordCheckFailed("910815450350", "10.0.0.1"))
+	}
+	retryAfter, err := uc.CheckPasswordAllowed("910815450350", "10.0.0.1")
+	assert.Equal(t, myerrors.ErrLoginLocked, err)
+	assert.True(t, retryAfter > 59*time.Minute)
+
+	// username that equals the IIN is counted separately
+	_, err = uc.CheckAllowed("910815450350", "10.0.0.2")
+	assert.NoError(t, err)
+
+	assert.NoError(t, uc.PasswordCheckSucceeded("910815450350"))
+	_, err = uc.CheckPasswordAllowed("910815450350", "10.0.0.2")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,82 @@
+package usecase
+
+import (
+	"auth/domain"
+	"auth/myerrors"
+	"auth/user/repository"
+	"strings"
+	"time"
+)
+
+const (
+	auditPageLimit = 50
+	auditMaxLimit  = 500
+	// auditExportLimit is the most events exported at once, narrower filter is needed for older ones
+	auditExportLimit = 10000
+)
+
+type AuditUsecase interface {
+	ListEvents(filter *domain.AuditFilter) ([]domain.AuditEvent, int, error)
+	ExportEvents(filter *domain.AuditFilter) ([]domain.AuditEvent, error)
+}
+
+type auditUsecaseImpl struct {
+	audit repository.AuditRepository
+}
+
+// ListEvents returns page of audit events matching filter and total number of them. Zero limit gives default page size
+func (uc *auditUsecaseImpl) ListEvents(filter *domain.AuditFilter) ([]domain.AuditEvent, int, error) {
+	if filter.Limit == 0 {
+		filter.Limit = auditPageLimit
+	}
+	if err := validateAuditFilter(filter); err != nil {
+		return nil, 0, err
+	}
+	if filter.Limit > auditMaxLimit {
+		return nil, 0, myerrors.ErrInvalidInput
+	}
+	return uc.audit.ListEvents(filter)
+}
+
+// ExportEvents returns newest events matching filter ignoring its paging, at most auditExportLimit of them
+func (uc *auditUsecaseImpl) ExportEvents(filter *domain.AuditFilter) ([]domain.AuditEvent, error) {
+	if err := validateAuditFilter(filter); err != nil {
+		return nil, err
+	}
+	filter.Limit, filter.Offset = auditExportLimit, 0
+	events, _, err := uc.audit.ListEvents(filter)
+	return events, err
+}
+
+// validateAuditFilter trims filter fields and checks dates and paging
+func validateAuditFilter(filter *domain.AuditFilter) error {
+	for _, field := range []*string{&filter.ActorIIN, &filter.Action, &filter.Target, &filter.Outcome, &filter.From, &filter.To} {
+		*field = strings.TrimSpace(*field)
+	}
+	var from, to time.Time
+	var err error
+	if filter.From != "" {
+		if from, err = time.Parse("2006-01-02", filter.From); err != nil {
+			return myerrors.ErrInvalidInput
+		}
+	}
+	if filter.To != "" {
+		if to, err = time.Parse("2006-01-02", filter.To); err != nil {
+			return myerrors.ErrInvalidInput
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && from.After(to) {
+		return myerrors.ErrInvalidInput
+	}
+	if filter.Limit < 0 || filter.Offset < 0 {
+		return myerrors.ErrInvalidInput
+	}
+	return nil
+}
+
+// NewAuditUsecase returns new AuditUsecase
+func NewAuditUsecase(audit repository.AuditRepository) AuditUsecase {
+	return &auditUsecaseImpl{
+		audit: audit,
+	}
+}
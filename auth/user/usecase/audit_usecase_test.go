@@ -0,0 +1,68 @@
+package usecase
+
+import (
+	"auth/domain"
+	"auth/myerrors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// auditTestRepository remembers the last filter it was asked for
+type auditTestRepository struct {
+	filter domain.AuditFilter
+}
+
+func (r *auditTestRepository) RecordEvent(event *domain.AuditEvent) error {
+	return nil
+}
+
+func (r *auditTestRepository) ListEvents(filter *domain.AuditFilter) ([]domain.AuditEvent, int, error) {
+	r.filter = *filter
+	return []domain.AuditEvent{{ID: 1, Action: filter.Action}}, 1, nil
+}
+
+func TestListAuditEvents(t *testing.T) {
+	repo := &auditTestRepository{}
+	uc := NewAuditUsecase(repo)
+
+	var testTable = []struct {
+		name     string
+		filter   domain.AuditFilter
+		expected error
+	}{
+		{"no filter", domain.AuditFilter{}, nil},
+		{"dates", domain.AuditFilter{From: "2022-01-01", To: "2022-01-01"}, nil},
+		{"max limit", domain.AuditFilter{Limit: auditMaxLimit}, nil},
+		{"wrong from", domain.AuditFilter{From: "01.01.2022"}, myerrors.ErrInvalidInput},
+		{"wrong to", domain.AuditFilter{To: "2022-13-01"}, myerrors.ErrInvalidInput},
+		{"from after to", domain.AuditFilter{From: "2022-01-02", To: "2022-01-01"}, myerrors.ErrInvalidInput},
+		{"big limit", domain.AuditFilter{Limit: auditMaxLimit + 1}, myerrors.ErrInvalidInput},
+		{"negative limit", domain.AuditFilter{Limit: -1}, myerrors.ErrInvalidInput},
+		{"negative offset", domain.AuditFilter{Offset: -1}, myerrors.ErrInvalidInput},
+	}
+	for _, tt := range testTable {
+		_, _, err := uc.ListEvents(&tt.filter)
+		assert.Equal(t, tt.expected, err, tt.name)
+	}
+
+	events, total, err := uc.ListEvents(&domain.AuditFilter{ActorIIN: " 910815450350 ", Action: "login"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, total)
+	assert.Len(t, events, 1)
+	assert.Equal(t, domain.AuditFilter{ActorIIN: "910815450350", Action: "login", Limit: auditPageLimit}, repo.filter)
+}
+
+func TestExportAuditEvents(t *testing.T) {
+	repo := &auditTestRepository{}
+	uc := NewAuditUsecase(repo)
+
+	events, err := uc.ExportEvents(&domain.AuditFilter{Outcome: "failure", Limit: 10, Offset: 30})
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+	// paging is ignored
+	assert.Equal(t, domain.AuditFilter{Outcome: "failure", Limit: auditExportLimit}, repo.filter)
+
+	_, err = uc.ExportEvents(&domain.AuditFilter{From: "yesterday"})
+	assert.Equal(t, myerrors.ErrInvalidInput, err)
+}
@@ -0,0 +1,173 @@
+package usecase
+
+import (
+	"auth/domain"
+	"auth/logger"
+	"auth/myerrors"
+	"auth/totp"
+	"auth/user/repository"
+	"strings"
+	"time"
+)
+
+type MFAUsecase interface {
+	Status(IIN string) (*domain.MFAStatus, error)
+	IsEnabled(user *domain.User) (bool, error)
+	Enroll(IIN string) (*domain.MFAEnrollment, error)
+	Confirm(IIN, code string) ([]string, error)
+	Verify(user *domain.User, code string) error
+	Disable(IIN, code string) error
+}
+
+type mfaUsecaseImpl struct {
+	dbConn repository.DBInterface
+	cipher *totp.Cipher
+	issuer string
+}
+
+// Status returns two-factor settings of the user
+func (uc *mfaUsecaseImpl) Status(IIN string) (*domain.MFAStatus, error) {
+	user, err := uc.dbConn.GetUserByIIN(IIN)
+	if err != nil {
+		return nil, err
+	}
+	enabled, err := uc.IsEnabled(user)
+	if err != nil {
+		return nil, err
+	}
+	return &domain.MFAStatus{Enabled: enabled}, nil
+}
+
+// IsEnabled tells whether login of the user needs second factor
+func (uc *mfaUsecaseImpl) IsEnabled(user *domain.User) (bool, error) {
+	mfa, err := uc.dbConn.GetMFA(user.ID)
+	if err == myerrors.ErrMFANotEnrolled {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return mfa.Enabled, nil
+}
+
+// Enroll generates new secret stored as pending until confirmed with a code
+func (uc *mfaUsecaseImpl) Enroll(IIN string) (*domain.MFAEnrollment, error) {
+	user, err := uc.dbConn.GetUserByIIN(IIN)
+	if err != nil {
+		return nil, err
+	}
+	if enabled, err := uc.IsEnabled(user); err != nil {
+		return nil, err
+	} else if enabled {
+		return nil, myerrors.ErrMFAEnabled
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := uc.cipher.Encrypt(secret)
+	if err != nil {
+		return nil, err
+	}
+	if err := uc.dbConn.SaveMFA(user.ID, encrypted); err != nil {
+		return nil, err
+	}
+	return &domain.MFAEnrollment{
+		Secret: totp.EncodeSecret(secret),
+		URI:    totp.ProvisioningURI(uc.issuer, user.Username, secret),
+	}, nil
+}
+
+// Confirm enables pending enrollment if code is valid and returns recovery codes, they are shown only once
+func (uc *mfaUsecaseImpl) Confirm(IIN, code string) ([]string, error) {
+	user, err := uc.dbConn.GetUserByIIN(IIN)
+	if err != nil {
+		return nil, err
+	}
+	mfa, err := uc.dbConn.GetMFA(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if mfa.Enabled {
+		return nil, myerrors.ErrMFAEnabled
+	}
+	secret, err := uc.cipher.Decrypt(mfa.Secret)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return nil, myerrors.ErrInvalidMFACode
+	}
+	codes, err := totp.GenerateRecoveryCodes(totp.RecoveryCodes)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = totp.HashRecoveryCode(c)
+	}
+	if err := uc.dbConn.EnableMFA(user.ID, step, hashes); err != nil {
+		return nil, err
+	}
+	logger.Security("Two-factor authentication enabled", "iin", user.IIN)
+	return codes, nil
+}
+
+// Verify checks TOTP code or unused recovery code, each of them is accepted only once
+func (uc *mfaUsecaseImpl) Verify(user *domain.User, code string) error {
+	mfa, err := uc.dbConn.GetMFA(user.ID)
+	if err != nil {
+		return err
+	}
+	if !mfa.Enabled {
+		return myerrors.ErrMFANotEnrolled
+	}
+	code = strings.TrimSpace(code)
+	if len(code) == totp.Digits {
+		secret, err := uc.cipher.Decrypt(mfa.Secret)
+		if err != nil {
+			return err
+		}
+		step, ok := totp.Validate(secret, code, time.Now())
+		if !ok {
+			return myerrors.ErrInvalidMFACode
+		}
+		if ok, err = uc.dbConn.UseMFAStep(user.ID, step); err != nil {
+			return err
+		} else if !ok {
+			logger.Security("Reused two-factor code", "iin", user.IIN)
+			return myerrors.ErrInvalidMFACode
+		}
+		return nil
+	}
+	ok, err := uc.dbConn.UseRecoveryCode(user.ID, totp.HashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return myerrors.ErrInvalidMFACode
+	}
+	logger.Security("Recovery code used", "iin", user.IIN)
+	return nil
+}
+
+// Disable removes enrollment and recovery codes, current code is required
+func (uc *mfaUsecaseImpl) Disable(IIN, code string) error {
+	user, err := uc.dbConn.GetUserByIIN(IIN)
+	if err != nil {
+		return err
+	}
+	if err := uc.Verify(user, code); err != nil {
+		return err
+	}
+	if err := uc.dbConn.DeleteMFA(user.ID); err != nil {
+		return err
+	}
+	logger.Security("Two-factor authentication disabled", "iin", user.IIN)
+	return nil
+}
+
+func NewMFAUsecase(db repository.DBInterface, cipher *totp.Cipher, issuer string) MFAUsecase {
+	return &mfaUsecaseImpl{dbConn: db, cipher: cipher, issuer: issuer}
+}
@@ -0,0 +1,349 @@
+package usecase
+
+import (
+	"auth/config"
+	"auth/domain"
+	"auth/logger"
+	"auth/myerrors"
+	"auth/user/repository"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type OAuthUsecase interface {
+	RegisterClient(name string, redirectURIs, scopes []string, public bool) (*domain.OAuthClient, error)
+	RegisterServiceClient(name string, scopes []string) (*domain.OAuthClient, error)
+	ListClients() ([]domain.OAuthClient, error)
+	GetClient(clientID string) (*domain.OAuthClient, error)
+	AuthenticateClient(clientID, secret string) (*domain.OAuthClient, error)
+	ValidateAuthorization(req *domain.AuthorizationRequest) (*domain.OAuthClient, []string, error)
+	IssueCode(code *domain.AuthCode) (string, error)
+	ExchangeCode(client *domain.OAuthClient, code, redirectURI, verifier string) (*domain.AuthCode, error)
+	AuthorizeService(client *domain.OAuthClient, scope string) ([]string, error)
+	GetUser(IIN string, scopes []string) (*domain.User, error)
+	CreateSession(session *domain.Session, token string) error
+	RotateToken(IIN, sessionID, oldToken, newToken string) error
+	CheckSession(IIN, sessionID, refreshToken string) (*domain.User, error)
+	IsTokenRevoked(jti string) (bool, error)
+	RevokeToken(IIN, sessionID, jti string, expires time.Time) error
+	Policy() *config.OAuthPolicy
+}
+
+type oauthUsecaseImpl struct {
+	cacheConn repository.CacheInterface
+	dbConn    repository.DBInterface
+	policy    *config.OAuthPolicy
+	update    UpdateTokenUsecase
+}
+
+// pkcePattern matches code verifiers and S256 challenges, RFC 7636 section 4.1
+var pkcePattern = regexp.MustCompile(`^[A-Za-z0-9._~-]{43,128}$`)
+
+// newSecret returns random hex string of size bytes
+func newSecret(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// RegisterClient stores new client, secret of a confidential client is returned only here
+func (uc *oauthUsecaseImpl) RegisterClient(name string, redirectURIs, scopes []string, public bool) (*domain.OAuthClient, error) {
+	if strings.TrimSpace(name) == "" || len(redirectURIs) == 0 {
+		return nil, myerrors.ErrInvalidInput
+	}
+	for _, redirectURI := range redirectURIs {
+		if !validRedirectURI(redirectURI) {
+			return nil, myerrors.ErrInvalidRedirect
+		}
+	}
+	for _, scope := range scopes {
+		if _, ok := domain.OAuthScopes[scope]; !ok {
+			return nil, myerrors.ErrInvalidScope
+		}
+	}
+	clientID, err := newSecret(16)
+	if err != nil {
+		return nil, err
+	}
+	client := &domain.OAuthClient{
+		ClientID:     clientID,
+		Name:         strings.TrimSpace(name),
+		RedirectURIs: redirectURIs,
+		Scopes:       scopes,
+		Public:       public,
+	}
+	if !public {
+		if client.ClientSecret, err = newSecret(32); err != nil {
+			return nil, err
+		}
+		client.SecretHash = hashToken(client.ClientSecret)
+	}
+	if err := uc.dbConn.AddOAuthClient(client); err != nil {
+		return nil, err
+	}
+	logger.Security("OAuth client registered", "client_id", client.ClientID, "name", client.Name)
+	return client, nil
+}
+
+// RegisterServiceClient stores new service client, it always has a secret and no redirect URIs
+func (uc *oauthUsecaseImpl) RegisterServiceClient(name string, scopes []string) (*domain.OAuthClient, error) {
+	if strings.TrimSpace(name) == "" || len(scopes) == 0 {
+		return nil, myerrors.ErrInvalidInput
+	}
+	for _, scope := range scopes {
+		if _, ok := domain.ServiceScopes[scope]; !ok {
+			return nil, myerrors.ErrInvalidScope
+		}
+	}
+	clientID, err := newSecret(16)
+	if err != nil {
+		return nil, err
+	}
+	client := &domain.OAuthClient{
+		ClientID:     clientID,
+		Name:         strings.TrimSpace(name),
+		RedirectURIs: []string{},
+		Scopes:       scopes,
+		Service:      true,
+	}
+	if client.ClientSecret, err = newSecret(32); err != nil {
+		return nil, err
+	}
+	client.SecretHash = hashToken(client.ClientSecret)
+	if err := uc.dbConn.AddOAuthClient(client); err != nil {
+		return nil, err
+	}
+	logger.Security("OAuth service client registered", "client_id", client.ClientID, "name", client.Name, "scopes", client.Scopes)
+	return client, nil
+}
+
+// validRedirectURI accepts absolute URIs without fragment, plain http only for local development
+func validRedirectURI(redirectURI string) bool {
+	u, err := url.Parse(redirectURI)
+	if err != nil || !u.IsAbs() || u.Fragment != "" || strings.ContainsAny(redirectURI, " \n") {
+		return false
+	}
+	if u.Scheme == "http" {
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1"
+	}
+	// https and private-use schemes of native apps
+	return u.Scheme == "https" || (u.Host == "" && strings.Contains(u.Scheme, "."))
+}
+
+func (uc *oauthUsecaseImpl) ListClients() ([]domain.OAuthClient, error) {
+	return uc.dbConn.ListOAuthClients()
+}
+
+func (uc *oauthUsecaseImpl) GetClient(clientID string) (*domain.OAuthClient, error) {
+	return uc.dbConn.GetOAuthClient(clientID)
+}
+
+// AuthenticateClient checks client secret, public clients must not send one
+func (uc *oauthUsecaseImpl) AuthenticateClient(clientID, secret string) (*domain.OAuthClient, error) {
+	if clientID == "" {
+		return nil, myerrors.ErrInvalidClient
+	}
+	client, err := uc.dbConn.GetOAuthClient(clientID)
+	if err == myerrors.ErrClientNotFound {
+		return nil, myerrors.ErrInvalidClient
+	}
+	if err != nil {
+		return nil, err
+	}
+	if client.Public {
+		if secret != "" {
+			return nil, myerrors.ErrInvalidClient
+		}
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, myerrors.ErrInvalidClient
+	}
+	return client, nil
+}
+
+// ValidateAuthorization checks authorization request and returns its client and scopes, all client scopes by default.
+// ErrClientNotFound and ErrInvalidRedirect must not be redirected back to the client
+func (uc *oauthUsecaseImpl) ValidateAuthorization(req *domain.AuthorizationRequest) (*domain.OAuthClient, []string, error) {
+	client, err := uc.dbConn.GetOAuthClient(req.ClientID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !contains(client.RedirectURIs, req.RedirectURI) {
+		return nil, nil, myerrors.ErrInvalidRedirect
+	}
+	if req.ResponseType != "code" {
+		return client, nil, myerrors.ErrResponseType
+	}
+	if req.CodeChallengeMethod != "S256" || !pkcePattern.MatchString(req.CodeChallenge) {
+		return client, nil, myerrors.ErrInvalidPKCE
+	}
+	scopes := strings.Fields(req.Scope)
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+	for _, scope := range scopes {
+		if !contains(client.Scopes, scope) {
+			return client, nil, myerrors.ErrInvalidScope
+		}
+	}
+	return client, scopes, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// IssueCode stores authorization code approved by the user, only its hash is kept
+func (uc *oauthUsecaseImpl) IssueCode(code *domain.AuthCode) (string, error) {
+	value, err := newSecret(32)
+	if err != nil {
+		return "", err
+	}
+	if err := uc.cacheConn.InsertAuthCode(hashToken(value), code, uc.policy.CodeTtl); err != nil {
+		return "", err
+	}
+	logger.Security("OAuth client authorized", "client_id", code.ClientID, "iin", code.IIN, "scope", code.Scope)
+	return value, nil
+}
+
+// ExchangeCode uses authorization code up and checks it was issued to the client
+// for the same redirect URI and PKCE code verifier
+func (uc *oauthUsecaseImpl) ExchangeCode(client *domain.OAuthClient, code, redirectURI, verifier string) (*domain.AuthCode, error) {
+	grant, err := uc.cacheConn.ConsumeAuthCode(hashToken(code))
+	if err != nil {
+		return nil, err
+	}
+	if grant.ClientID != client.ClientID || grant.RedirectURI != redirectURI {
+		logger.Security("Authorization code presented by another client or for another redirect uri", "client_id", client.ClientID)
+		return nil, myerrors.ErrInvalidGrant
+	}
+	if !pkcePattern.MatchString(verifier) {
+		return nil, myerrors.ErrInvalidGrant
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	if subtle.ConstantTimeCompare([]byte(challenge), []byte(grant.CodeChallenge)) != 1 {
+		logger.Security("PKCE code verifier mismatch", "client_id", client.ClientID)
+		return nil, myerrors.ErrInvalidGrant
+	}
+	return grant, nil
+}
+
+// AuthorizeService returns scopes of client_credentials grant, all client scopes by default.
+// Only service clients may use it, clients acting for users must not get tokens of their own
+func (uc *oauthUsecaseImpl) AuthorizeService(client *domain.OAuthClient, scope string) ([]string, error) {
+	if !client.Service {
+		return nil, myerrors.ErrUnauthorizedClient
+	}
+	scopes := strings.Fields(scope)
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+	for _, s := range scopes {
+		if !contains(client.Scopes, s) {
+			return nil, myerrors.ErrInvalidScope
+		}
+	}
+	return scopes, nil
+}
+
+// GetUser gets user tokens are issued for. Client tokens carry only permissions granted as scopes and no roles,
+// so that a client never acts as admin
+func (uc *oauthUsecaseImpl) GetUser(IIN string, scopes []string) (*domain.User, error) {
+	user, err := uc.update.GetUser(IIN)
+	if err != nil {
+		return nil, err
+	}
+	granted := []string{}
+	for _, permission := range user.Permissions {
+		if contains(scopes, permission) {
+			granted = append(granted, permission)
+		}
+	}
+	user.Permissions = granted
+	user.Roles = []string{}
+	user.IsAdmin = false
+	return user, nil
+}
+
+// CreateSession stores session of the client, it is listed and revoked like other sessions of the user
+func (uc *oauthUsecaseImpl) CreateSession(session *domain.Session, token string) error {
+	return uc.cacheConn.InsertSession(session, token, uc.policy.RefreshTtl)
+}
+
+// RotateToken replaces refresh token of client session, reuse revokes the session
+func (uc *oauthUsecaseImpl) RotateToken(IIN, sessionID, oldToken, newToken string) error {
+	return uc.update.RotateToken(IIN, sessionID, oldToken, newToken, uc.policy.RefreshTtl)
+}
+
+// CheckSession returns user of a live session, so that tokens of revoked sessions and locked users
+// are reported inactive before they expire. A refresh token must also be the current one of the session
+func (uc *oauthUsecaseImpl) CheckSession(IIN, sessionID, refreshToken string) (*domain.User, error) {
+	session, err := uc.cacheConn.GetSession(sessionID)
+	if err == myerrors.ErrSessionNotFound {
+		return nil, myerrors.ErrRefreshNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if session.IIN != IIN {
+		return nil, myerrors.ErrRefreshNotFound
+	}
+	if refreshToken != "" {
+		current, err := uc.cacheConn.GetSessionToken(sessionID)
+		if err == myerrors.ErrSessionNotFound {
+			return nil, myerrors.ErrRefreshNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		if subtle.ConstantTimeCompare([]byte(current), []byte(refreshToken)) != 1 {
+			return nil, myerrors.ErrTokenReused
+		}
+	}
+	return uc.update.GetUser(IIN)
+}
+
+func (uc *oauthUsecaseImpl) IsTokenRevoked(jti string) (bool, error) {
+	return uc.cacheConn.IsTokenDenied(jti)
+}
+
+// RevokeToken denylists token until it expires and revokes its session, if it has one
+func (uc *oauthUsecaseImpl) RevokeToken(IIN, sessionID, jti string, expires time.Time) error {
+	if err := uc.cacheConn.DenyToken(jti, time.Until(expires)); err != nil {
+		return err
+	}
+	if sessionID == "" {
+		return nil
+	}
+	return uc.cacheConn.DeleteSession(IIN, sessionID)
+}
+
+func (uc *oauthUsecaseImpl) Policy() *config.OAuthPolicy {
+	return uc.policy
+}
+
+func NewOAuthUsecase(c repository.CacheInterface, db repository.DBInterface, policy *config.OAuthPolicy) OAuthUsecase {
+	return &oauthUsecaseImpl{
+		cacheConn: c,
+		dbConn:    db,
+		policy:    policy,
+		update:    NewUpdateTokenUsecase(c, db),
+	}
+}
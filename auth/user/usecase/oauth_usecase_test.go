@@ -0,0 +1,223 @@
+package usecase
+
+import (
+	"auth/config"
+	"auth/domain"
+	"auth/myerrors"
+	"auth/user/repository/memory"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// oauthTestDB keeps OAuth clients in memory, users have wallets and admin permissions
+type oauthTestDB struct {
+	resetTestDB
+	clients map[string]*domain.OAuthClient
+}
+
+func (db *oauthTestDB) AddOAuthClient(client *domain.OAuthClient) error {
+	c := *client
+	// like in MySQL only hash of the secret is kept
+	c.ClientSecret = ""
+	db.clients[client.ClientID] = &c
+	return nil
+}
+
+func (db *oauthTestDB) GetOAuthClient(clientID string) (*domain.OAuthClient, error) {
+	client, ok := db.clients[clientID]
+	if !ok {
+		return nil, myerrors.ErrClientNotFound
+	}
+	c := *client
+	return &c, nil
+}
+
+func (db *oauthTestDB) GetUserRoles(userID int) ([]string, []string, error) {
+	return []string{AdminRole}, []string{domain.PermWalletsRead, domain.PermWalletsWrite, domain.PermUsersWrite}, nil
+}
+
+func newOAuthTest() (OAuthUsecase, *oauthTestDB) {
+	db := &oauthTestDB{
+		resetTestDB: resetTestDB{users: map[string]*domain.User{
+			"910815450350": {IIN: "910815450350", Username: "user"},
+			"980124450084": {IIN: "980124450084", Username: "locked", Locked: true},
+		}},
+		clients: map[string]*domain.OAuthClient{},
+	}
+	return NewOAuthUsecase(memory.NewMemoryCacheInterface(), db, &config.OAuthPolicy{CodeTtl: time.Minute, RefreshTtl: time.Hour}), db
+}
+
+func TestRegisterClient(t *testing.T) {
+	uc, _ := newOAuthTest()
+
+	client, err := uc.RegisterClient("Shop", []string{"https://shop.example.com/cb"}, []string{domain.ScopeProfile}, false)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, client.ClientSecret)
+	authenticated, err := uc.AuthenticateClient(client.ClientID, client.ClientSecret)
+	assert.NoError(t, err)
+	assert.Empty(t, authenticated.ClientSecret)
+	_, err = uc.AuthenticateClient(client.ClientID, "wrong")
+	assert.Equal(t, myerrors.ErrInvalidClient, err)
+	_, err = uc.AuthenticateClient(client.ClientID, "")
+	assert.Equal(t, myerrors.ErrInvalidClient, err)
+
+	public, err := uc.RegisterClient("App", []string{"com.example.app:/cb", "http://127.0.0.1:8000/cb"}, nil, true)
+	assert.NoError(t, err)
+	assert.Empty(t, public.ClientSecret)
+	_, err = uc.AuthenticateClient(public.ClientID, "")
+	assert.NoError(t, err)
+	_, err = uc.AuthenticateClient(public.ClientID, "secret")
+	assert.Equal(t, myerrors.ErrInvalidClient, err)
+	_, err = uc.AuthenticateClient("unknown", "")
+	assert.Equal(t, myerrors.ErrInvalidClient, err)
+
+	for _, redirectURI := range []string{"http://shop.example.com/cb", "https://shop.example.com/cb#token", "/cb", "myapp://cb"} {
+		_, err = uc.RegisterClient("Shop", []string{redirectURI}, nil, false)
+		assert.Equal(t, myerrors.ErrInvalidRedirect, err, redirectURI)
+	}
+	_, err = uc.RegisterClient("Shop", []string{"https://shop.example.com/cb"}, []string{domain.PermUsersWrite}, false)
+	assert.Equal(t, myerrors.ErrInvalidScope, err)
+	_, err = uc.RegisterClient(" ", []string{"https://shop.example.com/cb"}, nil, false)
+	assert.Equal(t, myerrors.ErrInvalidInput, err)
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	uc, db := newOAuthTest()
+	db.clients["shop"] = &domain.OAuthClient{ClientID: "shop", RedirectURIs: []string{"https://shop.example.com/cb"}, Scopes: []string{domain.ScopeProfile, domain.PermWalletsRead}}
+	db.clients["other"] = &domain.OAuthClient{ClientID: "other", RedirectURIs: []string{"https://other.example.com/cb"}}
+
+	verifier := strings.Repeat("v", 43)
+	sum := sha256.Sum256([]byte(verifier))
+	request := func(change func(req *domain.AuthorizationRequest)) *domain.AuthorizationRequest {
+		req := &domain.AuthorizationRequest{ResponseType: "code", ClientID: "shop", RedirectURI: "https://shop.example.com/cb",
+			CodeChallenge: base64.RawURLEncoding.EncodeToString(sum[:]), CodeChallengeMethod: "S256"}
+		if change != nil {
+			change(req)
+		}
+		return req
+	}
+
+	client, scopes, err := uc.ValidateAuthorization(request(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{domain.ScopeProfile, domain.PermWalletsRead}, scopes)
+	_, scopes, err = uc.ValidateAuthorization(request(func(req *domain.AuthorizationRequest) { req.Scope = domain.ScopeProfile }))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{domain.ScopeProfile}, scopes)
+
+	var testTable = []struct {
+		name   string
+		change func(req *domain.AuthorizationRequest)
+		err    error
+	}{
+		{"unknown client", func(req *domain.AuthorizationRequest) { req.ClientID = "unknown" }, myerrors.ErrClientNotFound},
+		{"wrong redirect", func(req *domain.AuthorizationRequest) { req.RedirectURI = "https://shop.example.com/other" }, myerrors.ErrInvalidRedirect},
+		{"implicit", func(req *domain.AuthorizationRequest) { req.ResponseType = "token" }, myerrors.ErrResponseType},
+		{"no pkce", func(req *domain.AuthorizationRequest) { req.CodeChallenge = "" }, myerrors.ErrInvalidPKCE},
+		{"plain pkce", func(req *domain.AuthorizationRequest) { req.CodeChallengeMethod = "plain" }, myerrors.ErrInvalidPKCE},
+		{"scope not registered", func(req *domain.AuthorizationRequest) { req.Scope = domain.PermWalletsWrite }, myerrors.ErrInvalidScope},
+	}
+	for _, tt := range testTable {
+		_, _, err := uc.ValidateAuthorization(request(tt.change))
+		assert.Equal(t, tt.err, err, tt.name)
+	}
+
+	code, err := uc.IssueCode(&domain.AuthCode{ClientID: "shop", IIN: "910815450350", RedirectURI: "https://shop.example.com/cb", Scope: "profile wallets:read", CodeChallenge: request(nil).CodeChallenge})
+	assert.NoError(t, err)
+	_, err = uc.ExchangeCode(db.clients["other"], code, "https://shop.example.com/cb", verifier)
+	assert.Equal(t, myerrors.ErrInvalidGrant, err)
+	// code is used up by the failed exchange
+	_, err = uc.ExchangeCode(client, code, "https://shop.example.com/cb", verifier)
+	assert.Equal(t, myerrors.ErrInvalidGrant, err)
+
+	code, err = uc.IssueCode(&domain.AuthCode{ClientID: "shop", IIN: "910815450350", RedirectURI: "https://shop.example.com/cb", Scope: "profile wallets:read", CodeChallenge: request(nil).CodeChallenge})
+	assert.NoError(t, err)
+	_, err = uc.ExchangeCode(client, code, "https://shop.example.com/cb", strings.Repeat("w", 43))
+	assert.Equal(t, myerrors.ErrInvalidGrant, err)
+
+	code, err = uc.IssueCode(&domain.AuthCode{ClientID: "shop", IIN: "910815450350", RedirectURI: "https://shop.example.com/cb", Scope: "profile wallets:read", CodeChallenge: request(nil).CodeChallenge})
+	assert.NoError(t, err)
+	grant, err := uc.ExchangeCode(client, code, "https://shop.example.com/cb", verifier)
+	assert.NoError(t, err)
+	assert.Equal(t, "910815450350", grant.IIN)
+
+	// client gets only permissions granted as scopes and is never admin
+	user, err := uc.GetUser(grant.IIN, strings.Fields(grant.Scope))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{domain.PermWalletsRead}, user.Permissions)
+	assert.Empty(t, user.Roles)
+	assert.False(t, user.IsAdmin)
+	_, err = uc.GetUser("980124450084", nil)
+	assert.Equal(t, myerrors.ErrUserLocked, err)
+}
+
+func TestServiceClient(t *testing.T) {
+	uc, db := newOAuthTest()
+
+	client, err := uc.RegisterServiceClient("Batch", []string{domain.PermUsersRead, domain.PermWalletsRead})
+	assert.NoError(t, err)
+	assert.True(t, client.Service)
+	assert.NotEmpty(t, client.ClientSecret)
+	authenticated, err := uc.AuthenticateClient(client.ClientID, client.ClientSecret)
+	assert.NoError(t, err)
+
+	scopes, err := uc.AuthorizeService(authenticated, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{domain.PermUsersRead, domain.PermWalletsRead}, scopes)
+	scopes, err = uc.AuthorizeService(authenticated, domain.PermUsersRead)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{domain.PermUsersRead}, scopes)
+	_, err = uc.AuthorizeService(authenticated, domain.PermWalletsWrite)
+	assert.Equal(t, myerrors.ErrInvalidScope, err)
+
+	// service clients can't sign users in
+	_, _, err = uc.ValidateAuthorization(&domain.AuthorizationRequest{ResponseType: "code", ClientID: client.ClientID, RedirectURI: "https://shop.example.com/cb"})
+	assert.Equal(t, myerrors.ErrInvalidRedirect, err)
+
+	db.clients["shop"] = &domain.OAuthClient{ClientID: "shop", RedirectURIs: []string{"https://shop.example.com/cb"}, Scopes: []string{domain.PermWalletsRead}}
+	_, err = uc.AuthorizeService(db.clients["shop"], "")
+	assert.Equal(t, myerrors.ErrUnauthorizedClient, err)
+
+	_, err = uc.RegisterServiceClient("Batch", nil)
+	assert.Equal(t, myerrors.ErrInvalidInput, err)
+	_, err = uc.RegisterServiceClient("Batch", []string{domain.ScopeProfile})
+	assert.Equal(t, myerrors.ErrInvalidScope, err)
+	_, err = uc.RegisterServiceClient("Batch", []string{domain.PermUsersWrite})
+	assert.Equal(t, myerrors.ErrInvalidScope, err)
+}
+
+func TestIntrospectAndRevoke(t *testing.T) {
+	uc, _ := newOAuthTest()
+	session := &domain.Session{ID: "sid", IIN: "910815450350", CreatedAt: time.Now(), LastUsed: time.Now()}
+	assert.NoError(t, uc.CreateSession(session, "first"))
+	assert.NoError(t, uc.RotateToken("910815450350", "sid", "first", "second"))
+
+	user, err := uc.CheckSession("910815450350", "sid", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "user", user.Username)
+	_, err = uc.CheckSession("910815450350", "sid", "second")
+	assert.NoError(t, err)
+	_, err = uc.CheckSession("910815450350", "sid", "first")
+	assert.Equal(t, myerrors.ErrTokenReused, err)
+	_, err = uc.CheckSession("980124450084", "sid", "")
+	assert.Equal(t, myerrors.ErrRefreshNotFound, err)
+
+	revoked, err := uc.IsTokenRevoked("jti")
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+	assert.NoError(t, uc.RevokeToken("910815450350", "sid", "jti", time.Now().Add(time.Minute)))
+	revoked, err = uc.IsTokenRevoked("jti")
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+	_, err = uc.CheckSession("910815450350", "sid", "")
+	assert.Equal(t, myerrors.ErrRefreshNotFound, err)
+
+	// locked users have no live sessions
+	assert.NoError(t, uc.CreateSession(&domain.Session{ID: "locked", IIN: "980124450084"}, "token"))
+	_, err = uc.CheckSession("980124450084", "locked", "")
+	assert.Equal(t, myerrors.ErrUserLocked, err)
+}
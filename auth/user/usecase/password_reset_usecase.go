@@ -0,0 +1,132 @@
+package usecase
+
+import (
+	"auth/config"
+	"auth/domain"
+	"auth/logger"
+	"auth/myerrors"
+	"auth/passhash"
+	"auth/passpolicy"
+	"auth/user/repository"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+)
+
+type PasswordResetUsecase interface {
+	RequestReset(username string) error
+	SendResetLink(user *domain.User) error
+	ResetPassword(token, password string) error
+	PasswordRules() []domain.PasswordRule
+}
+
+type passwordResetUsecaseImpl struct {
+	cacheConn repository.CacheInterface
+	dbConn    repository.DBInterface
+	mailer    repository.Mailer
+	policy    *config.PasswordResetPolicy
+	hasher    passhash.Hasher
+	passwords *passpolicy.Policy
+}
+
+// hashToken returns hex SHA-256 of a random token, only hashes of reset tokens, authorization codes and client secrets are stored
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RequestReset mails reset link to the user. Unknown users and users without email are only logged,
+// so that the answer doesn't tell whether an account exists
+func (uc *passwordResetUsecaseImpl) RequestReset(username string) error {
+	user, err := uc.dbConn.GetUser(username)
+	if err == myerrors.ErrUserNotFound {
+		logger.Info("Password reset requested for unknown user", "username", username)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := uc.SendResetLink(user); err != myerrors.ErrNoEmail {
+		return err
+	}
+	logger.Info("Password reset requested for user without email", "iin", user.IIN)
+	return nil
+}
+
+// SendResetLink issues single-use reset token replacing the previous one and mails it to the user
+func (uc *passwordResetUsecaseImpl) SendResetLink(user *domain.User) error {
+	if user.Email == "" {
+		return myerrors.ErrNoEmail
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	token := hex.EncodeToString(b)
+	if err := uc.cacheConn.InsertResetToken(user.IIN, hashToken(token), uc.policy.TokenTtl); err != nil {
+		return err
+	}
+	link := uc.policy.BaseURL + "/password/reset?token=" + url.QueryEscape(token)
+	logger.Security("Password reset link sent", "iin", user.IIN)
+	return uc.mailer.Send(&domain.Mail{
+		To:      user.Email,
+		Subject: "Сброс пароля MyWallet",
+		Body: fmt.Sprintf("Здравствуйте, %s!\n\nЧтобы задать новый пароль, перейдите по ссылке:\n%s\n\n"+
+			"Ссылка действует %v и может быть использована один раз. Если вы не запрашивали сброс пароля, проигнорируйте это письмо.\n",
+			user.Username, link, uc.policy.TokenTtl),
+	})
+}
+
+// ResetPassword sets new password of the token owner and revokes all of their sessions.
+// Password not meeting the policy doesn't use the token up
+func (uc *passwordResetUsecaseImpl) ResetPassword(token, password string) error {
+	tokenHash := hashToken(token)
+	IIN, err := uc.cacheConn.GetResetToken(tokenHash)
+	if err != nil {
+		return err
+	}
+	user, err := uc.dbConn.GetUserByIIN(IIN)
+	if err != nil {
+		return err
+	}
+	if err := uc.passwords.Validate(password, user.Username, user.IIN); err != nil {
+		return err
+	}
+	hash, err := uc.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	consumedIIN, err := uc.cacheConn.ConsumeResetToken(tokenHash)
+	if err != nil {
+		return err
+	}
+	if consumedIIN != IIN {
+		return myerrors.ErrResetTokenInvalid
+	}
+	if err := uc.dbConn.UpdatePassword(IIN, hash); err != nil {
+		return err
+	}
+	logger.Security("Password reset", "iin", IIN)
+	if err := revokeAllSessions(uc.cacheConn, IIN); err != nil {
+		return err
+	}
+	return uc.cacheConn.ResetLoginAttempts(userAttemptsKey(user.Username))
+}
+
+// PasswordRules describes password policy for reset page
+func (uc *passwordResetUsecaseImpl) PasswordRules() []domain.PasswordRule {
+	return uc.passwords.Rules()
+}
+
+func NewPasswordResetUsecase(c repository.CacheInterface, db repository.DBInterface, mailer repository.Mailer, policy *config.PasswordResetPolicy, hasher passhash.Hasher, passwords *passpolicy.Policy) PasswordResetUsecase {
+	return &passwordResetUsecaseImpl{
+		cacheConn: c,
+		dbConn:    db,
+		mailer:    mailer,
+		policy:    policy,
+		hasher:    hasher,
+		passwords: passwords,
+	}
+}
@@ -0,0 +1,108 @@
+package usecase
+
+import (
+	"auth/config"
+	"auth/domain"
+	"auth/myerrors"
+	"auth/passpolicy"
+	"auth/user/repository"
+	"auth/user/repository/memory"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// resetTestDB fakes the DB methods used by password reset, others are not implemented
+type resetTestDB struct {
+	repository.DBInterface
+	users map[string]*domain.User
+}
+
+func (db *resetTestDB) GetUser(username string) (*domain.User, error) {
+	for _, user := range db.users {
+		if user.Username == username {
+			u := *user
+			return &u, nil
+		}
+	}
+	return nil, myerrors.ErrUserNotFound
+}
+
+func (db *resetTestDB) GetUserByIIN(IIN string) (*domain.User, error) {
+	user, ok := db.users[IIN]
+	if !ok {
+		return nil, myerrors.ErrUserNotFound
+	}
+	u := *user
+	return &u, nil
+}
+
+func (db *resetTestDB) UpdatePassword(IIN, password string) error {
+	user, ok := db.users[IIN]
+	if !ok {
+		return myerrors.ErrUserNotFound
+	}
+	user.Password = password
+	return nil
+}
+
+type recordingMailer struct {
+	sent []domain.Mail
+}
+
+func (m *recordingMailer) Send(mail *domain.Mail) error {
+	m.sent = append(m.sent, *mail)
+	return nil
+}
+
+func TestPasswordReset(t *testing.T) {
+	cache := memory.NewMemoryCacheInterface()
+	db := &resetTestDB{users: map[string]*domain.User{
+		"1": {IIN: "1", Username: "user", Password: "old", Email: "user@example.com"},
+		"2": {IIN: "2", Username: "noemail", Password: "old"},
+	}}
+	mailer := &recordingMailer{}
+	uc := NewPasswordResetUsecase(cache, db, mailer, &config.PasswordResetPolicy{TokenTtl: time.Minute, BaseURL: "https://wallet.example.com"}, testHasher, testPolicy)
+
+	now := time.Now()
+	for _, ID := range []string{"a", "b"} {
+		assert.NoError(t, cache.InsertSession(&domain.Session{ID: ID, IIN: "1", CreatedAt: now, LastUsed: now}, ID+"-token", time.Hour))
+	}
+	_, err := cache.AddLoginFailure(userAttemptsKey("user"), time.Hour)
+	assert.NoError(t, err)
+
+	// unknown users and users without email get no mail and no error
+	assert.NoError(t, uc.RequestReset("unknown"))
+	assert.NoError(t, uc.RequestReset("noemail"))
+	assert.Empty(t, mailer.sent)
+	assert.Equal(t, myerrors.ErrNoEmail, uc.SendResetLink(db.users["2"]))
+
+	assert.NoError(t, uc.RequestReset("user"))
+	assert.Len(t, mailer.sent, 1)
+	assert.Equal(t, "user@example.com", mailer.sent[0].To)
+	match := regexp.MustCompile(`https://wallet\.example\.com/password/reset\?token=([0-9a-f]{64})`).FindStringSubmatch(mailer.sent[0].Body)
+	if !assert.NotNil(t, match) {
+		return
+	}
+
+	assert.Equal(t, myerrors.ErrResetTokenInvalid, uc.ResetPassword("wrong", "newpass123"))
+	// password not meeting the policy doesn't use the token up
+	var policyErr *passpolicy.Error
+	assert.True(t, errors.As(uc.ResetPassword(match[1], "new"), &policyErr))
+	assert.Equal(t, "old", db.users["1"].Password)
+	assert.NoError(t, uc.ResetPassword(match[1], "newpass123"))
+	assert.NoError(t, testHasher.Verify(db.users["1"].Password, "newpass123"))
+	sessions, err := cache.ListSessions("1")
+	assert.NoError(t, err)
+	assert.Empty(t, sessions)
+	attempts, err := cache.GetLoginAttempts(userAttemptsKey("user"))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, attempts.Failures)
+
+	// token works only once
+	assert.Equal(t, myerrors.ErrResetTokenInvalid, uc.ResetPassword(match[1], "newerpass123"))
+	assert.NoError(t, testHasher.Verify(db.users["1"].Password, "newpass123"))
+}
@@ -0,0 +1,82 @@
+package usecase
+
+import (
+	"auth/domain"
+	"auth/logger"
+	"auth/myerrors"
+	"auth/passhash"
+	"auth/passpolicy"
+	"auth/user/repository"
+)
+
+type ProfileUsecase interface {
+	GetUser(IIN string) (*domain.User, error)
+	ChangePassword(IIN, currentPassword, password, currentSessionID string) error
+	ChangeUsername(IIN, username, currentSessionID string) error
+	PasswordRules() []domain.PasswordRule
+}
+
+type profileUsecaseImpl struct {
+	cacheConn repository.CacheInterface
+	dbConn    repository.DBInterface
+	hasher    passhash.Hasher
+	policy    *passpolicy.Policy
+}
+
+// GetUser gets user by IIN including password hash
+func (uc *profileUsecaseImpl) GetUser(IIN string) (*domain.User, error) {
+	return uc.dbConn.GetUserByIIN(IIN)
+}
+
+// ChangePassword checks the current password and the policy, sets the new password and revokes all sessions except the current one
+func (uc *profileUsecaseImpl) ChangePassword(IIN, currentPassword, password, currentSessionID string) error {
+	if currentSessionID == "" {
+		return myerrors.ErrInvalidInput
+	}
+	user, err := uc.dbConn.GetUserByIIN(IIN)
+	if err != nil {
+		return err
+	}
+	if err := uc.hasher.Verify(user.Password, currentPassword); err != nil {
+		return err
+	}
+	if err := uc.policy.Validate(password, user.Username, user.IIN); err != nil {
+		return err
+	}
+	hash, err := uc.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	if err := uc.dbConn.UpdatePassword(IIN, hash); err != nil {
+		return err
+	}
+	logger.Security("Password changed", "iin", IIN)
+	return revokeOtherSessions(uc.cacheConn, IIN, currentSessionID)
+}
+
+// ChangeUsername renames the user and revokes all sessions except the current one
+func (uc *profileUsecaseImpl) ChangeUsername(IIN, username, currentSessionID string) error {
+	if currentSessionID == "" {
+		return myerrors.ErrInvalidInput
+	}
+	if err := uc.dbConn.UpdateUsername(IIN, username); err != nil {
+		return err
+	}
+	logger.Security("Username changed", "iin", IIN)
+	return revokeOtherSessions(uc.cacheConn, IIN, currentSessionID)
+}
+
+// PasswordRules describes password policy for profile page
+func (uc *profileUsecaseImpl) PasswordRules() []domain.PasswordRule {
+	return uc.policy.Rules()
+}
+
+// NewProfileUsecase returns new ProfileUsecase
+func NewProfileUsecase(c repository.CacheInterface, db repository.DBInterface, hasher passhash.Hasher, policy *passpolicy.Policy) ProfileUsecase {
+	return &profileUsecaseImpl{
+		cacheConn: c,
+		dbConn:    db,
+		hasher:    hasher,
+		policy:    policy,
+	}
+}
@@ -0,0 +1,89 @@
+package usecase
+
+import (
+	"auth/domain"
+	"auth/myerrors"
+	"auth/passpolicy"
+	"auth/user/repository/memory"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// profileTestDB adds username updates to resetTestDB
+type profileTestDB struct {
+	resetTestDB
+}
+
+func (db *profileTestDB) UpdateUsername(IIN, username string) error {
+	for _, user := range db.users {
+		if user.Username == username && user.IIN != IIN {
+			return myerrors.ErrDuplicateUser
+		}
+	}
+	user, ok := db.users[IIN]
+	if !ok {
+		return myerrors.ErrUserNotFound
+	}
+	user.Username = username
+	return nil
+}
+
+func TestProfile(t *testing.T) {
+	cache := memory.NewMemoryCacheInterface()
+	old, err := testHasher.Hash("old")
+	assert.NoError(t, err)
+	db := &profileTestDB{resetTestDB{users: map[string]*domain.User{
+		"1": {IIN: "1", Username: "user", Password: old},
+		"2": {IIN: "2", Username: "taken", Password: old},
+	}}}
+	uc := NewProfileUsecase(cache, db, testHasher, testPolicy)
+
+	now := time.Now()
+	insertSessions := func() {
+		for _, ID := range []string{"current", "other"} {
+			assert.NoError(t, cache.InsertSession(&domain.Session{ID: ID, IIN: "1", CreatedAt: now, LastUsed: now}, ID+"-token", time.Hour))
+		}
+	}
+	assertOnlyCurrent := func() {
+		sessions, err := cache.ListSessions("1")
+		assert.NoError(t, err)
+		if assert.Len(t, sessions, 1) {
+			assert.Equal(t, "current", sessions[0].ID)
+		}
+	}
+
+	insertSessions()
+	assert.Equal(t, myerrors.ErrWrongPassword, uc.ChangePassword("1", "wrong", "newpass123", "current"))
+	var policyErr *passpolicy.Error
+	assert.True(t, errors.As(uc.ChangePassword("1", "old", "user1234", "current"), &policyErr))
+	assert.Equal(t, old, db.users["1"].Password)
+	assert.NoError(t, uc.ChangePassword("1", "old", "newpass123", "current"))
+	assert.NoError(t, testHasher.Verify(db.users["1"].Password, "newpass123"))
+	assertOnlyCurrent()
+
+	insertSessions()
+	assert.Equal(t, myerrors.ErrDuplicateUser, uc.ChangeUsername("1", "taken", "current"))
+	assert.Equal(t, "user", db.users["1"].Username)
+	sessions, err := cache.ListSessions("1")
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 2, "failed change must keep sessions")
+
+	assert.NoError(t, uc.ChangeUsername("1", "renamed", "current"))
+	assert.Equal(t, "renamed", db.users["1"].Username)
+	assertOnlyCurrent()
+
+	assert.Equal(t, myerrors.ErrUserNotFound, uc.ChangePassword("3", "old", "newpass123", "current"))
+
+	// without the current session nothing is changed, it would be revoked with the others
+	insertSessions()
+	assert.Equal(t, myerrors.ErrInvalidInput, uc.ChangePassword("1", "newpass123", "otherpass123", ""))
+	assert.NoError(t, testHasher.Verify(db.users["1"].Password, "newpass123"))
+	assert.Equal(t, myerrors.ErrInvalidInput, uc.ChangeUsername("1", "again", ""))
+	assert.Equal(t, "renamed", db.users["1"].Username)
+	sessions, err = cache.ListSessions("1")
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 2)
+}
@@ -2,7 +2,12 @@ package usecase
 
 import (
 	"auth/domain"
+	"auth/logger"
+	"auth/myerrors"
+	"auth/passhash"
+	"auth/passpolicy"
 	"auth/user/repository"
+	"sort"
 	"time"
 )
 
@@ -55,9 +60,8 @@ func NewTransferPageUsecase(api repository.APIInterface) TransferPageUsecase {
 }
 
 type UpdateTokenUsecase interface {
-	FindToken(IIN, token string) bool
+	RotateToken(IIN, sessionID, oldToken, newToken string, refreshTtl time.Duration) error
 	GetUser(IIN string) (*domain.User, error)
-	InsertToken(IIN, token string, refreshTtl time.Duration) error
 }
 
 type updateTokenUsecaseImpl struct {
@@ -65,23 +69,34 @@ type updateTokenUsecaseImpl struct {
 	dbConn    repository.DBInterface
 }
 
-// InsertToken inserts token
-func (uc *updateTokenUsecaseImpl) InsertToken(IIN, token string, refreshTtl time.Duration) error {
-	return uc.cacheConn.InsertToken(IIN, token, refreshTtl)
-}
-
-// GetUser gets user by IIN
+// GetUser gets user by IIN together with roles and permissions, locked users can't refresh tokens
 func (uc *updateTokenUsecaseImpl) GetUser(IIN string) (*domain.User, error) {
-	return uc.dbConn.GetUserByIIN(IIN)
+	user, err := uc.dbConn.GetUserByIIN(IIN)
+	if err != nil {
+		return nil, err
+	}
+	if user.Locked {
+		return nil, myerrors.ErrUserLocked
+	}
+	if err := loadRoles(uc.dbConn, user); err != nil {
+		return nil, err
+	}
+	return user, nil
 }
 
-// FindToken looks for refresh token in redis
-func (uc *updateTokenUsecaseImpl) FindToken(IIN, token string) bool {
-	value, err := uc.cacheConn.FindToken(IIN, token)
-	if err != nil {
-		return false
+// RotateToken replaces current refresh token of the session with a new one.
+// If a token that was already rotated out is presented, the whole session is revoked. The token rotated out
+// just now by a concurrent request gives ErrTokenRotated and keeps the session
+func (uc *updateTokenUsecaseImpl) RotateToken(IIN, sessionID, oldToken, newToken string, refreshTtl time.Duration) error {
+	err := uc.cacheConn.RotateToken(IIN, sessionID, oldToken, newToken, refreshTtl)
+	if err != myerrors.ErrTokenReused {
+		return err
 	}
-	return token == value
+	logger.Security("Refresh token reuse detected, revoking session", "iin", IIN, "session_id", sessionID)
+	if err := uc.cacheConn.DeleteSession(IIN, sessionID); err != nil {
+		logger.Error("Couldn't revoke session", "err", err)
+	}
+	return err
 }
 
 // NewUpdateTokenUsecase returns new UpdateTokenUsecase
@@ -94,33 +109,195 @@ func NewUpdateTokenUsecase(c repository.CacheInterface, db repository.DBInterfac
 
 type LoginUsecase interface {
 	GetUser(string) (*domain.User, error)
-	InsertToken(IIN, token string, refreshTtl time.Duration) error
+	CheckPassword(user *domain.User, password string) error
+	CheckPasswordPolicy(user *domain.User, password string) []domain.PasswordRule
+	CreateSession(session *domain.Session, token string, refreshTtl time.Duration) error
 }
 
 type loginUsecaseImpl struct {
 	cacheConn repository.CacheInterface
 	dbConn    repository.DBInterface
+	hasher    passhash.Hasher
+	policy    *passpolicy.Policy
 }
 
-// GetUser gets user by username
+// GetUser gets user by username together with roles and permissions
 func (uc *loginUsecaseImpl) GetUser(username string) (*domain.User, error) {
 	user, err := uc.dbConn.GetUser(username)
 	if err != nil {
 		return nil, err
 	}
+	if err := loadRoles(uc.dbConn, user); err != nil {
+		return nil, err
+	}
 	return user, nil
 }
 
-// InsertToken inserts new refresh token in redis
-func (uc *loginUsecaseImpl) InsertToken(IIN, token string, refreshTtl time.Duration) error {
-	return uc.cacheConn.InsertToken(IIN, token, refreshTtl)
+// CheckPassword verifies password of the user and upgrades its hash if it was made with outdated parameters
+func (uc *loginUsecaseImpl) CheckPassword(user *domain.User, password string) error {
+	if err := uc.hasher.Verify(user.Password, password); err != nil {
+		return err
+	}
+	if !uc.hasher.NeedsRehash(user.Password) {
+		return nil
+	}
+	hash, err := uc.hasher.Hash(password)
+	if err == nil {
+		err = uc.dbConn.UpdatePassword(user.IIN, hash)
+	}
+	if err != nil {
+		// the password is right, login goes on with the old hash
+		logger.Error("Couldn't rehash password", "iin", user.IIN, "err", err)
+		return nil
+	}
+	logger.Info("Password hash upgraded", "iin", user.IIN)
+	user.Password = hash
+	return nil
+}
+
+// CheckPasswordPolicy returns rules of the current policy failed by password of the user,
+// which may have been set under an older policy
+func (uc *loginUsecaseImpl) CheckPasswordPolicy(user *domain.User, password string) []domain.PasswordRule {
+	return uc.policy.Check(password, user.Username, user.IIN)
+}
+
+// CreateSession stores new session with its first refresh token in redis
+func (uc *loginUsecaseImpl) CreateSession(session *domain.Session, token string, refreshTtl time.Duration) error {
+	return uc.cacheConn.InsertSession(session, token, refreshTtl)
 }
 
 // NewLoginUsecase return new LoginUsecase
-func NewLoginUsecase(c repository.CacheInterface, db repository.DBInterface) LoginUsecase {
+func NewLoginUsecase(c repository.CacheInterface, db repository.DBInterface, hasher passhash.Hasher, policy *passpolicy.Policy) LoginUsecase {
 	return &loginUsecaseImpl{
 		cacheConn: c,
 		dbConn:    db,
+		hasher:    hasher,
+		policy:    policy,
+	}
+}
+
+// AdminRole grants access to user management
+const AdminRole = "admin"
+
+// loadRoles fills roles and permissions of the user from DB
+func loadRoles(db repository.DBInterface, user *domain.User) error {
+	roles, permissions, err := db.GetUserRoles(user.ID)
+	if err != nil {
+		return err
+	}
+	user.Roles = roles
+	user.Permissions = permissions
+	user.IsAdmin = false
+	for _, role := range roles {
+		if role == AdminRole {
+			user.IsAdmin = true
+		}
+	}
+	return nil
+}
+
+type SessionsUsecase interface {
+	ListSessions(IIN, currentID string) ([]domain.Session, error)
+	RevokeSession(IIN, sessionID string) error
+	RevokeOtherSessions(IIN, currentID string) error
+}
+
+type sessionsUsecaseImpl struct {
+	cacheConn repository.CacheInterface
+}
+
+// ListSessions retrieves active sessions of the user and marks the current one
+func (uc *sessionsUsecaseImpl) ListSessions(IIN, currentID string) ([]domain.Session, error) {
+	sessions, err := uc.cacheConn.ListSessions(IIN)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentID
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsed.After(sessions[j].LastUsed)
+	})
+	return sessions, nil
+}
+
+// RevokeSession deletes one of the user's sessions
+func (uc *sessionsUsecaseImpl) RevokeSession(IIN, sessionID string) error {
+	session, err := uc.cacheConn.GetSession(sessionID)
+	if err != nil {
+		return err
+	}
+	if session.IIN != IIN {
+		return myerrors.ErrSessionNotFound
+	}
+	return uc.cacheConn.DeleteSession(IIN, sessionID)
+}
+
+// RevokeOtherSessions deletes all of the user's sessions except the current one
+func (uc *sessionsUsecaseImpl) RevokeOtherSessions(IIN, currentID string) error {
+	return revokeOtherSessions(uc.cacheConn, IIN, currentID)
+}
+
+// revokeOtherSessions deletes every session of the user except keepID. ErrInvalidInput is returned
+// for empty keepID, so that the current session is never revoked by mistake
+func revokeOtherSessions(c repository.CacheInterface, IIN, keepID string) error {
+	if keepID == "" {
+		return myerrors.ErrInvalidInput
+	}
+	return revokeSessions(c, IIN, keepID)
+}
+
+// revokeAllSessions deletes every session of the user, e.g. when the account is locked or its password reset
+func revokeAllSessions(c repository.CacheInterface, IIN string) error {
+	return revokeSessions(c, IIN, "")
+}
+
+func revokeSessions(c repository.CacheInterface, IIN, keepID string) error {
+	sessions, err := c.ListSessions(IIN)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if session.ID == keepID {
+			continue
+		}
+		if err := c.DeleteSession(IIN, session.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewSessionsUsecase returns new SessionsUsecase
+func NewSessionsUsecase(c repository.CacheInterface) SessionsUsecase {
+	return &sessionsUsecaseImpl{
+		cacheConn: c,
+	}
+}
+
+type LogoutUsecase interface {
+	RevokeSession(IIN, sessionID string) error
+	DenyToken(jti string, ttl time.Duration) error
+}
+
+type logoutUsecaseImpl struct {
+	cacheConn repository.CacheInterface
+}
+
+// RevokeSession deletes session and its refresh token from redis
+func (uc *logoutUsecaseImpl) RevokeSession(IIN, sessionID string) error {
+	return uc.cacheConn.DeleteSession(IIN, sessionID)
+}
+
+// DenyToken denylists access token until it expires
+func (uc *logoutUsecaseImpl) DenyToken(jti string, ttl time.Duration) error {
+	return uc.cacheConn.DenyToken(jti, ttl)
+}
+
+// NewLogoutUsecase returns new LogoutUsecase
+func NewLogoutUsecase(c repository.CacheInterface) LogoutUsecase {
+	return &logoutUsecaseImpl{
+		cacheConn: c,
 	}
 }
 
@@ -149,22 +326,39 @@ func NewAddWalletUsecase(api repository.APIInterface) AddWalletUsecase {
 }
 
 type SignupUsecase interface {
-	AddUser(IIN, username, password string) error
+	AddUser(IIN, username, password, email string) error
+	PasswordRules() []domain.PasswordRule
 }
 
 type signupUsecaseImpl struct {
 	dbConn repository.DBInterface
+	hasher passhash.Hasher
+	policy *passpolicy.Policy
 }
 
-// AddUser adds new user to DB
-func (uc *signupUsecaseImpl) AddUser(IIN, username, password string) error {
-	return uc.dbConn.AddUser(IIN, username, password)
+// AddUser checks password against the policy, hashes it and adds new user to DB
+func (uc *signupUsecaseImpl) AddUser(IIN, username, password, email string) error {
+	if err := uc.policy.Validate(password, username, IIN); err != nil {
+		return err
+	}
+	hash, err := uc.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	return uc.dbConn.AddUser(IIN, username, hash, email)
+}
+
+// PasswordRules describes password policy for signup page
+func (uc *signupUsecaseImpl) PasswordRules() []domain.PasswordRule {
+	return uc.policy.Rules()
 }
 
 // NewSignupUsecase returns new SignupUsecase
-func NewSignupUsecase(db repository.DBInterface) SignupUsecase {
+func NewSignupUsecase(db repository.DBInterface, hasher passhash.Hasher, policy *passpolicy.Policy) SignupUsecase {
 	return &signupUsecaseImpl{
 		dbConn: db,
+		hasher: hasher,
+		policy: policy,
 	}
 }
 
@@ -178,9 +372,9 @@ type getInfoUsecaseImpl struct {
 	dbConn repository.DBInterface
 }
 
-// GetUserInfo retrieves user data from DB
-func (uc *getInfoUsecaseImpl) GetUserInfo(username string) (*domain.User, error) {
-	user, err := uc.dbConn.GetUser(username)
+// GetUserInfo retrieves user data from DB by IIN, which unlike username never changes
+func (uc *getInfoUsecaseImpl) GetUserInfo(IIN string) (*domain.User, error) {
+	user, err := uc.dbConn.GetUserByIIN(IIN)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,111 @@
+package usecase
+
+import (
+	"auth/config"
+	"auth/domain"
+	"auth/myerrors"
+	"auth/passhash"
+	"auth/passpolicy"
+	"auth/user/repository/memory"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testHasher uses cheap argon2id parameters
+var testHasher = passhash.NewHasher(&config.PasswordHashPolicy{Algorithm: config.HashArgon2id, BcryptCost: 10, Argon2Memory: 1024, Argon2Time: 1, Argon2Threads: 1})
+
+var testPolicy, _ = passpolicy.NewPolicy(&config.PasswordPolicy{MinLength: 8, MaxLength: 72, Classes: []string{passpolicy.RuleLower, passpolicy.RuleDigit}})
+
+func TestCheckPasswordRehash(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("password "), 4)
+	assert.NoError(t, err)
+	db := &resetTestDB{users: map[string]*domain.User{
+		"1": {IIN: "1", Username: "user", Password: string(bcryptHash)},
+	}}
+	uc := NewLoginUsecase(memory.NewMemoryCacheInterface(), db, testHasher, testPolicy)
+
+	user, err := db.GetUser("user")
+	assert.NoError(t, err)
+	assert.Equal(t, myerrors.ErrWrongPassword, uc.CheckPassword(user, "wrong"))
+	assert.Equal(t, string(bcryptHash), db.users["1"].Password, "wrong password must not rehash")
+
+	// outdated bcrypt hash is upgraded to argon2id
+	assert.NoError(t, uc.CheckPassword(user, "password "))
+	upgraded := db.users["1"].Password
+	assert.Equal(t, upgraded, user.Password)
+	assert.False(t, testHasher.NeedsRehash(upgraded))
+	assert.NoError(t, testHasher.Verify(upgraded, "password "))
+
+	// current hash is kept
+	assert.NoError(t, uc.CheckPassword(user, "password "))
+	assert.Equal(t, upgraded, db.users["1"].Password)
+
+	// password set under older policy is reported
+	assert.Equal(t, []string{passpolicy.RuleDigit}, ruleNames(uc.CheckPasswordPolicy(user, "password ")))
+	assert.Empty(t, uc.CheckPasswordPolicy(user, "password 1"))
+}
+
+func ruleNames(rules []domain.PasswordRule) []string {
+	var names []string
+	for _, rule := range rules {
+		names = append(names, rule.Rule)
+	}
+	return names
+}
+
+func TestSignup(t *testing.T) {
+	db := &signupTestDB{}
+	uc := NewSignupUsecase(db, testHasher, testPolicy)
+	assert.NoError(t, uc.AddUser("1", "user", "password 1", ""))
+	assert.NotEqual(t, "password 1", db.password)
+	assert.NoError(t, testHasher.Verify(db.password, "password 1"))
+
+	db.password = ""
+	err := uc.AddUser("910815450350", "bobby", "bobby123", "")
+	var policyErr *passpolicy.Error
+	if assert.True(t, errors.As(err, &policyErr)) {
+		assert.Equal(t, []string{passpolicy.RuleUsername}, ruleNames(policyErr.Rules))
+	}
+	assert.Empty(t, db.password)
+}
+
+type signupTestDB struct {
+	resetTestDB
+	password string
+}
+
+func (db *signupTestDB) AddUser(IIN, username, password, email string) error {
+	db.password = password
+	return nil
+}
+
+func TestRevokeOtherSessions(t *testing.T) {
+	cache := memory.NewMemoryCacheInterface()
+	uc := NewSessionsUsecase(cache)
+	now := time.Now()
+	for _, ID := range []string{"current", "other", "third"} {
+		assert.NoError(t, cache.InsertSession(&domain.Session{ID: ID, IIN: "1", CreatedAt: now, LastUsed: now}, ID+"-token", time.Hour))
+	}
+
+	// empty current session must not revoke every session
+	assert.Equal(t, myerrors.ErrInvalidInput, uc.RevokeOtherSessions("1", ""))
+	sessions, err := cache.ListSessions("1")
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 3)
+
+	assert.NoError(t, uc.RevokeOtherSessions("1", "current"))
+	sessions, err = cache.ListSessions("1")
+	assert.NoError(t, err)
+	if assert.Len(t, sessions, 1) {
+		assert.Equal(t, "current", sessions[0].ID)
+	}
+
+	assert.NoError(t, revokeAllSessions(cache, "1"))
+	sessions, err = cache.ListSessions("1")
+	assert.NoError(t, err)
+	assert.Empty(t, sessions)
+}